@@ -26,6 +26,11 @@ const (
 
 	// Digits after comma
 	doubleDecodePrecision = 6
+
+	// Server status flags in OK and EOF packets
+	serverMoreResultsExists   = 0x0008
+	serverStatusCursorExists  = 0x0040
+	serverSessionStateChanged = 0x4000
 )
 
 const (
@@ -57,6 +62,9 @@ const (
 	comStmtReset
 	comSetOption
 	comStmtFetch
+	comDaemon
+	comBinlogDumpGTID
+	comResetConnection
 )
 
 // Capability flags
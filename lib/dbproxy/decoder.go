@@ -11,6 +11,7 @@ import (
 	"io"
 	"math"
 	"strconv"
+	"sync"
 )
 
 var errInvalidPacketLength = errors.New("protocol: Invalid packet length")
@@ -21,6 +22,286 @@ func getPacketType(packet []byte) byte {
 	return packet[4]
 }
 
+// Check if data contains exactly one packet
+func isSinglePacket(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	bodyLen := int(uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16)
+
+	return bodyLen+4 == len(data)
+}
+
+// States of a server response
+const (
+	responseStateIdle = iota
+	responseStateFirst
+	responseStateParams
+	responseStateParamsEOF
+	responseStateColumns
+	responseStateColumnsEOF
+	responseStateRows
+	responseStateFieldList
+)
+
+// Bytes of a packet body enough to find its type and status flags
+const responsePrefixSize = 32
+
+// Tracks a server response on a command to know when it is complete.
+// Data comes in chunks of any size, a packet can be split between chunks.
+// Only first bytes of each packet are kept
+type responseTracker struct {
+	lock         sync.Mutex
+	command      byte
+	deprecateEOF bool
+	state        int
+	params       uint64 // param definitions left in a prepare response
+	columns      uint64 // column definitions left
+	packet       []byte // header and first bytes of a body of a current packet
+	bodyLen      int
+	bodyRead     int
+	continued    bool // current packet continues a previous one of max size
+}
+
+// Start tracking a response on a command sent to a server
+func (t *responseTracker) start(command byte, deprecateEOF bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.command = command
+	t.deprecateEOF = deprecateEOF
+	t.state = responseStateFirst
+
+	switch command {
+	case comFieldList:
+		t.state = responseStateFieldList
+	case comStmtFetch:
+		t.state = responseStateRows
+	}
+}
+
+// Check if a response on last command is complete
+func (t *responseTracker) isComplete() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.state == responseStateIdle
+}
+
+// Data received from a server. Returns true if a response is complete
+// and data ends on a packet border
+func (t *responseTracker) write(data []byte) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for len(data) > 0 {
+		if len(t.packet) < 4 {
+			n := 4 - len(t.packet)
+
+			if n > len(data) {
+				n = len(data)
+			}
+			t.packet = append(t.packet, data[:n]...)
+			data = data[n:]
+
+			if len(t.packet) < 4 {
+				break
+			}
+			t.bodyLen = int(uint32(t.packet[0]) | uint32(t.packet[1])<<8 | uint32(t.packet[2])<<16)
+			t.bodyRead = 0
+		}
+		n := t.bodyLen - t.bodyRead
+
+		if n > len(data) {
+			n = len(data)
+		}
+		keep := responsePrefixSize - (len(t.packet) - 4)
+
+		if keep > n {
+			keep = n
+		}
+		if keep > 0 {
+			t.packet = append(t.packet, data[:keep]...)
+		}
+		t.bodyRead += n
+		data = data[n:]
+
+		if t.bodyRead < t.bodyLen {
+			break
+		}
+		if t.continued {
+			// rest of a big packet. it is a row or a column
+			t.continued = t.bodyLen == 0xffffff
+		} else {
+			t.continued = t.bodyLen == 0xffffff
+			t.addPacket(t.packet[4:], t.bodyLen)
+		}
+		t.packet = t.packet[:0]
+	}
+	return t.state == responseStateIdle && len(t.packet) == 0
+}
+
+// Complete packet received. Body can be cut to the prefix size
+func (t *responseTracker) addPacket(body []byte, bodyLen int) {
+	if len(body) == 0 {
+		return
+	}
+	switch t.state {
+	case responseStateIdle:
+		// nothing is expected. a connection phase
+		if body[0] != responseOk && body[0] != responseErr {
+			t.command = 0
+			t.state = responseStateFirst
+		}
+
+	case responseStateFirst:
+		t.addFirstPacket(body)
+
+	case responseStateParams:
+		if t.params--; t.params == 0 {
+			t.state = responseStateParamsEOF
+			t.skipEOF()
+		}
+
+	case responseStateParamsEOF:
+		t.state = responseStateColumns
+
+		if t.columns == 0 {
+			t.state = responseStateIdle
+		}
+
+	case responseStateColumns:
+		if t.columns--; t.columns == 0 {
+			t.state = responseStateColumnsEOF
+			t.skipEOF()
+		}
+
+	case responseStateColumnsEOF:
+		status := getEOFStatus(body)
+
+		if t.command == comStmtPrepare || status&serverStatusCursorExists > 0 {
+			// no rows. they will be fetched later
+			t.state = responseStateIdle
+		} else {
+			t.state = responseStateRows
+		}
+
+	case responseStateRows, responseStateFieldList:
+		switch {
+		case body[0] == responseErr:
+			t.state = responseStateIdle
+		case body[0] == responseEof && t.deprecateEOF && bodyLen < 0xffffff:
+			t.completeResult(getOKStatus(body))
+		case body[0] == responseEof && bodyLen < 9:
+			t.completeResult(getEOFStatus(body))
+		}
+	}
+}
+
+// First packet of a response
+func (t *responseTracker) addFirstPacket(body []byte) {
+	switch {
+	case t.command == 0 || t.command == comChangeUser:
+		// authentication. only OK or ERR completes it
+		if body[0] == responseOk || body[0] == responseErr {
+			t.state = responseStateIdle
+		}
+
+	case t.command == comStatistics:
+		// a string response
+		t.state = responseStateIdle
+
+	case body[0] == responseErr:
+		t.state = responseStateIdle
+
+	case body[0] == responseOk && t.command == comStmtPrepare:
+		// statement ID, columns and params count
+		if len(body) < 9 {
+			t.state = responseStateIdle
+			return
+		}
+		t.columns = uint64(binary.LittleEndian.Uint16(body[5:7]))
+		t.params = uint64(binary.LittleEndian.Uint16(body[7:9]))
+
+		switch {
+		case t.params > 0:
+			t.state = responseStateParams
+		case t.columns > 0:
+			t.state = responseStateColumns
+		default:
+			t.state = responseStateIdle
+		}
+
+	case body[0] == responseOk:
+		t.completeResult(getOKStatus(body))
+
+	case body[0] == responseLocalinfile, body[0] == responseEof:
+		// a client sends a file or auth data. OK or ERR will follow
+
+	default:
+		t.columns, _ = readLenEncodedInteger(bytes.NewReader(body))
+
+		if t.columns == 0 {
+			t.state = responseStateIdle
+			return
+		}
+		t.state = responseStateColumns
+	}
+}
+
+// After definitions there is EOF packet if a client and a server don't deprecate it
+func (t *responseTracker) skipEOF() {
+	if !t.deprecateEOF {
+		return
+	}
+	switch t.state {
+	case responseStateParamsEOF:
+		t.state = responseStateColumns
+	case responseStateColumnsEOF:
+		if t.command == comStmtPrepare {
+			t.state = responseStateIdle
+		} else {
+			t.state = responseStateRows
+		}
+	}
+	if t.state == responseStateColumns && t.columns == 0 {
+		t.state = responseStateIdle
+	}
+}
+
+// A result is complete. Next one can follow if it is multi statement
+func (t *responseTracker) completeResult(status uint16) {
+	if status&serverMoreResultsExists > 0 {
+		t.state = responseStateFirst
+		return
+	}
+	t.state = responseStateIdle
+}
+
+// Server status from EOF packet
+func getEOFStatus(body []byte) uint16 {
+	if len(body) < 5 {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(body[3:5])
+}
+
+// Server status from OK packet
+func getOKStatus(body []byte) uint16 {
+	r := bytes.NewReader(body[1:])
+
+	// affected rows and last insert ID
+	readLenEncodedInteger(r)
+	readLenEncodedInteger(r)
+
+	status := []byte{0, 0}
+
+	if _, err := io.ReadFull(r, status); err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(status)
+}
+
 // DecodeOkResponse decodes ERR_Packet from server.
 // Part of basic packet structure shown below.
 //
@@ -218,11 +499,41 @@ func decodeHandshakeV10(packet []byte) (*handshakeV10, error) {
 type handshakeResponse41 struct {
 	ClientCapabilities uint32
 	ClientCharset      byte
+	Username           string
+	AuthResponse       []byte
+	Database           string
+	AuthPluginName     string
 }
 
 // DecodeHandshakeResponse41 decodes handshake response packet send by client.
-// TODO: Add packet struct comment
 // TODO: Add packet length check
+//
+// int<4> ClientCapabilities
+// int<4> MaxPacketSize
+// int<1> Charset
+// string<23> Reserved (all 0x00)
+// string<NUL> Username
+// if capabilities & clientPluginAuthLenEncClientData
+// {
+//		string<lenenc> AuthResponse
+// }
+// else if capabilities & clientSecureConnection
+// {
+//		int<1> AuthResponseLength
+//		string<$len> AuthResponse
+// }
+// else
+// {
+//		string<NUL> AuthResponse
+// }
+// if capabilities & clientConnectWithDB
+// {
+//		string<NUL> Database
+// }
+// if capabilities & clientPluginAuth
+// {
+//		string<NUL> AuthPluginName
+// }
 func decodeHandshakeResponse41(packet []byte) (*handshakeResponse41, error) {
 	r := bytes.NewReader(packet)
 
@@ -249,7 +560,41 @@ func decodeHandshakeResponse41(packet []byte) (*handshakeResponse41, error) {
 		return nil, err
 	}
 
-	return &handshakeResponse41{clientCapabilities, charset}, nil
+	res := &handshakeResponse41{ClientCapabilities: clientCapabilities, ClientCharset: charset}
+
+	// Skip filler. Short packet is SSL request, it has no more data
+	if _, err := r.Seek(23, io.SeekCurrent); err != nil || r.Len() == 0 {
+		return res, nil
+	}
+
+	res.Username = readNullTerminatedString(r)
+
+	if clientCapabilities&clientPluginAuthLenEncClientData != 0 {
+		authLen, _ := readLenEncodedInteger(r)
+		res.AuthResponse = make([]byte, authLen)
+		r.Read(res.AuthResponse)
+
+	} else if clientCapabilities&clientSecureConnection != 0 {
+		authLen, err := r.ReadByte()
+		if err != nil {
+			return res, nil
+		}
+		res.AuthResponse = make([]byte, authLen)
+		r.Read(res.AuthResponse)
+
+	} else {
+		res.AuthResponse = []byte(readNullTerminatedString(r))
+	}
+
+	if clientCapabilities&clientConnectWithDB != 0 && r.Len() > 0 {
+		res.Database = readNullTerminatedString(r)
+	}
+
+	if clientCapabilities&clientPluginAuth != 0 && r.Len() > 0 {
+		res.AuthPluginName = readNullTerminatedString(r)
+	}
+
+	return res, nil
 }
 
 // QueryRequest represents COM_QUERY or COM_STMT_PREPARE command sent by client to server.
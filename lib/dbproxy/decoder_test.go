@@ -16,7 +16,7 @@ func TestDecodeOkResponse(t *testing.T) {
 		Packet   []byte
 		HasError bool
 		Error    error
-		OkResponse
+		okResponse
 	}
 
 	testData := []*DecodeOkResponseAssert{
@@ -29,31 +29,31 @@ func TestDecodeOkResponse(t *testing.T) {
 			},
 			false,
 			nil,
-			OkResponse{0x00, uint64(1), uint64(0)},
+			okResponse{0x00, uint64(1), uint64(0)},
 		},
 		{
 			[]byte{0x07, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00},
 			false,
 			nil,
-			OkResponse{0x00, uint64(0), uint64(0)},
+			okResponse{0x00, uint64(0), uint64(0)},
 		},
 		{
 			[]byte{0x07, 0x00, 0x00, 0x01, 0x00, 0x01, 0x02, 0x02, 0x00, 0x00, 0x00},
 			false,
 			nil,
-			OkResponse{0x00, uint64(1), uint64(2)},
+			okResponse{0x00, uint64(1), uint64(2)},
 		},
 	}
 
 	for _, asserted := range testData {
-		decoded, err := DecodeOkResponse(asserted.Packet)
+		decoded, err := decodeOkResponse(asserted.Packet)
 
 		assert.Nil(t, err)
 
 		if err == nil {
-			assert.Equal(t, asserted.OkResponse.PacketType, decoded.PacketType)
-			assert.Equal(t, asserted.OkResponse.AffectedRows, decoded.AffectedRows)
-			assert.Equal(t, asserted.OkResponse.LastInsertID, decoded.LastInsertID)
+			assert.Equal(t, asserted.okResponse.PacketType, decoded.PacketType)
+			assert.Equal(t, asserted.okResponse.AffectedRows, decoded.AffectedRows)
+			assert.Equal(t, asserted.okResponse.LastInsertID, decoded.LastInsertID)
 		}
 	}
 }
@@ -123,7 +123,7 @@ func TestDecodeHandshakeV10(t *testing.T) {
 	}
 
 	for _, asserted := range testData {
-		decoded, err := DecodeHandshakeV10(asserted.Packet)
+		decoded, err := decodeHandshakeV10(asserted.Packet)
 
 		if err != nil {
 			assert.Equal(t, asserted.Error, err)
@@ -250,7 +250,7 @@ func TestDecodeComStmtExecuteRequest(t *testing.T) {
 	}
 
 	for _, asserted := range testData {
-		decoded, err := DecodeComStmtExecuteRequest(asserted.Packet, uint16(len(asserted.PreparedParameters)))
+		decoded, err := decodeComStmtExecuteRequest(asserted.Packet, uint16(len(asserted.PreparedParameters)))
 
 		actualHasError := err != nil
 		if asserted.HasError != actualHasError {
@@ -312,7 +312,7 @@ func TestDecodeQueryRequest(t *testing.T) {
 	}
 
 	for _, asserted := range testData {
-		decoded, err := DecodeQueryRequest(asserted.Packet)
+		decoded, err := decodeQueryRequest(asserted.Packet)
 
 		if err != nil {
 			assert.Equal(t, asserted.Error, err)
@@ -354,7 +354,7 @@ func TestDecodeComStmtPrepareOkResponse(t *testing.T) {
 	}
 
 	for _, asserted := range testData {
-		decoded, err := DecodeComStmtPrepareOkResponse(asserted.Packet)
+		decoded, err := decodeComStmtPrepareOkResponse(asserted.Packet)
 
 		if err != nil {
 			assert.Equal(t, asserted.Error, err)
@@ -372,7 +372,7 @@ func TestDecodeComStmtPrepareOkResponse(t *testing.T) {
 //		0x51, 0x52, 0x53, 0x54, 0x59, 0x57,
 //	}
 //
-//	decoded, _ := ReadLenEncodedString(packet)
+//	decoded, _ := readLenEncodedString(packet)
 //
 //	assert.Equal(t, expected, decoded)
 //}
@@ -384,12 +384,12 @@ func TestReadEOFLengthString(t *testing.T) {
 		0x49, 0x43, 0x54, 0x5f, 0x54, 0x52, 0x41, 0x4e, 0x53, 0x5f, 0x54, 0x41, 0x42, 0x4c, 0x45, 0x53, 0x27,
 	}
 
-	decoded := ReadEOFLengthString(encoded)
+	decoded := readEOFLengthString(encoded)
 
 	assert.Equal(t, expected, decoded)
 }
 
 func TestReadNullTerminatedString(t *testing.T) {
 	x := bytes.NewReader([]byte{0x35, 0x2e, 0x37, 0x2e, 0x31, 0x38, 0x00})
-	assert.Equal(t, "5.7.18", ReadNullTerminatedString(x))
+	assert.Equal(t, "5.7.18", readNullTerminatedString(x))
 }
@@ -12,8 +12,10 @@ type customResponseError struct {
 	Code    uint16
 }
 
-type customResponseRowsKeyValues struct {
-	rows     []CustomResponseKeyValue
+type customResponseRows struct {
+	columns  []string
+	types    []CustomResponseColumnType // can be empty. then all columns are text
+	rows     [][]string
 	counter  uint
 	protocol *protocolInfo
 }
@@ -61,19 +63,20 @@ func (e customResponseError) Error() string {
 }
 
 // =========================================================
-// KeyValues response
+// Rows response
 // Prepare response packet. which contains list of rows
 // https://github.com/siddontang/mixer/blob/master/doc/mysql-proxy/protocol.txt
 // https://dev.mysql.com/doc/internals/en/com-query-response.html
-func (r *customResponseRowsKeyValues) getPacket() []byte {
+func (r *customResponseRows) getPacket() []byte {
 	r.counter = 0
 
 	var b bytes.Buffer
 
-	b.Write(r.completePacket([]byte{2})) // 2 columns
+	b.Write(r.completePacket([]byte{uint8(len(r.columns))}))
 
-	b.Write(r.getColumnDefPacket("BC", "CustomResponse", "Key"))
-	b.Write(r.getColumnDefPacket("BC", "CustomResponse", "Value"))
+	for i, c := range r.columns {
+		b.Write(r.getColumnDefPacket("BC", "CustomResponse", c, r.getColumnType(i)))
+	}
 
 	if !r.protocol.deprecateEOFSet() {
 		// EOF
@@ -82,7 +85,7 @@ func (r *customResponseRowsKeyValues) getPacket() []byte {
 
 	// send rows
 	for _, row := range r.rows {
-		b.Write(r.getRowData(row.Key, row.Value))
+		b.Write(r.getRowData(row))
 	}
 	if !r.protocol.deprecateEOFSet() {
 		// EOF
@@ -95,12 +98,12 @@ func (r *customResponseRowsKeyValues) getPacket() []byte {
 	return b.Bytes()
 }
 
-func (r *customResponseRowsKeyValues) setProtocolInfo(pi protocolInfo) {
+func (r *customResponseRows) setProtocolInfo(pi protocolInfo) {
 	r.protocol = &pi
 }
 
 // make a data to be a packet in a sequence
-func (r *customResponseRowsKeyValues) completePacket(data []byte) []byte {
+func (r *customResponseRows) completePacket(data []byte) []byte {
 	r.counter = r.counter + 1
 
 	length := make([]byte, 4)
@@ -113,7 +116,14 @@ func (r *customResponseRowsKeyValues) completePacket(data []byte) []byte {
 	return res
 }
 
-func (r *customResponseRowsKeyValues) getColumnDefPacket(schema, table, column string) []byte {
+func (r *customResponseRows) getColumnType(i int) CustomResponseColumnType {
+	if i < len(r.types) {
+		return r.types[i]
+	}
+	return ColumnTypeText
+}
+
+func (r *customResponseRows) getColumnDefPacket(schema, table, column string, columnType CustomResponseColumnType) []byte {
 	var b bytes.Buffer
 
 	b.Write(r.getLengEncStr("def"))
@@ -130,17 +140,33 @@ func (r *customResponseRowsKeyValues) getColumnDefPacket(schema, table, column s
 
 	b.WriteByte(0x0c)
 
-	b.Write([]byte{0x21, 0x00, 0xfd, 0xff, 0x02, 0x00}) // charset and max length
-
-	b.WriteByte(0xfc) // type MYSQL_TYPE_BLOB
+	// charset, max length, type, flags, decimals
+	switch columnType {
+	case ColumnTypeInteger:
+		b.Write([]byte{0x3f, 0x00, 0x14, 0x00, 0x00, 0x00})
+		b.WriteByte(0x08) // type MYSQL_TYPE_LONGLONG
+		b.Write([]byte{0x81, 0x00, 0x00})
+	case ColumnTypeDecimal:
+		b.Write([]byte{0x3f, 0x00, 0x16, 0x00, 0x00, 0x00})
+		b.WriteByte(0x05) // type MYSQL_TYPE_DOUBLE
+		b.Write([]byte{0x81, 0x00, 0x1f})
+	case ColumnTypeDateTime:
+		b.Write([]byte{0x3f, 0x00, 0x13, 0x00, 0x00, 0x00})
+		b.WriteByte(0x0c) // type MYSQL_TYPE_DATETIME
+		b.Write([]byte{0x81, 0x00, 0x00})
+	default:
+		b.Write([]byte{0x21, 0x00, 0xfd, 0xff, 0x02, 0x00})
+		b.WriteByte(0xfc) // type MYSQL_TYPE_BLOB
+		b.Write([]byte{0x10, 0x00, 0x00})
+	}
 
-	b.Write([]byte{0x10, 0x00, 0x00, 0x00, 0x00})
+	b.Write([]byte{0x00, 0x00}) // filler
 
 	return r.completePacket(b.Bytes())
 }
 
 // Returns length encoded string for MySQL protocol
-func (r *customResponseRowsKeyValues) getLengEncStr(data string) []byte {
+func (r *customResponseRows) getLengEncStr(data string) []byte {
 	str := []byte(data)
 
 	length := len(str)
@@ -162,10 +188,12 @@ func (r *customResponseRowsKeyValues) getLengEncStr(data string) []byte {
 }
 
 // Create row packet
-func (r *customResponseRowsKeyValues) getRowData(val1, val2 string) []byte {
-	row := r.getLengEncStr(val1)
+func (r *customResponseRows) getRowData(values []string) []byte {
+	row := []byte{}
 
-	row = append(row, r.getLengEncStr(val2)...)
+	for _, v := range values {
+		row = append(row, r.getLengEncStr(v)...)
+	}
 
 	return r.completePacket(row)
 }
@@ -173,6 +201,9 @@ func (r *customResponseRowsKeyValues) getRowData(val1, val2 string) []byte {
 // ======================================================
 // Protocol detection functions
 func (p protocolInfo) deprecateEOFSet() bool {
+	if p.serverInfo == nil || p.clientInfo == nil {
+		return false
+	}
 	return ((clientDeprecateEOF & p.serverInfo.ServerCapabilities) != 0) &&
 		((clientDeprecateEOF & p.clientInfo.ClientCapabilities) != 0)
 }
@@ -182,6 +213,85 @@ func (p protocolInfo) clientTransactionsSet() bool {
 		((clientDeprecateEOF & p.clientInfo.ClientCapabilities) != 0)
 }
 
+func (p protocolInfo) sessionTrackSet() bool {
+	if p.serverInfo == nil || p.clientInfo == nil {
+		return false
+	}
+	return ((clientSessionTrack & p.serverInfo.ServerCapabilities) != 0) &&
+		((clientSessionTrack & p.clientInfo.ClientCapabilities) != 0)
+}
+
+// ===============================================================
+// Set info string of OK packet received from a server. Other values are not changed.
+// Session state changes are removed if they are present
+//
+// int<1> PacketType (0x00)
+// int<lenenc> AffectedRows
+// int<lenenc> LastInsertID
+// int<2> StatusFlags
+// int<2> Warnings
+// if session track is set
+// {
+//		string<lenenc> Info
+//		if StatusFlags & serverSessionStateChanged
+//		{
+//			string<lenenc> SessionStateChanges
+//		}
+// }
+// else
+// {
+//		string<EOF> Info
+// }
+func setOKResponseInfo(packet []byte, info string, protocol protocolInfo) []byte {
+	if len(packet) < 7 || getPacketType(packet) != responseOk {
+		return packet
+	}
+
+	r := bytes.NewReader(packet[5:])
+
+	readLenEncodedInteger(r)
+	readLenEncodedInteger(r)
+
+	// position of status flags
+	pos := len(packet) - r.Len()
+
+	if pos+4 > len(packet) {
+		return packet
+	}
+
+	res := append([]byte{}, packet[:pos+4]...)
+
+	// clear "session state changed" flag
+	res[pos+1] &^= serverSessionStateChanged >> 8
+
+	if protocol.sessionTrackSet() {
+		res = append(res, getLengEncInt(uint64(len(info)))...)
+	}
+	res = append(res, []byte(info)...)
+
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(res)-4))
+	copy(res[0:3], length[0:3])
+
+	return res
+}
+
+// Returns length encoded integer for MySQL protocol
+func getLengEncInt(v uint64) []byte {
+	switch {
+	case v < 251:
+		return []byte{byte(v)}
+	case v < 1<<16:
+		return []byte{0xfc, byte(v), byte(v >> 8)}
+	case v < 1<<24:
+		return []byte{0xfd, byte(v), byte(v >> 8), byte(v >> 16)}
+	}
+	b := make([]byte, 9)
+	b[0] = 0xfe
+	binary.LittleEndian.PutUint64(b[1:], v)
+	return b
+}
+
 // ===============================================================
 // OK Response
 func (r customResponseOK) getPacket() []byte {
@@ -0,0 +1,61 @@
+package dbproxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetOKResponseInfo(t *testing.T) {
+	// OK, 1 affected row, no insert id, autocommit status, no warnings, info "Rows matched"
+	packet := []byte{0x11, 0, 0, 1, 0, 1, 0, 2, 0, 0, 0}
+	packet = append(packet, []byte("Rows matched")...)
+
+	res := setOKResponseInfo(packet, "TX: ab", protocolInfo{})
+
+	assert.Equal(t, []byte{0x0d, 0, 0, 1, 0, 1, 0, 2, 0, 0, 0, 'T', 'X', ':', ' ', 'a', 'b'}, res)
+	assert.True(t, isSinglePacket(res))
+
+	decoded, err := decodeOkResponse(res)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), decoded.AffectedRows)
+
+	// with session track info is length encoded and session state is removed
+	protocol := protocolInfo{
+		clientInfo: &handshakeResponse41{ClientCapabilities: clientSessionTrack},
+		serverInfo: &handshakeV10{ServerCapabilities: clientSessionTrack}}
+
+	packet = []byte{0x0c, 0, 0, 1, 0, 0, 0, 2, 0x40, 0, 0, 0, 3, 1, 2, 3}
+
+	res = setOKResponseInfo(packet, "TX", protocol)
+
+	assert.Equal(t, []byte{0x0a, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 2, 'T', 'X'}, res)
+
+	// not OK packet is not changed
+	packet = []byte{0x03, 0, 0, 1, 0xff, 1, 2}
+	assert.Equal(t, packet, setOKResponseInfo(packet, "TX", protocol))
+}
+
+func TestTypedRowsResponse(t *testing.T) {
+	r := NewCustomDataTypedRowsResponse([]CustomResponseColumn{
+		{"height", ColumnTypeInteger},
+		{"hash", ColumnTypeText},
+		{"time", ColumnTypeDateTime}}, [][]string{{"1", "ab", "2018-01-02 03:04:05"}}).(*customResponseRows)
+
+	// type is 6th byte from the end of a column definition
+	types := []byte{0x08, 0xfc, 0x0c}
+
+	for i, c := range r.columns {
+		packet := r.getColumnDefPacket("BC", "CustomResponse", c, r.getColumnType(i))
+		assert.Equal(t, types[i], packet[len(packet)-6])
+	}
+
+	// PostgreSQL type OIDs follow column name in row description
+	desc := r.getPGRowDescription()
+	pos := 7 + len("height") + 1 + 6
+	assert.Equal(t, []byte{0, 0, 0, pgTypeInt8}, desc[pos:pos+4])
+
+	// untyped columns are text
+	r = NewCustomDataRowsResponse([]string{"a"}, [][]string{{"1"}}).(*customResponseRows)
+	assert.Equal(t, ColumnTypeText, r.getColumnType(0))
+}
@@ -2,6 +2,7 @@ package dbproxy
 
 import (
 	"log"
+	"time"
 )
 
 type DBProxyInterface interface {
@@ -32,6 +33,22 @@ type CustomResponseKeyValue struct {
 	Value string
 }
 
+// Type of a column in custom rows response. Values are always passed as strings,
+// a type only tells a client how to interpret them
+type CustomResponseColumnType int
+
+const (
+	ColumnTypeText CustomResponseColumnType = iota
+	ColumnTypeInteger
+	ColumnTypeDecimal
+	ColumnTypeDateTime // values in format "2006-01-02 15:04:05"
+)
+
+type CustomResponseColumn struct {
+	Name string
+	Type CustomResponseColumnType
+}
+
 type RequestQueryFilterCallback func(query string, sessionID string) (CustomRequestActionInterface, error)
 type ResponseFilterCallback func(sessionID string, err error)
 
@@ -42,6 +59,34 @@ type DBProxyFilter interface {
 	ResponseCallback(sessionID string, err error)
 }
 
+// Optional interface for a proxy which can keep backend connections for reuse
+// size is max number of idle connections. 0 means no pool. maxOpen is max number of all
+// backend connections, 0 means no limit
+type DBProxyPoolInterface interface {
+	SetPool(size int, maxOpen int, idleTimeout time.Duration)
+}
+
+// Optional interface for a filter structure. Returned string is set as info
+// of OK response sent to a client. Empty string means a response is not changed
+type DBProxyResponseInfoFilter interface {
+	GetResponseInfo(sessionID string) string
+}
+
+// Information about a client session. It is known after a client is authorised
+type DBProxySessionInfo struct {
+	SessionID     string
+	ClientAddress string
+	User          string
+	Database      string
+}
+
+// Optional interface for a filter structure. If a filter implements it
+// a proxy informs it about client sessions
+type DBProxySessionFilter interface {
+	SessionStarted(info DBProxySessionInfo)
+	SessionClosed(sessionID string)
+}
+
 // Custom responses constructors
 // Make new Custom Error Response
 func NewCustomErrorResponse(err string, code uint16) CustomRequestActionInterface {
@@ -51,11 +96,36 @@ func NewCustomErrorResponse(err string, code uint16) CustomRequestActionInterfac
 	return &r
 }
 func NewCustomDataKeyValueResponse(rows []CustomResponseKeyValue) CustomRequestActionInterface {
-	r := customResponseRowsKeyValues{}
+	r := customResponseRows{}
+	r.columns = []string{"Key", "Value"}
+	r.rows = [][]string{}
+
+	for _, row := range rows {
+		r.rows = append(r.rows, []string{row.Key, row.Value})
+	}
+	return &r
+}
+
+// Rows with any list of columns. Each row must have a value for each column
+func NewCustomDataRowsResponse(columns []string, rows [][]string) CustomRequestActionInterface {
+	r := customResponseRows{}
+	r.columns = columns
 	r.rows = rows
 	return &r
 }
 
+// Rows with typed columns
+func NewCustomDataTypedRowsResponse(columns []CustomResponseColumn, rows [][]string) CustomRequestActionInterface {
+	r := customResponseRows{}
+	r.rows = rows
+
+	for _, c := range columns {
+		r.columns = append(r.columns, c.Name)
+		r.types = append(r.types, c.Type)
+	}
+	return &r
+}
+
 func NewCustomOKResponse(ar uint) CustomRequestActionInterface {
 	r := customResponseOK{}
 	r.rowsUpdated = ar
@@ -0,0 +1,431 @@
+package dbproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pool of backend MySQL connections. A connection is returned to the pool when a client
+// sends COM_QUIT. Before to return it the session is reset with COM_RESET_CONNECTION.
+// A new client gets the handshake of the pooled connection. Auth data of its handshake response
+// is not used because the seed in the handshake is same for all clients of the connection
+// and the response could be replayed. A server gets COM_CHANGE_USER with unknown auth plugin,
+// so it asks to switch the plugin and sends new seed. A client authenticates with this seed.
+// A server which sends same seed again can not be used with the pool
+
+const (
+	poolAliveCheckTimeout = 2 * time.Second
+	poolWaitTimeout       = 10 * time.Second // how long a client waits for a connection when all are busy
+	// auth plugin name in COM_CHANGE_USER. A server doesn't know it and sends AuthSwitchRequest
+	poolChangeUserPlugin = "oursql_proxy_new_seed"
+)
+
+type backendConn struct {
+	conn      net.Conn
+	handshake []byte // initial handshake packet received from a server
+	idleSince time.Time
+}
+
+type backendPool struct {
+	size        int // max number of idle connections
+	maxOpen     int // max number of all open connections. 0 means no limit
+	open        int
+	idleTimeout time.Duration
+	idle        []*backendConn
+	lock        sync.Mutex
+	released    *sync.Cond // signalled when a connection is closed or returned to the pool
+	traceLog    *log.Logger
+}
+
+func newBackendPool(size int, maxOpen int, idleTimeout time.Duration, traceLog *log.Logger) *backendPool {
+	bp := &backendPool{size: size, maxOpen: maxOpen, idleTimeout: idleTimeout, traceLog: traceLog}
+	bp.released = sync.NewCond(&bp.lock)
+	return bp
+}
+
+// Open new connection to a server
+func dialBackend(address string) (*backendConn, error) {
+	var conn net.Conn
+	var err error
+
+	if strings.HasPrefix(address, "/") {
+		// unix socket connection
+		conn, err = net.Dial("unix", address)
+	} else {
+		// tcp connection
+		conn, err = net.Dial("tcp", address)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return &backendConn{conn: conn}, nil
+}
+
+// Returns idle connection. If there is no any, nil is returned and a place for new connection
+// is reserved. Waits while all connections are busy and the limit of open connections is reached
+func (bp *backendPool) get() (*backendConn, error) {
+	deadline := time.Now().Add(poolWaitTimeout)
+
+	// wake up waiting when the timeout is over
+	timer := time.AfterFunc(poolWaitTimeout, func() {
+		bp.lock.Lock()
+		bp.released.Broadcast()
+		bp.lock.Unlock()
+	})
+	defer timer.Stop()
+
+	for {
+		bp.lock.Lock()
+
+		for len(bp.idle) == 0 && bp.maxOpen > 0 && bp.open >= bp.maxOpen {
+			if time.Now().After(deadline) {
+				bp.lock.Unlock()
+				return nil, errors.New(fmt.Sprintf("All %d MySQL connections are busy", bp.maxOpen))
+			}
+			bp.released.Wait()
+		}
+
+		if len(bp.idle) == 0 {
+			bp.open++
+			bp.lock.Unlock()
+			return nil, nil
+		}
+		// last added is used first
+		bc := bp.idle[len(bp.idle)-1]
+		bp.idle = bp.idle[:len(bp.idle)-1]
+
+		bp.lock.Unlock()
+
+		if bp.isExpired(bc) {
+			bp.discard(bc)
+			continue
+		}
+
+		// server could close it by own timeout
+		if err := bc.sendCommand([]byte{comPing}); err != nil {
+			bp.traceLog.Printf("Pooled connection is dead: %s", err.Error())
+			bp.discard(bc)
+			continue
+		}
+		return bc, nil
+	}
+}
+
+// Close a connection and free its place in the pool. bc is nil when new connection could not be opened
+func (bp *backendPool) discard(bc *backendConn) {
+	if bc != nil {
+		bc.conn.Close()
+	}
+
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+
+	bp.open--
+	bp.released.Signal()
+}
+
+// Reset a connection and return it to the pool. Connection is closed if reset fails
+func (bp *backendPool) put(bc *backendConn) {
+	if len(bc.handshake) == 0 {
+		bp.discard(bc)
+		return
+	}
+
+	if err := bc.sendCommand([]byte{comResetConnection}); err != nil {
+		bp.traceLog.Printf("Connection reset failed: %s", err.Error())
+		bp.discard(bc)
+		return
+	}
+
+	bp.lock.Lock()
+
+	if len(bp.idle) >= bp.size {
+		bp.lock.Unlock()
+		bp.discard(bc)
+		return
+	}
+
+	bc.idleSince = time.Now()
+	bp.idle = append(bp.idle, bc)
+	bp.released.Signal()
+
+	bp.traceLog.Printf("Connection returned to pool. Idle connections %d", len(bp.idle))
+
+	bp.lock.Unlock()
+}
+
+func (bp *backendPool) isExpired(bc *backendConn) bool {
+	return bp.idleTimeout > 0 && time.Since(bc.idleSince) > bp.idleTimeout
+}
+
+// Close connections which are idle too long
+func (bp *backendPool) cleanIdle() {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+
+	list := []*backendConn{}
+
+	for _, bc := range bp.idle {
+		if bp.isExpired(bc) {
+			bc.conn.Close()
+			bp.open--
+			continue
+		}
+		list = append(list, bc)
+	}
+	bp.idle = list
+	bp.released.Broadcast()
+}
+
+// Close all idle connections
+func (bp *backendPool) close() {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+
+	for _, bc := range bp.idle {
+		bc.conn.Close()
+	}
+	bp.open -= len(bp.idle)
+	bp.idle = nil
+	bp.released.Broadcast()
+}
+
+// Send a command with empty body and wait for OK
+func (bc *backendConn) sendCommand(command []byte) error {
+	bc.conn.SetDeadline(time.Now().Add(poolAliveCheckTimeout))
+	defer bc.conn.SetDeadline(time.Time{})
+
+	packet := []byte{byte(len(command)), 0, 0, 0}
+
+	if _, err := writePacket(append(packet, command...), bc.conn); err != nil {
+		return err
+	}
+
+	response, err := readPacket(bc.conn)
+
+	if err != nil {
+		return err
+	}
+
+	if getPacketType(response) != responseOk {
+		return errors.New(fmt.Sprintf("Unexpected response %x", getPacketType(response)))
+	}
+	return nil
+}
+
+// Authenticate new client on pooled connection. The client gets handshake of the connection,
+// then its user and database are passed to a server as COM_CHANGE_USER without auth data.
+// New seed from a server goes to the client in AuthSwitchRequest
+func (bc *backendConn) changeUser(client net.Conn) (*handshakeResponse41, error) {
+	if _, err := writePacket(removeSSLCapability(bc.handshake), client); err != nil {
+		return nil, err
+	}
+
+	packet, err := readPacket(client)
+
+	if err != nil {
+		return nil, err
+	}
+
+	clientInfo, err := decodeHandshakeResponse41(packet)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if clientInfo.ClientCapabilities&clientSSL != 0 {
+		return nil, errors.New("SSL is not supported on pooled connections")
+	}
+
+	if clientInfo.ClientCapabilities&clientPluginAuth == 0 {
+		return nil, errors.New("Client without auth plugins support can not use pooled connections")
+	}
+
+	request := *clientInfo
+	request.AuthResponse = nil
+	request.AuthPluginName = poolChangeUserPlugin
+
+	changeUser, err := encodeChangeUser(&request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := writePacket(changeUser, bc.conn); err != nil {
+		return nil, err
+	}
+
+	response, err := readPacket(bc.conn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch getPacketType(response) {
+	case responseOk, responseErr:
+	case responseEof:
+		if bytes.Equal(getAuthSwitchSeed(response), getHandshakeSeed(bc.handshake)) {
+			return nil, errors.New("Server sent same auth seed on COM_CHANGE_USER")
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Unexpected response %x on COM_CHANGE_USER", getPacketType(response)))
+	}
+
+	// relay authentication packets till OK or error. A client is one step ahead
+	// in packets sequence because it has sent handshake response
+	for {
+		response[3]++
+
+		if _, err := writePacket(response, client); err != nil {
+			return nil, err
+		}
+
+		switch getPacketType(response) {
+		case responseOk:
+			return clientInfo, nil
+		case responseErr:
+			decoded, _ := decodeErrResponse(response)
+			return nil, errors.New(decoded)
+		}
+
+		// on fast auth success OK follows without client reply
+		if len(response) <= 5 || response[4] != 0x01 || response[5] != 0x03 {
+			reply, err := readPacket(client)
+
+			if err != nil {
+				return nil, err
+			}
+
+			reply[3]--
+
+			if _, err := writePacket(reply, bc.conn); err != nil {
+				return nil, err
+			}
+		}
+
+		response, err = readPacket(bc.conn)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Returns auth seed of a server handshake. It is in 2 parts
+func getHandshakeSeed(handshake []byte) []byte {
+	// header, protocol version, then server version is null terminated string
+	pos := 5
+
+	for pos < len(handshake) && handshake[pos] != 0 {
+		pos++
+	}
+	// skip zero byte and connection ID
+	pos += 1 + 4
+
+	if pos+8 > len(handshake) {
+		return nil
+	}
+	seed := append([]byte{}, handshake[pos:pos+8]...)
+
+	// filler, capabilities, charset, status, capabilities 2nd part
+	pos += 8 + 1 + 2 + 1 + 2 + 2
+
+	if pos >= len(handshake) {
+		return seed
+	}
+
+	// 2nd part is at least 13 bytes. It includes zero byte at the end
+	length := int(handshake[pos]) - 8
+
+	if length < 13 {
+		length = 13
+	}
+	// skip auth data length and reserved bytes
+	pos += 1 + 10
+
+	end := pos + length
+
+	if end > len(handshake) {
+		end = len(handshake)
+	}
+	if pos < end {
+		seed = append(seed, handshake[pos:end]...)
+	}
+	return bytes.TrimRight(seed, "\x00")
+}
+
+// Returns auth seed of AuthSwitchRequest packet. It follows null terminated plugin name
+func getAuthSwitchSeed(packet []byte) []byte {
+	pos := 5
+
+	for pos < len(packet) && packet[pos] != 0 {
+		pos++
+	}
+	pos++
+
+	if pos >= len(packet) {
+		return nil
+	}
+	return bytes.TrimRight(packet[pos:], "\x00")
+}
+
+// Returns copy of a server handshake where SSL capability is not set
+// so a client will not try to start SSL session
+func removeSSLCapability(handshake []byte) []byte {
+	res := append([]byte{}, handshake...)
+
+	// header, protocol version, then server version is null terminated string
+	pos := 5
+
+	for pos < len(res) && res[pos] != 0 {
+		pos++
+	}
+	// skip zero byte, connection ID, auth data part 1 and filler
+	pos += 1 + 4 + 8 + 1
+
+	if pos+2 > len(res) {
+		return res
+	}
+
+	capabilities := uint32(res[pos]) | uint32(res[pos+1])<<8
+	capabilities &^= clientSSL
+
+	res[pos] = byte(capabilities)
+	res[pos+1] = byte(capabilities >> 8)
+
+	return res
+}
+
+// Build COM_CHANGE_USER packet from client handshake response
+func encodeChangeUser(hr *handshakeResponse41) ([]byte, error) {
+	// length of auth data is one byte in COM_CHANGE_USER
+	if len(hr.AuthResponse) > 255 {
+		return nil, errors.New(fmt.Sprintf("Auth response is too long for COM_CHANGE_USER: %d bytes", len(hr.AuthResponse)))
+	}
+
+	payload := []byte{comChangeUser}
+
+	payload = append(payload, []byte(hr.Username)...)
+	payload = append(payload, 0)
+	payload = append(payload, byte(len(hr.AuthResponse)))
+	payload = append(payload, hr.AuthResponse...)
+	payload = append(payload, []byte(hr.Database)...)
+	payload = append(payload, 0)
+	payload = append(payload, hr.ClientCharset, 0)
+
+	if hr.AuthPluginName != "" {
+		payload = append(payload, []byte(hr.AuthPluginName)...)
+		payload = append(payload, 0)
+	}
+
+	l := len(payload)
+
+	return append([]byte{byte(l), byte(l >> 8), byte(l >> 16), 0}, payload...), nil
+}
@@ -0,0 +1,490 @@
+package dbproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Minimal MySQL backend. Accepts any user, answers OK on all commands
+// and remembers commands it received. On COM_CHANGE_USER it asks to switch auth plugin
+// and sends new seed, or seed of a handshake if sameSeed is set
+type testMySQLBackend struct {
+	listener    net.Listener
+	lock        sync.Mutex
+	connections int
+	commands    []byte
+	users       []string
+	plugins     []string // auth plugins in COM_CHANGE_USER
+	sameSeed    bool
+}
+
+func newTestMySQLBackend(t *testing.T) *testMySQLBackend {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &testMySQLBackend{listener: l}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+			b.lock.Lock()
+			b.connections++
+			b.lock.Unlock()
+
+			go b.handle(conn)
+		}
+	}()
+	return b
+}
+
+func (b *testMySQLBackend) handle(conn net.Conn) {
+	defer conn.Close()
+
+	writePacket(testMySQLHandshake(), conn)
+
+	packet, err := readPacket(conn)
+
+	if err != nil {
+		return
+	}
+	hr, _ := decodeHandshakeResponse41(packet)
+
+	b.lock.Lock()
+	b.users = append(b.users, hr.Username)
+	b.lock.Unlock()
+
+	writePacket(testMySQLOK(2), conn)
+
+	for {
+		packet, err := readPacket(conn)
+
+		if err != nil {
+			return
+		}
+		b.lock.Lock()
+		b.commands = append(b.commands, packet[4])
+
+		if packet[4] == comChangeUser {
+			fields := bytes.Split(packet[5:], []byte{0})
+			b.users = append(b.users, string(fields[0]))
+			b.plugins = append(b.plugins, string(fields[len(fields)-2]))
+		}
+		sameSeed := b.sameSeed
+		b.lock.Unlock()
+
+		if packet[4] == comQuit {
+			return
+		}
+
+		if packet[4] == comChangeUser {
+			seed := testMySQLNewSeed
+
+			if sameSeed {
+				seed = testMySQLSeed
+			}
+			writePacket(testMySQLAuthSwitch(seed), conn)
+
+			if _, err := readPacket(conn); err != nil {
+				return
+			}
+			writePacket(testMySQLOK(3), conn)
+			continue
+		}
+		writePacket(testMySQLOK(1), conn)
+	}
+}
+
+var testMySQLSeed = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+var testMySQLNewSeed = []byte{21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40}
+
+func testMySQLAuthSwitch(seed []byte) []byte {
+	payload := []byte{responseEof}
+	payload = append(payload, []byte("mysql_native_password")...)
+	payload = append(payload, 0)
+	payload = append(payload, seed...)
+	payload = append(payload, 0)
+
+	return append([]byte{byte(len(payload)), 0, 0, 1}, payload...)
+}
+
+func testMySQLOK(seq byte) []byte {
+	return []byte{7, 0, 0, seq, responseOk, 0, 0, 2, 0, 0, 0}
+}
+
+func testMySQLHandshake() []byte {
+	capabilities := clientProtocol41 | clientSecureConnection | clientPluginAuth | clientSSL | clientConnectWithDB
+
+	payload := []byte{10}
+	payload = append(payload, []byte("5.7.0-test")...)
+	payload = append(payload, 0)
+	payload = append(payload, 1, 0, 0, 0) // connection id
+	payload = append(payload, testMySQLSeed[:8]...)
+	payload = append(payload, 0) // filler
+	payload = append(payload, byte(capabilities), byte(capabilities>>8))
+	payload = append(payload, 0x21, 2, 0)
+	payload = append(payload, byte(capabilities>>16), byte(capabilities>>24))
+	payload = append(payload, 21)
+	payload = append(payload, make([]byte, 10)...)
+	payload = append(payload, testMySQLSeed[8:]...)
+	payload = append(payload, 0)
+	payload = append(payload, []byte("mysql_native_password")...)
+	payload = append(payload, 0)
+
+	return append([]byte{byte(len(payload)), 0, 0, 0}, payload...)
+}
+
+func testMySQLHandshakeResponse(user string) []byte {
+	capabilities := clientProtocol41 | clientSecureConnection | clientPluginAuth | clientConnectWithDB
+
+	payload := make([]byte, 4)
+	binary.LittleEndian.PutUint32(payload, capabilities)
+	payload = append(payload, 0, 0, 0, 1, 0x21)
+	payload = append(payload, make([]byte, 23)...)
+	payload = append(payload, []byte(user)...)
+	payload = append(payload, 0, 3, 'a', 'b', 'c')
+	payload = append(payload, []byte("db")...)
+	payload = append(payload, 0)
+	payload = append(payload, []byte("mysql_native_password")...)
+	payload = append(payload, 0)
+
+	return append([]byte{byte(len(payload)), 0, 0, 1}, payload...)
+}
+
+func TestEncodeChangeUser(t *testing.T) {
+	hr, err := decodeHandshakeResponse41(testMySQLHandshakeResponse("user1"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", hr.Username)
+	assert.Equal(t, "db", hr.Database)
+	assert.Equal(t, []byte("abc"), hr.AuthResponse)
+	assert.Equal(t, "mysql_native_password", hr.AuthPluginName)
+
+	packet, err := encodeChangeUser(hr)
+
+	assert.NoError(t, err)
+	assert.Equal(t, len(packet)-4, int(packet[0]))
+	assert.Equal(t, comChangeUser, packet[4])
+	assert.Equal(t, "user1\x00\x03abcdb\x00\x21\x00mysql_native_password\x00", string(packet[5:]))
+
+	// length of auth data is one byte
+	hr.AuthResponse = make([]byte, 256)
+
+	_, err = encodeChangeUser(hr)
+	assert.Error(t, err)
+}
+
+func TestAuthSeed(t *testing.T) {
+	assert.Equal(t, testMySQLSeed, getHandshakeSeed(testMySQLHandshake()))
+	assert.Equal(t, testMySQLNewSeed, getAuthSwitchSeed(testMySQLAuthSwitch(testMySQLNewSeed)))
+}
+
+func testMySQLPacket(seq byte, body ...byte) []byte {
+	return append([]byte{byte(len(body)), byte(len(body) >> 8), byte(len(body) >> 16), seq}, body...)
+}
+
+func TestResponseTracker(t *testing.T) {
+	tr := responseTracker{}
+
+	tr.start(comQuery, false)
+	assert.True(t, tr.write(testMySQLOK(1)))
+
+	tr.start(comQuery, false)
+	assert.True(t, tr.write([]byte{3, 0, 0, 1, responseErr, 1, 2}))
+
+	// column count, column, EOF, row and EOF
+	tr.start(comQuery, false)
+	resultset := testMySQLPacket(1, 1)
+	assert.False(t, tr.write(resultset))
+
+	resultset = testMySQLPacket(2, 3, 'd', 'e', 'f')
+	assert.False(t, tr.write(resultset))
+
+	resultset = testMySQLPacket(3, responseEof, 0, 0, 2, 0)
+	assert.False(t, tr.write(resultset))
+
+	resultset = testMySQLPacket(4, 1, 'a')
+	assert.False(t, tr.write(resultset))
+
+	resultset = testMySQLPacket(5, responseEof, 0, 0, 2, 0)
+
+	// part of a packet
+	assert.False(t, tr.write(resultset[:len(resultset)-1]))
+	assert.True(t, tr.write(resultset[len(resultset)-1:]))
+}
+
+func TestResponseTrackerRows(t *testing.T) {
+	tr := responseTracker{}
+
+	// text row with empty first column
+	tr.start(comQuery, false)
+	data := testMySQLPacket(1, 2)
+	data = append(data, testMySQLPacket(2, 3, 'd', 'e', 'f')...)
+	data = append(data, testMySQLPacket(3, 3, 'd', 'e', 'f')...)
+	data = append(data, testMySQLPacket(4, responseEof, 0, 0, 2, 0)...)
+	data = append(data, testMySQLPacket(5, 0, 1, 'a')...)
+	assert.False(t, tr.write(data))
+
+	// byte by byte
+	data = testMySQLPacket(6, responseEof, 0, 0, 2, 0)
+
+	for i := 0; i < len(data)-1; i++ {
+		assert.False(t, tr.write(data[i:i+1]))
+	}
+	assert.True(t, tr.write(data[len(data)-1:]))
+
+	// binary rows of prepared statement, EOF deprecated
+	tr.start(comStmtExecute, true)
+	data = testMySQLPacket(1, 1)
+	data = append(data, testMySQLPacket(2, 3, 'd', 'e', 'f')...)
+	data = append(data, testMySQLPacket(3, 0, 0, 1, 'a')...)
+	assert.False(t, tr.write(data))
+
+	assert.False(t, tr.write(testMySQLPacket(4, 0, 0, 1, 'b')))
+	assert.True(t, tr.write(testMySQLPacket(5, responseEof, 0, 0, 2, 0, 0, 0)))
+	assert.True(t, tr.isComplete())
+}
+
+func TestResponseTrackerMultiResults(t *testing.T) {
+	tr := responseTracker{}
+
+	tr.start(comQuery, false)
+
+	// OK with more results flag
+	assert.False(t, tr.write(testMySQLPacket(1, responseOk, 0, 0, 8, 0, 0, 0)))
+
+	data := testMySQLPacket(2, 1)
+	data = append(data, testMySQLPacket(3, 3, 'd', 'e', 'f')...)
+	data = append(data, testMySQLPacket(4, responseEof, 0, 0, 2, 0)...)
+	data = append(data, testMySQLPacket(5, 1, 'a')...)
+	data = append(data, testMySQLPacket(6, responseEof, 0, 0, 8, 0)...)
+	assert.False(t, tr.write(data))
+
+	assert.True(t, tr.write(testMySQLPacket(7, responseOk, 0, 0, 2, 0, 0, 0)))
+}
+
+func TestResponseTrackerPrepare(t *testing.T) {
+	tr := responseTracker{}
+
+	// 2 columns, 1 param
+	tr.start(comStmtPrepare, false)
+	assert.False(t, tr.write(testMySQLPacket(1, responsePrepareOk, 1, 0, 0, 0, 2, 0, 1, 0, 0, 0, 0)))
+	assert.False(t, tr.write(testMySQLPacket(2, 3, 'd', 'e', 'f')))
+	assert.False(t, tr.write(testMySQLPacket(3, responseEof, 0, 0, 2, 0)))
+	assert.False(t, tr.write(testMySQLPacket(4, 3, 'd', 'e', 'f')))
+	assert.False(t, tr.write(testMySQLPacket(5, 3, 'd', 'e', 'f')))
+	assert.True(t, tr.write(testMySQLPacket(6, responseEof, 0, 0, 2, 0)))
+
+	// no columns and params
+	tr.start(comStmtPrepare, false)
+	assert.True(t, tr.write(testMySQLPacket(1, responsePrepareOk, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)))
+
+	// EOF deprecated
+	tr.start(comStmtPrepare, true)
+	assert.False(t, tr.write(testMySQLPacket(1, responsePrepareOk, 1, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0)))
+	assert.False(t, tr.write(testMySQLPacket(2, 3, 'd', 'e', 'f')))
+	assert.True(t, tr.write(testMySQLPacket(3, 3, 'd', 'e', 'f')))
+}
+
+func TestResponseTrackerConnection(t *testing.T) {
+	tr := responseTracker{}
+
+	// handshake, auth switch, OK
+	assert.False(t, tr.write(testMySQLHandshake()))
+	assert.False(t, tr.write(testMySQLAuthSwitch(testMySQLNewSeed)))
+	assert.True(t, tr.write(testMySQLOK(4)))
+}
+
+func TestBackendPoolMaxOpen(t *testing.T) {
+	pool := newBackendPool(1, 1, time.Minute, log.New(ioutil.Discard, "", 0))
+
+	bc, err := pool.get()
+	assert.NoError(t, err)
+	assert.Nil(t, bc)
+
+	got := make(chan bool)
+
+	go func() {
+		pool.get()
+		close(got)
+	}()
+
+	select {
+	case <-got:
+		t.Fatal("Connection is got over the limit")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// first connection is closed. its place is free
+	pool.discard(nil)
+
+	select {
+	case <-got:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connection is not got after other is closed")
+	}
+}
+
+func TestRemoveSSLCapability(t *testing.T) {
+	handshake := testMySQLHandshake()
+
+	info, err := decodeHandshakeV10(removeSSLCapability(handshake))
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), info.ServerCapabilities&clientSSL)
+	assert.NotEqual(t, uint32(0), info.ServerCapabilities&clientPluginAuth)
+
+	// original is not changed
+	info, _ = decodeHandshakeV10(handshake)
+	assert.NotEqual(t, uint32(0), info.ServerCapabilities&clientSSL)
+}
+
+func startTestMySQLProxy(t *testing.T, backend *testMySQLBackend) (DBProxyInterface, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	proxyAddr := l.Addr().String()
+	l.Close()
+
+	proxy, err := NewMySQLProxy(proxyAddr, backend.listener.Addr().String())
+	assert.NoError(t, err)
+
+	proxy.SetLoggers(log.New(ioutil.Discard, "", 0), log.New(ioutil.Discard, "", 0))
+	proxy.(DBProxyPoolInterface).SetPool(2, 0, time.Minute)
+
+	assert.NoError(t, proxy.Init())
+	assert.NoError(t, proxy.Run())
+
+	return proxy, proxyAddr
+}
+
+// Connect to a proxy, authenticate and quit. Returns false if authentication failed
+func testMySQLSession(t *testing.T, proxyAddr string, user string) bool {
+	var client net.Conn
+	var err error
+
+	for i := 0; i < 10; i++ {
+		client, err = net.Dial("tcp", proxyAddr)
+
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	handshake, err := readPacket(client)
+	assert.NoError(t, err)
+
+	writePacket(testMySQLHandshakeResponse(user), client)
+
+	response, err := readPacket(client)
+
+	if err != nil {
+		return false
+	}
+
+	if response[4] == responseEof {
+		// pooled connection. auth with new seed
+		assert.Equal(t, byte(2), response[3])
+		assert.NotEqual(t, getHandshakeSeed(handshake), getAuthSwitchSeed(response))
+
+		writePacket([]byte{3, 0, 0, 3, 'x', 'y', 'z'}, client)
+
+		response, err = readPacket(client)
+		assert.NoError(t, err)
+		assert.Equal(t, byte(4), response[3])
+	} else {
+		assert.Equal(t, byte(2), response[3])
+	}
+	assert.Equal(t, byte(responseOk), response[4])
+
+	writePacket([]byte{1, 0, 0, 0, comQuit}, client)
+
+	return true
+}
+
+// wait while a connection is returned to the pool
+func waitTestPoolIdle(proxy DBProxyInterface) {
+	pool := proxy.(*mysqlProxy).pool
+
+	for i := 0; i < 20; i++ {
+		pool.lock.Lock()
+		idle := len(pool.idle)
+		pool.lock.Unlock()
+
+		if idle > 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestMySQLProxyPool(t *testing.T) {
+	backend := newTestMySQLBackend(t)
+	defer backend.listener.Close()
+
+	proxy, proxyAddr := startTestMySQLProxy(t, backend)
+	defer proxy.Stop()
+
+	assert.True(t, testMySQLSession(t, proxyAddr, "user1"))
+
+	waitTestPoolIdle(proxy)
+
+	assert.True(t, testMySQLSession(t, proxyAddr, "user2"))
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	assert.Equal(t, 1, backend.connections)
+	assert.Equal(t, []string{"user1", "user2"}, backend.users)
+	assert.Equal(t, []string{poolChangeUserPlugin}, backend.plugins)
+	assert.Equal(t, []byte{comResetConnection, comPing, comChangeUser}, backend.commands[:3])
+}
+
+func TestMySQLProxyPoolSameSeed(t *testing.T) {
+	backend := newTestMySQLBackend(t)
+	backend.sameSeed = true
+	defer backend.listener.Close()
+
+	proxy, proxyAddr := startTestMySQLProxy(t, backend)
+	defer proxy.Stop()
+
+	assert.True(t, testMySQLSession(t, proxyAddr, "user1"))
+
+	waitTestPoolIdle(proxy)
+
+	// captured auth response could be replayed with same seed. The connection is not reused
+	assert.False(t, testMySQLSession(t, proxyAddr, "user2"))
+
+	assert.True(t, testMySQLSession(t, proxyAddr, "user3"))
+
+	pool := proxy.(*mysqlProxy).pool
+
+	pool.lock.Lock()
+	assert.True(t, pool.open <= 1)
+	pool.lock.Unlock()
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	assert.Equal(t, 2, backend.connections)
+}
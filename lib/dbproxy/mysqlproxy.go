@@ -7,7 +7,8 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
-	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // proxy implements server for capturing and forwarding MySQL traffic.
@@ -22,6 +23,10 @@ type mysqlProxy struct {
 	state            byte
 	stopChan         chan bool
 	completeChan     chan bool
+	pool             *backendPool
+	poolSize         int
+	poolMaxOpen      int
+	poolIdleTimeout  time.Duration
 }
 
 func NewMySQLProxy(proxyHost, mysqlHost string) (DBProxyInterface, error) {
@@ -74,6 +79,13 @@ func (p *mysqlProxy) SetLoggers(t *log.Logger, e *log.Logger) {
 	p.errorLog = e
 }
 
+// Keep backend connections after clients quit. Must be called before Run
+func (p *mysqlProxy) SetPool(size int, maxOpen int, idleTimeout time.Duration) {
+	p.poolSize = size
+	p.poolMaxOpen = maxOpen
+	p.poolIdleTimeout = idleTimeout
+}
+
 // run starts accepting TCP connection and forwarding it to MySQL server.
 // Each incoming TCP connection is handled in own goroutine.
 func (p *mysqlProxy) Run() error {
@@ -93,6 +105,12 @@ func (p *mysqlProxy) Run() error {
 	// state before to start listener
 	p.state = 1
 
+	if p.poolSize > 0 {
+		p.pool = newBackendPool(p.poolSize, p.poolMaxOpen, p.poolIdleTimeout, p.traceLog)
+
+		go p.cleanPool()
+	}
+
 	go func() {
 		defer listener.Close()
 
@@ -162,6 +180,28 @@ func (p *mysqlProxy) Stop() error {
 	return nil
 }
 
+// Close idle connections by timeout and all connections when the proxy stops
+func (p *mysqlProxy) cleanPool() {
+	interval := p.poolIdleTimeout / 2
+
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopChan:
+			p.pool.close()
+			return
+		case <-ticker.C:
+			p.pool.cleanIdle()
+		}
+	}
+}
+
 func (p *mysqlProxy) IsStopped() bool {
 	return p.state == 3
 }
@@ -177,41 +217,119 @@ func (p *mysqlProxy) handleConnection(client net.Conn) {
 
 	defer client.Close()
 
-	// New connection to MySQL is made per each incoming TCP request to proxy server.
-	var server net.Conn
+	var backend *backendConn
 	var err error
 
-	if strings.HasPrefix(p.mysqlHost, "/") {
-		// unix socket connection
-		server, err = net.Dial("unix", p.mysqlHost)
-	} else {
-		// tcp connection
-		server, err = net.Dial("tcp", p.mysqlHost)
+	reused := false
+
+	if p.pool != nil {
+		backend, err = p.pool.get()
+
+		if err != nil {
+			p.errorLog.Printf("Can not get connection from pool: %s", err.Error())
+			return
+		}
+		reused = backend != nil
 	}
 
-	if err != nil {
+	if backend == nil {
+		// New connection to MySQL is made per each incoming TCP request to proxy server.
+		backend, err = dialBackend(p.mysqlHost)
 
-		p.errorLog.Printf("Can not connect to mysql %s : Error: %s", p.mysqlHost, err.Error())
+		if err != nil {
 
-		// return error to client
-		return
+			p.errorLog.Printf("Can not connect to mysql %s : Error: %s", p.mysqlHost, err.Error())
+
+			if p.pool != nil {
+				p.pool.discard(nil)
+			}
+			// return error to client
+			return
+		}
 	}
-	defer server.Close()
+	server := backend.conn
 
-	p.traceLog.Printf("Connected to MySQL")
+	p.traceLog.Printf("Connected to MySQL. Reused connection: %t", reused)
 
 	sessionID := randString(10)
 
+	defer notifySessionClosed(p.queryFilter, sessionID)
+
 	requestFilter := p.getRequestManager(server, client, sessionID)
+	requestFilter.keepServer = p.pool != nil
 
-	// read request in parallel routine
-	go io.Copy(requestFilter, client)
 	// response manager will be connected to request manager
 	responseFilter := p.getResponseManager(client, sessionID, requestFilter)
 
-	// read response. Response will be first operation
-	io.Copy(responseFilter, server)
+	if reused {
+		clientInfo, err := backend.changeUser(client)
+
+		if err != nil {
+			p.traceLog.Printf("Authentication on pooled connection failed: %s", err.Error())
+			p.pool.discard(backend)
+			return
+		}
+		// handshake is already done
+		requestFilter.protocol.clientInfo = clientInfo
+		requestFilter.parseHandshake(backend.handshake)
+		requestFilter.initialResponseSet = true
+		responseFilter.initialResponseSet = true
+
+		requestFilter.sessionStarted()
+	}
+
+	clientDone := make(chan bool)
+
+	// read request in parallel routine
+	go func() {
+		io.Copy(requestFilter, client)
+		close(clientDone)
+	}()
+
+	if p.pool == nil {
+		// read response. Response will be first operation
+		io.Copy(responseFilter, server)
+		server.Close()
+		return
+	}
+
+	serverDone := make(chan bool)
+
+	go func() {
+		io.Copy(responseFilter, server)
+		close(serverDone)
+	}()
+
+	select {
+	case <-serverDone:
+		// server closed connection. nothing to reuse
+		p.pool.discard(backend)
+		return
+	case <-clientDone:
+	}
+
+	// stop reading from a server
+	server.SetReadDeadline(time.Now())
+	<-serverDone
+	server.SetReadDeadline(time.Time{})
+
+	if !requestFilter.quit {
+		// client disconnected without COM_QUIT. A state of the connection is unknown
+		p.pool.discard(backend)
+		return
+	}
+
+	if requestFilter.isWaitingResponse() {
+		// client quit in the middle of a command. A rest of a response would go to next client
+		p.traceLog.Printf("Client quit before complete response. Server connection is closed")
+		p.pool.discard(backend)
+		return
+	}
 
+	if len(backend.handshake) == 0 {
+		backend.handshake = requestFilter.serverHandshake
+	}
+	p.pool.put(backend)
 }
 
 // Build requestPacketParser object
@@ -248,6 +366,11 @@ type requestPacketParser struct {
 	client             net.Conn
 	sessionID          string
 	initialResponseSet bool
+	keepServer         bool   // server connection will be reused. COM_QUIT is not passed
+	quit               bool   // client sent COM_QUIT
+	serverHandshake    []byte // initial handshake packet of a server
+	waitResponse       int32  // 1 when a command is sent to a server and its response is not complete. Atomic
+	response           responseTracker
 	protocol           protocolInfo
 	requestCallback    RequestQueryFilterCallback
 	queryFilter        DBProxyFilter
@@ -266,6 +389,8 @@ func (pp *requestPacketParser) Write(p []byte) (n int, err error) {
 
 		pp.parseHandshakeClientResponse(p)
 		pp.initialResponseSet = true
+
+		pp.sessionStarted()
 	}
 	// pass request to server or return error response
 
@@ -274,6 +399,12 @@ func (pp *requestPacketParser) Write(p []byte) (n int, err error) {
 
 	switch getPacketType(p) {
 
+	case comQuit:
+		if pp.keepServer {
+			pp.traceLog.Printf("Client quit. Server connection is kept")
+			pp.quit = true
+			return
+		}
 	case comStmtPrepare:
 	case comQuery:
 
@@ -315,7 +446,7 @@ func (pp *requestPacketParser) Write(p []byte) (n int, err error) {
 
 			pp.traceLog.Printf("Send custom request to server. %d bytes", len(packet))
 
-			io.Copy(pp.server, bytes.NewReader(packet))
+			pp.sendToServer(packet)
 		} else {
 
 			packet := customResponse.getPacket()
@@ -328,11 +459,47 @@ func (pp *requestPacketParser) Write(p []byte) (n int, err error) {
 		return
 	}
 	// Default Action
-	io.Copy(pp.server, bytes.NewReader(p))
+	pp.sendToServer(p)
 
 	return
 }
 
+// Send a packet to a server and start waiting for a response
+func (pp *requestPacketParser) sendToServer(p []byte) {
+	if pp.initialResponseSet && pp.response.isComplete() {
+		// a new command. other packets are data for a command in progress (LOCAL INFILE or auth)
+		switch getPacketType(p) {
+		case comStmtClose, comStmtSendLongData:
+			// no response
+		default:
+			pp.response.start(getPacketType(p), pp.protocol.deprecateEOFSet())
+			atomic.StoreInt32(&pp.waitResponse, 1)
+		}
+	} else {
+		atomic.StoreInt32(&pp.waitResponse, 1)
+	}
+	io.Copy(pp.server, bytes.NewReader(p))
+}
+
+// Check if a server still sends a response on last command
+func (pp *requestPacketParser) isWaitingResponse() bool {
+	return atomic.LoadInt32(&pp.waitResponse) == 1
+}
+
+// inform a filter about new session
+func (pp *requestPacketParser) sessionStarted() {
+	info := DBProxySessionInfo{}
+	info.SessionID = pp.sessionID
+	info.ClientAddress = pp.client.RemoteAddr().String()
+
+	if pp.protocol.clientInfo != nil {
+		info.User = pp.protocol.clientInfo.Username
+		info.Database = pp.protocol.clientInfo.Database
+	}
+
+	notifySessionStarted(pp.queryFilter, info)
+}
+
 // extract protocol info from initial handshake
 // this info will be needed to make custom responses later
 func (pp *requestPacketParser) parseHandshake(packet []byte) {
@@ -343,6 +510,7 @@ func (pp *requestPacketParser) parseHandshake(packet []byte) {
 		return
 	}
 	pp.protocol.serverInfo = serverHandshake
+	pp.serverHandshake = append([]byte{}, packet...)
 }
 
 // extract client capabilities from a response on handshake from server
@@ -372,6 +540,8 @@ type responsePacketParser struct {
 
 // Data received from server and it is time to send it to client
 func (pp *responsePacketParser) Write(p []byte) (n int, err error) {
+	n = len(p) // we will return this number for any action
+
 	pp.traceLog.Printf("Write to Response , bytes received %d, type %x\n", len(p), getPacketType(p))
 
 	switch getPacketType(p) {
@@ -400,11 +570,38 @@ func (pp *responsePacketParser) Write(p []byte) (n int, err error) {
 			pp.traceLog.Printf("Initial handshake")
 			pp.requestParser.parseHandshake(p)
 			pp.initialResponseSet = true
+
+		} else if getPacketType(p) == responseOk && isSinglePacket(p) {
+			p = pp.setResponseInfo(p)
 		}
 	}
+	if pp.requestParser.response.write(p) {
+		atomic.StoreInt32(&pp.requestParser.waitResponse, 0)
+	} else {
+		atomic.StoreInt32(&pp.requestParser.waitResponse, 1)
+	}
+
 	pp.traceLog.Printf("Send response to client. %d bytes", len(p))
 
 	io.Copy(pp.client, bytes.NewReader(p))
 
-	return len(p), nil
+	return n, nil
+}
+
+// A filter can add info to OK response
+func (pp *responsePacketParser) setResponseInfo(p []byte) []byte {
+	f, ok := pp.queryFilter.(DBProxyResponseInfoFilter)
+
+	if !ok {
+		return p
+	}
+
+	info := f.GetResponseInfo(pp.sessionID)
+
+	if info == "" {
+		return p
+	}
+	pp.traceLog.Printf("Set response info: %s", info)
+
+	return setOKResponseInfo(p, info, pp.requestParser.protocol)
 }
@@ -0,0 +1,61 @@
+package dbproxy
+
+// PostgreSQL frontend/backend protocol constants
+// https://www.postgresql.org/docs/current/protocol-message-formats.html
+
+const (
+	// codes sent in untyped startup packets instead of protocol version
+	pgProtocolVersion3  = 196608 // 3.0
+	pgCancelRequestCode = 80877102
+	pgSSLRequestCode    = 80877103
+	pgGSSENCRequestCode = 80877104
+
+	// max size of a startup packet. Real one is few hundreds of bytes
+	pgMaxStartupLength = 10000
+	// same limit as PostgreSQL server has
+	pgMaxMessageLength = 1 << 30
+
+	// error code used for errors returned by the proxy itself
+	pgCustomErrorSQLState = "P0001"
+
+	// OIDs of data types used in custom responses. Values are always sent in text format
+	pgTypeText      = 25
+	pgTypeInt8      = 20
+	pgTypeFloat8    = 701
+	pgTypeTimestamp = 1114
+)
+
+// Messages sent by a client (frontend)
+const (
+	pgMsgBind          byte = 'B'
+	pgMsgClose         byte = 'C'
+	pgMsgDescribe      byte = 'D'
+	pgMsgExecute       byte = 'E'
+	pgMsgFunctionCall  byte = 'F'
+	pgMsgFlush         byte = 'H'
+	pgMsgParse         byte = 'P'
+	pgMsgQuery         byte = 'Q'
+	pgMsgSync          byte = 'S'
+	pgMsgTerminate     byte = 'X'
+	pgMsgPasswordReply byte = 'p'
+)
+
+// Messages sent by a server (backend)
+const (
+	pgMsgParseComplete        byte = '1'
+	pgMsgBindComplete         byte = '2'
+	pgMsgCloseComplete        byte = '3'
+	pgMsgCommandComplete      byte = 'C'
+	pgMsgDataRow              byte = 'D'
+	pgMsgEmptyQueryResponse   byte = 'I'
+	pgMsgErrorResponse        byte = 'E'
+	pgMsgNoData               byte = 'n'
+	pgMsgNoticeResponse       byte = 'N'
+	pgMsgParameterDescription byte = 't'
+	pgMsgPortalSuspended      byte = 's'
+	pgMsgReadyForQuery        byte = 'Z'
+	pgMsgRowDescription       byte = 'T'
+
+	// transaction status in ReadyForQuery
+	pgTxStatusIdle byte = 'I'
+)
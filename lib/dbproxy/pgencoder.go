@@ -0,0 +1,144 @@
+package dbproxy
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Encode custom responses for PostgreSQL clients
+// Responses are split on 2 parts because with extended query protocol
+// a client asks for rows description and for rows separately
+
+type pgCustomResponseInterface interface {
+	// RowDescription message. Or NoData if a response has no rows
+	getPGRowDescription() []byte
+	// DataRow messages and CommandComplete
+	getPGExecuteResult() []byte
+}
+
+// ===============================================================
+// Error response
+func (e customResponseError) getPGPacket() []byte {
+	body := []byte{'S'}
+	body = append(body, getPGCString("ERROR")...)
+	body = append(body, 'V')
+	body = append(body, getPGCString("ERROR")...)
+	body = append(body, 'C')
+	body = append(body, getPGCString(pgCustomErrorSQLState)...)
+	body = append(body, 'M')
+	body = append(body, getPGCString(e.Message)...)
+	body = append(body, 'D')
+	body = append(body, getPGCString(fmt.Sprintf("OurSQL error code %d", e.Code))...)
+	body = append(body, 0)
+
+	return encodePGMessage(pgMsgErrorResponse, body)
+}
+
+// =========================================================
+// Rows response
+func (r *customResponseRows) getPGRowDescription() []byte {
+	types := []CustomResponseColumnType{}
+
+	for i := range r.columns {
+		types = append(types, r.getColumnType(i))
+	}
+	return getPGRowDescription(r.columns, types)
+}
+
+func (r *customResponseRows) getPGExecuteResult() []byte {
+	res := []byte{}
+
+	for _, row := range r.rows {
+		res = append(res, getPGDataRow(row)...)
+	}
+
+	return append(res, getPGCommandComplete(fmt.Sprintf("SELECT %d", len(r.rows)))...)
+}
+
+// ===============================================================
+// OK Response
+func (r *customResponseOK) getPGRowDescription() []byte {
+	return encodePGMessage(pgMsgNoData, []byte{})
+}
+
+func (r *customResponseOK) getPGExecuteResult() []byte {
+	return getPGCommandComplete(fmt.Sprintf("UPDATE %d", r.rowsUpdated))
+}
+
+// ===============================================================
+// Helpers
+
+// Columns description. Values are in text format for all types
+func getPGRowDescription(columns []string, types []CustomResponseColumnType) []byte {
+	body := make([]byte, 2)
+	binary.BigEndian.PutUint16(body, uint16(len(columns)))
+
+	for i, c := range columns {
+		body = append(body, getPGCString(c)...)
+
+		field := make([]byte, 18)
+		// table OID (4) and column number (2) stay 0
+		oid, size := getPGType(types[i])
+		binary.BigEndian.PutUint32(field[6:10], oid)
+		// type size -1 means variable length
+		binary.BigEndian.PutUint16(field[10:12], size)
+		// type modifier -1
+		binary.BigEndian.PutUint32(field[12:16], 0xffffffff)
+		// format code (2) 0 is text
+
+		body = append(body, field...)
+	}
+
+	return encodePGMessage(pgMsgRowDescription, body)
+}
+
+// Type OID and size for a column type
+func getPGType(t CustomResponseColumnType) (uint32, uint16) {
+	switch t {
+	case ColumnTypeInteger:
+		return pgTypeInt8, 8
+	case ColumnTypeDecimal:
+		return pgTypeFloat8, 8
+	case ColumnTypeDateTime:
+		return pgTypeTimestamp, 8
+	}
+	return pgTypeText, 0xffff
+}
+
+func getPGDataRow(values []string) []byte {
+	body := make([]byte, 2)
+	binary.BigEndian.PutUint16(body, uint16(len(values)))
+
+	for _, v := range values {
+		l := make([]byte, 4)
+		binary.BigEndian.PutUint32(l, uint32(len(v)))
+
+		body = append(body, l...)
+		body = append(body, []byte(v)...)
+	}
+
+	return encodePGMessage(pgMsgDataRow, body)
+}
+
+func getPGCommandComplete(tag string) []byte {
+	return encodePGMessage(pgMsgCommandComplete, getPGCString(tag))
+}
+
+// Body of notice message for a client
+func getPGNoticeBody(message string) []byte {
+	body := []byte{'S'}
+	body = append(body, getPGCString("NOTICE")...)
+	body = append(body, 'V')
+	body = append(body, getPGCString("NOTICE")...)
+	body = append(body, 'C')
+	body = append(body, getPGCString("00000")...)
+	body = append(body, 'M')
+	body = append(body, getPGCString(message)...)
+	body = append(body, 0)
+
+	return body
+}
+
+func getPGReadyForQuery(txStatus byte) []byte {
+	return encodePGMessage(pgMsgReadyForQuery, []byte{txStatus})
+}
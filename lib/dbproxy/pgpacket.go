@@ -0,0 +1,208 @@
+package dbproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// PostgreSQL messages reading/writing and decoding
+
+// Startup packet sent by a client. It has no type byte
+type pgStartupPacket struct {
+	raw        []byte
+	code       uint32
+	parameters map[string]string
+}
+
+// Reads untyped startup packet. Int32 length including itself, Int32 version or request code, body
+func readPGStartup(r io.Reader) (*pgStartupPacket, error) {
+	header := make([]byte, 8)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+
+	if length < 8 || length > pgMaxStartupLength {
+		return nil, errors.New(fmt.Sprintf("Invalid startup packet length %d", length))
+	}
+
+	raw := make([]byte, length)
+	copy(raw, header)
+
+	if _, err := io.ReadFull(r, raw[8:]); err != nil {
+		return nil, err
+	}
+
+	p := &pgStartupPacket{}
+	p.raw = raw
+	p.code = binary.BigEndian.Uint32(header[4:8])
+	p.parameters = map[string]string{}
+
+	if p.code == pgProtocolVersion3 {
+		// list of name/value pairs terminated with zero byte
+		body := bytes.NewReader(raw[8:])
+
+		for {
+			name, err := readPGCString(body)
+
+			if err != nil || name == "" {
+				break
+			}
+			value, err := readPGCString(body)
+
+			if err != nil {
+				break
+			}
+			p.parameters[name] = value
+		}
+	}
+
+	return p, nil
+}
+
+// Reads typed message. Byte1 type, Int32 length including itself, body
+func readPGMessage(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[1:5])
+
+	if length < 4 || length > pgMaxMessageLength {
+		return 0, nil, errors.New(fmt.Sprintf("Invalid message length %d for message %c", length, header[0]))
+	}
+
+	body := make([]byte, length-4)
+
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], body, nil
+}
+
+// Builds typed message from a type and a body
+func encodePGMessage(msgType byte, body []byte) []byte {
+	res := make([]byte, 5, 5+len(body))
+
+	res[0] = msgType
+	binary.BigEndian.PutUint32(res[1:5], uint32(len(body)+4))
+
+	return append(res, body...)
+}
+
+// Reads zero terminated string
+func readPGCString(r *bytes.Reader) (string, error) {
+	var b bytes.Buffer
+
+	for {
+		c, err := r.ReadByte()
+
+		if err != nil {
+			return "", err
+		}
+		if c == 0 {
+			return b.String(), nil
+		}
+		b.WriteByte(c)
+	}
+}
+
+// Returns zero terminated string
+func getPGCString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+// Query message contains only a query string
+func decodePGQuery(body []byte) (string, error) {
+	return readPGCString(bytes.NewReader(body))
+}
+
+func encodePGQuery(query string) []byte {
+	return encodePGMessage(pgMsgQuery, getPGCString(query))
+}
+
+// Parse message. Statement name, query, then parameters types. Parameters types are returned as is
+func decodePGParse(body []byte) (name string, query string, rest []byte, err error) {
+	r := bytes.NewReader(body)
+
+	name, err = readPGCString(r)
+
+	if err != nil {
+		return
+	}
+
+	query, err = readPGCString(r)
+
+	if err != nil {
+		return
+	}
+
+	rest = body[len(body)-r.Len():]
+	return
+}
+
+func encodePGParse(name, query string, rest []byte) []byte {
+	body := getPGCString(name)
+	body = append(body, getPGCString(query)...)
+	body = append(body, rest...)
+
+	return encodePGMessage(pgMsgParse, body)
+}
+
+// Bind message starts with a portal name and a statement name
+func decodePGBind(body []byte) (portal string, statement string, err error) {
+	r := bytes.NewReader(body)
+
+	portal, err = readPGCString(r)
+
+	if err != nil {
+		return
+	}
+
+	statement, err = readPGCString(r)
+	return
+}
+
+// Describe and Close messages. 'S' for a statement or 'P' for a portal, then a name
+func decodePGTarget(body []byte) (kind byte, name string, err error) {
+	if len(body) == 0 {
+		err = errors.New("Target type is expected")
+		return
+	}
+	kind = body[0]
+
+	name, err = readPGCString(bytes.NewReader(body[1:]))
+	return
+}
+
+// Extracts error text from ErrorResponse message. It is a list of fields, each has a type byte
+func decodePGErrorResponse(body []byte) string {
+	r := bytes.NewReader(body)
+
+	fields := map[byte]string{}
+
+	for {
+		t, err := r.ReadByte()
+
+		if err != nil || t == 0 {
+			break
+		}
+
+		v, err := readPGCString(r)
+
+		if err != nil {
+			break
+		}
+		fields[t] = v
+	}
+
+	return fmt.Sprintf("%s %s: %s", fields['S'], fields['C'], fields['M'])
+}
@@ -0,0 +1,715 @@
+package dbproxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Proxy for PostgreSQL frontend/backend protocol (version 3).
+// Works same way as mysqlProxy. Queries from simple (Query) and extended (Parse)
+// protocols are passed to same callbacks
+type pgProxy struct {
+	pgHost           string
+	proxyHost        string
+	requestCallback  RequestQueryFilterCallback
+	responseCallback ResponseFilterCallback
+	queryFilter      DBProxyFilter
+	traceLog         *log.Logger
+	errorLog         *log.Logger
+	state            byte
+	stopChan         chan bool
+	completeChan     chan bool
+}
+
+func NewPostgreSQLProxy(proxyHost, pgHost string) (DBProxyInterface, error) {
+	obj := &pgProxy{}
+
+	if proxyHost == "" {
+		return nil, NewConfigDBProxyError("DB Proxy listening address is empty. Expected `host:port` or `:port` value")
+	}
+	if pgHost == "" {
+		return nil, NewConfigDBProxyError("PostgreSQL server host/socket info is missed")
+	}
+
+	obj.pgHost = pgHost
+	obj.proxyHost = proxyHost
+
+	obj.SetLoggers(log.New(ioutil.Discard, "", 0),
+		log.New(ioutil.Discard, "", 0))
+	return obj, nil
+}
+
+// Init the object.
+func (p *pgProxy) Init() error {
+	if p.traceLog == nil {
+		return errors.New("No trace logger object!")
+	}
+
+	if p.errorLog == nil {
+		return errors.New("No error logger object!")
+	}
+	p.stopChan = make(chan bool)
+	p.completeChan = make(chan bool)
+
+	return nil
+}
+
+// Set filtering callback function
+func (p *pgProxy) SetCallbacks(requestCallback RequestQueryFilterCallback, responseCallback ResponseFilterCallback) {
+	p.requestCallback = requestCallback
+	p.responseCallback = responseCallback
+}
+
+// Set query filter structure
+func (p *pgProxy) SetFilter(filterObj DBProxyFilter) {
+	p.queryFilter = filterObj
+}
+
+func (p *pgProxy) SetLoggers(t *log.Logger, e *log.Logger) {
+	p.traceLog = t
+	p.errorLog = e
+}
+
+// Starts accepting TCP connections and forwarding them to PostgreSQL server.
+// Each incoming connection is handled in own goroutine.
+func (p *pgProxy) Run() error {
+
+	listener, err := net.Listen("tcp", p.proxyHost)
+
+	if err != nil {
+		return err
+	}
+
+	p.traceLog.Printf("Started listening on %s", p.proxyHost)
+
+	if p.state == 2 {
+		return errors.New("Got signal to stop before normal start")
+	}
+
+	p.state = 1
+
+	go func() {
+		defer listener.Close()
+
+		p.state = 2
+
+		for {
+			client, err := listener.Accept()
+
+			exit := false
+
+			select {
+			case <-p.stopChan:
+				exit = true
+			default:
+			}
+
+			if exit {
+				break
+			}
+
+			if err != nil {
+				p.errorLog.Printf("Incoming connection error %s", err.Error())
+				continue
+			}
+
+			p.traceLog.Printf("New incoming connection %s", client.RemoteAddr().String())
+
+			go p.handleConnection(client)
+		}
+
+		p.traceLog.Printf("Return postgresql proxy routine")
+		p.completeChan <- true
+		p.state = 3
+	}()
+
+	return nil
+}
+
+func (p *pgProxy) Stop() error {
+
+	if p.state == 3 {
+		return nil
+	}
+
+	close(p.stopChan)
+
+	if p.state == 2 {
+		// open connection to itself to unblock listener
+		conn, err := net.Dial("tcp", p.proxyHost)
+
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		conn.Write([]byte{0, 0, 0})
+	}
+
+	return nil
+}
+
+func (p *pgProxy) IsStopped() bool {
+	return p.state == 3
+}
+
+func (p *pgProxy) WaitStop() {
+	<-p.completeChan
+}
+
+func (p *pgProxy) handleConnection(client net.Conn) {
+	p.traceLog.Printf("Handle incoming connection from %s", client.RemoteAddr().String())
+	defer p.traceLog.Printf("Close incoming connection from %s", client.RemoteAddr().String())
+
+	defer client.Close()
+
+	var server net.Conn
+	var err error
+
+	if strings.HasPrefix(p.pgHost, "/") {
+		server, err = net.Dial("unix", p.pgHost)
+	} else {
+		server, err = net.Dial("tcp", p.pgHost)
+	}
+
+	if err != nil {
+		p.errorLog.Printf("Can not connect to postgresql %s : Error: %s", p.pgHost, err.Error())
+		return
+	}
+	defer server.Close()
+
+	s := p.getSession(server, client)
+
+	clientReader := bufio.NewReader(client)
+
+	err = s.processStartup(clientReader)
+
+	if err != nil {
+		if err != io.EOF {
+			p.errorLog.Printf("Startup error from %s: %s", client.RemoteAddr().String(), err.Error())
+		}
+		return
+	}
+
+	notifySessionStarted(p.queryFilter, DBProxySessionInfo{s.sessionID, client.RemoteAddr().String(), s.user, s.database})
+
+	defer notifySessionClosed(p.queryFilter, s.sessionID)
+
+	go s.processRequests(clientReader)
+
+	s.processResponses(bufio.NewReader(server))
+}
+
+// Build session object for new client connection
+func (p *pgProxy) getSession(server net.Conn, client net.Conn) *pgSession {
+	s := pgSession{}
+	s.server = server
+	s.client = client
+	s.sessionID = randString(10)
+	s.requestCallback = p.requestCallback
+	s.responseCallback = p.responseCallback
+	s.queryFilter = p.queryFilter
+	s.traceLog = p.traceLog
+	s.errorLog = p.errorLog
+	s.txStatus = pgTxStatusIdle
+	s.localStatements = make(map[string]CustomRequestActionInterface)
+	s.localPortals = make(map[string]CustomRequestActionInterface)
+	return &s
+}
+
+// Part of a response to a client. It is either response from a server
+// or custom response made by the proxy.
+// Server part ends with ReadyForQuery or, for extended protocol messages before Sync,
+// after given number of replies
+type pgResponseSlot struct {
+	local   bool
+	data    []byte
+	replies int
+}
+
+// PostgreSQL client session. Unlike MySQL, messages in PostgreSQL can be pipelined
+// so custom responses must be sent to a client only after all previous responses
+// from a server. Every message causing a response from a server takes a slot in the queue
+type pgSession struct {
+	server           net.Conn
+	client           net.Conn
+	sessionID        string
+	user             string
+	database         string
+	requestCallback  RequestQueryFilterCallback
+	responseCallback ResponseFilterCallback
+	queryFilter      DBProxyFilter
+	traceLog         *log.Logger
+	errorLog         *log.Logger
+	lock             sync.Mutex
+	queue            []*pgResponseSlot
+	txStatus         byte
+	serverFailed     bool // server sent error in extended protocol batch and skips messages till Sync
+	// extended protocol statements and portals answered by the proxy. They are kept till Sync
+	localStatements map[string]CustomRequestActionInterface
+	localPortals    map[string]CustomRequestActionInterface
+	localError      bool // error was sent for a local statement. Messages till Sync are skipped
+	batchForwarded  bool // some messages of current extended protocol batch went to a server
+}
+
+// Startup phase. SSL is not supported, client is asked to continue without it
+func (s *pgSession) processStartup(r io.Reader) error {
+	for {
+		p, err := readPGStartup(r)
+
+		if err != nil {
+			return err
+		}
+
+		switch p.code {
+		case pgSSLRequestCode, pgGSSENCRequestCode:
+			s.traceLog.Printf("Encryption request rejected")
+
+			if _, err := s.client.Write([]byte{'N'}); err != nil {
+				return err
+			}
+			continue
+
+		case pgCancelRequestCode:
+			// cancel request is sent on new connection. Pass it and close
+			s.server.Write(p.raw)
+			return io.EOF
+
+		case pgProtocolVersion3:
+			s.user = p.parameters["user"]
+			s.database = p.parameters["database"]
+
+			s.traceLog.Printf("Startup for user %s, database %s", s.user, s.database)
+
+			// authentication ends with ReadyForQuery
+			s.addServerSlot()
+
+			_, err := s.server.Write(p.raw)
+			return err
+		}
+
+		return errors.New("Unsupported protocol version")
+	}
+}
+
+// Read messages from a client, filter queries and send to a server
+func (s *pgSession) processRequests(r io.Reader) {
+	// when a client is gone, server connection is not needed
+	defer s.server.Close()
+
+	for {
+		msgType, body, err := readPGMessage(r)
+
+		if err != nil {
+			if err != io.EOF {
+				s.errorLog.Printf("Request read error: %s", err.Error())
+			}
+			return
+		}
+
+		s.traceLog.Printf("Request: %d bytes with type %c", len(body), msgType)
+
+		if s.handleLocalMessage(msgType, body) {
+			continue
+		}
+
+		packet := encodePGMessage(msgType, body)
+
+		switch msgType {
+		case pgMsgQuery:
+			query, err := decodePGQuery(body)
+
+			if err != nil {
+				break
+			}
+
+			customResponse := s.filterQuery(query)
+
+			if customResponse == nil {
+				break
+			}
+
+			if rq, ok := customResponse.(*customResponseReplaceQuery); ok {
+				packet = encodePGQuery(rq.replaceQuery)
+				break
+			}
+
+			s.sendLocal(s.getSimpleQueryResponse(customResponse))
+			continue
+
+		case pgMsgParse:
+			name, query, rest, err := decodePGParse(body)
+
+			if err != nil {
+				break
+			}
+			// same name statement from a server replaces a local one
+			delete(s.localStatements, name)
+
+			customResponse := s.filterQuery(query)
+
+			if customResponse == nil {
+				break
+			}
+
+			if rq, ok := customResponse.(*customResponseReplaceQuery); ok {
+				packet = encodePGParse(name, rq.replaceQuery, rest)
+				break
+			}
+
+			// the statement is not sent to a server. Messages for it are answered till Sync
+			if e, ok := customResponse.(*customResponseError); ok {
+				s.sendLocalError(e)
+			} else {
+				s.localStatements[name] = customResponse
+				s.sendLocal(encodePGMessage(pgMsgParseComplete, []byte{}))
+			}
+			continue
+		}
+
+		switch msgType {
+		case pgMsgQuery, pgMsgFunctionCall:
+			s.addServerSlot()
+		case pgMsgSync:
+			s.addServerSlot()
+		case pgMsgParse, pgMsgBind, pgMsgDescribe, pgMsgExecute, pgMsgClose:
+			s.batchForwarded = true
+			s.addServerReply()
+		}
+
+		if _, err := s.server.Write(packet); err != nil {
+			s.errorLog.Printf("Request write error: %s", err.Error())
+			return
+		}
+
+		if msgType == pgMsgTerminate {
+			return
+		}
+	}
+}
+
+// Pass a query through filters. Returns nil if a query should go to a server as is
+func (s *pgSession) filterQuery(query string) CustomRequestActionInterface {
+	var clientErr error
+	var customResponse CustomRequestActionInterface
+
+	if s.queryFilter != nil {
+		customResponse, clientErr = s.queryFilter.RequestCallback(query, s.sessionID)
+	}
+	if customResponse == nil && clientErr == nil && s.requestCallback != nil {
+		customResponse, clientErr = s.requestCallback(query, s.sessionID)
+	}
+
+	s.traceLog.Printf("Request: %s", query)
+
+	if clientErr != nil {
+		s.traceLog.Printf("Custom error response: %s", clientErr)
+		customResponse = NewCustomErrorResponse(clientErr.Error(), 3001)
+	}
+
+	return customResponse
+}
+
+// Full response for a simple query protocol
+func (s *pgSession) getSimpleQueryResponse(customResponse CustomRequestActionInterface) []byte {
+	res := []byte{}
+
+	if e, ok := customResponse.(*customResponseError); ok {
+		res = e.getPGPacket()
+	} else if r, ok := customResponse.(pgCustomResponseInterface); ok {
+		desc := r.getPGRowDescription()
+
+		if desc[0] == pgMsgRowDescription {
+			res = append(res, desc...)
+		}
+		res = append(res, r.getPGExecuteResult()...)
+	} else {
+		res = NewCustomErrorResponse("Response is not supported for PostgreSQL", 3002).(*customResponseError).getPGPacket()
+	}
+
+	return append(res, s.getReadyForQuery()...)
+}
+
+// Handle extended protocol message for a statement answered by the proxy
+// Returns false if the message must go to a server
+func (s *pgSession) handleLocalMessage(msgType byte, body []byte) bool {
+	switch msgType {
+	case pgMsgSync:
+		local := s.localError || len(s.localStatements) > 0
+		forwarded := s.batchForwarded
+
+		s.resetLocal()
+
+		if !local || forwarded {
+			// a server must finish its part of the batch
+			return false
+		}
+		s.sendLocal(s.getReadyForQuery())
+		return true
+
+	case pgMsgQuery, pgMsgTerminate:
+		// batch was not finished correctly. Continue with normal processing
+		s.resetLocal()
+		return false
+	}
+
+	if s.localError {
+		// error was sent. skip all till Sync
+		return true
+	}
+
+	var r CustomRequestActionInterface
+
+	switch msgType {
+	case pgMsgBind:
+		portal, statement, err := decodePGBind(body)
+
+		if err != nil {
+			return false
+		}
+		var ok bool
+
+		if r, ok = s.localStatements[statement]; !ok {
+			delete(s.localPortals, portal)
+			return false
+		}
+		s.localPortals[portal] = r
+
+	case pgMsgDescribe, pgMsgClose:
+		kind, name, err := decodePGTarget(body)
+
+		if err != nil {
+			return false
+		}
+		var ok bool
+
+		if kind == 'S' {
+			r, ok = s.localStatements[name]
+		} else {
+			r, ok = s.localPortals[name]
+		}
+		if !ok {
+			return false
+		}
+		if msgType == pgMsgClose {
+			if kind == 'S' {
+				delete(s.localStatements, name)
+			} else {
+				delete(s.localPortals, name)
+			}
+			s.sendLocal(encodePGMessage(pgMsgCloseComplete, []byte{}))
+			return true
+		}
+
+	case pgMsgExecute:
+		portal, err := readPGCString(bytes.NewReader(body))
+
+		if err != nil {
+			return false
+		}
+		var ok bool
+
+		if r, ok = s.localPortals[portal]; !ok {
+			return false
+		}
+
+	default:
+		return false
+	}
+
+	pr, ok := r.(pgCustomResponseInterface)
+
+	if !ok {
+		s.sendLocalError(NewCustomErrorResponse("Response is not supported for PostgreSQL", 3002).(*customResponseError))
+		return true
+	}
+
+	switch msgType {
+	case pgMsgBind:
+		s.sendLocal(encodePGMessage(pgMsgBindComplete, []byte{}))
+	case pgMsgDescribe:
+		// for a statement there is parameters description first. We never have parameters
+		// for a portal it is only rows description
+		if body[0] == 'S' {
+			s.sendLocal(encodePGMessage(pgMsgParameterDescription, []byte{0, 0}))
+		}
+		s.sendLocal(pr.getPGRowDescription())
+	case pgMsgExecute:
+		s.sendLocal(pr.getPGExecuteResult())
+	}
+
+	return true
+}
+
+// Error on a local statement fails the batch. All messages till Sync are skipped
+func (s *pgSession) sendLocalError(e *customResponseError) {
+	s.localError = true
+	s.sendLocal(e.getPGPacket())
+}
+
+// Batch is finished with Sync
+func (s *pgSession) resetLocal() {
+	s.localError = false
+	s.batchForwarded = false
+	s.localStatements = make(map[string]CustomRequestActionInterface)
+	s.localPortals = make(map[string]CustomRequestActionInterface)
+}
+
+func (s *pgSession) getReadyForQuery() []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return getPGReadyForQuery(s.txStatus)
+}
+
+// Next response from a server will be sent before next custom responses
+func (s *pgSession) addServerSlot() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.queue = append(s.queue, &pgResponseSlot{false, nil, 0})
+}
+
+// Extended protocol message sent to a server before Sync. Its reply is sent before next custom responses
+func (s *pgSession) addServerReply() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.queue) > 0 {
+		last := s.queue[len(s.queue)-1]
+
+		if !last.local && last.replies > 0 {
+			last.replies++
+			return
+		}
+	}
+	s.queue = append(s.queue, &pgResponseSlot{false, nil, 1})
+}
+
+// Send custom response to a client. If a server has not yet answered on previous messages
+// the response is kept in a queue
+func (s *pgSession) sendLocal(data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.serverFailed {
+		// a server failed the batch. The client expects nothing more till ReadyForQuery
+		return
+	}
+
+	if len(s.queue) == 0 {
+		s.client.Write(data)
+		return
+	}
+
+	last := s.queue[len(s.queue)-1]
+
+	if last.local {
+		last.data = append(last.data, data...)
+		return
+	}
+	s.queue = append(s.queue, &pgResponseSlot{true, data, 0})
+}
+
+// Read messages from a server and send them to a client
+func (s *pgSession) processResponses(r io.Reader) {
+	for {
+		msgType, body, err := readPGMessage(r)
+
+		if err != nil {
+			if err != io.EOF {
+				s.traceLog.Printf("Response read error: %s", err.Error())
+			}
+			return
+		}
+
+		switch msgType {
+		case pgMsgErrorResponse:
+			decoded := decodePGErrorResponse(body)
+			s.traceLog.Printf("Server response error %s", decoded)
+
+			if s.queryFilter != nil {
+				s.queryFilter.ResponseCallback(s.sessionID, errors.New(decoded))
+			}
+			if s.responseCallback != nil {
+				s.responseCallback(s.sessionID, errors.New(decoded))
+			}
+
+		case pgMsgCommandComplete:
+			s.traceLog.Printf("Response OK")
+
+			if s.queryFilter != nil {
+				s.queryFilter.ResponseCallback(s.sessionID, nil)
+			}
+			if s.responseCallback != nil {
+				s.responseCallback(s.sessionID, nil)
+			}
+
+			// info from a filter is sent as a notice before command result
+			if f, ok := s.queryFilter.(DBProxyResponseInfoFilter); ok {
+				if info := f.GetResponseInfo(s.sessionID); info != "" {
+					s.sendServerResponse(pgMsgNoticeResponse, getPGNoticeBody(info))
+				}
+			}
+		}
+
+		if err := s.sendServerResponse(msgType, body); err != nil {
+			s.errorLog.Printf("Response write error: %s", err.Error())
+			return
+		}
+	}
+}
+
+// Send a message from a server to a client. ReadyForQuery or last reply on extended protocol
+// messages closes current server slot and custom responses waiting for it are sent after
+func (s *pgSession) sendServerResponse(msgType byte, body []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data := encodePGMessage(msgType, body)
+
+	closed := false
+
+	if msgType == pgMsgReadyForQuery {
+		if len(body) > 0 {
+			s.txStatus = body[0]
+		}
+		s.serverFailed = false
+		closed = len(s.queue) > 0
+	} else if len(s.queue) > 0 && s.queue[0].replies > 0 {
+		switch msgType {
+		case pgMsgErrorResponse:
+			// a server skips all messages till Sync. Custom responses for them are dropped too
+			for len(s.queue) > 1 && (s.queue[1].local || s.queue[1].replies > 0) {
+				s.queue = append(s.queue[:1], s.queue[2:]...)
+			}
+			s.serverFailed = len(s.queue) == 1
+			closed = true
+
+		case pgMsgParseComplete, pgMsgBindComplete, pgMsgCloseComplete, pgMsgRowDescription,
+			pgMsgNoData, pgMsgCommandComplete, pgMsgEmptyQueryResponse, pgMsgPortalSuspended:
+			s.queue[0].replies--
+			closed = s.queue[0].replies == 0
+		}
+	}
+
+	if closed {
+		s.queue = s.queue[1:]
+
+		for len(s.queue) > 0 && s.queue[0].local {
+			data = append(data, s.queue[0].data...)
+			s.queue = s.queue[1:]
+		}
+	}
+
+	_, err := s.client.Write(data)
+
+	return err
+}
@@ -0,0 +1,296 @@
+package dbproxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Minimal PostgreSQL backend. Accepts any startup and answers every query with UPDATE 1.
+// Extended protocol statements are executed same way, query starting with BAD fails
+type testPGBackend struct {
+	listener net.Listener
+	queries  chan string
+}
+
+func newTestPGBackend(t *testing.T) *testPGBackend {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &testPGBackend{l, make(chan string, 10)}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+			go b.handle(conn)
+		}
+	}()
+	return b
+}
+
+func (b *testPGBackend) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	if _, err := readPGStartup(r); err != nil {
+		return
+	}
+	// AuthenticationOk and ReadyForQuery
+	conn.Write(encodePGMessage('R', []byte{0, 0, 0, 0}))
+	conn.Write(getPGReadyForQuery(pgTxStatusIdle))
+
+	statements := map[string]string{}
+	portals := map[string]string{}
+	failed := false
+
+	for {
+		msgType, body, err := readPGMessage(r)
+
+		if err != nil {
+			return
+		}
+
+		if failed && msgType != pgMsgSync {
+			continue
+		}
+
+		switch msgType {
+		case pgMsgParse:
+			name, q, _, _ := decodePGParse(body)
+
+			if strings.HasPrefix(q, "BAD") {
+				conn.Write(NewCustomErrorResponse("syntax error", 1).(*customResponseError).getPGPacket())
+				failed = true
+				continue
+			}
+			statements[name] = q
+			conn.Write(encodePGMessage(pgMsgParseComplete, []byte{}))
+		case pgMsgBind:
+			portal, statement, _ := decodePGBind(body)
+			portals[portal] = statements[statement]
+			conn.Write(encodePGMessage(pgMsgBindComplete, []byte{}))
+		case pgMsgDescribe:
+			if body[0] == 'S' {
+				conn.Write(encodePGMessage(pgMsgParameterDescription, []byte{0, 0}))
+			}
+			conn.Write(encodePGMessage(pgMsgNoData, []byte{}))
+		case pgMsgExecute:
+			portal, _ := readPGCString(bytes.NewReader(body))
+			b.queries <- portals[portal]
+			conn.Write(getPGCommandComplete("UPDATE 1"))
+		case pgMsgClose:
+			conn.Write(encodePGMessage(pgMsgCloseComplete, []byte{}))
+		case pgMsgSync:
+			failed = false
+			conn.Write(getPGReadyForQuery(pgTxStatusIdle))
+		case pgMsgQuery:
+			q, _ := decodePGQuery(body)
+			b.queries <- q
+
+			if strings.HasPrefix(q, "BAD") {
+				conn.Write(NewCustomErrorResponse("syntax error", 1).(*customResponseError).getPGPacket())
+			} else {
+				conn.Write(getPGCommandComplete("UPDATE 1"))
+			}
+			conn.Write(getPGReadyForQuery(pgTxStatusIdle))
+		case pgMsgTerminate:
+			return
+		}
+	}
+}
+
+func testPGStartupPacket() []byte {
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, pgProtocolVersion3)
+	body = append(body, getPGCString("user")...)
+	body = append(body, getPGCString("test")...)
+	body = append(body, 0)
+
+	l := make([]byte, 4)
+	binary.BigEndian.PutUint32(l, uint32(len(body)+4))
+
+	return append(l, body...)
+}
+
+// read messages from the proxy till ReadyForQuery
+func testPGReadTillReady(t *testing.T, r *bufio.Reader) []byte {
+	types := []byte{}
+
+	for {
+		msgType, _, err := readPGMessage(r)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, msgType)
+
+		if msgType == pgMsgReadyForQuery {
+			return types
+		}
+	}
+}
+
+func TestPostgreSQLProxy(t *testing.T) {
+	backend := newTestPGBackend(t)
+	defer backend.listener.Close()
+
+	proxy, err := NewPostgreSQLProxy("127.0.0.1:0", backend.listener.Addr().String())
+	assert.NoError(t, err)
+
+	responses := make(chan error, 10)
+
+	proxy.SetCallbacks(func(query string, sessionID string) (CustomRequestActionInterface, error) {
+		switch {
+		case strings.HasPrefix(query, "REPLACE"):
+			return NewCustomQueryRequest("REPLACED"), nil
+		case strings.HasPrefix(query, "LOCAL"):
+			return NewCustomDataKeyValueResponse([]CustomResponseKeyValue{{"TX", "data"}}), nil
+		case strings.HasPrefix(query, "DENY"):
+			return nil, errors.New("denied")
+		}
+		return nil, nil
+	}, func(sessionID string, err error) {
+		responses <- err
+	})
+
+	assert.NoError(t, proxy.Init())
+
+	// listen on random port
+	p := proxy.(*pgProxy)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	p.proxyHost = l.Addr().String()
+	l.Close()
+
+	assert.NoError(t, proxy.Run())
+	defer proxy.Stop()
+
+	var client net.Conn
+
+	for i := 0; i < 10; i++ {
+		client, err = net.Dial("tcp", p.proxyHost)
+
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.NoError(t, err)
+	defer client.Close()
+
+	r := bufio.NewReader(client)
+
+	client.Write(testPGStartupPacket())
+	assert.Equal(t, []byte{'R', pgMsgReadyForQuery}, testPGReadTillReady(t, r))
+
+	// query passed as is
+	client.Write(encodePGQuery("UPDATE a"))
+	assert.Equal(t, []byte{pgMsgCommandComplete, pgMsgReadyForQuery}, testPGReadTillReady(t, r))
+	assert.Equal(t, "UPDATE a", <-backend.queries)
+	assert.NoError(t, <-responses)
+
+	// query replaced
+	client.Write(encodePGQuery("REPLACE a"))
+	testPGReadTillReady(t, r)
+	assert.Equal(t, "REPLACED", <-backend.queries)
+	<-responses
+
+	// error from a server goes to response callback
+	client.Write(encodePGQuery("BAD a"))
+	assert.Equal(t, []byte{pgMsgErrorResponse, pgMsgReadyForQuery}, testPGReadTillReady(t, r))
+	<-backend.queries
+	assert.Error(t, <-responses)
+
+	// custom response on simple query
+	client.Write(encodePGQuery("LOCAL a"))
+	assert.Equal(t, []byte{pgMsgRowDescription, pgMsgDataRow, pgMsgCommandComplete, pgMsgReadyForQuery},
+		testPGReadTillReady(t, r))
+
+	// custom error
+	client.Write(encodePGQuery("DENY a"))
+	assert.Equal(t, []byte{pgMsgErrorResponse, pgMsgReadyForQuery}, testPGReadTillReady(t, r))
+
+	// custom response on extended query
+	client.Write(encodePGParse("", "LOCAL b", []byte{0, 0}))
+	client.Write(encodePGMessage(pgMsgBind, []byte{0, 0, 0, 0, 0, 0, 0, 0}))
+	client.Write(encodePGMessage(pgMsgDescribe, append([]byte{'P'}, 0)))
+	client.Write(encodePGMessage(pgMsgExecute, []byte{0, 0, 0, 0, 0}))
+	client.Write(encodePGMessage(pgMsgSync, []byte{}))
+	assert.Equal(t, []byte{pgMsgParseComplete, pgMsgBindComplete, pgMsgRowDescription,
+		pgMsgDataRow, pgMsgCommandComplete, pgMsgReadyForQuery}, testPGReadTillReady(t, r))
+
+	select {
+	case q := <-backend.queries:
+		t.Errorf("Unexpected query on a server %s", q)
+	default:
+	}
+
+	// extended query passed to a server
+	client.Write(encodePGParse("", "UPDATE b", []byte{0, 0}))
+	client.Write(encodePGMessage(pgMsgBind, []byte{0, 0, 0, 0, 0, 0, 0, 0}))
+	client.Write(encodePGMessage(pgMsgDescribe, append([]byte{'P'}, 0)))
+	client.Write(encodePGMessage(pgMsgExecute, []byte{0, 0, 0, 0, 0}))
+	client.Write(encodePGMessage(pgMsgSync, []byte{}))
+	assert.Equal(t, []byte{pgMsgParseComplete, pgMsgBindComplete, pgMsgNoData,
+		pgMsgCommandComplete, pgMsgReadyForQuery}, testPGReadTillReady(t, r))
+	assert.Equal(t, "UPDATE b", <-backend.queries)
+	assert.NoError(t, <-responses)
+
+	// server and local statements in one batch. Replies keep order of messages
+	client.Write(encodePGParse("s1", "UPDATE c", []byte{0, 0}))
+	client.Write(encodePGParse("s2", "LOCAL c", []byte{0, 0}))
+	client.Write(testPGBind("p1", "s1"))
+	client.Write(testPGBind("p2", "s2"))
+	client.Write(encodePGMessage(pgMsgDescribe, append([]byte{'P'}, getPGCString("p2")...)))
+	client.Write(encodePGMessage(pgMsgExecute, append(getPGCString("p1"), 0, 0, 0, 0)))
+	client.Write(encodePGMessage(pgMsgExecute, append(getPGCString("p2"), 0, 0, 0, 0)))
+	client.Write(encodePGMessage(pgMsgSync, []byte{}))
+	assert.Equal(t, []byte{pgMsgParseComplete, pgMsgParseComplete, pgMsgBindComplete, pgMsgBindComplete,
+		pgMsgRowDescription, pgMsgCommandComplete, pgMsgDataRow, pgMsgCommandComplete, pgMsgReadyForQuery},
+		testPGReadTillReady(t, r))
+	assert.Equal(t, "UPDATE c", <-backend.queries)
+	assert.NoError(t, <-responses)
+
+	// server error fails the batch. Local replies after it are dropped
+	client.Write(encodePGParse("", "BAD b", []byte{0, 0}))
+	client.Write(encodePGParse("s2", "LOCAL d", []byte{0, 0}))
+	client.Write(encodePGMessage(pgMsgSync, []byte{}))
+	assert.Equal(t, []byte{pgMsgErrorResponse, pgMsgReadyForQuery}, testPGReadTillReady(t, r))
+	assert.Error(t, <-responses)
+
+	// local error skips messages till Sync
+	client.Write(encodePGParse("", "DENY b", []byte{0, 0}))
+	client.Write(encodePGMessage(pgMsgBind, []byte{0, 0, 0, 0, 0, 0, 0, 0}))
+	client.Write(encodePGMessage(pgMsgExecute, []byte{0, 0, 0, 0, 0}))
+	client.Write(encodePGMessage(pgMsgSync, []byte{}))
+	assert.Equal(t, []byte{pgMsgErrorResponse, pgMsgReadyForQuery}, testPGReadTillReady(t, r))
+
+	select {
+	case q := <-backend.queries:
+		t.Errorf("Unexpected query on a server %s", q)
+	default:
+	}
+}
+
+func testPGBind(portal, statement string) []byte {
+	body := getPGCString(portal)
+	body = append(body, getPGCString(statement)...)
+	body = append(body, 0, 0, 0, 0, 0, 0)
+
+	return encodePGMessage(pgMsgBind, body)
+}
@@ -13,3 +13,16 @@ func randString(n int) string {
 	}
 	return string(b)
 }
+
+// Inform a filter about new session if it wants to know this
+func notifySessionStarted(filter DBProxyFilter, info DBProxySessionInfo) {
+	if sf, ok := filter.(DBProxySessionFilter); ok {
+		sf.SessionStarted(info)
+	}
+}
+
+func notifySessionClosed(filter DBProxyFilter, sessionID string) {
+	if sf, ok := filter.(DBProxySessionFilter); ok {
+		sf.SessionClosed(sessionID)
+	}
+}
@@ -0,0 +1,444 @@
+package net
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	gonet "net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Address book of other nodes found with discovery. Addresses are kept in buckets. A bucket is chosen
+// by network group of an address and of a node which told about it, so one node or one network
+// can fill only few buckets and can not push out all other addresses (eclipse attack).
+// "New" buckets keep addresses which were never connected, "tried" buckets keep addresses connected before
+const (
+	AddrBookNewBuckets     = 64
+	AddrBookTriedBuckets   = 16
+	AddrBookBucketSize     = 32
+	AddrBookMaxAge         = 14 * 24 * time.Hour // addresses not seen so long are forgotten
+	AddrBookMaxAttempts    = 5                   // new address is forgotten after so many failed attempts
+	GetAddrMaxCount        = 1000                // max addresses in getaddr response
+	DiscoveryTargetNodes   = 8                   // a node tries to know so many working nodes
+	addrBookSourceBuckets  = 8                   // new buckets which can be filled from one network
+	addrBookGroupBuckets   = 4                   // tried buckets which can be filled by one network
+	addrBookUpdateInterval = 20 * time.Minute    // last seen time is not saved more often
+	addrUnknownAge         = 2 * time.Hour       // age of addresses received without a time
+)
+
+// Address of a node with time when the node was seen last time
+type TimedNodeAddr struct {
+	Host     string `proto:"1"`
+	Port     int    `proto:"2"`
+	LastSeen int64  `proto:"3"` // unix time
+}
+
+// Address in the book
+type KnownAddress struct {
+	Addr     NodeAddr
+	LastSeen int64
+	Source   string // host which told about the address
+	Tried    bool
+	Attempts int // failed attempts since last success
+	bucket   int
+}
+
+type AddressBook struct {
+	lock         sync.Mutex
+	key          []byte // random key to choose buckets. other nodes can not predict them
+	addrs        map[string]*KnownAddress
+	newBuckets   [AddrBookNewBuckets]map[string]bool
+	triedBuckets [AddrBookTriedBuckets]map[string]bool
+}
+
+func NewAddressBook() *AddressBook {
+	b := AddressBook{}
+	b.key = make([]byte, 32)
+	rand.Read(b.key)
+	b.addrs = map[string]*KnownAddress{}
+
+	for i := range b.newBuckets {
+		b.newBuckets[i] = map[string]bool{}
+	}
+	for i := range b.triedBuckets {
+		b.triedBuckets[i] = map[string]bool{}
+	}
+	return &b
+}
+
+// Convert to string in format lastseen:tried:attempts:source to save in a storage
+func (a KnownAddress) String() string {
+	tried := "0"
+
+	if a.Tried {
+		tried = "1"
+	}
+	return strconv.FormatInt(a.LastSeen, 10) + ":" + tried + ":" + strconv.Itoa(a.Attempts) + ":" + a.Source
+}
+
+// Parse from a string in format lastseen:tried:attempts:source
+func (a *KnownAddress) LoadFromString(addr, data string) error {
+	parts := strings.SplitN(data, ":", 4)
+
+	if len(parts) < 4 {
+		return errors.New("Wrong address book record format")
+	}
+
+	if err := a.Addr.LoadFromString(addr); err != nil {
+		return err
+	}
+
+	lastSeen, err := strconv.ParseInt(parts[0], 10, 64)
+
+	if err != nil {
+		return err
+	}
+
+	attempts, err := strconv.Atoi(parts[2])
+
+	if err != nil {
+		return err
+	}
+
+	a.LastSeen = lastSeen
+	a.Tried = parts[1] == "1"
+	a.Attempts = attempts
+	a.Source = parts[3]
+
+	return nil
+}
+
+// Check if an address was seen not long ago
+func (a KnownAddress) IsFresh() bool {
+	return time.Since(time.Unix(a.LastSeen, 0)) < AddrBookMaxAge
+}
+
+// Network group of a host. Addresses from one group are probably controlled by same owner.
+// It is /16 for IPv4 and /32 for IPv6. Host names are groups itself
+func addressGroup(host string) string {
+	ip := gonet.ParseIP(host)
+
+	if ip == nil {
+		return host
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d", ip4[0], ip4[1])
+	}
+	return ip.Mask(gonet.CIDRMask(32, 128)).String()
+}
+
+func (b *AddressBook) hash(parts ...string) uint64 {
+	h := sha256.New()
+	h.Write(b.key)
+
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return binary.BigEndian.Uint64(h.Sum(nil)[:8])
+}
+
+func (b *AddressBook) newBucket(a *KnownAddress) int {
+	srcGroup := addressGroup(a.Source)
+	n := b.hash(addressGroup(a.Addr.Host), srcGroup) % addrBookSourceBuckets
+
+	return int(b.hash(srcGroup, strconv.FormatUint(n, 10)) % AddrBookNewBuckets)
+}
+
+func (b *AddressBook) triedBucket(a *KnownAddress) int {
+	group := addressGroup(a.Addr.Host)
+	n := b.hash(a.Addr.String()) % addrBookGroupBuckets
+
+	return int(b.hash(group, strconv.FormatUint(n, 10)) % AddrBookTriedBuckets)
+}
+
+// Put an address to a bucket. If a bucket is full, oldest address is removed from it.
+// Returns removed address
+func (b *AddressBook) place(a *KnownAddress) *KnownAddress {
+	key := a.Addr.String()
+
+	var bucket map[string]bool
+
+	if a.Tried {
+		a.bucket = b.triedBucket(a)
+		bucket = b.triedBuckets[a.bucket]
+	} else {
+		a.bucket = b.newBucket(a)
+		bucket = b.newBuckets[a.bucket]
+	}
+
+	var evicted *KnownAddress
+
+	if len(bucket) >= AddrBookBucketSize {
+		for k := range bucket {
+			if evicted == nil || b.addrs[k].LastSeen < evicted.LastSeen {
+				evicted = b.addrs[k]
+			}
+		}
+		delete(bucket, evicted.Addr.String())
+		delete(b.addrs, evicted.Addr.String())
+	}
+
+	bucket[key] = true
+	b.addrs[key] = a
+
+	return evicted
+}
+
+func (b *AddressBook) remove(a *KnownAddress) {
+	key := a.Addr.String()
+
+	if a.Tried {
+		delete(b.triedBuckets[a.bucket], key)
+	} else {
+		delete(b.newBuckets[a.bucket], key)
+	}
+	delete(b.addrs, key)
+}
+
+// Returns count of addresses in the book
+func (b *AddressBook) Count() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return len(b.addrs)
+}
+
+func (n *NodeNetwork) getAddressBook() *AddressBook {
+	if n.Book == nil {
+		n.Book = NewAddressBook()
+	}
+	return n.Book
+}
+
+// Load address book from a storage
+func (n *NodeNetwork) LoadAddressBook() error {
+	if n.Storage == nil {
+		return nil
+	}
+
+	list, err := n.Storage.GetAddresses()
+
+	if err != nil {
+		return err
+	}
+
+	b := n.getAddressBook()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, a := range list {
+		if !a.IsFresh() {
+			n.Storage.RemoveAddress(a.Addr)
+			continue
+		}
+		ka := a
+
+		if evicted := b.place(&ka); evicted != nil {
+			n.Storage.RemoveAddress(evicted.Addr)
+		}
+	}
+	return nil
+}
+
+// Add addresses received from other node. Returns count of new addresses
+func (n *NodeNetwork) AddAddresses(list []TimedNodeAddr, source string) int {
+	b := n.getAddressBook()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	added := 0
+	now := time.Now()
+
+	for _, ta := range list {
+		addr := NewNodeAddr(ta.Host, ta.Port)
+
+		if addr.Host == "" || addr.Port < 1 || addr.Port > 65535 {
+			continue
+		}
+
+		lastSeen := time.Unix(ta.LastSeen, 0)
+
+		if ta.LastSeen == 0 {
+			lastSeen = now.Add(-addrUnknownAge)
+		} else if lastSeen.After(now) {
+			// clock of other node can be wrong
+			lastSeen = now
+		}
+
+		if now.Sub(lastSeen) > AddrBookMaxAge {
+			continue
+		}
+
+		if a, ok := b.addrs[addr.String()]; ok {
+			if lastSeen.Unix() > a.LastSeen+int64(addrBookUpdateInterval/time.Second) {
+				a.LastSeen = lastSeen.Unix()
+				n.saveAddress(a)
+			}
+			continue
+		}
+
+		a := &KnownAddress{Addr: addr, LastSeen: lastSeen.Unix(), Source: source}
+
+		if evicted := b.place(a); evicted != nil && n.Storage != nil {
+			n.Storage.RemoveAddress(evicted.Addr)
+		}
+		n.saveAddress(a)
+		added++
+	}
+	return added
+}
+
+func (n *NodeNetwork) saveAddress(a *KnownAddress) {
+	if n.Storage != nil {
+		n.Storage.SaveAddress(*a)
+	}
+}
+
+// Connection to a node was success. The address is moved to tried buckets
+func (n *NodeNetwork) MarkAddressGood(addr NodeAddr) {
+	b := n.getAddressBook()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	a, ok := b.addrs[addr.String()]
+
+	if !ok {
+		a = &KnownAddress{Addr: NewNodeAddr(addr.Host, addr.Port), Source: addr.Host}
+	} else if a.Tried && a.Attempts == 0 &&
+		time.Since(time.Unix(a.LastSeen, 0)) < addrBookUpdateInterval {
+		// nothing to save
+		return
+	}
+
+	a.LastSeen = time.Now().Unix()
+	a.Attempts = 0
+
+	if !a.Tried {
+		if ok {
+			b.remove(a)
+		}
+		a.Tried = true
+
+		if evicted := b.place(a); evicted != nil {
+			// it is still good address, return it to new ones
+			evicted.Tried = false
+
+			if b.place(evicted) != nil && n.Storage != nil {
+				n.Storage.RemoveAddress(evicted.Addr)
+			}
+			n.saveAddress(evicted)
+		}
+	}
+	n.saveAddress(a)
+}
+
+// Connection to a node failed. New addresses are forgotten after few failed attempts
+func (n *NodeNetwork) MarkAddressAttempt(addr NodeAddr) {
+	b := n.getAddressBook()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	a, ok := b.addrs[addr.String()]
+
+	if !ok {
+		return
+	}
+	a.Attempts++
+
+	if !a.Tried && a.Attempts >= AddrBookMaxAttempts {
+		b.remove(a)
+
+		if n.Storage != nil {
+			n.Storage.RemoveAddress(a.Addr)
+		}
+		return
+	}
+	n.saveAddress(a)
+}
+
+// Returns random addresses to send to other node. Known nodes are included
+func (n *NodeNetwork) GetAddressesToShare(max int) []TimedNodeAddr {
+	b := n.getAddressBook()
+
+	b.lock.Lock()
+
+	list := []TimedNodeAddr{}
+	included := map[string]bool{}
+
+	for _, a := range b.addrs {
+		if a.IsFresh() && a.Attempts < AddrBookMaxAttempts {
+			list = append(list, TimedNodeAddr{a.Addr.Host, a.Addr.Port, a.LastSeen})
+			included[a.Addr.String()] = true
+		}
+	}
+	b.lock.Unlock()
+
+	for _, node := range n.Nodes {
+		if !included[node.String()] {
+			list = append(list, TimedNodeAddr{node.Host, node.Port, 0})
+		}
+	}
+
+	mathrand.Shuffle(len(list), func(i, j int) { list[i], list[j] = list[j], list[i] })
+
+	if max > 0 && len(list) > max {
+		list = list[:max]
+	}
+	return list
+}
+
+// Returns addresses to connect. Nodes which are known already are skipped.
+// Tried and new addresses are chosen in turn
+func (n *NodeNetwork) PickAddressesToTry(count int) []NodeAddr {
+	b := n.getAddressBook()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	tried := []NodeAddr{}
+	fresh := []NodeAddr{}
+
+	for _, a := range b.addrs {
+		if n.CheckIsKnown(a.Addr) || !a.IsFresh() {
+			continue
+		}
+		if a.Tried {
+			tried = append(tried, a.Addr)
+		} else {
+			fresh = append(fresh, a.Addr)
+		}
+	}
+
+	mathrand.Shuffle(len(tried), func(i, j int) { tried[i], tried[j] = tried[j], tried[i] })
+	mathrand.Shuffle(len(fresh), func(i, j int) { fresh[i], fresh[j] = fresh[j], fresh[i] })
+
+	list := []NodeAddr{}
+
+	for len(list) < count && (len(tried) > 0 || len(fresh) > 0) {
+		if len(tried) > 0 && (len(list)%2 == 0 || len(fresh) == 0) {
+			list = append(list, tried[0])
+			tried = tried[1:]
+		} else {
+			list = append(list, fresh[0])
+			fresh = fresh[1:]
+		}
+	}
+	return list
+}
+
+// Returns count of addresses in the address book
+func (n *NodeNetwork) GetAddressBookSize() int {
+	return n.getAddressBook().Count()
+}
@@ -0,0 +1,88 @@
+package net
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressBook(t *testing.T) {
+	n := NodeNetwork{}
+	n.Init()
+
+	now := time.Now().Unix()
+
+	// one source can fill only few buckets, so it can not push out all other addresses
+	list := []TimedNodeAddr{}
+
+	for i := 0; i < 5000; i++ {
+		list = append(list, TimedNodeAddr{fmt.Sprintf("10.%d.%d.1", i/256, i%256), 8765, now})
+	}
+	n.AddAddresses(list, "10.0.0.99")
+
+	assert.True(t, n.GetAddressBookSize() <= addrBookSourceBuckets*AddrBookBucketSize)
+	assert.True(t, n.GetAddressBookSize() > AddrBookBucketSize)
+
+	// buckets are full now, new address can replace other one. next checks use empty book
+	n = NodeNetwork{}
+	n.Init()
+
+	// old addresses and wrong ports are skipped
+	size := n.GetAddressBookSize()
+	added := n.AddAddresses([]TimedNodeAddr{
+		{"192.168.1.1", 8765, now - int64(AddrBookMaxAge/time.Second) - 10},
+		{"192.168.1.2", 0, now},
+		{"192.168.1.3", 8765, 0},
+	}, "192.168.1.100")
+	assert.Equal(t, 1, added)
+	assert.Equal(t, size+1, n.GetAddressBookSize())
+
+	// failed new address is forgotten
+	addr := NewNodeAddr("192.168.1.3", 8765)
+
+	for i := 0; i < AddrBookMaxAttempts; i++ {
+		n.MarkAddressAttempt(addr)
+	}
+	assert.Equal(t, size, n.GetAddressBookSize())
+
+	// tried address stays after failures
+	n.AddAddresses([]TimedNodeAddr{{"192.168.1.3", 8765, now}}, "192.168.1.100")
+	n.MarkAddressGood(addr)
+
+	for i := 0; i < AddrBookMaxAttempts; i++ {
+		n.MarkAddressAttempt(addr)
+	}
+	assert.Equal(t, size+1, n.GetAddressBookSize())
+
+	// known nodes are not returned to try
+	n.AddAddresses(list, "10.0.0.99")
+	n.Nodes = []NodeAddr{addr}
+
+	for _, a := range n.PickAddressesToTry(10000) {
+		assert.False(t, a.CompareToAddress(addr))
+	}
+	assert.Equal(t, 10, len(n.GetAddressesToShare(10)))
+}
+
+func TestKnownAddressString(t *testing.T) {
+	a := KnownAddress{NewNodeAddr("10.1.1.1", 8765), 1500000000, "10.2.2.2", true, 2, 0}
+
+	b := KnownAddress{}
+	assert.NoError(t, b.LoadFromString(a.Addr.String(), a.String()))
+	assert.Equal(t, a, b)
+
+	assert.Error(t, b.LoadFromString("10.1.1.1:8765", "wrong"))
+}
+
+func TestLocalAnnounce(t *testing.T) {
+	a := LocalAnnounce{[]byte("12345678"), 8765, []byte("genesishash")}
+
+	b, err := DecodeLocalAnnounce(a.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, a, *b)
+
+	_, err = DecodeLocalAnnounce([]byte("something else"))
+	assert.Error(t, err)
+}
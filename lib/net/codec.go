@@ -0,0 +1,526 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// Codecs of message payloads. Gob is the legacy codec, it can be used only by Go clients.
+// Binary codec is Protocol Buffers wire format (proto3), messages schema is in docs/protocol.proto.
+//
+// Field numbers of a message are set with proto tags of Go structure fields, for example `proto:"3"`.
+// Every exported field must have the tag, `proto:"-"` means a field is not sent. A number of a field
+// must never change and must not be used again for other field after a field is removed.
+//
+// Go type                      proto type
+// bool                         bool
+// int, int64                   int64
+// uint, uint32, uint64         uint64
+// float64                      double
+// string                       string
+// []byte                       bytes
+// []T                          repeated T
+// struct                       message
+//
+// If a payload is not a structure (for example a string or a list) it is wrapped
+// in a message with single field 1
+//
+// JSON codec is used by HTTP API. Nodes don't negotiate it
+const (
+	CodecGob    byte = 0
+	CodecBinary byte = 1
+	CodecJSON   byte = 2
+)
+
+const (
+	CodecNameGob    = "gob"
+	CodecNameBinary = "binary"
+	CodecNameJSON   = "json"
+)
+
+// Codecs supported by this node. Most preferred first
+var SupportedCodecs = []string{CodecNameBinary, CodecNameGob}
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Returns codec ID by name
+func GetCodecByName(name string) (byte, error) {
+	switch name {
+	case CodecNameGob:
+		return CodecGob, nil
+	case CodecNameBinary:
+		return CodecBinary, nil
+	case CodecNameJSON:
+		return CodecJSON, nil
+	}
+	return 0, errors.New(fmt.Sprintf("Unknown codec %s", name))
+}
+
+// Returns codec name by ID
+func GetCodecName(codec byte) (string, error) {
+	switch codec {
+	case CodecGob:
+		return CodecNameGob, nil
+	case CodecBinary:
+		return CodecNameBinary, nil
+	case CodecJSON:
+		return CodecNameJSON, nil
+	}
+	return "", errors.New(fmt.Sprintf("Unknown codec %d", codec))
+}
+
+// Choose codec to talk to other node from the list of codecs it supports.
+// Empty string means the node knows only legacy protocol
+func ChooseCodec(remoteCodecs []string) string {
+	for _, c := range SupportedCodecs {
+		for _, rc := range remoteCodecs {
+			if c == rc {
+				return c
+			}
+		}
+	}
+	return ""
+}
+
+// Encode a payload with given codec
+func EncodePayload(codec byte, data interface{}) ([]byte, error) {
+	switch codec {
+	case CodecGob:
+		return GobEncode(data)
+	case CodecBinary:
+		return BinaryEncode(data)
+	case CodecJSON:
+		return json.Marshal(data)
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown codec %d", codec))
+}
+
+// Decode a payload with given codec
+func DecodePayload(codec byte, data []byte, v interface{}) error {
+	switch codec {
+	case CodecGob:
+		dec := gob.NewDecoder(bytes.NewReader(data))
+		return dec.Decode(v)
+	case CodecBinary:
+		return BinaryDecode(data, v)
+	case CodecJSON:
+		return json.Unmarshal(data, v)
+	}
+	return errors.New(fmt.Sprintf("Unknown codec %d", codec))
+}
+
+// ===============================================================
+// Binary codec
+
+// Encode structure to protobuf wire format
+func BinaryEncode(data interface{}) ([]byte, error) {
+	v := reflect.ValueOf(data)
+
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return []byte{}, nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Struct {
+		return encodeMessage(v)
+	}
+	return appendField([]byte{}, 1, v, true)
+}
+
+// Decode protobuf wire format data to a structure. Unknown fields are skipped
+func BinaryDecode(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("Decode destination must be a pointer")
+	}
+	rv = rv.Elem()
+
+	if rv.Kind() == reflect.Struct {
+		return decodeMessage(data, rv)
+	}
+
+	return decodeFields(data, func(num int) (reflect.Value, bool) {
+		return rv, num == 1
+	})
+}
+
+// Field of a structure which is sent in a message
+type messageField struct {
+	num   int // field number from proto tag
+	index int // index of a field in a structure
+}
+
+// Fields of structure types. Tags are parsed once per type
+var messageFieldsCache sync.Map
+
+// Returns fields of a structure which have field numbers, in order of a structure
+func getMessageFields(t reflect.Type) ([]messageField, error) {
+	if cached, ok := messageFieldsCache.Load(t); ok {
+		return cached.([]messageField), nil
+	}
+
+	fields := []messageField{}
+	used := map[int]bool{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		if f.PkgPath != "" {
+			// not exported
+			continue
+		}
+
+		tag := f.Tag.Get("proto")
+
+		if tag == "-" {
+			continue
+		}
+
+		if tag == "" {
+			return nil, errors.New(fmt.Sprintf("%s.%s: proto tag is missed", t.Name(), f.Name))
+		}
+
+		num, err := strconv.Atoi(tag)
+
+		if err != nil || num < 1 {
+			return nil, errors.New(fmt.Sprintf("%s.%s: wrong proto tag %s", t.Name(), f.Name, tag))
+		}
+
+		if used[num] {
+			return nil, errors.New(fmt.Sprintf("%s.%s: field number %d is used twice", t.Name(), f.Name, num))
+		}
+		used[num] = true
+
+		fields = append(fields, messageField{num, i})
+	}
+
+	messageFieldsCache.Store(t, fields)
+
+	return fields, nil
+}
+
+func encodeMessage(v reflect.Value) ([]byte, error) {
+	fields, err := getMessageFields(v.Type())
+
+	if err != nil {
+		return nil, err
+	}
+
+	b := []byte{}
+
+	for _, f := range fields {
+		b, err = appendField(b, f.num, v.Field(f.index), true)
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s.%s: %s", v.Type().Name(), v.Type().Field(f.index).Name, err.Error()))
+		}
+	}
+	return b, nil
+}
+
+// Zero values are not written for single fields. Elements of repeated fields are always written
+func appendField(b []byte, num int, f reflect.Value, omitZero bool) ([]byte, error) {
+	switch f.Kind() {
+	case reflect.Bool:
+		if f.Bool() || !omitZero {
+			b = appendKey(b, num, wireVarint)
+			v := uint64(0)
+
+			if f.Bool() {
+				v = 1
+			}
+			b = appendUvarint(b, v)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f.Int() != 0 || !omitZero {
+			b = appendKey(b, num, wireVarint)
+			b = appendUvarint(b, uint64(f.Int()))
+		}
+
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if f.Uint() != 0 || !omitZero {
+			b = appendKey(b, num, wireVarint)
+			b = appendUvarint(b, f.Uint())
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if f.Float() != 0 || !omitZero {
+			b = appendKey(b, num, wireFixed64)
+			v := make([]byte, 8)
+			binary.LittleEndian.PutUint64(v, math.Float64bits(f.Float()))
+			b = append(b, v...)
+		}
+
+	case reflect.String:
+		if f.Len() > 0 || !omitZero {
+			b = appendBytes(b, num, []byte(f.String()))
+		}
+
+	case reflect.Slice:
+		if f.Type().Elem().Kind() == reflect.Uint8 {
+			if f.Len() > 0 || !omitZero {
+				b = appendBytes(b, num, f.Bytes())
+			}
+			break
+		}
+
+		var err error
+
+		for i := 0; i < f.Len(); i++ {
+			b, err = appendField(b, num, f.Index(i), false)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case reflect.Struct:
+		msg, err := encodeMessage(f)
+
+		if err != nil {
+			return nil, err
+		}
+		b = appendBytes(b, num, msg)
+
+	case reflect.Ptr:
+		if f.IsNil() {
+			break
+		}
+		return appendField(b, num, f.Elem(), omitZero)
+
+	default:
+		return nil, errors.New(fmt.Sprintf("Type %s is not supported", f.Type().String()))
+	}
+
+	return b, nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, v)
+	return append(b, buf[:n]...)
+}
+
+func appendKey(b []byte, num int, wireType int) []byte {
+	return appendUvarint(b, uint64(num)<<3|uint64(wireType))
+}
+
+func appendBytes(b []byte, num int, data []byte) []byte {
+	b = appendKey(b, num, wireBytes)
+	b = appendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func decodeMessage(data []byte, v reflect.Value) error {
+	fields, err := getMessageFields(v.Type())
+
+	if err != nil {
+		return err
+	}
+
+	return decodeFields(data, func(num int) (reflect.Value, bool) {
+		for _, f := range fields {
+			if f.num == num {
+				return v.Field(f.index), true
+			}
+		}
+		return reflect.Value{}, false
+	})
+}
+
+// Reads all fields of a message. getField returns a value to set for a field number
+func decodeFields(data []byte, getField func(num int) (reflect.Value, bool)) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+
+		if n <= 0 {
+			return errors.New("Wrong field key")
+		}
+		data = data[n:]
+
+		num := int(key >> 3)
+		wireType := int(key & 7)
+
+		var value uint64
+		var raw []byte
+
+		switch wireType {
+		case wireVarint:
+			value, n = binary.Uvarint(data)
+
+			if n <= 0 {
+				return errors.New("Wrong varint value")
+			}
+			data = data[n:]
+
+		case wireFixed64:
+			if len(data) < 8 {
+				return errors.New("Unexpected end of data")
+			}
+			value = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+
+		case wireFixed32:
+			if len(data) < 4 {
+				return errors.New("Unexpected end of data")
+			}
+			value = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+
+			if n <= 0 || uint64(len(data)-n) < l {
+				return errors.New("Wrong length of bytes value")
+			}
+			raw = data[n : n+int(l)]
+			data = data[n+int(l):]
+
+		default:
+			return errors.New(fmt.Sprintf("Wire type %d is not supported", wireType))
+		}
+
+		f, ok := getField(num)
+
+		if !ok {
+			continue
+		}
+
+		if err := setField(f, wireType, value, raw); err != nil {
+			return errors.New(fmt.Sprintf("Field %d: %s", num, err.Error()))
+		}
+	}
+	return nil
+}
+
+func setField(f reflect.Value, wireType int, value uint64, raw []byte) error {
+	kind := f.Kind()
+
+	if wireType == wireBytes && !isBytesKind(f.Type()) {
+		// only repeated scalars can be packed
+		if kind != reflect.Slice {
+			return errors.New("Unexpected length delimited value")
+		}
+	} else if wireType != wireBytes && isBytesKind(f.Type()) {
+		return errors.New("Length delimited value expected")
+	}
+
+	switch kind {
+	case reflect.Bool:
+		f.SetBool(value != 0)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f.SetInt(int64(value))
+
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f.SetUint(value)
+
+	case reflect.Float32, reflect.Float64:
+		if wireType == wireFixed32 {
+			f.SetFloat(float64(math.Float32frombits(uint32(value))))
+		} else {
+			f.SetFloat(math.Float64frombits(value))
+		}
+
+	case reflect.String:
+		f.SetString(string(raw))
+
+	case reflect.Slice:
+		elemType := f.Type().Elem()
+
+		if elemType.Kind() == reflect.Uint8 {
+			f.SetBytes(append([]byte{}, raw...))
+			return nil
+		}
+
+		if wireType == wireBytes && !isBytesKind(elemType) {
+			// packed repeated scalars
+			return decodePacked(f, raw)
+		}
+
+		e := reflect.New(elemType).Elem()
+
+		if err := setField(e, wireType, value, raw); err != nil {
+			return err
+		}
+		f.Set(reflect.Append(f, e))
+
+	case reflect.Struct:
+		return decodeMessage(raw, f)
+
+	case reflect.Ptr:
+		if f.IsNil() {
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+		return setField(f.Elem(), wireType, value, raw)
+
+	default:
+		return errors.New(fmt.Sprintf("Type %s is not supported", f.Type().String()))
+	}
+	return nil
+}
+
+// Types encoded as length delimited values
+func isBytesKind(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Struct:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	case reflect.Ptr:
+		return isBytesKind(t.Elem())
+	}
+	return false
+}
+
+func decodePacked(f reflect.Value, data []byte) error {
+	elemType := f.Type().Elem()
+
+	for len(data) > 0 {
+		var value uint64
+		wireType := wireVarint
+
+		if elemType.Kind() == reflect.Float64 || elemType.Kind() == reflect.Float32 {
+			if len(data) < 8 {
+				return errors.New("Unexpected end of packed data")
+			}
+			value = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+			wireType = wireFixed64
+		} else {
+			v, n := binary.Uvarint(data)
+
+			if n <= 0 {
+				return errors.New("Wrong packed varint")
+			}
+			value = v
+			data = data[n:]
+		}
+
+		e := reflect.New(elemType).Elem()
+
+		if err := setField(e, wireType, value, nil); err != nil {
+			return err
+		}
+		f.Set(reflect.Append(f, e))
+	}
+	return nil
+}
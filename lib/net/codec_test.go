@@ -0,0 +1,149 @@
+package net
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCodecInner struct {
+	Name string `proto:"1"`
+	Port int    `proto:"2"`
+}
+
+type testCodecMessage struct {
+	Flag    bool             `proto:"1"`
+	Number  int              `proto:"2"`
+	Amount  float64          `proto:"3"`
+	Text    string           `proto:"4"`
+	Data    []byte           `proto:"5"`
+	List    [][]byte         `proto:"6"`
+	Inner   testCodecInner   `proto:"7"`
+	Inners  []testCodecInner `proto:"8"`
+	Numbers []int            `proto:"9"`
+}
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	m := testCodecMessage{
+		Flag:    true,
+		Number:  -5,
+		Amount:  1.25,
+		Text:    "hello",
+		Data:    []byte{1, 2, 3},
+		List:    [][]byte{{4}, {}, {5, 6}},
+		Inner:   testCodecInner{"host", 8765},
+		Inners:  []testCodecInner{{"a", 1}, {"b", 2}},
+		Numbers: []int{0, 7, 300},
+	}
+
+	data, err := EncodePayload(CodecBinary, &m)
+	assert.NoError(t, err)
+
+	r := testCodecMessage{}
+	assert.NoError(t, DecodePayload(CodecBinary, data, &r))
+	assert.Equal(t, m, r)
+
+	// not a structure is wrapped as field 1
+	data, err = BinaryEncode("error message")
+	assert.NoError(t, err)
+	assert.Equal(t, append([]byte{0x0a, 13}, []byte("error message")...), data)
+
+	s := ""
+	assert.NoError(t, BinaryDecode(data, &s))
+	assert.Equal(t, "error message", s)
+
+	nodes := []NodeAddr{NewNodeAddr("host1", 8000), NewNodeAddr("host2", 9000)}
+	data, err = BinaryEncode(&nodes)
+	assert.NoError(t, err)
+
+	rnodes := []NodeAddr{}
+	assert.NoError(t, BinaryDecode(data, &rnodes))
+	assert.Equal(t, nodes, rnodes)
+}
+
+func TestBinaryCodecWireFormat(t *testing.T) {
+	data, err := BinaryEncode(testCodecInner{"ab", 150})
+	assert.NoError(t, err)
+	// field 1 string "ab", field 2 varint 150
+	assert.Equal(t, []byte{0x0a, 0x02, 'a', 'b', 0x10, 0x96, 0x01}, data)
+
+	// zero values are not written
+	data, err = BinaryEncode(testCodecInner{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{}, data)
+
+	// unknown fields are skipped. field 5 varint, field 6 bytes, field 7 fixed32, field 8 fixed64
+	data = []byte{0x28, 0x01, 0x32, 0x01, 0xff, 0x3d, 1, 2, 3, 4, 0x41, 1, 2, 3, 4, 5, 6, 7, 8, 0x10, 0x03}
+	r := testCodecInner{}
+	assert.NoError(t, BinaryDecode(data, &r))
+	assert.Equal(t, testCodecInner{"", 3}, r)
+
+	// packed repeated field
+	m := testCodecMessage{}
+	assert.NoError(t, BinaryDecode([]byte{0x4a, 0x03, 0x01, 0x02, 0x03}, &m))
+	assert.Equal(t, []int{1, 2, 3}, m.Numbers)
+
+	// broken data
+	assert.Error(t, BinaryDecode([]byte{0x0a, 0x05, 'a'}, &r))
+}
+
+func TestBinaryCodecFieldNumbers(t *testing.T) {
+	// numbers come from tags, not from order of fields
+	type message struct {
+		skipped string
+		Port    int    `proto:"2"`
+		Local   string `proto:"-"`
+		Name    string `proto:"1"`
+	}
+
+	data, err := BinaryEncode(message{"x", 150, "local", "ab"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x10, 0x96, 0x01, 0x0a, 0x02, 'a', 'b'}, data)
+
+	r := message{}
+	assert.NoError(t, BinaryDecode(data, &r))
+	assert.Equal(t, message{"", 150, "", "ab"}, r)
+
+	// same message as other structure with same tags
+	inner := testCodecInner{}
+	assert.NoError(t, BinaryDecode(data, &inner))
+	assert.Equal(t, testCodecInner{"ab", 150}, inner)
+
+	type noTag struct {
+		Name string
+	}
+	_, err = BinaryEncode(noTag{"a"})
+	assert.Error(t, err)
+
+	type twice struct {
+		Name string `proto:"1"`
+		Host string `proto:"1"`
+	}
+	_, err = BinaryEncode(twice{"a", "b"})
+	assert.Error(t, err)
+}
+
+func TestEnvelope(t *testing.T) {
+	request, err := EncodeEnvelopeRequest(CodecBinary, "getblock", "auth", testCodecInner{"ab", 150})
+	assert.NoError(t, err)
+	assert.True(t, IsEnvelopeRequest(request))
+	assert.Equal(t, []byte{EnvelopeVersion, CodecBinary, 8}, request[:3])
+	assert.Equal(t, "getblock", string(request[3:11]))
+
+	// legacy request starts with a command name
+	assert.False(t, IsEnvelopeRequest(CommandToBytes("getblock")))
+
+	response := EncodeEnvelopeResponse(CodecBinary, false, []byte{1, 2})
+	codec, success, payload, err := DecodeEnvelopeResponse(response)
+	assert.NoError(t, err)
+	assert.Equal(t, CodecBinary, codec)
+	assert.False(t, success)
+	assert.Equal(t, []byte{1, 2}, payload)
+
+	_, _, _, err = DecodeEnvelopeResponse(response[:len(response)-1])
+	assert.Error(t, err)
+
+	assert.Equal(t, CodecNameBinary, ChooseCodec([]string{"gob", "binary"}))
+	assert.Equal(t, CodecNameGob, ChooseCodec([]string{"json", "gob"}))
+	assert.Equal(t, "", ChooseCodec(nil))
+}
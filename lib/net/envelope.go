@@ -0,0 +1,122 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Versioned message envelope. It is used instead of legacy requests format
+// [command 12 bytes][payload length][extra data length][payload][extra data]
+// Legacy request starts with a command name, so first byte of a request tells what format is used.
+// Full description is in docs/Protocol.md
+//
+// Request
+// uint8      envelope version
+// uint8      codec of a payload
+// uint8      length of a command name
+// bytes      command name
+// uint32 BE  length of auth string
+// bytes      auth string
+// uint32 BE  length of a payload
+// bytes      payload
+//
+// Response
+// uint8      envelope version
+// uint8      status. 1 is success, 0 is error
+// uint8      codec of a payload
+// uint32 BE  length of a payload
+// bytes      payload. In case of error it is error message wrapped in a message as field 1
+const EnvelopeVersion = 1
+
+// Max length of a command name in the envelope
+const maxEnvelopeCommandLength = 64
+
+// Build a request in envelope format
+func EncodeEnvelopeRequest(codec byte, command string, auth string, data interface{}) ([]byte, error) {
+	if len(command) > maxEnvelopeCommandLength {
+		return nil, errors.New("Command name is too long")
+	}
+
+	payload := []byte{}
+
+	if data != nil {
+		var err error
+		payload, err = EncodePayload(codec, data)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	request := []byte{EnvelopeVersion, codec, byte(len(command))}
+	request = append(request, []byte(command)...)
+	request = appendUint32Bytes(request, []byte(auth))
+	request = appendUint32Bytes(request, payload)
+
+	return request, nil
+}
+
+// Build a response in envelope format
+func EncodeEnvelopeResponse(codec byte, success bool, payload []byte) []byte {
+	status := byte(0)
+
+	if success {
+		status = 1
+	}
+	return appendUint32Bytes([]byte{EnvelopeVersion, status, codec}, payload)
+}
+
+// Parse a response in envelope format. Returns codec, status and payload
+func DecodeEnvelopeResponse(response []byte) (byte, bool, []byte, error) {
+	if len(response) < 7 {
+		return 0, false, nil, errors.New("Response is too short")
+	}
+
+	if response[0] != EnvelopeVersion {
+		return 0, false, nil, errors.New(fmt.Sprintf("Unsupported envelope version %d", response[0]))
+	}
+
+	length := binary.BigEndian.Uint32(response[3:7])
+
+	if uint32(len(response)-7) < length {
+		return 0, false, nil, errors.New("Response payload is incomplete")
+	}
+	return response[2], response[1] == 1, response[7 : 7+length], nil
+}
+
+// Read a response in envelope format from a connection which stays open after a response
+func ReadEnvelopeResponse(r io.Reader, maxLength uint32) ([]byte, error) {
+	head := make([]byte, 7)
+
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(head[3:7])
+
+	if length > maxLength {
+		return nil, errors.New(fmt.Sprintf("Response is too long, %d bytes", length))
+	}
+
+	payload := make([]byte, length)
+
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return append(head, payload...), nil
+}
+
+// Checks if a request is in envelope format
+func IsEnvelopeRequest(request []byte) bool {
+	return len(request) > 0 && request[0] == EnvelopeVersion
+}
+
+func appendUint32Bytes(b []byte, data []byte) []byte {
+	l := make([]byte, 4)
+	binary.BigEndian.PutUint32(l, uint32(len(data)))
+
+	b = append(b, l...)
+	return append(b, data...)
+}
@@ -9,8 +9,16 @@ const (
 	errorCanNotSend          = "cannotsend"
 	errorNoResponse          = "noresponse"
 	errorCanNotParseResponse = "cannotparseresponse"
+	errorIdentityMismatch    = "identitymismatch"
+	errorTimeout             = "timeout"
+	errorBusy                = "busy"
+	errorPlainRefused        = "plainrefused"
+	errorTLSHandshake        = "tlshandshake"
 )
 
+// Busy error is sent to other node with this prefix, so the node knows it must wait
+const busyErrorPrefix = "Node is busy: "
+
 type NetworkError struct {
 	errStr string
 	kind   string
@@ -29,6 +37,21 @@ func (e NetworkError) Error() string {
 	if e.kind == errorCanNotParseResponse {
 		return fmt.Sprintf("Can Not Parse Network Response: %s", e.errStr)
 	}
+	if e.kind == errorIdentityMismatch {
+		return fmt.Sprintf("Node Identity Mismatch: %s", e.errStr)
+	}
+	if e.kind == errorTimeout {
+		return fmt.Sprintf("Network Timeout: %s", e.errStr)
+	}
+	if e.kind == errorBusy {
+		return busyErrorPrefix + e.errStr
+	}
+	if e.kind == errorPlainRefused {
+		return fmt.Sprintf("Plain Connection Refused: %s", e.errStr)
+	}
+	if e.kind == errorTLSHandshake {
+		return fmt.Sprintf("TLS Handshake Error: %s", e.errStr)
+	}
 	return fmt.Sprintf("Network Error: %s", e.errStr)
 }
 
@@ -39,6 +62,30 @@ func (e NetworkError) WasConnFailure() bool {
 	return false
 }
 
+func (e NetworkError) IsIdentityMismatch() bool {
+	return e.kind == errorIdentityMismatch
+}
+
+func (e NetworkError) IsTimeout() bool {
+	return e.kind == errorTimeout
+}
+
+func (e NetworkError) IsNoResponse() bool {
+	return e.kind == errorNoResponse
+}
+
+func (e NetworkError) IsBusy() bool {
+	return e.kind == errorBusy
+}
+
+func (e NetworkError) IsPlainRefused() bool {
+	return e.kind == errorPlainRefused
+}
+
+func (e NetworkError) IsTLSHandshakeFailure() bool {
+	return e.kind == errorTLSHandshake
+}
+
 func NewCanNotConnectError(err string) error {
 	return &NetworkError{err, errorCanNotConnect}
 }
@@ -54,3 +101,23 @@ func NewNoResponseError(err string) error {
 func NewCanNotParseResponseError(err string) error {
 	return &NetworkError{err, errorCanNotParseResponse}
 }
+
+func NewIdentityMismatchError(err string) error {
+	return &NetworkError{err, errorIdentityMismatch}
+}
+
+func NewTimeoutError(err string) error {
+	return &NetworkError{err, errorTimeout}
+}
+
+func NewBusyError(err string) error {
+	return &NetworkError{err, errorBusy}
+}
+
+func NewPlainRefusedError(err string) error {
+	return &NetworkError{err, errorPlainRefused}
+}
+
+func NewTLSHandshakeError(err string) error {
+	return &NetworkError{err, errorTLSHandshake}
+}
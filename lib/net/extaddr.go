@@ -0,0 +1,69 @@
+package net
+
+import (
+	gonet "net"
+	"sync"
+)
+
+// External address of a node is detected from addresses other nodes see in connections from it.
+// Every node returns it in response for version. An address is trusted when few different nodes report it
+const ExternalAddressMinVotes = 2
+
+type AddressVotes struct {
+	lock  sync.Mutex
+	votes map[string]string // host of other node -> address it sees
+}
+
+func NewAddressVotes() *AddressVotes {
+	v := AddressVotes{}
+	v.votes = map[string]string{}
+	return &v
+}
+
+// Remember an address reported by other node. Loopback addresses are skipped,
+// nodes on same machine can not tell an external address
+func (n *NodeNetwork) ReportObservedAddress(peerHost string, observed string) {
+	ip := gonet.ParseIP(observed)
+
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || isLoopbackHost(peerHost) {
+		return
+	}
+
+	v := n.getAddressVotes()
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.votes[peerHost] = ip.String()
+}
+
+// Returns external address reported by most nodes. Empty string if there are not enough reports
+func (n *NodeNetwork) GetExternalAddress() string {
+	v := n.getAddressVotes()
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	counts := map[string]int{}
+	best := ""
+
+	for _, addr := range v.votes {
+		counts[addr]++
+
+		if counts[addr] > counts[best] || (counts[addr] == counts[best] && addr < best) {
+			best = addr
+		}
+	}
+
+	if counts[best] < ExternalAddressMinVotes {
+		return ""
+	}
+	return best
+}
+
+func (n *NodeNetwork) getAddressVotes() *AddressVotes {
+	if n.Votes == nil {
+		n.Votes = NewAddressVotes()
+	}
+	return n.Votes
+}
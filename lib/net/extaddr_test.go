@@ -0,0 +1,45 @@
+package net
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExternalAddress(t *testing.T) {
+	n := NodeNetwork{}
+	n.Init()
+
+	n.ReportObservedAddress("10.0.0.1", "1.2.3.4")
+	// one node is not trusted
+	assert.Equal(t, "", n.GetExternalAddress())
+
+	// loopback addresses and reports from nodes on same machine are skipped
+	n.ReportObservedAddress("10.0.0.2", "127.0.0.1")
+	n.ReportObservedAddress("127.0.0.1", "1.2.3.4")
+	n.ReportObservedAddress("10.0.0.3", "wrong")
+	assert.Equal(t, "", n.GetExternalAddress())
+
+	n.ReportObservedAddress("10.0.0.2", "1.2.3.4")
+	assert.Equal(t, "1.2.3.4", n.GetExternalAddress())
+
+	// a node reports once. most reported address wins
+	n.ReportObservedAddress("10.0.0.3", "5.6.7.8")
+	n.ReportObservedAddress("10.0.0.3", "5.6.7.8")
+	assert.Equal(t, "1.2.3.4", n.GetExternalAddress())
+
+	n.ReportObservedAddress("10.0.0.4", "5.6.7.8")
+	n.ReportObservedAddress("10.0.0.5", "5.6.7.8")
+	assert.Equal(t, "5.6.7.8", n.GetExternalAddress())
+}
+
+func TestNodesToShare(t *testing.T) {
+	n := NodeNetwork{}
+	n.Init()
+
+	n.AddNodeToKnown(NewNodeAddr("10.0.0.1", 8765))
+	n.AddNodeToKnown(NewNodeAddr("10.0.0.2", 0))
+
+	assert.Equal(t, 2, n.GetCountOfKnownNodes())
+	assert.Equal(t, []NodeAddr{NewNodeAddr("10.0.0.1", 8765)}, n.GetNodesToShare())
+}
@@ -0,0 +1,115 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Limits of messages sizes. A request is rejected as soon as its length prefix is read,
+// so a payload bigger than a limit is never read to memory.
+// Big blocks and consensus data are transferred in chunks with "getchunk" command
+const (
+	DefaultMaxRequestSize = 2 * 1024 * 1024  // for commands without own limit
+	MaxResponseSize       = 64 * 1024 * 1024 // a client doesn't read bigger responses
+	MaxAuthStringSize     = 1024
+	TransferChunkSize     = 1024 * 1024     // big data are requested in chunks of this size
+	MaxTransferChunkSize  = 4 * 1024 * 1024 // a node doesn't return bigger chunks
+)
+
+// Limits of requests payloads per command
+type MessageLimits struct {
+	Default  uint32
+	Commands map[string]uint32
+}
+
+// Default limits. Custom limits replace them for given commands. Key "default" sets limit for all other commands
+func NewMessageLimits(custom map[string]int) *MessageLimits {
+	l := MessageLimits{}
+	l.Default = DefaultMaxRequestSize
+	l.Commands = map[string]uint32{
+		"block":        32 * 1024 * 1024, // a block can be pushed by other node in one piece
+		"tx":           8 * 1024 * 1024,
+		"txdata":       8 * 1024 * 1024,
+		"txsqlrequest": 8 * 1024 * 1024,
+	}
+
+	for command, limit := range custom {
+		if limit <= 0 {
+			continue
+		}
+		if command == "default" {
+			l.Default = uint32(limit)
+		} else {
+			l.Commands[command] = uint32(limit)
+		}
+	}
+	return &l
+}
+
+// Returns max length of a payload of a command
+func (l *MessageLimits) GetLimit(command string) uint32 {
+	if l == nil {
+		return DefaultMaxRequestSize
+	}
+	if limit, ok := l.Commands[command]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+// Returns biggest limit of all commands
+func (l *MessageLimits) GetMaxLimit() uint32 {
+	if l == nil {
+		return DefaultMaxRequestSize
+	}
+	max := l.Default
+
+	for _, limit := range l.Commands {
+		if limit > max {
+			max = limit
+		}
+	}
+	return max
+}
+
+// Check length of a payload before it is read
+func (l *MessageLimits) CheckLength(command string, length uint32) error {
+	if limit := l.GetLimit(command); length > limit {
+		return errors.New(fmt.Sprintf("Request %s is too big, %d bytes. Limit is %d bytes", command, length, limit))
+	}
+	return nil
+}
+
+// Read all data from a reader but not more than a limit. It is used to read responses
+func ReadAllLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, errors.New(fmt.Sprintf("Response is bigger than %d bytes", limit))
+	}
+	return data, nil
+}
+
+// Returns part of data for "getchunk" request
+func GetDataChunk(data []byte, offset int, length int) ([]byte, error) {
+	if offset < 0 || offset > len(data) {
+		return nil, errors.New(fmt.Sprintf("Wrong offset %d, data size is %d", offset, len(data)))
+	}
+
+	if length <= 0 || length > MaxTransferChunkSize {
+		length = MaxTransferChunkSize
+	}
+
+	end := offset + length
+
+	if end > len(data) {
+		end = len(data)
+	}
+	return data[offset:end], nil
+}
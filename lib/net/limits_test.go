@@ -0,0 +1,49 @@
+package net
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageLimits(t *testing.T) {
+	l := NewMessageLimits(map[string]int{"default": 1000, "tx": 5000, "block": 0})
+
+	assert.Equal(t, uint32(1000), l.GetLimit("version"))
+	assert.Equal(t, uint32(5000), l.GetLimit("tx"))
+	// zero doesn't replace default limit of a command
+	assert.Equal(t, uint32(32*1024*1024), l.GetLimit("block"))
+
+	assert.NoError(t, l.CheckLength("version", 1000))
+	assert.Error(t, l.CheckLength("version", 1001))
+
+	var empty *MessageLimits
+	assert.Equal(t, uint32(DefaultMaxRequestSize), empty.GetLimit("version"))
+
+	data, err := ReadAllLimited(bytes.NewReader(make([]byte, 100)), 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, len(data))
+
+	_, err = ReadAllLimited(bytes.NewReader(make([]byte, 101)), 100)
+	assert.Error(t, err)
+}
+
+func TestGetDataChunk(t *testing.T) {
+	data := []byte("0123456789")
+
+	chunk, err := GetDataChunk(data, 0, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123", string(chunk))
+
+	chunk, err = GetDataChunk(data, 8, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "89", string(chunk))
+
+	chunk, err = GetDataChunk(data, 10, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(chunk))
+
+	_, err = GetDataChunk(data, 11, 4)
+	assert.Error(t, err)
+}
@@ -0,0 +1,169 @@
+package net
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	gonet "net"
+	"time"
+)
+
+// Discovery of nodes in a local network. Every node sends announce packets to a multicast group
+// and listens for packets of other nodes. Nodes with same genesis block find each other without
+// any bootstrap list. This is useful for LAN clusters
+//
+// Packet
+// 6 bytes    magic "oursql"
+// uint8      version of a packet format
+// 8 bytes    random ID of a sender. A node skips own packets
+// uint16 BE  port of a node server
+// bytes      hash of a genesis block
+const (
+	LocalDiscoveryGroup    = "239.255.42.99:28899"
+	LocalDiscoveryInterval = 30 * time.Second
+	localDiscoveryMagic    = "oursql"
+	localDiscoveryVersion  = 1
+	localDiscoveryHeadSize = 17
+	localDiscoveryMaxSize  = 512
+)
+
+type LocalAnnounce struct {
+	SenderID []byte
+	Port     int
+	Genesis  []byte
+}
+
+// Called when other node of same blockchain is found in a local network
+type LocalNodeFoundHandler func(addr NodeAddr)
+
+type LocalDiscovery struct {
+	id      []byte
+	port    int
+	genesis []byte
+	found   LocalNodeFoundHandler
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func (a LocalAnnounce) Encode() []byte {
+	packet := make([]byte, localDiscoveryHeadSize, localDiscoveryHeadSize+len(a.Genesis))
+	copy(packet, localDiscoveryMagic)
+	packet[6] = localDiscoveryVersion
+	copy(packet[7:15], a.SenderID)
+	binary.BigEndian.PutUint16(packet[15:17], uint16(a.Port))
+
+	return append(packet, a.Genesis...)
+}
+
+func DecodeLocalAnnounce(packet []byte) (*LocalAnnounce, error) {
+	if len(packet) <= localDiscoveryHeadSize || string(packet[:6]) != localDiscoveryMagic {
+		return nil, errors.New("Not a discovery packet")
+	}
+
+	if packet[6] != localDiscoveryVersion {
+		return nil, errors.New("Unsupported discovery packet version")
+	}
+
+	a := LocalAnnounce{}
+	a.SenderID = append([]byte{}, packet[7:15]...)
+	a.Port = int(binary.BigEndian.Uint16(packet[15:17]))
+	a.Genesis = append([]byte{}, packet[localDiscoveryHeadSize:]...)
+
+	if a.Port == 0 {
+		return nil, errors.New("Port is empty")
+	}
+	return &a, nil
+}
+
+func NewLocalDiscovery(port int, genesis []byte, found LocalNodeFoundHandler) *LocalDiscovery {
+	d := LocalDiscovery{}
+	d.id = make([]byte, 8)
+	rand.Read(d.id)
+	d.port = port
+	d.genesis = genesis
+	d.found = found
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	return &d
+}
+
+// Join the multicast group and start to send and receive announces
+func (d *LocalDiscovery) Start() error {
+	group, err := gonet.ResolveUDPAddr("udp4", LocalDiscoveryGroup)
+
+	if err != nil {
+		return err
+	}
+
+	listener, err := gonet.ListenMulticastUDP("udp4", nil, group)
+
+	if err != nil {
+		return err
+	}
+
+	sender, err := gonet.DialUDP("udp4", nil, group)
+
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	go d.listen(listener)
+	go d.announce(sender)
+
+	return nil
+}
+
+func (d *LocalDiscovery) Stop() {
+	close(d.stop)
+	<-d.done
+}
+
+func (d *LocalDiscovery) announce(conn *gonet.UDPConn) {
+	defer conn.Close()
+
+	packet := LocalAnnounce{d.id, d.port, d.genesis}.Encode()
+
+	for {
+		conn.Write(packet)
+
+		select {
+		case <-d.stop:
+			return
+		case <-time.After(LocalDiscoveryInterval):
+		}
+	}
+}
+
+func (d *LocalDiscovery) listen(conn *gonet.UDPConn) {
+	defer close(d.done)
+
+	go func() {
+		<-d.stop
+		conn.Close()
+	}()
+
+	buf := make([]byte, localDiscoveryMaxSize)
+
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+
+		if err != nil {
+			select {
+			case <-d.stop:
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		a, err := DecodeLocalAnnounce(buf[:n])
+
+		if err != nil || bytes.Equal(a.SenderID, d.id) || !bytes.Equal(a.Genesis, d.genesis) {
+			continue
+		}
+
+		d.found(NewNodeAddr(from.IP.String(), a.Port))
+	}
+}
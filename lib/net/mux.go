@@ -0,0 +1,401 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	gonet "net"
+	"sync"
+	"time"
+)
+
+// Persistent connection between nodes. It is opened with "mux" command and after that
+// both sides can send requests over it. Many requests can wait for responses at same time,
+// every request has own stream ID and a response comes with same ID.
+//
+// Frame
+// uint32 BE  length of rest of a frame
+// uint32 BE  stream ID
+// uint8      kind of a frame
+// bytes      data. Request or response in envelope format
+//
+// A request frame is checked by limits of its command as soon as the command name is read,
+// so a too big request is skipped without reading it to memory
+const (
+	muxFrameRequest  = 0 // a response is expected
+	muxFrameOneWay   = 1 // no response
+	muxFrameResponse = 2
+	muxFramePing     = 3
+)
+
+const (
+	MuxPingInterval  = 30 * time.Second
+	MuxMaxHandlers   = 32 // requests executed at same time on one connection. Others get "busy" response
+	muxWriteTimeout  = 10 * time.Second
+	muxIdleTimeout   = 3 * MuxPingInterval
+	muxFrameHeadSize = 9
+	muxMaxResponse   = MaxResponseSize + 7 // envelope head of a response
+)
+
+// Called for every request received on a connection. If needResponse is true, Respond must be called
+type MuxRequestHandler func(c *MuxConn, id uint32, data []byte, needResponse bool)
+
+type MuxConn struct {
+	Addr   NodeAddr       // other node
+	Limits *MessageLimits // limits of received requests. Must be set before Run
+
+	conn      gonet.Conn
+	handler   MuxRequestHandler
+	handlers  chan struct{} // slots of requests executed now
+	writeLock sync.Mutex
+	lock      sync.Mutex
+	nextID    uint32
+	waiting   map[uint32]chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewMuxConn(conn gonet.Conn, addr NodeAddr, handler MuxRequestHandler) *MuxConn {
+	c := &MuxConn{}
+	c.Addr = addr
+	c.conn = conn
+	c.handler = handler
+	c.Limits = NewMessageLimits(nil)
+	c.handlers = make(chan struct{}, MuxMaxHandlers)
+	c.waiting = map[uint32]chan []byte{}
+	c.closed = make(chan struct{})
+	return c
+}
+
+// Reads frames till a connection is closed. Requests are handled in separate routines
+func (c *MuxConn) Run() error {
+	defer c.Close()
+
+	go c.pinger()
+
+	head := make([]byte, muxFrameHeadSize)
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(muxIdleTimeout))
+
+		if _, err := io.ReadFull(c.conn, head); err != nil {
+			return err
+		}
+
+		length := binary.BigEndian.Uint32(head[0:4])
+		id := binary.BigEndian.Uint32(head[4:8])
+		kind := head[8]
+
+		if length < muxFrameHeadSize-4 || length-(muxFrameHeadSize-4) > c.getMaxFrameData(kind) {
+			return errors.New(fmt.Sprintf("Wrong frame length %d", length))
+		}
+
+		length -= muxFrameHeadSize - 4
+
+		switch kind {
+		case muxFrameRequest, muxFrameOneWay:
+			data, err := c.readRequest(id, length, kind == muxFrameRequest)
+
+			if err != nil {
+				return err
+			}
+
+			if data != nil {
+				c.handleRequest(id, data, kind == muxFrameRequest)
+			}
+		case muxFrameResponse:
+			data := make([]byte, length)
+
+			if _, err := io.ReadFull(c.conn, data); err != nil {
+				return err
+			}
+
+			c.lock.Lock()
+			ch, ok := c.waiting[id]
+			delete(c.waiting, id)
+			c.lock.Unlock()
+
+			if ok {
+				ch <- data
+			}
+		case muxFramePing:
+		default:
+			return errors.New(fmt.Sprintf("Unknown frame kind %d", kind))
+		}
+	}
+}
+
+// Max length of data in a frame of a kind
+func (c *MuxConn) getMaxFrameData(kind byte) uint32 {
+	switch kind {
+	case muxFrameRequest, muxFrameOneWay:
+		// version, codec, command, auth string and a payload of a biggest command
+		return 3 + maxEnvelopeCommandLength + 4 + MaxAuthStringSize + 4 + c.Limits.GetMaxLimit()
+	case muxFrameResponse:
+		return muxMaxResponse
+	}
+	return 0
+}
+
+// Read a request of a frame. A command name and auth string are read first, and if a payload is bigger
+// than the command limit, rest of the request is skipped and nil is returned
+func (c *MuxConn) readRequest(id uint32, length uint32, needResponse bool) ([]byte, error) {
+	data := make([]byte, 3, length)
+
+	if length < 3+4+4 {
+		return nil, errors.New("Request frame is too short")
+	}
+
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		return nil, err
+	}
+
+	if data[0] != EnvelopeVersion || 3+uint32(data[2])+4+4 > length {
+		return nil, errors.New("Request frame is not in envelope format")
+	}
+
+	// command name and length of auth string
+	data = data[:3+int(data[2])+4]
+
+	if _, err := io.ReadFull(c.conn, data[3:]); err != nil {
+		return nil, err
+	}
+
+	command := string(data[3 : len(data)-4])
+	authLength := binary.BigEndian.Uint32(data[len(data)-4:])
+	rest := length - uint32(len(data))
+
+	if authLength > MaxAuthStringSize || authLength+4 > rest {
+		return nil, errors.New("Wrong length of auth string in request frame")
+	}
+
+	if payloadLength := rest - authLength - 4; payloadLength > c.Limits.GetLimit(command) {
+		if _, err := io.CopyN(ioutil.Discard, c.conn, int64(rest)); err != nil {
+			return nil, err
+		}
+
+		if needResponse {
+			err := errors.New(fmt.Sprintf("Request %s is too big, %d bytes. Limit is %d bytes", command, payloadLength, c.Limits.GetLimit(command)))
+			c.respondError(id, data[1], err)
+		}
+		return nil, nil
+	}
+
+	data = data[:length]
+
+	if _, err := io.ReadFull(c.conn, data[length-rest:]); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Execute a request in separate routine. When too many requests are executed, the request is refused with "busy"
+func (c *MuxConn) handleRequest(id uint32, data []byte, needResponse bool) {
+	if c.handler == nil {
+		return
+	}
+
+	select {
+	case c.handlers <- struct{}{}:
+	default:
+		if needResponse {
+			c.respondError(id, data[1], NewBusyError(fmt.Sprintf("too many requests on a connection. Retry after %d seconds", BusyRetryAfter/time.Second)))
+		}
+		return
+	}
+
+	go func() {
+		defer func() { <-c.handlers }()
+
+		c.handler(c, id, data, needResponse)
+	}()
+}
+
+// Send error response in envelope format
+func (c *MuxConn) respondError(id uint32, codec byte, err error) {
+	payload, perr := EncodePayload(codec, err.Error())
+
+	if perr != nil {
+		payload, _ = EncodePayload(CodecGob, err.Error())
+		codec = CodecGob
+	}
+	c.Respond(id, EncodeEnvelopeResponse(codec, false, payload))
+}
+
+// Send ping regularly, so other side knows the connection is alive
+func (c *MuxConn) pinger() {
+	ticker := time.NewTicker(MuxPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			if c.writeFrame(0, muxFramePing, []byte{}) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *MuxConn) writeFrame(id uint32, kind byte, data []byte) error {
+	frame := make([]byte, muxFrameHeadSize, muxFrameHeadSize+len(data))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(data)+muxFrameHeadSize-4))
+	binary.BigEndian.PutUint32(frame[4:8], id)
+	frame[8] = kind
+	frame = append(frame, data...)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(muxWriteTimeout))
+
+	_, err := c.conn.Write(frame)
+
+	if err != nil {
+		c.Close()
+		return NewCanNotSendError(err.Error())
+	}
+	return nil
+}
+
+// Send a request and wait for a response
+func (c *MuxConn) Request(data []byte, timeout time.Duration) ([]byte, error) {
+	ch := make(chan []byte, 1)
+
+	c.lock.Lock()
+	c.nextID++
+	id := c.nextID
+	c.waiting[id] = ch
+	c.lock.Unlock()
+
+	removeWaiting := func() {
+		c.lock.Lock()
+		delete(c.waiting, id)
+		c.lock.Unlock()
+	}
+
+	if err := c.writeFrame(id, muxFrameRequest, data); err != nil {
+		removeWaiting()
+		return nil, err
+	}
+
+	select {
+	case response := <-ch:
+		return response, nil
+	case <-c.closed:
+		removeWaiting()
+		return nil, NewCanNotSendError("Connection closed")
+	case <-time.After(timeout):
+		removeWaiting()
+		return nil, NewTimeoutError(fmt.Sprintf("No response from %s", c.Addr.NodeAddrToString()))
+	}
+}
+
+// Send a request without waiting any response
+func (c *MuxConn) Send(data []byte) error {
+	return c.writeFrame(0, muxFrameOneWay, data)
+}
+
+// Send a response for a request
+func (c *MuxConn) Respond(id uint32, data []byte) error {
+	return c.writeFrame(id, muxFrameResponse, data)
+}
+
+func (c *MuxConn) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+// The channel is closed when a connection is closed
+func (c *MuxConn) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Persistent connections to other nodes, one per node address. This is shared between all clones of a node
+type PeerConnections struct {
+	lock  sync.Mutex
+	conns map[string]*MuxConn
+}
+
+func NewPeerConnections() *PeerConnections {
+	p := PeerConnections{}
+	p.conns = map[string]*MuxConn{}
+	return &p
+}
+
+// Returns a connection to a node or nil
+func (p *PeerConnections) Get(addr NodeAddr) *MuxConn {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	c, ok := p.conns[addr.NodeAddrToString()]
+
+	if !ok {
+		return nil
+	}
+
+	select {
+	case <-c.closed:
+		delete(p.conns, addr.NodeAddrToString())
+		return nil
+	default:
+	}
+	return c
+}
+
+// Remember a connection. Returns false if there is a connection to the node already.
+// The connection is forgotten when it is closed
+func (p *PeerConnections) Add(c *MuxConn) bool {
+	p.lock.Lock()
+
+	if old, ok := p.conns[c.Addr.NodeAddrToString()]; ok {
+		select {
+		case <-old.closed:
+		default:
+			p.lock.Unlock()
+			return false
+		}
+	}
+	p.conns[c.Addr.NodeAddrToString()] = c
+	p.lock.Unlock()
+
+	go func() {
+		<-c.closed
+		p.remove(c)
+	}()
+	return true
+}
+
+func (p *PeerConnections) remove(c *MuxConn) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.conns[c.Addr.NodeAddrToString()] == c {
+		delete(p.conns, c.Addr.NodeAddrToString())
+	}
+}
+
+// Returns count of open connections
+func (p *PeerConnections) Count() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.conns)
+}
+
+func (p *PeerConnections) CloseAll() {
+	p.lock.Lock()
+	conns := p.conns
+	p.conns = map[string]*MuxConn{}
+	p.lock.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+}
@@ -0,0 +1,154 @@
+package net
+
+import (
+	gonet "net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestMuxRequest(t *testing.T, command string, data interface{}) []byte {
+	request, err := EncodeEnvelopeRequest(CodecGob, command, "", data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request
+}
+
+func getTestMuxCommand(data []byte) string {
+	return string(data[3 : 3+data[2]])
+}
+
+func TestMuxConn(t *testing.T) {
+	c1, c2 := gonet.Pipe()
+
+	oneway := make(chan string, 1)
+
+	server := NewMuxConn(c1, NewNodeAddr("client", 8000), func(c *MuxConn, id uint32, data []byte, needResponse bool) {
+		command := getTestMuxCommand(data)
+
+		if !needResponse {
+			oneway <- command
+			return
+		}
+		if command == "slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		c.Respond(id, []byte("re:"+command))
+	})
+	client := NewMuxConn(c2, NewNodeAddr("server", 8000), nil)
+
+	go server.Run()
+	go client.Run()
+
+	// responses come to the request which waits for them, even if they are sent in other order
+	var wg sync.WaitGroup
+
+	for _, r := range []string{"slow", "fast", "other"} {
+		wg.Add(1)
+
+		go func(r string) {
+			defer wg.Done()
+
+			response, err := client.Request(makeTestMuxRequest(t, r, nil), time.Second)
+			assert.NoError(t, err)
+			assert.Equal(t, "re:"+r, string(response))
+		}(r)
+	}
+	wg.Wait()
+
+	assert.NoError(t, client.Send(makeTestMuxRequest(t, "push", nil)))
+	assert.Equal(t, "push", <-oneway)
+
+	conns := NewPeerConnections()
+	assert.True(t, conns.Add(client))
+	assert.False(t, conns.Add(NewMuxConn(nil, NewNodeAddr("server", 8000), nil)))
+	assert.Equal(t, client, conns.Get(NewNodeAddr("server", 8000)))
+	assert.Nil(t, conns.Get(NewNodeAddr("server", 8001)))
+
+	server.Close()
+
+	_, err := client.Request(makeTestMuxRequest(t, "fast", nil), time.Second)
+	assert.Error(t, err)
+
+	select {
+	case <-client.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("Connection is not closed")
+	}
+	assert.Nil(t, conns.Get(NewNodeAddr("server", 8000)))
+}
+
+func TestMuxConnLimits(t *testing.T) {
+	c1, c2 := gonet.Pipe()
+
+	release := make(chan struct{})
+
+	server := NewMuxConn(c1, NewNodeAddr("client", 8000), func(c *MuxConn, id uint32, data []byte, needResponse bool) {
+		if getTestMuxCommand(data) == "wait" {
+			<-release
+		}
+		c.Respond(id, EncodeEnvelopeResponse(CodecGob, true, []byte{}))
+	})
+	server.Limits = &MessageLimits{Default: 100, Commands: map[string]uint32{"block": 1000}}
+
+	client := NewMuxConn(c2, NewNodeAddr("server", 8000), nil)
+
+	go server.Run()
+	go client.Run()
+
+	defer client.Close()
+
+	checkError := func(response []byte, message string) {
+		codec, success, payload, err := DecodeEnvelopeResponse(response)
+		assert.NoError(t, err)
+		assert.False(t, success)
+
+		var errstr string
+		assert.NoError(t, DecodePayload(codec, payload, &errstr))
+		assert.Contains(t, errstr, message)
+	}
+
+	// request bigger than a limit of its command is skipped without reading
+	assert.Equal(t, uint32(1000), server.Limits.GetMaxLimit())
+
+	response, err := client.Request(makeTestMuxRequest(t, "addr", string(make([]byte, 500))), time.Second)
+	assert.NoError(t, err)
+	checkError(response, "Request addr is too big")
+
+	// other command has bigger limit
+	response, err = client.Request(makeTestMuxRequest(t, "block", string(make([]byte, 500))), time.Second)
+	assert.NoError(t, err)
+	_, success, _, _ := DecodeEnvelopeResponse(response)
+	assert.True(t, success)
+
+	// all handlers are busy
+	for i := 0; i < MuxMaxHandlers; i++ {
+		assert.NoError(t, client.Send(makeTestMuxRequest(t, "wait", nil)))
+	}
+
+	response, err = client.Request(makeTestMuxRequest(t, "tx", nil), time.Second)
+	assert.NoError(t, err)
+	checkError(response, busyErrorPrefix)
+
+	close(release)
+
+	time.Sleep(50 * time.Millisecond)
+
+	response, err = client.Request(makeTestMuxRequest(t, "tx", nil), time.Second)
+	assert.NoError(t, err)
+	_, success, _, _ = DecodeEnvelopeResponse(response)
+	assert.True(t, success)
+
+	// frame bigger than limits of all commands closes the connection
+	client.Send(makeTestMuxRequest(t, "block", string(make([]byte, 5000))))
+
+	select {
+	case <-server.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("Connection is not closed")
+	}
+}
@@ -0,0 +1,423 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	gonet "net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Port mapping on a router. Nodes behind NAT ask a router to forward a port to them,
+// so other nodes can connect. UPnP IGD and NAT-PMP protocols are supported
+type NAT interface {
+	// Returns external IP address of a router
+	GetExternalAddress() (gonet.IP, error)
+	// Forward external port to a local port. Returns external port, a router can choose other port
+	AddPortMapping(internalPort, externalPort int, lifetime time.Duration) (int, error)
+	DeletePortMapping(internalPort, externalPort int) error
+	String() string
+}
+
+const (
+	NATKindAuto = "auto"
+	NATKindUPnP = "upnp"
+	NATKindPMP  = "pmp"
+
+	NATMappingLifetime = 20 * time.Minute
+	natTimeout         = 3 * time.Second
+	natPMPPort         = 5351
+	ssdpAddress        = "239.255.255.250:1900"
+	natMappingName     = "oursql node"
+)
+
+// Find a router which supports port mapping. kind is auto, upnp or pmp
+func DiscoverNAT(kind string) (NAT, error) {
+	if kind == NATKindUPnP || kind == NATKindAuto {
+		n, err := discoverUPnP()
+
+		if err == nil || kind == NATKindUPnP {
+			return n, err
+		}
+	}
+
+	if kind == NATKindPMP || kind == NATKindAuto {
+		return discoverPMP()
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown NAT kind %s", kind))
+}
+
+// NAT-PMP, RFC 6886
+type natPMP struct {
+	gateway gonet.IP
+}
+
+func discoverPMP() (NAT, error) {
+	gateway, err := getDefaultGateway()
+
+	if err != nil {
+		return nil, err
+	}
+
+	n := &natPMP{gateway}
+
+	if _, err := n.GetExternalAddress(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *natPMP) String() string {
+	return "NAT-PMP " + n.gateway.String()
+}
+
+func (n *natPMP) request(msg []byte, responseLength int) ([]byte, error) {
+	conn, err := gonet.DialUDP("udp4", nil, &gonet.UDPAddr{IP: n.gateway, Port: natPMPPort})
+
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	response := make([]byte, 16)
+
+	// request is repeated, UDP packets can be lost
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(natTimeout / 3))
+
+		read, err := conn.Read(response)
+
+		if err != nil {
+			continue
+		}
+
+		if read < responseLength || response[1] != msg[1]+128 {
+			return nil, errors.New("Wrong NAT-PMP response")
+		}
+
+		if code := binary.BigEndian.Uint16(response[2:4]); code != 0 {
+			return nil, errors.New(fmt.Sprintf("NAT-PMP error code %d", code))
+		}
+		return response[:read], nil
+	}
+	return nil, NewTimeoutError("No NAT-PMP response from " + n.gateway.String())
+}
+
+func (n *natPMP) GetExternalAddress() (gonet.IP, error) {
+	response, err := n.request([]byte{0, 0}, 12)
+
+	if err != nil {
+		return nil, err
+	}
+	return gonet.IPv4(response[8], response[9], response[10], response[11]), nil
+}
+
+func (n *natPMP) AddPortMapping(internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	msg := make([]byte, 12)
+	msg[1] = 2 // TCP
+	binary.BigEndian.PutUint16(msg[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(msg[6:8], uint16(externalPort))
+	binary.BigEndian.PutUint32(msg[8:12], uint32(lifetime/time.Second))
+
+	response, err := n.request(msg, 16)
+
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(response[10:12])), nil
+}
+
+func (n *natPMP) DeletePortMapping(internalPort, externalPort int) error {
+	_, err := n.AddPortMapping(internalPort, 0, 0)
+	return err
+}
+
+// Reads default gateway from routes table. On other systems than Linux it is guessed from a local address
+func getDefaultGateway() (gonet.IP, error) {
+	if data, err := ioutil.ReadFile("/proc/net/route"); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+
+			if len(fields) < 3 || fields[1] != "00000000" {
+				continue
+			}
+
+			gw, err := hex.DecodeString(fields[2])
+
+			if err != nil || len(gw) != 4 {
+				continue
+			}
+			// little endian
+			return gonet.IPv4(gw[3], gw[2], gw[1], gw[0]), nil
+		}
+	}
+
+	ip, err := getLocalIP()
+
+	if err != nil {
+		return nil, err
+	}
+	ip4 := ip.To4()
+
+	if ip4 == nil {
+		return nil, errors.New("Default gateway is not found")
+	}
+	return gonet.IPv4(ip4[0], ip4[1], ip4[2], 1), nil
+}
+
+// Returns local address used for outgoing connections
+func getLocalIP() (gonet.IP, error) {
+	conn, err := gonet.Dial("udp4", ssdpAddress)
+
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*gonet.UDPAddr).IP, nil
+}
+
+// UPnP Internet Gateway Device
+type natUPnP struct {
+	controlURL  string
+	serviceType string
+	localIP     gonet.IP
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+func discoverUPnP() (NAT, error) {
+	location, err := ssdpSearch()
+
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.Client{Timeout: natTimeout}
+
+	resp, err := client.Get(location)
+
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	root := upnpRoot{}
+
+	if err := xml.NewDecoder(resp.Body).Decode(&root); err != nil {
+		return nil, err
+	}
+
+	service := findUPnPService(root.Device)
+
+	if service == nil {
+		return nil, errors.New("Router doesn't support port mapping")
+	}
+
+	base := location
+
+	if root.URLBase != "" {
+		base = root.URLBase
+	}
+
+	baseURL, err := url.Parse(base)
+
+	if err != nil {
+		return nil, err
+	}
+
+	controlURL, err := baseURL.Parse(service.ControlURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	localIP, err := getLocalIP()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &natUPnP{controlURL.String(), service.ServiceType, localIP}, nil
+}
+
+// Send SSDP search and return location of a device description
+func ssdpSearch() (string, error) {
+	conn, err := gonet.ListenUDP("udp4", nil)
+
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	addr, err := gonet.ResolveUDPAddr("udp4", ssdpAddress)
+
+	if err != nil {
+		return "", err
+	}
+
+	msg := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddress + "\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+
+	if _, err := conn.WriteTo([]byte(msg), addr); err != nil {
+		return "", err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(natTimeout))
+
+	buf := make([]byte, 2048)
+
+	for {
+		n, _, err := conn.ReadFrom(buf)
+
+		if err != nil {
+			return "", NewTimeoutError("UPnP router is not found")
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+
+		if err != nil {
+			continue
+		}
+
+		if location := resp.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+func findUPnPService(d upnpDevice) *upnpService {
+	for _, s := range d.Services {
+		if strings.Contains(s.ServiceType, ":WANIPConnection:") || strings.Contains(s.ServiceType, ":WANPPPConnection:") {
+			return &s
+		}
+	}
+	for _, sub := range d.Devices {
+		if s := findUPnPService(sub); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+func (n *natUPnP) String() string {
+	return "UPnP " + n.controlURL
+}
+
+// Call SOAP action of a router. Returns response body
+func (n *natUPnP) soapRequest(action string, args string) ([]byte, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + n.serviceType + `">` + args + `</u:` + action + `></s:Body></s:Envelope>`
+
+	req, err := http.NewRequest("POST", n.controlURL, strings.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+n.serviceType+"#"+action+`"`)
+
+	client := http.Client{Timeout: natTimeout}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ReadAllLimited(resp.Body, 64*1024)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("UPnP %s failed with status %d", action, resp.StatusCode))
+	}
+	return data, nil
+}
+
+func (n *natUPnP) GetExternalAddress() (gonet.IP, error) {
+	data, err := n.soapRequest("GetExternalIPAddress", "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}{}
+
+	if err := xml.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+
+	ip := gonet.ParseIP(result.IP)
+
+	if ip == nil {
+		return nil, errors.New("Router returned wrong external address")
+	}
+	return ip, nil
+}
+
+func (n *natUPnP) AddPortMapping(internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	args := "<NewRemoteHost></NewRemoteHost>" +
+		"<NewExternalPort>" + strconv.Itoa(externalPort) + "</NewExternalPort>" +
+		"<NewProtocol>TCP</NewProtocol>" +
+		"<NewInternalPort>" + strconv.Itoa(internalPort) + "</NewInternalPort>" +
+		"<NewInternalClient>" + n.localIP.String() + "</NewInternalClient>" +
+		"<NewEnabled>1</NewEnabled>" +
+		"<NewPortMappingDescription>" + natMappingName + "</NewPortMappingDescription>" +
+		"<NewLeaseDuration>" + strconv.Itoa(int(lifetime/time.Second)) + "</NewLeaseDuration>"
+
+	_, err := n.soapRequest("AddPortMapping", args)
+
+	if err != nil {
+		return 0, err
+	}
+	return externalPort, nil
+}
+
+func (n *natUPnP) DeletePortMapping(internalPort, externalPort int) error {
+	args := "<NewRemoteHost></NewRemoteHost>" +
+		"<NewExternalPort>" + strconv.Itoa(externalPort) + "</NewExternalPort>" +
+		"<NewProtocol>TCP</NewProtocol>"
+
+	_, err := n.soapRequest("DeletePortMapping", args)
+
+	return err
+}
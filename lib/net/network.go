@@ -10,22 +10,25 @@ import (
 )
 
 const Protocol = "tcp"
-const NodeVersion = 1
+const NodeVersion = 3
+const MinNodeVersion = 1 // nodes with older protocol are refused. Nodes of version 1 don't send chain hashes, they are accepted
 const CommandLength = 12
 const AuthStringLength = 20
 
 // Represents a node address
 type NodeAddr struct {
-	Host                     string
-	Port                     int
-	SuccessConnections       uint
-	FailedConnections        uint
-	SuccessIncomeConnections uint
+	Host                     string `proto:"1"`
+	Port                     int    `proto:"2"`
+	SuccessConnections       uint   `proto:"3"`
+	FailedConnections        uint   `proto:"4"`
+	SuccessIncomeConnections uint   `proto:"5"`
+	Identity                 string `proto:"6"` // pinned identity of a node. Empty if not known yet
+	Codec                    string `proto:"7"` // codec negotiated with a node. Empty means legacy protocol
 }
 
 type NodeAddrShort struct {
-	Host []byte
-	Port int
+	Host []byte `proto:"1"`
+	Port int    `proto:"2"`
 }
 
 func NewNodeAddr(host string, port int) NodeAddr {
@@ -45,6 +48,14 @@ func (n NodeAddr) String() string {
 	return n.Host + ":" + strconv.Itoa(n.Port)
 }
 
+// Convert to string in format host:port#identity. Identity part is added only if it is known
+func (n NodeAddr) StringWithIdentity() string {
+	if n.Identity == "" {
+		return n.String()
+	}
+	return n.String() + "#" + n.Identity
+}
+
 // Notify this address got success attempt to connect
 func (n *NodeAddr) ReportSuccessConn() {
 	n.SuccessConnections = n.SuccessConnections + 1
@@ -84,8 +95,17 @@ func (n NodeAddr) CompareToAddress(addr NodeAddr) bool {
 	return (h1 == h2 && addr.Port == n.Port)
 }
 
-// Parse from string
+// Nodes in outbound-only mode have zero port. They can be reached only over persistent connections they open
+func (n NodeAddr) AcceptsConnections() bool {
+	return n.Port > 0
+}
+
+// Parse from string. Format is host:port with optional #identity
 func (n *NodeAddr) LoadFromString(addr string) error {
+	if pos := strings.Index(addr, "#"); pos > 0 {
+		n.Identity = addr[pos+1:]
+		addr = addr[:pos]
+	}
 	parts := strings.SplitN(addr, ":", 2)
 
 	if len(parts) < 2 {
@@ -7,13 +7,18 @@ import (
 )
 
 // INterface for extra storage for a nodes.
-// TODO
-// This is not used yet
+// It keeps known nodes, bans and the address book
 type NodeNetworkStorage interface {
 	GetNodes() ([]NodeAddr, error)
 	AddNodeToKnown(addr NodeAddr)
 	RemoveNodeFromKnown(addr NodeAddr)
 	GetCountOfKnownNodes() (int, error)
+	GetBans() ([]PeerBan, error)
+	AddBan(ban PeerBan)
+	RemoveBan(host string)
+	GetAddresses() ([]KnownAddress, error)
+	SaveAddress(addr KnownAddress)
+	RemoveAddress(addr NodeAddr)
 }
 
 // This manages list of known nodes by a node
@@ -23,6 +28,10 @@ type NodeNetwork struct {
 	hadInputConnects       bool
 	hadRecentInputConnects bool
 	Storage                NodeNetworkStorage
+	Peers                  *PeerScores
+	Conns                  *PeerConnections // persistent connections to other nodes
+	Book                   *AddressBook     // addresses found with discovery
+	Votes                  *AddressVotes    // external address of this node reported by other nodes
 	lock                   *sync.Mutex
 }
 
@@ -34,6 +43,10 @@ type NodesListJSON struct {
 // Init nodes network object
 func (n *NodeNetwork) Init() {
 	n.lock = &sync.Mutex{}
+	n.Peers = NewPeerScores()
+	n.Conns = NewPeerConnections()
+	n.Book = NewAddressBook()
+	n.Votes = NewAddressVotes()
 }
 
 // Set extra storage for a nodes
@@ -130,6 +143,18 @@ func (n *NodeNetwork) CheckHadInputConnects() bool {
 	return n.hadRecentInputConnects
 }
 
+// Returns nodes which can be given to other nodes. Outbound-only nodes are skipped, nobody can connect to them
+func (n *NodeNetwork) GetNodesToShare() []NodeAddr {
+	list := []NodeAddr{}
+
+	for _, node := range n.Nodes {
+		if node.AcceptsConnections() {
+			list = append(list, node)
+		}
+	}
+	return list
+}
+
 // Get list of nodes in short format
 func (n *NodeNetwork) GetNodesToExport() (list []NodeAddrShort) {
 	list = []NodeAddrShort{}
@@ -153,6 +178,8 @@ func (n *NodeNetwork) AddNodeToKnown(addr NodeAddr) bool {
 	for _, node := range n.Nodes {
 		if node.CompareToAddress(addr) {
 			exists = true
+			// pinned identity is not changed here. SetNodeIdentity must be used for this
+			addr = node
 			break
 		}
 	}
@@ -160,13 +187,83 @@ func (n *NodeNetwork) AddNodeToKnown(addr NodeAddr) bool {
 		n.Nodes = append(n.Nodes, addr)
 	}
 
-	if n.Storage != nil {
+	// outbound-only node is known while its persistent connection is open. It is not saved
+	if n.Storage != nil && addr.AcceptsConnections() {
 		n.Storage.AddNodeToKnown(addr)
 	}
 
 	return !exists
 }
 
+// Returns pinned identity of a node. Empty string if a node is not known or identity is not pinned
+func (n *NodeNetwork) GetNodeIdentity(addr NodeAddr) string {
+	for _, node := range n.Nodes {
+		if node.CompareToAddress(addr) {
+			return node.Identity
+		}
+	}
+	return ""
+}
+
+// Pin identity of a known node. Returns false if a node is not known
+func (n *NodeNetwork) SetNodeIdentity(addr NodeAddr, identity string) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for i, node := range n.Nodes {
+		if node.CompareToAddress(addr) {
+			n.Nodes[i].Identity = identity
+
+			if n.Storage != nil {
+				n.Storage.AddNodeToKnown(n.Nodes[i])
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Returns codec negotiated with a node. Empty string if a node knows only legacy protocol
+func (n *NodeNetwork) GetNodeCodec(addr NodeAddr) string {
+	for _, node := range n.Nodes {
+		if node.CompareToAddress(addr) {
+			return node.Codec
+		}
+	}
+	return ""
+}
+
+// Remember codec negotiated with a known node. It is not saved to a storage,
+// codecs are negotiated again after restart. Returns false if a node is not known
+func (n *NodeNetwork) SetNodeCodec(addr NodeAddr, codec string) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for i, node := range n.Nodes {
+		if node.CompareToAddress(addr) {
+			n.Nodes[i].Codec = codec
+			return true
+		}
+	}
+	return false
+}
+
+// Returns persistent connection to a node or nil if there is no connection
+func (n *NodeNetwork) GetPeerConnection(addr NodeAddr) *MuxConn {
+	if n.Conns == nil {
+		return nil
+	}
+	return n.Conns.Get(addr)
+}
+
+// Returns count of persistent connections to other nodes
+func (n *NodeNetwork) CountPeerConnections() int {
+	if n.Conns == nil {
+		return 0
+	}
+	return n.Conns.Count()
+}
+
 // Removes a node from known
 func (n *NodeNetwork) RemoveNodeFromKnown(addr NodeAddr) {
 	n.lock.Lock()
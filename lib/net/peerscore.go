@@ -0,0 +1,281 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	gonet "net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scoring of peers. Every host starts with max score. The score is decreased when a peer
+// sends invalid data or doesn't respond in time, and it restores slowly with time.
+// When the score falls to zero, the host is banned for some time.
+// Score is kept per host, not per host:port, a peer can connect from any port.
+const (
+	PeerScoreMax              = 100
+	PeerScoreRestorePerMinute = 1
+	PeerBanDuration           = 24 * time.Hour
+)
+
+// Penalties for misbehavior
+const (
+	PenaltyInvalidBlock       = 50
+	PenaltyInvalidTransaction = 20
+	PenaltyMalformedMessage   = 10
+	PenaltyTimeout            = 5
+	PenaltyWrongChain         = PeerScoreMax // a node of other blockchain is banned at once
+)
+
+// Temporary ban of a host
+type PeerBan struct {
+	Host   string `proto:"1"`
+	Until  int64  `proto:"2"` // unix time
+	Reason string `proto:"3"`
+}
+
+type peerScore struct {
+	score   int
+	updated time.Time
+}
+
+// Scores and bans. This is shared between all clones of a node
+type PeerScores struct {
+	lock   sync.Mutex
+	scores map[string]*peerScore
+	bans   map[string]PeerBan
+	busy   map[string]time.Time // hosts which asked to not send requests till this time
+	plain  map[string]time.Time // hosts which don't support TLS. They are contacted with plain connections till this time
+}
+
+func NewPeerScores() *PeerScores {
+	p := PeerScores{}
+	p.scores = map[string]*peerScore{}
+	p.bans = map[string]PeerBan{}
+	p.busy = map[string]time.Time{}
+	p.plain = map[string]time.Time{}
+	return &p
+}
+
+// Convert to string in format until:reason to save in a storage
+func (b PeerBan) String() string {
+	return strconv.FormatInt(b.Until, 10) + ":" + b.Reason
+}
+
+// Parse a ban from string in format until:reason
+func (b *PeerBan) LoadFromString(host, data string) error {
+	pos := strings.Index(data, ":")
+
+	if pos < 0 {
+		return errors.New("Wrong ban format")
+	}
+
+	until, err := strconv.ParseInt(data[:pos], 10, 64)
+
+	if err != nil {
+		return err
+	}
+
+	b.Host = host
+	b.Until = until
+	b.Reason = data[pos+1:]
+
+	return nil
+}
+
+// Check if a ban is still active
+func (b PeerBan) IsActive() bool {
+	return b.Until > time.Now().Unix()
+}
+
+// Returns current score of a host. Restores it for the time passed since last change
+func (p *PeerScores) getScore(host string) *peerScore {
+	s, ok := p.scores[host]
+
+	if !ok {
+		s = &peerScore{PeerScoreMax, time.Now()}
+		p.scores[host] = s
+		return s
+	}
+
+	restore := int(time.Since(s.updated)/time.Minute) * PeerScoreRestorePerMinute
+
+	if restore > 0 {
+		s.score += restore
+		s.updated = time.Now()
+
+		if s.score > PeerScoreMax {
+			s.score = PeerScoreMax
+		}
+	}
+	return s
+}
+
+// Loopback hosts are never banned. Operators use CLI to talk to own node on localhost
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := gonet.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+func (n *NodeNetwork) getPeerScores() *PeerScores {
+	if n.Peers == nil {
+		n.Peers = NewPeerScores()
+	}
+	return n.Peers
+}
+
+// Load bans from a storage
+func (n *NodeNetwork) LoadBans() error {
+	if n.Storage == nil {
+		return nil
+	}
+
+	bans, err := n.Storage.GetBans()
+
+	if err != nil {
+		return err
+	}
+
+	p := n.getPeerScores()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, ban := range bans {
+		if ban.IsActive() {
+			p.bans[ban.Host] = ban
+		} else {
+			n.Storage.RemoveBan(ban.Host)
+		}
+	}
+	return nil
+}
+
+// Decrease score of a host. The host is banned when the score falls to zero.
+// Returns true if the host is banned after this
+func (n *NodeNetwork) ReportPeerMisbehavior(host string, penalty int, reason string) bool {
+	if host == "" || isLoopbackHost(host) {
+		return false
+	}
+
+	p := n.getPeerScores()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s := p.getScore(host)
+	s.score -= penalty
+	s.updated = time.Now()
+
+	if n.Logger != nil {
+		n.Logger.Trace.Printf("Peer %s score %d after: %s", host, s.score, reason)
+	}
+
+	if s.score > 0 {
+		return false
+	}
+
+	ban := PeerBan{host, time.Now().Add(PeerBanDuration).Unix(), reason}
+	p.bans[host] = ban
+
+	// score starts from max after a ban
+	delete(p.scores, host)
+
+	if n.Logger != nil {
+		n.Logger.Error.Printf("Peer %s is banned till %s: %s", host, time.Unix(ban.Until, 0).Format(time.RFC3339), reason)
+	}
+
+	if n.Storage != nil {
+		n.Storage.AddBan(ban)
+	}
+	return true
+}
+
+// Returns current score of a host
+func (n *NodeNetwork) GetPeerScore(host string) int {
+	p := n.getPeerScores()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.getScore(host).score
+}
+
+// Check if a host is banned. Expired bans are removed
+func (n *NodeNetwork) IsPeerBanned(host string) bool {
+	p := n.getPeerScores()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	ban, ok := p.bans[host]
+
+	if !ok {
+		return false
+	}
+
+	if ban.IsActive() {
+		return true
+	}
+
+	delete(p.bans, host)
+
+	if n.Storage != nil {
+		n.Storage.RemoveBan(host)
+	}
+	return false
+}
+
+// Returns list of active bans
+func (n *NodeNetwork) GetPeerBans() []PeerBan {
+	p := n.getPeerScores()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	list := []PeerBan{}
+
+	for _, ban := range p.bans {
+		if ban.IsActive() {
+			list = append(list, ban)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Host < list[j].Host })
+
+	return list
+}
+
+// Remove a ban of a host. If host is empty, all bans are removed
+func (n *NodeNetwork) ClearPeerBans(host string) error {
+	p := n.getPeerScores()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if host != "" {
+		if _, ok := p.bans[host]; !ok {
+			return errors.New(fmt.Sprintf("Host %s is not banned", host))
+		}
+	}
+
+	for h := range p.bans {
+		if host != "" && h != host {
+			continue
+		}
+		delete(p.bans, h)
+		delete(p.scores, h)
+
+		if n.Storage != nil {
+			n.Storage.RemoveBan(h)
+		}
+	}
+	return nil
+}
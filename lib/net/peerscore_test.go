@@ -0,0 +1,102 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testBansStorage struct {
+	bans map[string]PeerBan
+}
+
+func (s *testBansStorage) GetNodes() ([]NodeAddr, error) {
+	return []NodeAddr{}, nil
+}
+func (s *testBansStorage) AddNodeToKnown(addr NodeAddr) {
+}
+func (s *testBansStorage) RemoveNodeFromKnown(addr NodeAddr) {
+}
+func (s *testBansStorage) GetCountOfKnownNodes() (int, error) {
+	return 0, nil
+}
+func (s *testBansStorage) GetBans() ([]PeerBan, error) {
+	list := []PeerBan{}
+
+	for _, b := range s.bans {
+		list = append(list, b)
+	}
+	return list, nil
+}
+func (s *testBansStorage) AddBan(ban PeerBan) {
+	s.bans[ban.Host] = ban
+}
+func (s *testBansStorage) RemoveBan(host string) {
+	delete(s.bans, host)
+}
+func (s *testBansStorage) GetAddresses() ([]KnownAddress, error) {
+	return []KnownAddress{}, nil
+}
+func (s *testBansStorage) SaveAddress(addr KnownAddress) {
+}
+func (s *testBansStorage) RemoveAddress(addr NodeAddr) {
+}
+
+func TestPeerScoreAndBans(t *testing.T) {
+	storage := &testBansStorage{map[string]PeerBan{}}
+
+	n := NodeNetwork{}
+	n.Init()
+	n.SetExtraManager(storage)
+
+	assert.Equal(t, PeerScoreMax, n.GetPeerScore("10.0.0.1"))
+
+	assert.False(t, n.ReportPeerMisbehavior("10.0.0.1", PenaltyInvalidBlock, "bad block"))
+	assert.Equal(t, PeerScoreMax-PenaltyInvalidBlock, n.GetPeerScore("10.0.0.1"))
+	assert.False(t, n.IsPeerBanned("10.0.0.1"))
+
+	assert.True(t, n.ReportPeerMisbehavior("10.0.0.1", PenaltyInvalidBlock, "bad block"))
+	assert.True(t, n.IsPeerBanned("10.0.0.1"))
+	assert.False(t, n.IsPeerBanned("10.0.0.2"))
+
+	// loopback is never banned
+	for i := 0; i < 20; i++ {
+		assert.False(t, n.ReportPeerMisbehavior("127.0.0.1", PenaltyInvalidBlock, "bad block"))
+	}
+	assert.False(t, n.IsPeerBanned("127.0.0.1"))
+
+	// ban is persisted and loaded by other object
+	assert.Len(t, storage.bans, 1)
+	assert.Equal(t, "bad block", storage.bans["10.0.0.1"].Reason)
+
+	n2 := NodeNetwork{}
+	n2.Init()
+	n2.SetExtraManager(storage)
+
+	storage.bans["10.0.0.3"] = PeerBan{"10.0.0.3", time.Now().Add(-time.Minute).Unix(), "expired"}
+
+	assert.NoError(t, n2.LoadBans())
+	assert.True(t, n2.IsPeerBanned("10.0.0.1"))
+	assert.False(t, n2.IsPeerBanned("10.0.0.3"))
+	assert.Len(t, storage.bans, 1)
+
+	bans := n2.GetPeerBans()
+	assert.Len(t, bans, 1)
+	assert.Equal(t, "10.0.0.1", bans[0].Host)
+
+	assert.Error(t, n2.ClearPeerBans("10.0.0.2"))
+	assert.NoError(t, n2.ClearPeerBans(""))
+	assert.False(t, n2.IsPeerBanned("10.0.0.1"))
+	assert.Len(t, storage.bans, 0)
+}
+
+func TestPeerBanString(t *testing.T) {
+	ban := PeerBan{"host", 1500000000, "invalid block: wrong hash"}
+
+	loaded := PeerBan{}
+	assert.NoError(t, loaded.LoadFromString("host", ban.String()))
+	assert.Equal(t, ban, loaded)
+
+	assert.Error(t, loaded.LoadFromString("host", "wrong"))
+}
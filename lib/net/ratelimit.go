@@ -0,0 +1,292 @@
+package net
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of requests to a node server. Every remote host has a token bucket per class of commands
+// and a bucket for bandwidth. When a bucket is empty a request is refused with "busy" error,
+// and a client doesn't send requests to the node for some time.
+// Loopback hosts are not limited, operators use CLI to talk to own node on localhost
+const (
+	RateClassSync    = "sync"    // loading of blocks and headers
+	RateClassPoll    = "poll"    // periodic requests of other nodes
+	RateClassClient  = "client"  // lite clients requests
+	RateClassDefault = "default" // all other commands, mostly pushes of new data
+
+	DefaultMaxConnections = 256
+	DefaultBandwidthLimit = 8 * 1024 * 1024 // bytes per second for a host
+	BusyRetryAfter        = 10 * time.Second
+	rateBucketIdleTime    = 10 * time.Minute // buckets of hosts not seen so long are dropped
+)
+
+// Commands which are not in this list are in default class
+var commandRateClasses = map[string]string{
+	"getblocks":      RateClassSync,
+	"getblocksup":    RateClassSync,
+	"getblock":       RateClassSync,
+	"getheaders":     RateClassSync,
+	"getchunk":       RateClassSync,
+	"getfblocks":     RateClassSync,
+	"getdata":        RateClassSync,
+	"getcnsdata":     RateClassSync,
+	"getupdates":     RateClassPoll,
+	"getaddr":        RateClassPoll,
+	"getnodes":       RateClassPoll,
+	"version":        RateClassPoll,
+	"checkblock":     RateClassPoll,
+	"getbalance":     RateClassClient,
+	"getunspent":     RateClassClient,
+	"gethistory":     RateClassClient,
+	"gettransact":    RateClassClient,
+	"txcurrequest":   RateClassClient,
+	"txsqlrequest":   RateClassClient,
+	"txdata":         RateClassClient,
+	"graphql":        RateClassClient,
+	"getmerkleproof": RateClassClient,
+	"txstatus":       RateClassClient,
+	"rowhistory":     RateClassClient,
+}
+
+// Requests per second and max burst of requests
+type RateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+type TokenBucket struct {
+	rate    float64
+	burst   float64
+	tokens  float64
+	updated time.Time
+}
+
+func NewTokenBucket(rate float64, burst float64) *TokenBucket {
+	return &TokenBucket{rate, burst, burst, time.Now()}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if now.Before(b.updated) {
+		return
+	}
+	b.tokens += now.Sub(b.updated).Seconds() * b.rate
+
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.updated = now
+}
+
+// Take tokens if there are so many
+func (b *TokenBucket) Take(n float64, now time.Time) bool {
+	b.refill(now)
+
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// Take tokens if a bucket is not empty. Tokens can go below zero, a big response is not known before it is built
+func (b *TokenBucket) TakeDebt(n float64, now time.Time) bool {
+	b.refill(now)
+
+	if b.tokens <= 0 {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+type RateLimiter struct {
+	lock           sync.Mutex
+	classes        map[string]RateLimit
+	bandwidth      RateLimit
+	buckets        map[string]*TokenBucket // host/class -> bucket
+	cleaned        time.Time
+	MaxConnections int
+	connections    int
+}
+
+// Default limits. Custom limits replace them for given classes. bandwidth is bytes per second for a host
+func NewRateLimiter(custom map[string]RateLimit, bandwidth int, maxConnections int) *RateLimiter {
+	l := RateLimiter{}
+	l.classes = map[string]RateLimit{
+		RateClassSync:    {10, 50},
+		RateClassPoll:    {2, 10},
+		RateClassClient:  {20, 100},
+		RateClassDefault: {50, 200},
+	}
+
+	for class, limit := range custom {
+		if limit.Rate <= 0 {
+			continue
+		}
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+		l.classes[class] = limit
+	}
+
+	if bandwidth <= 0 {
+		bandwidth = DefaultBandwidthLimit
+	}
+	l.bandwidth = RateLimit{float64(bandwidth), float64(bandwidth) * 4}
+
+	if maxConnections <= 0 {
+		maxConnections = DefaultMaxConnections
+	}
+	l.MaxConnections = maxConnections
+
+	l.buckets = map[string]*TokenBucket{}
+	l.cleaned = time.Now()
+
+	return &l
+}
+
+// Returns class of a command for rate limits
+func GetCommandRateClass(command string) string {
+	if class, ok := commandRateClasses[command]; ok {
+		return class
+	}
+	return RateClassDefault
+}
+
+func (l *RateLimiter) getBucket(key string, limit RateLimit, now time.Time) *TokenBucket {
+	if now.Sub(l.cleaned) > rateBucketIdleTime {
+		for k, b := range l.buckets {
+			if now.Sub(b.updated) > rateBucketIdleTime {
+				delete(l.buckets, k)
+			}
+		}
+		l.cleaned = now
+	}
+
+	b, ok := l.buckets[key]
+
+	if !ok {
+		b = NewTokenBucket(limit.Rate, limit.Burst)
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// Check if a host can execute a command now. requestSize is added to used bandwidth
+func (l *RateLimiter) AllowRequest(host string, command string, requestSize int) error {
+	if l == nil || isLoopbackHost(host) {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	class := GetCommandRateClass(command)
+
+	if !l.getBucket(host+"/bandwidth", l.bandwidth, now).TakeDebt(float64(requestSize), now) {
+		return NewBusyError(fmt.Sprintf("bandwidth limit is reached. Retry after %d seconds", BusyRetryAfter/time.Second))
+	}
+
+	if !l.getBucket(host+"/"+class, l.classes[class], now).Take(1, now) {
+		return NewBusyError(fmt.Sprintf("too many %s requests. Retry after %d seconds", class, BusyRetryAfter/time.Second))
+	}
+	return nil
+}
+
+// Count size of a response sent to a host
+func (l *RateLimiter) AddSentData(host string, size int) {
+	if l == nil || isLoopbackHost(host) {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	b := l.getBucket(host+"/bandwidth", l.bandwidth, now)
+	b.refill(now)
+	b.tokens -= float64(size)
+}
+
+// Count new connection. Returns false if there are too many connections
+func (l *RateLimiter) AcquireConnection() bool {
+	if l == nil {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.connections >= l.MaxConnections {
+		return false
+	}
+	l.connections++
+	return true
+}
+
+func (l *RateLimiter) ReleaseConnection() {
+	if l == nil {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.connections > 0 {
+		l.connections--
+	}
+}
+
+// Returns number of connections served now
+func (l *RateLimiter) CountConnections() int {
+	if l == nil {
+		return 0
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.connections
+}
+
+// Check if an error message received from a node is "busy" error. Returns nil if it is other error
+func ParseBusyMessage(message string) error {
+	if !strings.HasPrefix(message, busyErrorPrefix) {
+		return nil
+	}
+	return NewBusyError(strings.TrimPrefix(message, busyErrorPrefix))
+}
+
+// A node which responded "busy" is not contacted for some time
+func (n *NodeNetwork) SetPeerBusy(host string) {
+	p := n.getPeerScores()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.busy[host] = time.Now().Add(BusyRetryAfter)
+}
+
+// Check if a client must wait before next request to a host
+func (n *NodeNetwork) IsPeerBusy(host string) bool {
+	p := n.getPeerScores()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	until, ok := p.busy[host]
+
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(until) {
+		delete(p.busy, host)
+		return false
+	}
+	return true
+}
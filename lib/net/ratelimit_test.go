@@ -0,0 +1,82 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(2, 3)
+	now := time.Now()
+
+	assert.True(t, b.Take(3, now))
+	assert.False(t, b.Take(1, now))
+
+	// 2 tokens per second
+	assert.True(t, b.Take(1, now.Add(500*time.Millisecond)))
+	assert.False(t, b.Take(1, now.Add(500*time.Millisecond)))
+
+	// never more than burst
+	assert.False(t, b.Take(4, now.Add(time.Hour)))
+	assert.True(t, b.Take(3, now.Add(time.Hour)))
+
+	// debt is allowed while a bucket is not empty
+	assert.True(t, b.TakeDebt(10, now.Add(time.Hour+time.Second)))
+	assert.False(t, b.TakeDebt(1, now.Add(time.Hour+time.Second)))
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(map[string]RateLimit{RateClassPoll: {0.001, 2}}, 1000, 2)
+
+	assert.NoError(t, l.AllowRequest("10.0.0.1", "getupdates", 10))
+	assert.NoError(t, l.AllowRequest("10.0.0.1", "getaddr", 10))
+
+	err := l.AllowRequest("10.0.0.1", "getupdates", 10)
+	assert.Error(t, err)
+	assert.True(t, err.(*NetworkError).IsBusy())
+
+	// other classes and other hosts have own buckets
+	assert.NoError(t, l.AllowRequest("10.0.0.1", "getblock", 10))
+	assert.NoError(t, l.AllowRequest("10.0.0.2", "getupdates", 10))
+
+	// loopback is not limited
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, l.AllowRequest("127.0.0.1", "getupdates", 10))
+	}
+
+	// bandwidth
+	l.AddSentData("10.0.0.3", 5000)
+	assert.Error(t, l.AllowRequest("10.0.0.3", "getblock", 10))
+
+	assert.True(t, l.AcquireConnection())
+	assert.True(t, l.AcquireConnection())
+	assert.False(t, l.AcquireConnection())
+	l.ReleaseConnection()
+	assert.True(t, l.AcquireConnection())
+	assert.Equal(t, 2, l.CountConnections())
+
+	var empty *RateLimiter
+	assert.NoError(t, empty.AllowRequest("10.0.0.1", "getupdates", 10))
+	assert.True(t, empty.AcquireConnection())
+}
+
+func TestBusyError(t *testing.T) {
+	err := NewBusyError("too many requests")
+
+	busy := ParseBusyMessage(err.Error())
+	assert.Error(t, busy)
+	assert.True(t, busy.(*NetworkError).IsBusy())
+	assert.Equal(t, err.Error(), busy.Error())
+
+	assert.Nil(t, ParseBusyMessage("Other error"))
+
+	n := NodeNetwork{}
+	n.Init()
+
+	assert.False(t, n.IsPeerBusy("10.0.0.1"))
+	n.SetPeerBusy("10.0.0.1")
+	assert.True(t, n.IsPeerBusy("10.0.0.1"))
+	assert.False(t, n.IsPeerBusy("10.0.0.2"))
+}
@@ -0,0 +1,278 @@
+package net
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	gonet "net"
+	"os"
+	"time"
+)
+
+// Encrypted connections between nodes. Every node has own identity key. TLS certificate
+// is made from the key on every start, so a node is identified by hash of its public key only.
+// The identity is pinned in the list of known nodes on first connection or can be set by an operator.
+// A server accepts plain and TLS connections on same port. Lite clients can still use plain connections
+// unless a server is configured to require TLS
+
+// First byte of TLS handshake record. Plain requests start with a command name
+const tlsHandshakeRecordType = 0x16
+
+// Time to complete TLS handshake
+const tlsHandshakeTimeout = 10 * time.Second
+
+// A node of older version which doesn't support TLS is contacted with plain connections for this time
+const plainPeerTime = 10 * time.Minute
+
+type NodeIdentity struct {
+	ID          string // hex encoded SHA256 of a public key
+	certificate tls.Certificate
+}
+
+// Loads identity key from a file. New key is created if the file doesn't exist
+func LoadNodeIdentity(keyFile string) (*NodeIdentity, error) {
+	var key *ecdsa.PrivateKey
+
+	data, err := ioutil.ReadFile(keyFile)
+
+	if os.IsNotExist(err) {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		keyBytes, err := x509.MarshalECPrivateKey(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+
+		if err := ioutil.WriteFile(keyFile, data, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		block, _ := pem.Decode(data)
+
+		if block == nil {
+			return nil, errors.New(fmt.Sprintf("No key found in %s", keyFile))
+		}
+
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newNodeIdentity(key)
+}
+
+func newNodeIdentity(key *ecdsa.PrivateKey) (*NodeIdentity, error) {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "oursql node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		return nil, err
+	}
+
+	i := NodeIdentity{}
+	i.ID = GetCertificateIdentity(cert)
+	i.certificate = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+
+	return &i, nil
+}
+
+// Node identity is hash of a public key of the certificate
+func GetCertificateIdentity(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	return hex.EncodeToString(hash[:])
+}
+
+// Certificates are self signed. Instead of CA check, identity of other side is compared
+// to expected one if it is known
+func verifyIdentity(rawCerts [][]byte, expected string, result *string) error {
+	if len(rawCerts) == 0 {
+		return errors.New("Node certificate is missed")
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+
+	if err != nil {
+		return err
+	}
+
+	id := GetCertificateIdentity(cert)
+
+	if expected != "" && id != expected {
+		return NewIdentityMismatchError(fmt.Sprintf("Expected %s, got %s", expected, id))
+	}
+	*result = id
+
+	return nil
+}
+
+// Connect to a node with TLS. If expectedIdentity is empty, any identity is accepted.
+// Returns identity of the node
+func DialNodeTLS(addr NodeAddr, identity *NodeIdentity, expectedIdentity string, timeout time.Duration) (gonet.Conn, string, error) {
+	peerIdentity := ""
+
+	config := &tls.Config{
+		InsecureSkipVerify: true, // identity is verified in the callback
+		MinVersion:         tls.VersionTLS12,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyIdentity(rawCerts, expectedIdentity, &peerIdentity)
+		},
+	}
+
+	if identity != nil {
+		config.Certificates = []tls.Certificate{identity.certificate}
+	}
+
+	rawConn, err := gonet.DialTimeout(Protocol, addr.NodeAddrToString(), timeout)
+
+	if err != nil {
+		return nil, "", NewCanNotConnectError(err.Error())
+	}
+
+	conn := tls.Client(rawConn, config)
+
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	err = conn.Handshake()
+	conn.SetDeadline(time.Time{})
+
+	if err != nil {
+		rawConn.Close()
+
+		if _, ok := err.(*NetworkError); ok {
+			// identity mismatch
+			return nil, "", err
+		}
+		return nil, "", NewTLSHandshakeError(err.Error())
+	}
+	return conn, peerIdentity, nil
+}
+
+// Remember a node doesn't support TLS
+func (n *NodeNetwork) SetPeerPlain(host string) {
+	p := n.getPeerScores()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.plain[host] = time.Now().Add(plainPeerTime)
+}
+
+// Check if a node didn't support TLS recently
+func (n *NodeNetwork) IsPeerPlain(host string) bool {
+	p := n.getPeerScores()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	until, ok := p.plain[host]
+
+	if ok && time.Now().After(until) {
+		delete(p.plain, host)
+		return false
+	}
+	return ok
+}
+
+// Connection which returns already read byte first
+type peekedConn struct {
+	gonet.Conn
+	reader *bufio.Reader
+}
+
+func (c peekedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// Detects if a client starts TLS session and does handshake. Returns connection to use
+// and identity of a client. Identity is empty for plain connections and clients without certificate.
+// If allowPlain is false, a connection without TLS is refused
+func AcceptNodeConnection(conn gonet.Conn, identity *NodeIdentity, allowPlain bool) (gonet.Conn, string, error) {
+	if identity == nil {
+		if !allowPlain {
+			return nil, "", NewPlainRefusedError("node identity is not loaded, TLS is not possible")
+		}
+		return conn, "", nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(tlsHandshakeTimeout))
+
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+
+	conn.SetReadDeadline(time.Time{})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	pc := peekedConn{conn, reader}
+
+	if first[0] != tlsHandshakeRecordType {
+		if !allowPlain {
+			return nil, "", NewPlainRefusedError("TLS is required")
+		}
+		return pc, "", nil
+	}
+
+	peerIdentity := ""
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{identity.certificate},
+		MinVersion:   tls.VersionTLS12,
+		ClientAuth:   tls.RequestClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				// lite client
+				return nil
+			}
+			return verifyIdentity(rawCerts, "", &peerIdentity)
+		},
+	}
+
+	tlsConn := tls.Server(pc, config)
+
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, "", err
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	return tlsConn, peerIdentity, nil
+}
@@ -0,0 +1,243 @@
+package net
+
+import (
+	"io/ioutil"
+	gonet "net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeidentity")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	serverIdentity, err := LoadNodeIdentity(filepath.Join(dir, "server.pem"))
+	assert.NoError(t, err)
+
+	// same key is loaded second time
+	loaded, err := LoadNodeIdentity(filepath.Join(dir, "server.pem"))
+	assert.NoError(t, err)
+	assert.Equal(t, serverIdentity.ID, loaded.ID)
+
+	clientIdentity, err := LoadNodeIdentity(filepath.Join(dir, "client.pem"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, serverIdentity.ID, clientIdentity.ID)
+
+	l, err := gonet.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	peers := make(chan string, 10)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				c, peer, err := AcceptNodeConnection(conn, serverIdentity, true)
+
+				if err != nil {
+					peers <- "error"
+					return
+				}
+				buf := make([]byte, 4)
+
+				if _, err := c.Read(buf); err == nil {
+					c.Write(buf)
+				}
+				peers <- peer
+			}()
+		}
+	}()
+
+	addr := NodeAddr{}
+	assert.NoError(t, addr.LoadFromString(l.Addr().String()))
+
+	// TLS with pinned identity
+	conn, id, err := DialNodeTLS(addr, clientIdentity, serverIdentity.ID, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, serverIdentity.ID, id)
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+	conn.Close()
+
+	assert.Equal(t, clientIdentity.ID, <-peers)
+
+	// plain connection is accepted too
+	conn, err = gonet.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+
+	conn.Write([]byte("viod"))
+	_, err = conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "viod", string(buf))
+	conn.Close()
+
+	assert.Equal(t, "", <-peers)
+
+	// other identity is pinned
+	_, _, err = DialNodeTLS(addr, clientIdentity, clientIdentity.ID, time.Second)
+	assert.Error(t, err)
+
+	if errv, ok := err.(*NetworkError); assert.True(t, ok) {
+		assert.True(t, errv.IsIdentityMismatch())
+	}
+	<-peers
+}
+
+func TestNodeTransportRequireTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeidentity")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	serverIdentity, err := LoadNodeIdentity(filepath.Join(dir, "server.pem"))
+	assert.NoError(t, err)
+
+	clientIdentity, err := LoadNodeIdentity(filepath.Join(dir, "client.pem"))
+	assert.NoError(t, err)
+
+	l, err := gonet.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	results := make(chan error, 10)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				c, _, err := AcceptNodeConnection(conn, serverIdentity, false)
+
+				if err == nil {
+					buf := make([]byte, 4)
+
+					if _, err := c.Read(buf); err == nil {
+						c.Write(buf)
+					}
+				}
+				results <- err
+			}()
+		}
+	}()
+
+	addr := NodeAddr{}
+	assert.NoError(t, addr.LoadFromString(l.Addr().String()))
+
+	// TLS is accepted
+	conn, _, err := DialNodeTLS(addr, clientIdentity, serverIdentity.ID, time.Second)
+	assert.NoError(t, err)
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = conn.Read(buf)
+	assert.NoError(t, err)
+	conn.Close()
+
+	assert.NoError(t, <-results)
+
+	// plain is refused
+	conn, err = gonet.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+
+	conn.Write([]byte("viod"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(buf)
+	assert.Error(t, err)
+	conn.Close()
+
+	err = <-results
+
+	if errv, ok := err.(*NetworkError); assert.True(t, ok) {
+		assert.True(t, errv.IsPlainRefused())
+	}
+
+	// without identity TLS is not possible
+	client, server := gonet.Pipe()
+	defer client.Close()
+
+	_, _, err = AcceptNodeConnection(server, nil, false)
+	assert.Error(t, err)
+
+	c, _, err := AcceptNodeConnection(server, nil, true)
+	assert.NoError(t, err)
+	assert.Equal(t, server, c)
+}
+
+func TestNodeTransportLegacyNode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeidentity")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	clientIdentity, err := LoadNodeIdentity(filepath.Join(dir, "client.pem"))
+	assert.NoError(t, err)
+
+	l, err := gonet.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	// node of older version reads a handshake as a command and responds with an error
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+			conn.Read(make([]byte, 12))
+			conn.Write([]byte("\x00Unknown command"))
+			conn.Close()
+		}
+	}()
+
+	addr := NodeAddr{}
+	assert.NoError(t, addr.LoadFromString(l.Addr().String()))
+
+	_, _, err = DialNodeTLS(addr, clientIdentity, "", time.Second)
+
+	if errv, ok := err.(*NetworkError); assert.True(t, ok) {
+		assert.True(t, errv.IsTLSHandshakeFailure())
+	}
+
+	l.Close()
+
+	// node is not reachable, it is not a TLS problem
+	_, _, err = DialNodeTLS(addr, clientIdentity, "", time.Second)
+
+	if errv, ok := err.(*NetworkError); assert.True(t, ok) {
+		assert.True(t, errv.WasConnFailure())
+	}
+
+	n := NodeNetwork{}
+	n.Init()
+
+	assert.False(t, n.IsPeerPlain(addr.Host))
+	n.SetPeerPlain(addr.Host)
+	assert.True(t, n.IsPeerPlain(addr.Host))
+}
+
+func TestNodeAddrIdentityString(t *testing.T) {
+	addr := NodeAddr{}
+	assert.NoError(t, addr.LoadFromString("host:2000#abcd"))
+	assert.Equal(t, "abcd", addr.Identity)
+	assert.Equal(t, "host:2000", addr.String())
+	assert.Equal(t, "host:2000#abcd", addr.StringWithIdentity())
+}
@@ -12,8 +12,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
+	"sync/atomic"
 
 	netlib "github.com/gelembjuk/oursql/lib/net"
 	"github.com/gelembjuk/oursql/lib/utils"
@@ -30,220 +30,387 @@ const (
 	CommandCheckBlock       = "checkblock"
 	CommandGetBlock         = "getblock" // requests a block by hash
 	CommandBlock            = "block"    // send block body
+	CommandGetBans          = "getbans"
+	CommandClearBans        = "clearbans"
+	CommandGetHeaders       = "getheaders"     // requests headers of blocks after some block
+	CommandMux              = "mux"            // opens persistent connection
+	CommandGetAddr          = "getaddr"        // requests addresses of other nodes from the address book
+	CommandGetChunk         = "getchunk"       // requests part of big data
+	CommandGetMerkleProof   = "getmerkleproof" // requests proof that a transaction is in a block
+	CommandGetTXStatus      = "txstatus"       // requests state of a transaction and number of confirmations
+	CommandGetRowHistory    = "rowhistory"     // requests all changes of a DB row
+)
 
+// Kinds of data which can be loaded in chunks
+const (
+	ChunkKindBlock      = "block"
+	ChunkKindConfigFile = "cnsconfig"
+	ChunkKindModule     = "cnsmodule"
 )
 
+// Max time to wait for a response on a persistent connection
+const persistentRequestTimeout = 60 * time.Second
+
 type NodeClient struct {
 	DataDir     string
-	NodeAddress netlib.NodeAddr
-	Address     string // wallet address
+	nodeAddress atomic.Value // netlib.NodeAddr. It can be changed by NAT manager while requests are sent
+	Address     string       // wallet address
 	Logger      *utils.LoggerMan
 	NodeNet     *netlib.NodeNetwork
 	NodeAuthStr string
+	Identity    *netlib.NodeIdentity // if set, connections to nodes are encrypted
+	RequireTLS  bool                 // don't use plain connections to nodes which don't support TLS
+	Codec       string               // if set, this codec is used for all requests. Else codec negotiated with a node
+	Genesis     []byte               // genesis hash and consensus hash are sent in version. Other nodes check them
+	Consensus   []byte
 }
 
 // Command to send list of known addresses to other node
 type ComAddresses struct {
-	AddrFrom  netlib.NodeAddr
-	Addresses []netlib.NodeAddr
+	AddrFrom  netlib.NodeAddr   `proto:"1"`
+	Addresses []netlib.NodeAddr `proto:"2"`
 }
 
 type ComBlock struct {
-	AddrFrom netlib.NodeAddr
-	Block    []byte
+	AddrFrom netlib.NodeAddr `proto:"1"`
+	Block    []byte          `proto:"2"`
 }
 
 // this struct can be used for 2 commands. to get blocks starting from some block to down or to up
 type ComGetBlocks struct {
-	AddrFrom  netlib.NodeAddr
-	StartFrom []byte // has of block from which to start and go down or go up in case of Up command
+	AddrFrom  netlib.NodeAddr `proto:"1"`
+	StartFrom []byte          `proto:"2"` // has of block from which to start and go down or go up in case of Up command
 }
 
 // Response of GetBlock request
 type ComGetFirstBlocksData struct {
-	Blocks [][]byte // lowest block first
+	Blocks [][]byte `proto:"1"` // lowest block first
 	// it is serialised BlockShort structure
-	Height int
+	Height int `proto:"2"`
 }
 
 // Response of GetConsensusData request
 type ComGetConsensusData struct {
-	ConfigFile []byte
-	Module     []byte // this can be long string
+	ConfigFile     []byte `proto:"1"`
+	Module         []byte `proto:"2"` // this can be long string
+	ConfigFileSize int    `proto:"3"` // full sizes. If data are bigger than a chunk, rest is loaded with getchunk
+	ModuleSize     int    `proto:"4"`
+}
+
+// Request of consensus data. Old nodes send no data and get everything in one response
+type ComConsensusDataRequest struct {
+	ChunkSize int `proto:"1"`
 }
 
 type ComGetData struct {
-	AddrFrom netlib.NodeAddr
-	Type     string
-	ID       []byte
+	AddrFrom netlib.NodeAddr `proto:"1"`
+	Type     string          `proto:"2"`
+	ID       []byte          `proto:"3"`
 }
 
 // Wallet Balance response
 type ComWalletBalance struct {
-	Total    float64
-	Approved float64
-	Pending  float64
+	Total    float64 `proto:"1"`
+	Approved float64 `proto:"2"`
+	Pending  float64 `proto:"3"`
 }
 
 // Request for a wallet balance
 type ComGetWalletBalance struct {
-	Address string
+	Address string `proto:"1"`
 }
 
 // New Transaction command. Is used by lite wallets
 type ComNewTransaction struct {
-	Address string
-	TX      []byte
+	Address string `proto:"1"`
+	TX      []byte `proto:"2"`
 }
 
 // New Transaction Data command. It includes prepared TX and signatures for imputs
 type ComNewTransactionData struct {
-	Address   string
-	TX        []byte
-	Signature []byte
+	Address   string `proto:"1"`
+	TX        []byte `proto:"2"`
+	Signature []byte `proto:"3"`
 }
 
 // To Request new transaction by wallet.
 // Wallet sends address where to send and amount to send
 // and own pubkey. Server returns transaction but wihout signatures
 type ComRequestTransaction struct {
-	PubKey []byte
-	To     string
-	Amount float64
+	PubKey []byte  `proto:"1"`
+	To     string  `proto:"2"`
+	Amount float64 `proto:"3"`
 }
 
 // To Request new SQL transaction by wallet.
 // Wallet sends SQL command and own pubkey. Server returns transaction but wihout signatures
 type ComRequestSQLTransaction struct {
-	PubKey []byte
-	SQL    string
+	PubKey []byte `proto:"1"`
+	SQL    string `proto:"2"`
 }
 
 // Response on prepare transaction request. Returns transaction without signs
 // and data to sign
 type ComRequestTransactionData struct {
-	Finished   bool
-	TX         []byte
-	DataToSign []byte
+	Finished   bool   `proto:"1"`
+	TX         []byte `proto:"2"`
+	DataToSign []byte `proto:"3"`
 }
 
 // For request to get list of unspent transactions by wallet
 type ComGetUnspentTransactions struct {
-	Address   string
-	LastBlock []byte
+	Address   string `proto:"1"`
+	LastBlock []byte `proto:"2"`
 }
 
 // Unspent Transaction record
 type ComUnspentTransaction struct {
-	TXID   []byte
-	Vout   int
-	Amount float64
-	IsBase bool
-	From   string
+	TXID   []byte  `proto:"1"`
+	Vout   int     `proto:"2"`
+	Amount float64 `proto:"3"`
+	IsBase bool    `proto:"4"`
+	From   string  `proto:"5"`
 }
 
 // Lit of unspent transactions returned on request
 type ComUnspentTransactions struct {
-	Transactions []ComUnspentTransaction
-	LastBlock    []byte
+	Transactions []ComUnspentTransaction `proto:"1"`
+	LastBlock    []byte                  `proto:"2"`
 }
 
 // Request for history of transactions
 type ComGetHistoryTransactions struct {
-	Address string
+	Address string `proto:"1"`
 }
 
 // Record of transaction in list of history transactions
 type ComHistoryTransaction struct {
-	IOType bool // In (false) or Out (true)
-	TXID   []byte
-	Amount float64
-	From   string
-	To     string
+	IOType bool    `proto:"1"` // In (false) or Out (true)
+	TXID   []byte  `proto:"2"`
+	Amount float64 `proto:"3"`
+	From   string  `proto:"4"`
+	To     string  `proto:"5"`
 }
 
 // Request for inventory. It can be used to get blocks and transactions from other node
 type ComInv struct {
-	AddrFrom netlib.NodeAddr
-	Type     string
-	Items    [][]byte
+	AddrFrom netlib.NodeAddr `proto:"1"`
+	Type     string          `proto:"2"`
+	Items    [][]byte        `proto:"3"`
 }
 
 // Transaction to send to other node
 type ComTx struct {
-	AddFrom     netlib.NodeAddr
-	Transaction []byte // Transaction serialised
+	AddFrom     netlib.NodeAddr `proto:"1"`
+	Transaction []byte          `proto:"2"` // Transaction serialised
 }
 
 // Version mesage to other nodes
 type ComVersion struct {
-	Version    int
-	BestHeight int
-	AddrFrom   netlib.NodeAddr
+	Version    int             `proto:"1"`
+	BestHeight int             `proto:"2"`
+	AddrFrom   netlib.NodeAddr `proto:"3"`
+	Codecs     []string        `proto:"4"` // codecs supported by a node. Empty for nodes knowing only legacy protocol
+	Genesis    []byte          `proto:"5"` // hash of genesis block. Empty if a node has no blockchain yet
+	Consensus  []byte          `proto:"6"` // hash of consensus rules
+}
+
+// Response for version. Only requests in envelope format get it
+type ResponseVersion struct {
+	AddrYou string `proto:"1"` // IP address of a sender as a receiver sees it
 }
 
 // To send nodes manage command.
 type ComManageNode struct {
-	Node netlib.NodeAddr
+	Node netlib.NodeAddr `proto:"1"`
+}
+
+// To clear bans of peers. Empty host means all bans
+type ComClearBans struct {
+	Host string `proto:"1"`
 }
 
 // To get node state
 type ComGetNodeState struct {
-	Host                  string
-	BlocksNumber          int
-	ExpectingBlocksHeight int
-	TransactionsCached    int
-	UnspentOutputs        int
+	Host                  string `proto:"1"`
+	BlocksNumber          int    `proto:"2"`
+	ExpectingBlocksHeight int    `proto:"3"`
+	TransactionsCached    int    `proto:"4"`
+	UnspentOutputs        int    `proto:"5"`
+	Syncing               bool   `proto:"6"` // headers-first sync is in progress
+	SyncHeadersHeight     int    `proto:"7"` // height of last loaded header
+	SyncBlocksHeight      int    `proto:"8"` // height of last applied block
+	SyncTargetHeight      int    `proto:"9"`
+	SyncPeers             int    `proto:"10"` // count of nodes blocks are loaded from
 }
 
 // To get node last updates
 type ComGetUpdates struct {
-	LastCheckTime      int64
-	CurrentBlockHeight int
-	TopBlocks          [][]byte
-	AddrFrom           netlib.NodeAddr
+	LastCheckTime      int64           `proto:"1"`
+	CurrentBlockHeight int             `proto:"2"`
+	TopBlocks          [][]byte        `proto:"3"`
+	AddrFrom           netlib.NodeAddr `proto:"4"`
 }
 
 // Response with updates on a node
 type ResponseGetUpdates struct {
-	CurrentBlockHeight      int
-	CountTransactionsInPool int
-	Blocks                  [][]byte
-	TransactionsInPool      [][]byte
-	Nodes                   []netlib.NodeAddrShort
+	CurrentBlockHeight      int                    `proto:"1"`
+	CountTransactionsInPool int                    `proto:"2"`
+	Blocks                  [][]byte               `proto:"3"`
+	TransactionsInPool      [][]byte               `proto:"4"`
+	Nodes                   []netlib.NodeAddrShort `proto:"5"`
 }
 
 // To get transaction from other node
 type ComGetTransaction struct {
-	TransactionID []byte
-	AddrFrom      netlib.NodeAddr
+	TransactionID []byte          `proto:"1"`
+	AddrFrom      netlib.NodeAddr `proto:"2"`
 }
 
 // Response for transaction request
 type ResponseGetTransaction struct {
-	Transaction []byte // Transaction serialised
+	Transaction []byte `proto:"1"` // Transaction serialised
 }
 
 // Request to check if block exists. Executed before to send new block to node
 type ComCheckBlock struct {
-	BlockHash []byte
-	AddrFrom  netlib.NodeAddr
+	BlockHash []byte          `proto:"1"`
+	AddrFrom  netlib.NodeAddr `proto:"2"`
 }
 
 // Response for check block request
 type ResponseCheckBlock struct {
-	Exists bool // True if a node doesn't want to get a body of this TX
+	Exists bool `proto:"1"` // True if a node doesn't want to get a body of this TX
 }
 
 // To get transaction from other node
 type ComGetBlock struct {
-	BlockHash []byte
-	AddrFrom  netlib.NodeAddr
+	BlockHash []byte          `proto:"1"`
+	AddrFrom  netlib.NodeAddr `proto:"2"`
+	ChunkSize int             `proto:"3"` // if a block is bigger, only first chunk is returned. 0 means whole block
 }
 
 // Response for transaction request
 type ResponseGetBlock struct {
-	Block []byte // Transaction serialised
+	Block []byte `proto:"1"` // Transaction serialised
+	Size  int    `proto:"2"` // full size of a serialised block
+}
+
+// To get part of big data
+type ComGetChunk struct {
+	AddrFrom netlib.NodeAddr `proto:"1"`
+	Kind     string          `proto:"2"`
+	ID       []byte          `proto:"3"`
+	Offset   int             `proto:"4"`
+	Length   int             `proto:"5"`
+}
+
+// Response for chunk request
+type ResponseGetChunk struct {
+	Data []byte `proto:"1"`
+	Size int    `proto:"2"` // full size of data
+}
+
+// To get headers of blocks after some block. Empty StartFrom means from a genesis block
+type ComGetHeaders struct {
+	AddrFrom  netlib.NodeAddr `proto:"1"`
+	StartFrom []byte          `proto:"2"`
+	MaxCount  int             `proto:"3"`
+}
+
+// Request to keep a connection open. After a response both nodes send requests over the connection
+type ComMux struct {
+	AddrFrom netlib.NodeAddr `proto:"1"`
+}
+
+// To get addresses of nodes known by other node
+type ComGetAddr struct {
+	AddrFrom netlib.NodeAddr `proto:"1"`
+	MaxCount int             `proto:"2"`
+}
+
+// Response for addresses request
+type ResponseGetAddr struct {
+	Addresses []netlib.TimedNodeAddr `proto:"1"`
+}
+
+// Request of proof that a transaction is in a block of primary chain
+type ComGetMerkleProof struct {
+	TransactionID []byte          `proto:"1"`
+	AddrFrom      netlib.NodeAddr `proto:"2"`
+}
+
+// Merkle proof of a transaction. MerkleRoot must be same as TransactionsHash in the header of the block.
+// BlockHash is empty if a transaction is not in a block
+type ResponseGetMerkleProof struct {
+	BlockHash     []byte                  `proto:"1"`
+	PrevBlockHash []byte                  `proto:"2"`
+	Height        int                     `proto:"3"`
+	MerkleRoot    []byte                  `proto:"4"`
+	Proof         []utils.MerkleProofStep `proto:"5"`
+}
+
+// Header of a block as nodes send it in getheaders response. Same fields as a node has in BlockHeader
+type BlockHeader struct {
+	Timestamp        int64  `proto:"1"`
+	PrevBlockHash    []byte `proto:"2"`
+	Hash             []byte `proto:"3"`
+	Nonce            int    `proto:"4"`
+	Height           int    `proto:"5"`
+	TransactionsHash []byte `proto:"6"`
+	Version          int    `proto:"7"`
+}
+
+// Request of a transaction state. FinalDepth 0 means a node uses own setting
+type ComGetTXStatus struct {
+	TransactionID []byte          `proto:"1"`
+	FinalDepth    int             `proto:"2"`
+	AddrFrom      netlib.NodeAddr `proto:"3"`
+}
+
+// State of a transaction as a node sees it. State is pending, inblock, final, conflicted, dropped or unknown
+type ResponseGetTXStatus struct {
+	TXID          []byte   `proto:"1"`
+	State         string   `proto:"2"`
+	BlockHash     []byte   `proto:"3"`
+	Height        int      `proto:"4"`
+	Confirmations int      `proto:"5"`
+	FinalDepth    int      `proto:"6"`
+	SideBlocks    [][]byte `proto:"7"` // blocks of other branches with the transaction
+	ConflictTX    []byte   `proto:"8"`
+	Reason        string   `proto:"9"`
+}
+
+// Request of changes of a row. Key is a value of a primary key
+type ComGetRowHistory struct {
+	Table    string          `proto:"1"`
+	Key      string          `proto:"2"`
+	AddrFrom netlib.NodeAddr `proto:"3"`
+}
+
+// One change of a row. RollbackQuery returns a row to the state before the change
+type RowChange struct {
+	TXID          []byte `proto:"1"`
+	Kind          string `proto:"2"` // insert, update or delete
+	BlockHash     []byte `proto:"3"`
+	Height        int    `proto:"4"`
+	BlockTime     int64  `proto:"5"` // seconds
+	TXTime        int64  `proto:"6"` // nanoseconds
+	Signer        string `proto:"7"`
+	Query         string `proto:"8"`
+	RollbackQuery string `proto:"9"`
+	BaseTX        []byte `proto:"10"`
+}
+
+// Changes of a row in primary chain of a node. Last change first
+type ResponseGetRowHistory struct {
+	Changes []RowChange `proto:"1"`
+}
+
+// Response for headers request
+type ResponseGetHeaders struct {
+	Headers [][]byte `proto:"1"` // serialised BlockHeader structures. lowest block first
+	Height  int      `proto:"2"` // best height of a node
 }
 
 // Check if node address looks fine
@@ -251,6 +418,28 @@ func (c *NodeClient) SetAuthStr(auth string) {
 	c.NodeAuthStr = auth
 }
 
+// Set hashes of genesis block and consensus rules of this node. Nodes of other chains refuse versions with different hashes
+func (c *NodeClient) SetChainIdentity(genesis []byte, consensus []byte) {
+	c.Genesis = genesis
+	c.Consensus = consensus
+}
+
+// Set identity of this node. Connections to other nodes will use TLS
+func (c *NodeClient) SetIdentity(identity *netlib.NodeIdentity) {
+	c.Identity = identity
+}
+
+// Set codec to use for requests. Lite clients can set it when a node is known to support the codec
+func (c *NodeClient) SetCodec(codec string) error {
+	if codec != "" {
+		if _, err := netlib.GetCodecByName(codec); err != nil {
+			return err
+		}
+	}
+	c.Codec = codec
+	return nil
+}
+
 // Check if node address looks fine
 func (c *NodeClient) CheckNodeAddress(address netlib.NodeAddr) error {
 	if address.Port < 1024 {
@@ -267,7 +456,15 @@ func (c *NodeClient) CheckNodeAddress(address netlib.NodeAddr) error {
 
 // Set currrent node address , to include itin requests to other nodes
 func (c *NodeClient) SetNodeAddress(address netlib.NodeAddr) {
-	c.NodeAddress = address
+	c.nodeAddress.Store(address)
+}
+
+// Returns current node address
+func (c *NodeClient) GetNodeAddress() netlib.NodeAddr {
+	if address, ok := c.nodeAddress.Load().(netlib.NodeAddr); ok {
+		return address
+	}
+	return netlib.NodeAddr{}
 }
 
 // Send void commant to other node
@@ -283,9 +480,9 @@ func (c *NodeClient) SendVoid(address netlib.NodeAddr) error {
 func (c *NodeClient) SendAddrList(address netlib.NodeAddr, addresses []netlib.NodeAddr) error {
 	data := ComAddresses{}
 	data.Addresses = addresses
-	data.AddrFrom = c.NodeAddress
+	data.AddrFrom = c.GetNodeAddress()
 
-	request, err := c.BuildCommandData(CommandAddresses, &data)
+	request, err := c.BuildCommandDataForNode(address, CommandAddresses, &data)
 
 	if err != nil {
 		return err
@@ -294,11 +491,31 @@ func (c *NodeClient) SendAddrList(address netlib.NodeAddr, addresses []netlib.No
 	return c.SendData(address, request)
 }
 
+// Request addresses of other nodes. Every address comes with time when it was seen last time
+func (c *NodeClient) SendGetAddr(addr netlib.NodeAddr, maxCount int) ([]netlib.TimedNodeAddr, error) {
+	data := ComGetAddr{c.GetNodeAddress(), maxCount}
+
+	request, err := c.BuildCommandDataForNode(addr, CommandGetAddr, &data)
+
+	if err != nil {
+		return nil, err
+	}
+	datapayload := ResponseGetAddr{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return datapayload.Addresses, nil
+}
+
 // Request for a block full info form other node
 func (c *NodeClient) SendGetBlock(addr netlib.NodeAddr, blockHash []byte) (*ResponseGetBlock, error) {
-	data := ComGetBlock{blockHash, c.NodeAddress}
+	data := ComGetBlock{blockHash, c.GetNodeAddress(), netlib.TransferChunkSize}
 
-	request, err := c.BuildCommandData(CommandGetBlock, &data)
+	request, err := c.BuildCommandDataForNode(addr, CommandGetBlock, &data)
 
 	if err != nil {
 		return nil, err
@@ -311,13 +528,158 @@ func (c *NodeClient) SendGetBlock(addr netlib.NodeAddr, blockHash []byte) (*Resp
 		return nil, err
 	}
 
+	// big block is loaded in chunks
+	datapayload.Block, err = c.loadChunks(addr, ChunkKindBlock, blockHash, datapayload.Block, datapayload.Size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &datapayload, nil
+}
+
+// Request part of big data from other node
+func (c *NodeClient) SendGetChunk(addr netlib.NodeAddr, kind string, id []byte, offset int, length int) (*ResponseGetChunk, error) {
+	data := ComGetChunk{c.GetNodeAddress(), kind, id, offset, length}
+
+	request, err := c.BuildCommandDataForNode(addr, CommandGetChunk, &data)
+
+	if err != nil {
+		return nil, err
+	}
+	datapayload := ResponseGetChunk{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &datapayload, nil
+}
+
+// Loads rest of data with getchunk requests if only first part was received
+func (c *NodeClient) loadChunks(addr netlib.NodeAddr, kind string, id []byte, data []byte, size int) ([]byte, error) {
+	if size <= len(data) {
+		// old nodes don't set size
+		return data, nil
+	}
+
+	if size > netlib.MaxResponseSize {
+		return nil, errors.New(fmt.Sprintf("Data are too big, %d bytes", size))
+	}
+
+	for len(data) < size {
+		chunk, err := c.SendGetChunk(addr, kind, id, len(data), netlib.TransferChunkSize)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if chunk.Size != size || len(chunk.Data) == 0 || len(data)+len(chunk.Data) > size {
+			return nil, errors.New(fmt.Sprintf("Wrong chunk of %s received from %s", kind, addr.NodeAddrToString()))
+		}
+		data = append(data, chunk.Data...)
+	}
+	return data, nil
+}
+
+// Request proof that a transaction is in a block
+func (c *NodeClient) SendGetMerkleProof(addr netlib.NodeAddr, txID []byte) (*ResponseGetMerkleProof, error) {
+	data := ComGetMerkleProof{txID, c.GetNodeAddress()}
+
+	request, err := c.BuildCommandDataForNode(addr, CommandGetMerkleProof, &data)
+
+	if err != nil {
+		return nil, err
+	}
+	datapayload := ResponseGetMerkleProof{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &datapayload, nil
+}
+
+// Request state of a transaction. finalDepth 0 means a node decides when a transaction is final
+func (c *NodeClient) SendGetTXStatus(addr netlib.NodeAddr, txID []byte, finalDepth int) (*ResponseGetTXStatus, error) {
+	data := ComGetTXStatus{txID, finalDepth, c.GetNodeAddress()}
+
+	request, err := c.BuildCommandDataForNode(addr, CommandGetTXStatus, &data)
+
+	if err != nil {
+		return nil, err
+	}
+	datapayload := ResponseGetTXStatus{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &datapayload, nil
+}
+
+// Request all changes of a row, last change first
+func (c *NodeClient) SendGetRowHistory(addr netlib.NodeAddr, table string, key string) (*ResponseGetRowHistory, error) {
+	data := ComGetRowHistory{table, key, c.GetNodeAddress()}
+
+	request, err := c.BuildCommandDataForNode(addr, CommandGetRowHistory, &data)
+
+	if err != nil {
+		return nil, err
+	}
+	datapayload := ResponseGetRowHistory{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &datapayload, nil
+}
+
+// Decode a header from getheaders response
+func NewBlockHeaderFromBytes(data []byte) (*BlockHeader, error) {
+	h := &BlockHeader{}
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(h)
+
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Request headers of blocks after given block from other node
+func (c *NodeClient) SendGetHeaders(addr netlib.NodeAddr, startFrom []byte, maxCount int) (*ResponseGetHeaders, error) {
+	data := ComGetHeaders{c.GetNodeAddress(), startFrom, maxCount}
+
+	request, err := c.BuildCommandDataForNode(addr, CommandGetHeaders, &data)
+
+	if err != nil {
+		return nil, err
+	}
+	datapayload := ResponseGetHeaders{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
 	return &datapayload, nil
 }
 
 // Send block to other node
 func (c *NodeClient) SendBlock(addr netlib.NodeAddr, BlockSerialised []byte) error {
-	data := ComBlock{c.NodeAddress, BlockSerialised}
-	request, err := c.BuildCommandData(CommandBlock, &data)
+	data := ComBlock{c.GetNodeAddress(), BlockSerialised}
+	request, err := c.BuildCommandDataForNode(addr, CommandBlock, &data)
 
 	if err != nil {
 		return err
@@ -328,9 +690,9 @@ func (c *NodeClient) SendBlock(addr netlib.NodeAddr, BlockSerialised []byte) err
 
 // Send inventory. Blocks hashes or transactions IDs
 func (c *NodeClient) SendInv(address netlib.NodeAddr, kind string, items [][]byte) error {
-	data := ComInv{c.NodeAddress, kind, items}
+	data := ComInv{c.GetNodeAddress(), kind, items}
 
-	request, err := c.BuildCommandData("inv", &data)
+	request, err := c.BuildCommandDataForNode(address, "inv", &data)
 
 	if err != nil {
 		return err
@@ -341,9 +703,9 @@ func (c *NodeClient) SendInv(address netlib.NodeAddr, kind string, items [][]byt
 
 // Sedn request to get list of blocks on other node.
 func (c *NodeClient) SendGetBlocks(address netlib.NodeAddr, startfrom []byte) error {
-	data := ComGetBlocks{c.NodeAddress, startfrom}
+	data := ComGetBlocks{c.GetNodeAddress(), startfrom}
 
-	request, err := c.BuildCommandData("getblocks", &data)
+	request, err := c.BuildCommandDataForNode(address, "getblocks", &data)
 
 	if err != nil {
 		return err
@@ -354,9 +716,9 @@ func (c *NodeClient) SendGetBlocks(address netlib.NodeAddr, startfrom []byte) er
 
 // Request for blocks but result must be upper from some starting block
 func (c *NodeClient) SendGetBlocksUpper(address netlib.NodeAddr, startfrom []byte) error {
-	data := ComGetBlocks{c.NodeAddress, startfrom}
+	data := ComGetBlocks{c.GetNodeAddress(), startfrom}
 
-	request, err := c.BuildCommandData("getblocksup", &data)
+	request, err := c.BuildCommandDataForNode(address, "getblocksup", &data)
 
 	if err != nil {
 		return err
@@ -369,7 +731,7 @@ func (c *NodeClient) SendGetBlocksUpper(address netlib.NodeAddr, startfrom []byt
 // This is used by new nodes
 // TODO we can use SendGetBlocksUpper and empty hash. This will e same
 func (c *NodeClient) SendGetFirstBlocks(address netlib.NodeAddr) (*ComGetFirstBlocksData, error) {
-	request, err := c.BuildCommandData(CommandGetFirstBlocks, nil)
+	request, err := c.BuildCommandDataForNode(address, CommandGetFirstBlocks, nil)
 
 	if err != nil {
 		return nil, err
@@ -387,7 +749,9 @@ func (c *NodeClient) SendGetFirstBlocks(address netlib.NodeAddr) (*ComGetFirstBl
 
 // Request for consensus information from a node
 func (c *NodeClient) SendGetConsensusData(address netlib.NodeAddr) (*ComGetConsensusData, error) {
-	request, err := c.BuildCommandData(CommandGetConsensusData, nil)
+	data := ComConsensusDataRequest{netlib.TransferChunkSize}
+
+	request, err := c.BuildCommandDataForNode(address, CommandGetConsensusData, &data)
 
 	if err != nil {
 		return nil, err
@@ -400,15 +764,27 @@ func (c *NodeClient) SendGetConsensusData(address netlib.NodeAddr) (*ComGetConse
 		return nil, err
 	}
 
+	datapayload.ConfigFile, err = c.loadChunks(address, ChunkKindConfigFile, nil, datapayload.ConfigFile, datapayload.ConfigFileSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	datapayload.Module, err = c.loadChunks(address, ChunkKindModule, nil, datapayload.Module, datapayload.ModuleSize)
+
+	if err != nil {
+		return nil, err
+	}
+
 	return &datapayload, nil
 }
 
 // Request for a transaction or a block to get full info by ID or Hash
 func (c *NodeClient) SendGetData(address netlib.NodeAddr, kind string, id []byte) error {
 
-	data := ComGetData{c.NodeAddress, kind, id}
+	data := ComGetData{c.GetNodeAddress(), kind, id}
 
-	request, err := c.BuildCommandData("getdata", &data)
+	request, err := c.BuildCommandDataForNode(address, "getdata", &data)
 
 	if err != nil {
 		return err
@@ -421,9 +797,9 @@ func (c *NodeClient) SendGetData(address netlib.NodeAddr, kind string, id []byte
 func (c *NodeClient) SendGetTransaction(addr netlib.NodeAddr, txID []byte) (*ResponseGetTransaction, error) {
 	data := ComGetTransaction{}
 	data.TransactionID = txID
-	data.AddrFrom = c.NodeAddress
+	data.AddrFrom = c.GetNodeAddress()
 
-	request, err := c.BuildCommandData(CommandGetTransaction, &data)
+	request, err := c.BuildCommandDataForNode(addr, CommandGetTransaction, &data)
 
 	if err != nil {
 		return nil, err
@@ -444,9 +820,9 @@ func (c *NodeClient) SendGetTransaction(addr netlib.NodeAddr, txID []byte) (*Res
 func (c *NodeClient) SendCheckBlock(addr netlib.NodeAddr, hash []byte) (*ResponseCheckBlock, error) {
 	data := ComCheckBlock{}
 	data.BlockHash = hash
-	data.AddrFrom = c.NodeAddress
+	data.AddrFrom = c.GetNodeAddress()
 
-	request, err := c.BuildCommandData(CommandCheckBlock, &data)
+	request, err := c.BuildCommandDataForNode(addr, CommandCheckBlock, &data)
 
 	if err != nil {
 		return nil, err
@@ -465,8 +841,8 @@ func (c *NodeClient) SendCheckBlock(addr netlib.NodeAddr, hash []byte) (*Respons
 
 // Send Transaction to other node
 func (c *NodeClient) SendTx(addr netlib.NodeAddr, tnxserialised []byte) error {
-	data := ComTx{c.NodeAddress, tnxserialised}
-	request, err := c.BuildCommandData("tx", &data)
+	data := ComTx{c.GetNodeAddress(), tnxserialised}
+	request, err := c.BuildCommandDataForNode(addr, "tx", &data)
 
 	if err != nil {
 		return err
@@ -477,22 +853,43 @@ func (c *NodeClient) SendTx(addr netlib.NodeAddr, tnxserialised []byte) error {
 
 // Send own version and blockchain state to other node
 func (c *NodeClient) SendVersion(addr netlib.NodeAddr, bestHeight int) error {
-	data := ComVersion{netlib.NodeVersion, bestHeight, c.NodeAddress}
+	data := ComVersion{netlib.NodeVersion, bestHeight, c.GetNodeAddress(), netlib.SupportedCodecs, c.Genesis, c.Consensus}
 
-	request, err := c.BuildCommandData("version", &data)
+	request, err := c.BuildCommandDataForNode(addr, "version", &data)
 
 	if err != nil {
 		return err
 	}
 
-	return c.SendData(addr, request)
+	if !netlib.IsEnvelopeRequest(request) {
+		return c.SendData(addr, request)
+	}
+
+	// other node responds with address it sees this node from. It is used to detect external address
+	datapayload := ResponseVersion{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if errv, ok := err.(*netlib.NetworkError); ok && errv.IsNoResponse() {
+		// node doesn't respond on version
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if c.NodeNet != nil {
+		c.NodeNet.ReportObservedAddress(addr.Host, datapayload.AddrYou)
+	}
+	return nil
 }
 
 // Request for history of transaction from a wallet
 func (c *NodeClient) SendGetHistory(addr netlib.NodeAddr, address string) ([]ComHistoryTransaction, error) {
 	data := ComGetHistoryTransactions{address}
 
-	request, err := c.BuildCommandData("gethistory", &data)
+	request, err := c.BuildCommandDataForNode(addr, "gethistory", &data)
 
 	if err != nil {
 		return nil, err
@@ -516,7 +913,7 @@ func (c *NodeClient) SendNewTransactionData(addr netlib.NodeAddr, from string, t
 	data.TX = txBytes
 	data.Signature = signature
 
-	request, err := c.BuildCommandData("txdata", &data)
+	request, err := c.BuildCommandDataForNode(addr, "txdata", &data)
 
 	NewTXID := []byte{}
 
@@ -544,7 +941,7 @@ func (c *NodeClient) SendRequestNewCurrencyTransaction(addr netlib.NodeAddr,
 	data.To = to
 	data.Amount = amount
 
-	request, err := c.BuildCommandData("txcurrequest", &data)
+	request, err := c.BuildCommandDataForNode(addr, "txcurrequest", &data)
 
 	if err != nil {
 		return nil, nil, err
@@ -571,7 +968,7 @@ func (c *NodeClient) SendRequestNewSQLTransaction(addr netlib.NodeAddr,
 	data.PubKey = PubKey
 	data.SQL = sqlcommand
 
-	request, err := c.BuildCommandData("txsqlrequest", &data)
+	request, err := c.BuildCommandDataForNode(addr, "txsqlrequest", &data)
 
 	if err != nil {
 		return false, nil, nil, err
@@ -593,7 +990,7 @@ func (c *NodeClient) SendRequestNewSQLTransaction(addr netlib.NodeAddr,
 func (c *NodeClient) SendGetUnspent(addr netlib.NodeAddr, address string, chaintip []byte) (ComUnspentTransactions, error) {
 	data := ComGetUnspentTransactions{address, chaintip}
 
-	request, err := c.BuildCommandData("getunspent", &data)
+	request, err := c.BuildCommandDataForNode(addr, "getunspent", &data)
 
 	datapayload := ComUnspentTransactions{}
 
@@ -611,7 +1008,7 @@ func (c *NodeClient) SendGetUnspent(addr netlib.NodeAddr, address string, chaint
 func (c *NodeClient) SendGetBalance(addr netlib.NodeAddr, address string) (ComWalletBalance, error) {
 	data := ComGetWalletBalance{address}
 
-	request, err := c.BuildCommandData(CommandGetBalance, &data)
+	request, err := c.BuildCommandDataForNode(addr, CommandGetBalance, &data)
 
 	datapayload := ComWalletBalance{}
 
@@ -630,7 +1027,7 @@ func (c *NodeClient) SendGetNodes() ([]netlib.NodeAddr, error) {
 
 	datapayload := []netlib.NodeAddr{}
 
-	err = c.SendDataWaitResponse(c.NodeAddress, request, &datapayload)
+	err = c.SendDataWaitResponse(c.GetNodeAddress(), request, &datapayload)
 
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("Get Nodes Response Error: %s", err.Error()))
@@ -644,7 +1041,7 @@ func (c *NodeClient) SendAddNode(node netlib.NodeAddr) error {
 	data := ComManageNode{node}
 	request, err := c.BuildCommandDataWithAuth("addnode", &data)
 
-	err = c.SendDataWaitResponse(c.NodeAddress, request, nil)
+	err = c.SendDataWaitResponse(c.GetNodeAddress(), request, nil)
 
 	if err != nil {
 		return errors.New(fmt.Sprintf("Add Node Response Error: %s", err.Error()))
@@ -658,7 +1055,7 @@ func (c *NodeClient) SendRemoveNode(node netlib.NodeAddr) error {
 	data := ComManageNode{node}
 	request, err := c.BuildCommandDataWithAuth("removenode", &data)
 
-	err = c.SendDataWaitResponse(c.NodeAddress, request, nil)
+	err = c.SendDataWaitResponse(c.GetNodeAddress(), request, nil)
 
 	if err != nil {
 		return errors.New(fmt.Sprintf("Remove Node Response Error: %s", err.Error()))
@@ -667,13 +1064,42 @@ func (c *NodeClient) SendRemoveNode(node netlib.NodeAddr) error {
 	return nil
 }
 
+// Get list of banned hosts from a local node
+func (c *NodeClient) SendGetBans() ([]netlib.PeerBan, error) {
+	request, err := c.BuildCommandDataWithAuth(CommandGetBans, nil)
+
+	datapayload := []netlib.PeerBan{}
+
+	err = c.SendDataWaitResponse(c.GetNodeAddress(), request, &datapayload)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Get Bans Response Error: %s", err.Error()))
+	}
+
+	return datapayload, nil
+}
+
+// Remove ban of a host. If host is empty, all bans are removed
+func (c *NodeClient) SendClearBans(host string) error {
+	data := ComClearBans{host}
+	request, err := c.BuildCommandDataWithAuth(CommandClearBans, &data)
+
+	err = c.SendDataWaitResponse(c.GetNodeAddress(), request, nil)
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Clear Bans Response Error: %s", err.Error()))
+	}
+
+	return nil
+}
+
 // Get node blockchain height
 func (c *NodeClient) SendGetState() (ComGetNodeState, error) {
 	request, err := c.BuildCommandDataWithAuth(CommandGetState, nil)
 
 	data := ComGetNodeState{}
 
-	err = c.SendDataWaitResponse(c.NodeAddress, request, &data)
+	err = c.SendDataWaitResponse(c.GetNodeAddress(), request, &data)
 
 	if err != nil {
 		return data, errors.New(fmt.Sprintf("gettig state error: %s", err.Error()))
@@ -686,11 +1112,11 @@ func (c *NodeClient) SendGetState() (ComGetNodeState, error) {
 func (c *NodeClient) SendGetUpdates(addr netlib.NodeAddr, lastCheckTime int64, blockHeight int, topBlocks [][]byte) (*ResponseGetUpdates, error) {
 	data := ComGetUpdates{}
 	data.LastCheckTime = lastCheckTime
-	data.AddrFrom = c.NodeAddress
+	data.AddrFrom = c.GetNodeAddress()
 	data.CurrentBlockHeight = blockHeight
 	data.TopBlocks = topBlocks
 
-	request, err := c.BuildCommandData(CommandGetUpdates, &data)
+	request, err := c.BuildCommandDataForNode(addr, CommandGetUpdates, &data)
 
 	if err != nil {
 		return nil, err
@@ -718,6 +1144,28 @@ func (c *NodeClient) BuildCommandData(command string, data interface{}) ([]byte,
 	return c.doBuildCommandData(command, data, []byte{})
 }
 
+// Builds a command data for a node. If a codec is known for the node, the request
+// is built in envelope format. Else legacy format with gob is used
+func (c *NodeClient) BuildCommandDataForNode(addr netlib.NodeAddr, command string, data interface{}) ([]byte, error) {
+	codecName := c.Codec
+
+	if codecName == "" && c.NodeNet != nil {
+		codecName = c.NodeNet.GetNodeCodec(addr)
+	}
+
+	if codecName == "" {
+		return c.BuildCommandData(command, data)
+	}
+
+	codec, err := netlib.GetCodecByName(codecName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return netlib.EncodeEnvelopeRequest(codec, command, "", data)
+}
+
 // Builds a command data. It prepares a slice of bytes from given data
 func (c *NodeClient) doBuildCommandData(command string, data interface{}, extra []byte) ([]byte, error) {
 	var payload []byte
@@ -757,6 +1205,17 @@ func (c *NodeClient) doBuildCommandData(command string, data interface{}, extra
 
 // Sends prepared command to a node. This doesn't wait any response
 func (c *NodeClient) SendData(addr netlib.NodeAddr, data []byte) error {
+	if err := c.checkPeerBusy(addr); err != nil {
+		return err
+	}
+	// nodes which don't accept connections can be reached only with persistent connections
+	if mc := c.getPersistentConnection(addr, data); mc != nil {
+		if mc.Send(data) == nil {
+			return nil
+		}
+		// connection is broken. try new one
+	}
+
 	err := c.CheckNodeAddress(addr)
 
 	if err != nil {
@@ -764,9 +1223,12 @@ func (c *NodeClient) SendData(addr netlib.NodeAddr, data []byte) error {
 	}
 
 	//c.Logger.Trace.Printf("Sending %d bytes to %s", len(data), addr.NodeAddrToString())
-	conn, err := net.DialTimeout(netlib.Protocol, addr.NodeAddrToString(), 1*time.Second)
+	conn, err := c.dialNode(addr, 1*time.Second)
 
 	if err != nil {
+		if errv, ok := err.(*netlib.NetworkError); ok && errv.IsIdentityMismatch() {
+			return err
+		}
 		c.Logger.Error.Println(err.Error())
 		c.Logger.Trace.Println("Error: ", err.Error())
 
@@ -790,8 +1252,95 @@ func (c *NodeClient) SendData(addr netlib.NodeAddr, data []byte) error {
 	return nil
 }
 
+// Open connection to a node. With TLS identity of a known node is checked.
+// If it is not pinned yet, it is remembered on first connect
+func (c *NodeClient) dialNode(addr netlib.NodeAddr, timeout time.Duration) (net.Conn, error) {
+	if c.Identity == nil {
+		return net.DialTimeout(netlib.Protocol, addr.NodeAddrToString(), timeout)
+	}
+
+	pinned := ""
+
+	if c.NodeNet != nil {
+		pinned = c.NodeNet.GetNodeIdentity(addr)
+	}
+
+	// a node with known identity supported TLS before, plain connection is not used for it
+	allowPlain := !c.RequireTLS && pinned == "" && c.NodeNet != nil
+
+	if allowPlain && c.NodeNet.IsPeerPlain(addr.Host) {
+		return net.DialTimeout(netlib.Protocol, addr.NodeAddrToString(), timeout)
+	}
+
+	conn, identity, err := netlib.DialNodeTLS(addr, c.Identity, pinned, timeout)
+
+	if err != nil {
+		errv, ok := err.(*netlib.NetworkError)
+
+		if ok && errv.IsIdentityMismatch() {
+			c.Logger.Error.Printf("Node %s has wrong identity. %s", addr.NodeAddrToString(), err.Error())
+		}
+
+		if ok && errv.IsTLSHandshakeFailure() && allowPlain {
+			// node of older version. Rolling upgrade of nodes is possible this way
+			c.Logger.Trace.Printf("Node %s doesn't support TLS, plain connection is used. %s", addr.NodeAddrToString(), err.Error())
+			c.NodeNet.SetPeerPlain(addr.Host)
+
+			return net.DialTimeout(netlib.Protocol, addr.NodeAddrToString(), timeout)
+		}
+		return nil, err
+	}
+
+	if pinned == "" && c.NodeNet != nil && c.NodeNet.SetNodeIdentity(addr, identity) {
+		c.Logger.Trace.Printf("Pinned identity %s for node %s", identity, addr.NodeAddrToString())
+	}
+
+	return conn, nil
+}
+
 // Send data to a node and wait for response
 func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, datapayload interface{}) error {
+	if err := c.checkPeerBusy(addr); err != nil {
+		return err
+	}
+
+	err := c.sendDataWaitResponse(addr, data, datapayload)
+
+	if errv, ok := err.(*netlib.NetworkError); ok && errv.IsBusy() && c.NodeNet != nil {
+		// the node asked to wait. requests are not sent to it for some time
+		c.Logger.Trace.Printf("Node %s is busy: %s", addr.NodeAddrToString(), err.Error())
+		c.NodeNet.SetPeerBusy(addr.Host)
+	}
+	return err
+}
+
+// Returns error if a node responded "busy" recently
+func (c *NodeClient) checkPeerBusy(addr netlib.NodeAddr) error {
+	if c.NodeNet != nil && c.NodeNet.IsPeerBusy(addr.Host) {
+		return netlib.NewBusyError(fmt.Sprintf("%s asked to wait before next request", addr.NodeAddrToString()))
+	}
+	return nil
+}
+
+func (c *NodeClient) sendDataWaitResponse(addr netlib.NodeAddr, data []byte, datapayload interface{}) error {
+
+	c.Logger.TraceExt.Println("Sending data to " + addr.NodeAddrToString() + " and waiting response")
+
+	if mc := c.getPersistentConnection(addr, data); mc != nil {
+		response, err := mc.Request(data, persistentRequestTimeout)
+
+		if err == nil {
+			if len(response) == 0 {
+				return netlib.NewNoResponseError("Received 0 bytes as a response. Expected at least 1 byte")
+			}
+			return c.parseEnvelopeResponse(response, datapayload)
+		}
+
+		if errv, ok := err.(*netlib.NetworkError); ok && errv.IsTimeout() {
+			return err
+		}
+		// connection is broken. try new one
+	}
 
 	err := c.CheckNodeAddress(addr)
 
@@ -800,13 +1349,13 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 		return err
 	}
 
-	c.Logger.TraceExt.Println("Sending data to " + addr.NodeAddrToString() + " and waiting response")
-
 	// connect
-	dialer := net.Dialer{Timeout: time.Second * 2}
-	conn, err := dialer.Dial(netlib.Protocol, addr.NodeAddrToString())
+	conn, err := c.dialNode(addr, time.Second*2)
 
 	if err != nil {
+		if errv, ok := err.(*netlib.NetworkError); ok && errv.IsIdentityMismatch() {
+			return err
+		}
 		c.Logger.Error.Println(err.Error())
 		c.Logger.Trace.Println("Error: ", err.Error())
 
@@ -832,7 +1381,19 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 	// read everything
 	//c.Logger.Trace.Println("Start readin response")
 
-	response, err := ioutil.ReadAll(conn)
+	var response []byte
+
+	if netlib.IsEnvelopeRequest(data) {
+		// length of a response is known before it is read
+		response, err = netlib.ReadEnvelopeResponse(conn, netlib.MaxResponseSize)
+
+		if err == io.EOF {
+			// a node closed a connection without a response
+			err = nil
+		}
+	} else {
+		response, err = netlib.ReadAllLimited(conn, netlib.MaxResponseSize)
+	}
 
 	if err != nil {
 		c.Logger.Error.Println(err.Error())
@@ -849,6 +1410,11 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 
 	c.Logger.TraceExt.Printf("Received %d bytes as a response\n", len(response))
 
+	if netlib.IsEnvelopeRequest(data) {
+		// response comes in same format as a request
+		return c.parseEnvelopeResponse(response, datapayload)
+	}
+
 	// convert response for provided structure
 	var buff bytes.Buffer
 	buff.Write(response[1:])
@@ -865,6 +1431,10 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 			return netlib.NewCanNotParseResponseError(err.Error())
 		}
 
+		if busy := netlib.ParseBusyMessage(payload); busy != nil {
+			return busy
+		}
+
 		return errors.New(payload)
 	}
 
@@ -878,3 +1448,95 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 
 	return nil
 }
+
+// Returns persistent connection to a node if there is one. Only requests in envelope format can be sent over it
+func (c *NodeClient) getPersistentConnection(addr netlib.NodeAddr, data []byte) *netlib.MuxConn {
+	if c.NodeNet == nil || !netlib.IsEnvelopeRequest(data) {
+		return nil
+	}
+	return c.NodeNet.GetPeerConnection(addr)
+}
+
+// Opens persistent connection to a node. The node must support envelope format.
+// Requests from other node received over the connection are passed to the handler
+func (c *NodeClient) OpenPersistentConnection(addr netlib.NodeAddr, handler netlib.MuxRequestHandler) (*netlib.MuxConn, error) {
+	err := c.CheckNodeAddress(addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data := ComMux{c.GetNodeAddress()}
+
+	request, err := c.BuildCommandDataForNode(addr, CommandMux, &data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !netlib.IsEnvelopeRequest(request) {
+		return nil, errors.New(fmt.Sprintf("Node %s doesn't support persistent connections", addr.NodeAddrToString()))
+	}
+
+	conn, err := c.dialNode(addr, time.Second*2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	_, err = conn.Write(request)
+
+	if err == nil {
+		var response []byte
+		response, err = netlib.ReadEnvelopeResponse(conn, netlib.MaxResponseSize)
+
+		if err == nil {
+			err = c.parseEnvelopeResponse(response, nil)
+		}
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return netlib.NewMuxConn(conn, addr, handler), nil
+}
+
+// Parse response in envelope format
+func (c *NodeClient) parseEnvelopeResponse(response []byte, datapayload interface{}) error {
+	codec, success, payload, err := netlib.DecodeEnvelopeResponse(response)
+
+	if err != nil {
+		return netlib.NewCanNotParseResponseError(err.Error())
+	}
+
+	if !success {
+		var message string
+
+		err := netlib.DecodePayload(codec, payload, &message)
+
+		if err != nil {
+			return netlib.NewCanNotParseResponseError(err.Error())
+		}
+
+		if busy := netlib.ParseBusyMessage(message); busy != nil {
+			return busy
+		}
+
+		return errors.New(message)
+	}
+
+	if datapayload != nil {
+		err = netlib.DecodePayload(codec, payload, datapayload)
+
+		if err != nil {
+			return netlib.NewCanNotParseResponseError(err.Error())
+		}
+	}
+	return nil
+}
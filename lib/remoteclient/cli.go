@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/gelembjuk/oursql/lib/net"
 	"github.com/gelembjuk/oursql/lib/nodeclient"
@@ -14,17 +15,22 @@ import (
 const walletFile = "wallet.dat"
 
 type AppInput struct {
-	Command   string
-	Address   string
-	ToAddress string
-	Amount    float64
-	NodePort  int
-	NodeHost  string
-	ConfigDir string
-	Nodes     []net.NodeAddr
-	LogDest   string
-	SQL       string
-	Filepath  string
+	Command    string
+	Address    string
+	ToAddress  string
+	Amount     float64
+	NodePort   int
+	NodeHost   string
+	ConfigDir  string
+	Nodes      []net.NodeAddr
+	LogDest    string
+	SQL        string
+	Filepath   string
+	TXID       string
+	Lite       bool // don't trust one node. check headers and compare answers of all nodes
+	FinalDepth int  // confirmations when a transaction is final. 0 means a node decides
+	Table      string
+	Key        string // value of a primary key of a row
 }
 
 type WalletCLI struct {
@@ -50,6 +56,12 @@ func (wc *WalletCLI) Init(logger *utils.LoggerMan, input AppInput) {
 
 	wc.Node.Port = wc.Input.NodePort
 	wc.Node.Host = wc.Input.NodeHost
+
+	wc.Nodes = wc.Input.Nodes
+
+	if len(wc.Nodes) == 0 && wc.Node.Host != "" {
+		wc.Nodes = []net.NodeAddr{wc.Node}
+	}
 }
 
 // Creates Wallets object and fills it from a file if it exists
@@ -86,6 +98,12 @@ func (wc *WalletCLI) checkNodeAddress() error {
 	if wc.NodeMode {
 		return nil
 	}
+	if wc.Input.Lite || wc.Input.Command == "syncheaders" || wc.Input.Command == "verifytx" {
+		if len(wc.Nodes) == 0 {
+			return errors.New("No nodes addresses")
+		}
+		return nil
+	}
 	// only if this is wallet mode
 	if wc.Node.Host == "" {
 		return errors.New("No node address")
@@ -130,6 +148,12 @@ func (wc *WalletCLI) ExecuteCommand() error {
 		return wc.commandListAddressesExt()
 
 	}
+	if wc.Input.Command == "syncheaders" {
+		return wc.commandSyncHeaders()
+	}
+	if wc.Input.Lite && (wc.Input.Command == "getbalance" || wc.Input.Command == "showunspent") {
+		return wc.commandLiteBalance()
+	}
 	if wc.Input.Command == "getbalance" {
 		return wc.commandGetBalance()
 
@@ -149,6 +173,15 @@ func (wc *WalletCLI) ExecuteCommand() error {
 	if wc.Input.Command == "showhistory" {
 		return wc.commandShowHistory()
 	}
+	if wc.Input.Command == "verifytx" {
+		return wc.commandVerifyTransaction()
+	}
+	if wc.Input.Command == "txstatus" {
+		return wc.commandTransactionStatus()
+	}
+	if wc.Input.Command == "rowhistory" {
+		return wc.commandRowHistory()
+	}
 
 	return errors.New("Unknown wallets command")
 }
@@ -259,6 +292,199 @@ func (wc *WalletCLI) commandShowHistory() error {
 	return nil
 }
 
+// Checks that a transaction is in a block. A node returns Merkle proof, it is verified
+// against a header of the block synced from all nodes
+func (wc *WalletCLI) commandVerifyTransaction() error {
+	txID, err := hex.DecodeString(wc.Input.TXID)
+
+	if err != nil || len(txID) == 0 {
+		return errors.New("Transaction ID is not valid")
+	}
+
+	// a node can send any header with a proof. only headers with checked PoW are trusted
+	lc, err := wc.getLiteClient()
+
+	if err != nil {
+		return err
+	}
+
+	header, confirmations, err := lc.VerifyTransaction(txID)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Transaction %x is in block %x, height %d\n", txID, header.Hash, header.Height)
+	fmt.Printf("Confirmations - %d\n", confirmations)
+
+	printLiteConflicts(lc.Conflicts)
+
+	return nil
+}
+
+// Requests state of a transaction from all nodes. Nodes can see it differently if they are on different branches
+func (wc *WalletCLI) commandTransactionStatus() error {
+	txID, err := hex.DecodeString(wc.Input.TXID)
+
+	if err != nil || len(txID) == 0 {
+		return errors.New("Transaction ID is not valid")
+	}
+
+	fmt.Printf("Transaction %x\n", txID)
+
+	for _, addr := range wc.Nodes {
+		status, err := wc.NodeCLI.SendGetTXStatus(addr, txID, wc.Input.FinalDepth)
+
+		if err != nil {
+			fmt.Printf("  %s: error: %s\n", addr.NodeAddrToString(), err.Error())
+			continue
+		}
+
+		fmt.Printf("  %s: %s", addr.NodeAddrToString(), status.State)
+
+		if len(status.BlockHash) > 0 {
+			fmt.Printf(", block %x, height %d, confirmations %d of %d", status.BlockHash, status.Height, status.Confirmations, status.FinalDepth)
+		}
+
+		if len(status.ConflictTX) > 0 {
+			fmt.Printf(", conflicts with %x", status.ConflictTX)
+		}
+
+		if status.Reason != "" {
+			fmt.Printf(" (%s)", status.Reason)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+// Prints all changes of a row, last change first
+func (wc *WalletCLI) commandRowHistory() error {
+	if wc.Input.Table == "" || wc.Input.Key == "" {
+		return errors.New("Table and key of a row are required")
+	}
+
+	history, err := wc.NodeCLI.SendGetRowHistory(wc.Node, wc.Input.Table, wc.Input.Key)
+
+	if err != nil {
+		return err
+	}
+
+	if len(history.Changes) == 0 {
+		fmt.Println("No changes of the row in the blockchain")
+		return nil
+	}
+
+	fmt.Printf("History of row %s:%s\n", wc.Input.Table, wc.Input.Key)
+
+	for _, change := range history.Changes {
+		fmt.Printf("%s in transaction %x\n", change.Kind, change.TXID)
+		fmt.Printf("  Block: %x, height %d, time %s\n", change.BlockHash, change.Height,
+			time.Unix(change.BlockTime, 0).Format("2006-01-02 15:04:05"))
+		fmt.Printf("  Signed by: %s\n", change.Signer)
+		fmt.Printf("  SQL: %s\n", change.Query)
+		fmt.Printf("  Rollback: %s\n", change.RollbackQuery)
+	}
+
+	return nil
+}
+
+// Makes lite client with headers synced from all nodes
+func (wc *WalletCLI) getLiteClient() (*LiteClient, error) {
+	lc := NewLiteClient(wc.ConfigDir, wc.Nodes, wc.NodeCLI, wc.Logger)
+
+	err := lc.LoadFromFile()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = lc.SyncHeaders()
+
+	if err != nil {
+		return nil, err
+	}
+	return lc, nil
+}
+
+func printLiteConflicts(conflicts []LiteConflict) {
+	if len(conflicts) == 0 {
+		return
+	}
+	fmt.Println("\nWARNING! Nodes disagree:")
+
+	for _, c := range conflicts {
+		fmt.Printf("  %s: %s\n", c.Node.NodeAddrToString(), c.Reason)
+	}
+}
+
+// Syncs headers of blocks from all nodes and checks them
+func (wc *WalletCLI) commandSyncHeaders() error {
+	lc, err := wc.getLiteClient()
+
+	if err != nil {
+		return err
+	}
+
+	top := lc.GetHeader(lc.GetHeight())
+
+	fmt.Printf("Headers synced from %d nodes. Height %d, top block %x\n", len(wc.Nodes), top.Height, top.Hash)
+
+	printLiteConflicts(lc.Conflicts)
+
+	return nil
+}
+
+// Balance and unspent outputs of an address from all nodes. Transactions are checked against headers
+func (wc *WalletCLI) commandLiteBalance() error {
+	w := Wallet{}
+	// check input
+	if !w.ValidateAddress(wc.Input.Address) {
+		return errors.New("Address is not valid")
+	}
+
+	lc, err := wc.getLiteClient()
+
+	if err != nil {
+		return err
+	}
+
+	report, err := lc.CheckBalance(wc.Input.Address)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Balance of '%s' on %d nodes, headers height %d:\n", wc.Input.Address, len(report.Nodes), lc.GetHeight())
+
+	for _, nb := range report.Nodes {
+		if nb.Error != "" {
+			fmt.Printf("%s: error %s\n", nb.Node.NodeAddrToString(), nb.Error)
+			continue
+		}
+		fmt.Printf("%s: %.8f (Approved - %.8f, Pending - %.8f)\n", nb.Node.NodeAddrToString(),
+			nb.Balance.Total, nb.Balance.Approved, nb.Balance.Pending)
+	}
+
+	fmt.Println("\nUnspent outputs:")
+
+	for _, o := range report.Outputs {
+		fmt.Printf("%f\t from\t%s in transaction %s output #%d - %s", o.Amount, o.From, hex.EncodeToString(o.TXID), o.Vout, o.State)
+
+		if o.State == LiteOutputVerified {
+			fmt.Printf(" in block %d", o.Height)
+		}
+		fmt.Printf(", reported by %d nodes\n", o.Reported)
+	}
+
+	fmt.Printf("\nVerified in headers - %.8f\n", report.Verified)
+
+	printLiteConflicts(append(lc.Conflicts, report.Conflicts...))
+
+	return nil
+}
+
 // Shows list of unspent transactions for an address
 func (wc *WalletCLI) commandUnspentTransactions() error {
 	w := Wallet{}
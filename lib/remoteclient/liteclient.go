@@ -0,0 +1,558 @@
+package remoteclient
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+const (
+	headersFile      = "headers.json"
+	liteHeadersBatch = 2000
+)
+
+// States of an unspent output checked by lite client
+const (
+	LiteOutputVerified   = "verified"   // transaction is in a block of synced headers
+	LiteOutputPending    = "pending"    // transaction is not yet in a block
+	LiteOutputUnverified = "unverified" // transaction is in a legacy block, it can not be proved
+	LiteOutputInvalid    = "invalid"    // proof of a transaction is wrong
+)
+
+// PoW settings needed to check headers. Same as in consensus config of nodes
+type LitePoWSettings struct {
+	Complexity                     int
+	ComplexityStep2                int
+	MaxMinNumberTransactionInBlock int
+}
+
+// Disagreement of a node with headers or with other nodes
+type LiteConflict struct {
+	Node   net.NodeAddr
+	Reason string
+}
+
+// Balance of an address as a node reported it
+type LiteNodeBalance struct {
+	Node    net.NodeAddr
+	Balance nodeclient.ComWalletBalance
+	Error   string
+}
+
+// Unspent output reported by some nodes
+type LiteOutput struct {
+	TXID     []byte
+	Vout     int
+	Amount   float64
+	From     string
+	State    string
+	Height   int // height of a block with the transaction if it is verified
+	Reported int // number of nodes which reported this output
+}
+
+// Result of cross-check of an address balance on all nodes
+type LiteBalanceReport struct {
+	Address   string
+	Nodes     []LiteNodeBalance
+	Outputs   []LiteOutput
+	Verified  float64 // sum of outputs which are in blocks of synced headers
+	Conflicts []LiteConflict
+}
+
+// Headers chain and PoW settings saved between runs of a wallet
+type liteState struct {
+	Settings LitePoWSettings
+	Headers  []*nodeclient.BlockHeader
+}
+
+// Lite client doesn't trust a single node. It syncs headers of blocks from all nodes and checks
+// PoW and links of them, balances are requested from all nodes and compared
+type LiteClient struct {
+	ConfigDir string
+	Nodes     []net.NodeAddr
+	NodeCLI   *nodeclient.NodeClient
+	Logger    *utils.LoggerMan
+	Conflicts []LiteConflict
+	InMemory  bool // headers are not saved to a file
+	state     liteState
+}
+
+func NewLiteClient(configDir string, nodes []net.NodeAddr, client *nodeclient.NodeClient, logger *utils.LoggerMan) *LiteClient {
+	lc := &LiteClient{}
+	lc.ConfigDir = configDir
+	lc.Nodes = nodes
+	lc.NodeCLI = client
+	lc.Logger = logger
+	lc.Conflicts = []LiteConflict{}
+	lc.state.Headers = []*nodeclient.BlockHeader{}
+
+	return lc
+}
+
+// Load headers saved before
+func (lc *LiteClient) LoadFromFile() error {
+	file, err := os.Open(lc.ConfigDir + headersFile)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err != nil {
+		// headers were not synced yet
+		return nil
+	}
+	defer file.Close()
+
+	return json.NewDecoder(file).Decode(&lc.state)
+}
+
+// Save headers, so next time only new headers are loaded
+func (lc *LiteClient) SaveToFile() error {
+	if lc.InMemory {
+		return nil
+	}
+	file, err := os.OpenFile(lc.ConfigDir+headersFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(&lc.state)
+}
+
+// Height of synced headers. -1 if there are no headers
+func (lc *LiteClient) GetHeight() int {
+	return len(lc.state.Headers) - 1
+}
+
+// Returns synced header by height or nil
+func (lc *LiteClient) GetHeader(height int) *nodeclient.BlockHeader {
+	if height < 0 || height >= len(lc.state.Headers) {
+		return nil
+	}
+	return lc.state.Headers[height]
+}
+
+func (lc *LiteClient) addConflict(node net.NodeAddr, reason string) {
+	lc.Logger.Trace.Printf("Lite client: node %s disagrees: %s", node.NodeAddrToString(), reason)
+
+	lc.Conflicts = append(lc.Conflicts, LiteConflict{node, reason})
+}
+
+// PoW settings are requested from nodes first time. They are saved with headers and are not
+// changed later, so a node can not make headers with lower complexity
+func (lc *LiteClient) loadSettings() error {
+	if lc.state.Settings.Complexity > 0 {
+		return nil
+	}
+
+	var settings *LitePoWSettings
+
+	for _, addr := range lc.Nodes {
+		data, err := lc.NodeCLI.SendGetConsensusData(addr)
+
+		if err != nil {
+			lc.addConflict(addr, "consensus config not loaded: "+err.Error())
+			continue
+		}
+
+		config := struct{ Settings LitePoWSettings }{}
+
+		err = json.Unmarshal(data.ConfigFile, &config)
+
+		if err != nil {
+			lc.addConflict(addr, "consensus config not parsed: "+err.Error())
+			continue
+		}
+		config.Settings.completeSettings()
+
+		if settings == nil {
+			settings = &config.Settings
+		} else if *settings != config.Settings {
+			lc.addConflict(addr, "PoW settings are different from other nodes")
+		}
+	}
+
+	if settings == nil {
+		return errors.New("Consensus config is not loaded from any node")
+	}
+	lc.state.Settings = *settings
+
+	return nil
+}
+
+// Same defaults as nodes use
+func (s *LitePoWSettings) completeSettings() {
+	if s.Complexity < 1 {
+		s.Complexity = 16
+	}
+
+	if s.ComplexityStep2 < 1 {
+		s.ComplexityStep2 = 24
+	}
+
+	if s.MaxMinNumberTransactionInBlock < 1 {
+		s.MaxMinNumberTransactionInBlock = 1000
+	}
+}
+
+// Check a header is next after previous one and its hash is PoW of header data
+func (lc *LiteClient) VerifyHeader(h *nodeclient.BlockHeader, prevHash []byte, height int) error {
+	if !bytes.Equal(h.PrevBlockHash, prevHash) {
+		return errors.New(fmt.Sprintf("Block %x is not next after %x", h.Hash, prevHash))
+	}
+
+	if h.Height != height {
+		return errors.New(fmt.Sprintf("Block %x has wrong height %d, expected %d", h.Hash, h.Height, height))
+	}
+
+	s := lc.state.Settings
+
+	data := utils.MakeProofOfWorkData(h.PrevBlockHash, h.TransactionsHash, h.Timestamp, s.Complexity, h.Version)
+
+	if !bytes.Equal(utils.MakeProofOfWorkHash(data, h.Nonce), h.Hash) {
+		return errors.New(fmt.Sprintf("Hash of block header %x doesn't match its data", h.Hash))
+	}
+
+	complexity := s.Complexity
+
+	if h.Height >= s.MaxMinNumberTransactionInBlock {
+		complexity = s.ComplexityStep2
+	}
+
+	if !utils.CheckProofOfWorkHash(h.Hash, complexity) {
+		return errors.New(fmt.Sprintf("Hash of block header %x is not valid", h.Hash))
+	}
+	return nil
+}
+
+// Load headers from all nodes. Longest valid chain is kept. Nodes which send wrong headers or
+// are on other branch are added to conflicts
+func (lc *LiteClient) SyncHeaders() error {
+	if len(lc.Nodes) == 0 {
+		return errors.New("No nodes to sync headers from")
+	}
+
+	err := lc.loadSettings()
+
+	if err != nil {
+		return err
+	}
+
+	synced := []net.NodeAddr{}
+
+	for _, addr := range lc.Nodes {
+		err := lc.syncFromNode(addr)
+
+		if err != nil {
+			lc.addConflict(addr, err.Error())
+			continue
+		}
+		synced = append(synced, addr)
+	}
+
+	top := lc.GetHeader(lc.GetHeight())
+
+	if len(synced) == 0 || top == nil {
+		return errors.New("Headers are not loaded from any node")
+	}
+
+	// a node which was checked before longer chain was found can be on other branch or behind
+	for _, addr := range synced {
+		_, err := lc.NodeCLI.SendGetHeaders(addr, top.Hash, 0)
+
+		if err != nil {
+			lc.addConflict(addr, fmt.Sprintf("Node doesn't have top block %x at height %d", top.Hash, top.Height))
+		}
+	}
+
+	return lc.SaveToFile()
+}
+
+// Find a header in own chain which a node has in its primary chain. Returns position after it
+// and headers after it
+func (lc *LiteClient) findForkPoint(addr net.NodeAddr) (int, [][]byte, error) {
+	top := lc.GetHeight()
+
+	for step := 1; top >= 0; step *= 2 {
+		res, err := lc.NodeCLI.SendGetHeaders(addr, lc.state.Headers[top].Hash, liteHeadersBatch)
+
+		if err == nil {
+			return top + 1, res.Headers, nil
+		}
+		lc.Logger.Trace.Printf("Lite client: %s has no block %x: %s", addr.NodeAddrToString(), lc.state.Headers[top].Hash, err.Error())
+
+		if top == 0 {
+			break
+		}
+
+		top -= step
+
+		if top < 0 {
+			top = 0
+		}
+	}
+
+	if len(lc.state.Headers) > 0 {
+		return 0, nil, errors.New("Node has other genesis block")
+	}
+
+	res, err := lc.NodeCLI.SendGetHeaders(addr, []byte{}, liteHeadersBatch)
+
+	if err != nil {
+		return 0, nil, err
+	}
+	return 0, res.Headers, nil
+}
+
+func (lc *LiteClient) syncFromNode(addr net.NodeAddr) error {
+	start, data, err := lc.findForkPoint(addr)
+
+	if err != nil {
+		return err
+	}
+
+	branch := []*nodeclient.BlockHeader{}
+
+	prevHash := []byte{}
+
+	if start > 0 {
+		prevHash = lc.state.Headers[start-1].Hash
+	}
+
+	for len(data) > 0 {
+		for _, hdata := range data {
+			h, err := nodeclient.NewBlockHeaderFromBytes(hdata)
+
+			if err != nil {
+				return err
+			}
+
+			err = lc.VerifyHeader(h, prevHash, start+len(branch))
+
+			if err != nil {
+				return err
+			}
+
+			branch = append(branch, h)
+			prevHash = h.Hash
+		}
+
+		if len(data) < liteHeadersBatch {
+			break
+		}
+
+		res, err := lc.NodeCLI.SendGetHeaders(addr, prevHash, liteHeadersBatch)
+
+		if err != nil {
+			return err
+		}
+		data = res.Headers
+	}
+
+	if start+len(branch) > len(lc.state.Headers) {
+		if start < len(lc.state.Headers) {
+			lc.Logger.Trace.Printf("Lite client: switch to branch of %s from height %d", addr.NodeAddrToString(), start)
+		}
+		lc.state.Headers = append(lc.state.Headers[:start], branch...)
+
+	} else if start < len(lc.state.Headers) {
+		return errors.New(fmt.Sprintf("Node is on other branch from height %d", start))
+	}
+
+	return nil
+}
+
+// Requests balance and unspent outputs of an address from all nodes and compares them.
+// Transactions of outputs are checked against synced headers with Merkle proofs
+func (lc *LiteClient) CheckBalance(address string) (*LiteBalanceReport, error) {
+	report := &LiteBalanceReport{}
+	report.Address = address
+	report.Nodes = []LiteNodeBalance{}
+	report.Outputs = []LiteOutput{}
+	report.Conflicts = []LiteConflict{}
+
+	outputs := map[string]*LiteOutput{}
+	// node which reported an output first. a proof is requested from it
+	sources := map[string]net.NodeAddr{}
+	nodeOutputs := map[string]map[string]bool{}
+
+	for _, addr := range lc.Nodes {
+		nb := LiteNodeBalance{Node: addr}
+
+		balance, err := lc.NodeCLI.SendGetBalance(addr, address)
+
+		if err != nil {
+			nb.Error = err.Error()
+			report.Nodes = append(report.Nodes, nb)
+			continue
+		}
+		nb.Balance = balance
+
+		unspent, err := lc.NodeCLI.SendGetUnspent(addr, address, []byte{})
+
+		if err != nil {
+			nb.Error = err.Error()
+			report.Nodes = append(report.Nodes, nb)
+			continue
+		}
+		report.Nodes = append(report.Nodes, nb)
+
+		list := map[string]bool{}
+
+		for _, o := range unspent.Transactions {
+			key := fmt.Sprintf("%x:%d", o.TXID, o.Vout)
+			list[key] = true
+
+			if _, ok := outputs[key]; !ok {
+				outputs[key] = &LiteOutput{TXID: o.TXID, Vout: o.Vout, Amount: o.Amount, From: o.From, Height: -1}
+				sources[key] = addr
+			}
+			outputs[key].Reported++
+		}
+		nodeOutputs[addr.NodeAddrToString()] = list
+	}
+
+	answered := len(nodeOutputs)
+
+	if answered == 0 {
+		return nil, errors.New("Balance is not loaded from any node")
+	}
+
+	keys := []string{}
+
+	for key := range outputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		o := outputs[key]
+
+		lc.verifyOutput(o, sources[key])
+
+		if o.State == LiteOutputVerified {
+			report.Verified += o.Amount
+		}
+		if o.State == LiteOutputInvalid {
+			report.Conflicts = append(report.Conflicts,
+				LiteConflict{sources[key], fmt.Sprintf("Transaction %x is not in synced blocks", o.TXID)})
+		}
+		report.Outputs = append(report.Outputs, *o)
+	}
+
+	// every node must report same outputs and same balance
+	for _, nb := range report.Nodes {
+		if nb.Error != "" {
+			continue
+		}
+		list := nodeOutputs[nb.Node.NodeAddrToString()]
+
+		for _, key := range keys {
+			if !list[key] {
+				report.Conflicts = append(report.Conflicts,
+					LiteConflict{nb.Node, fmt.Sprintf("Output %s is not reported", key)})
+			}
+		}
+
+		for _, other := range report.Nodes {
+			if other.Error == "" && other.Balance.Approved != nb.Balance.Approved {
+				report.Conflicts = append(report.Conflicts,
+					LiteConflict{nb.Node, fmt.Sprintf("Approved balance %.8f is different from other nodes", nb.Balance.Approved)})
+				break
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// Checks a transaction is in a block of synced headers. A proof is requested from nodes till one
+// of them returns a valid proof. Returns header of the block and confirmations counted by synced headers
+func (lc *LiteClient) VerifyTransaction(txID []byte) (*nodeclient.BlockHeader, int, error) {
+	err := errors.New("No nodes to request a proof from")
+
+	for _, addr := range lc.Nodes {
+		var proof *nodeclient.ResponseGetMerkleProof
+
+		proof, err = lc.NodeCLI.SendGetMerkleProof(addr, txID)
+
+		if err != nil {
+			continue
+		}
+
+		var header *nodeclient.BlockHeader
+
+		header, err = lc.verifyProof(txID, proof)
+
+		if err != nil {
+			lc.Logger.Trace.Printf("Lite client: proof of %x from %s is not accepted: %s", txID, addr.NodeAddrToString(), err.Error())
+			continue
+		}
+		return header, lc.GetHeight() - header.Height + 1, nil
+	}
+	return nil, 0, err
+}
+
+// Check a proof against a header of synced chain
+func (lc *LiteClient) verifyProof(txID []byte, proof *nodeclient.ResponseGetMerkleProof) (*nodeclient.BlockHeader, error) {
+	if len(proof.BlockHash) == 0 {
+		return nil, errors.New("Transaction is not in a block yet")
+	}
+
+	header := lc.GetHeader(proof.Height)
+
+	if header == nil {
+		return nil, errors.New(fmt.Sprintf("Block %x at height %d is not in synced headers", proof.BlockHash, proof.Height))
+	}
+
+	err := VerifyTransactionProof(txID, proof, header)
+
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// Check a transaction of an output is in a block of synced headers
+func (lc *LiteClient) verifyOutput(o *LiteOutput, addr net.NodeAddr) {
+	proof, err := lc.NodeCLI.SendGetMerkleProof(addr, o.TXID)
+
+	if err != nil {
+		// legacy blocks have no Merkle root of transactions IDs
+		lc.Logger.Trace.Printf("Lite client: no proof for %x: %s", o.TXID, err.Error())
+		o.State = LiteOutputUnverified
+		return
+	}
+
+	if len(proof.BlockHash) == 0 {
+		o.State = LiteOutputPending
+		return
+	}
+
+	header := lc.GetHeader(proof.Height)
+
+	if header == nil {
+		// block is newer than synced headers
+		o.State = LiteOutputUnverified
+		return
+	}
+
+	err = VerifyTransactionProof(o.TXID, proof, header)
+
+	if err != nil {
+		lc.Logger.Trace.Printf("Lite client: proof of %s is wrong: %s", hex.EncodeToString(o.TXID), err.Error())
+		o.State = LiteOutputInvalid
+		return
+	}
+	o.State = LiteOutputVerified
+	o.Height = proof.Height
+}
@@ -0,0 +1,147 @@
+package remoteclient
+
+import (
+	"os"
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+// Makes a header with valid PoW for given settings
+func makeTestHeader(s LitePoWSettings, prev *nodeclient.BlockHeader) *nodeclient.BlockHeader {
+	h := &nodeclient.BlockHeader{}
+	h.PrevBlockHash = []byte{}
+	h.Timestamp = 1500000000
+	h.TransactionsHash = utils.GetMerkleRoot([][]byte{[]byte("tx")})
+	h.Version = 1
+
+	if prev != nil {
+		h.PrevBlockHash = prev.Hash
+		h.Height = prev.Height + 1
+		h.Timestamp = prev.Timestamp + 1
+	}
+
+	data := utils.MakeProofOfWorkData(h.PrevBlockHash, h.TransactionsHash, h.Timestamp, s.Complexity, h.Version)
+
+	for {
+		h.Hash = utils.MakeProofOfWorkHash(data, h.Nonce)
+
+		if utils.CheckProofOfWorkHash(h.Hash, s.Complexity) {
+			return h
+		}
+		h.Nonce++
+	}
+}
+
+func TestLiteVerifyHeader(t *testing.T) {
+	lc := NewLiteClient("./", nil, nil, utils.CreateLogger())
+	lc.state.Settings = LitePoWSettings{8, 8, 1000}
+
+	genesis := makeTestHeader(lc.state.Settings, nil)
+	next := makeTestHeader(lc.state.Settings, genesis)
+
+	if err := lc.VerifyHeader(genesis, []byte{}, 0); err != nil {
+		t.Fatalf("Genesis is not valid: %s", err.Error())
+	}
+
+	if err := lc.VerifyHeader(next, genesis.Hash, 1); err != nil {
+		t.Fatalf("Header is not valid: %s", err.Error())
+	}
+
+	if err := lc.VerifyHeader(next, []byte{1, 2, 3}, 1); err == nil {
+		t.Fatalf("Header not linked to previous is accepted")
+	}
+
+	if err := lc.VerifyHeader(next, genesis.Hash, 2); err == nil {
+		t.Fatalf("Header with wrong height is accepted")
+	}
+
+	changed := *next
+	changed.TransactionsHash = utils.GetMerkleRoot([][]byte{[]byte("other tx")})
+
+	if err := lc.VerifyHeader(&changed, genesis.Hash, 1); err == nil {
+		t.Fatalf("Header with changed data is accepted")
+	}
+
+	// same header is not enough for bigger complexity
+	lc.state.Settings.Complexity = 40
+
+	if err := lc.VerifyHeader(next, genesis.Hash, 1); err == nil {
+		t.Fatalf("Header with low complexity is accepted")
+	}
+}
+
+func TestLiteSaveAndLoad(t *testing.T) {
+	defer os.Remove("./" + headersFile)
+
+	lc := NewLiteClient("./", nil, nil, utils.CreateLogger())
+	lc.state.Settings = LitePoWSettings{8, 8, 1000}
+
+	genesis := makeTestHeader(lc.state.Settings, nil)
+	lc.state.Headers = append(lc.state.Headers, genesis, makeTestHeader(lc.state.Settings, genesis))
+
+	if err := lc.SaveToFile(); err != nil {
+		t.Fatalf("Save error: %s", err.Error())
+	}
+
+	lc2 := NewLiteClient("./", nil, nil, utils.CreateLogger())
+
+	if err := lc2.LoadFromFile(); err != nil {
+		t.Fatalf("Load error: %s", err.Error())
+	}
+
+	if lc2.GetHeight() != 1 || lc2.state.Settings != lc.state.Settings {
+		t.Fatalf("Loaded headers are wrong, height %d", lc2.GetHeight())
+	}
+
+	if err := lc2.VerifyHeader(lc2.GetHeader(1), lc2.GetHeader(0).Hash, 1); err != nil {
+		t.Fatalf("Loaded header is not valid: %s", err.Error())
+	}
+}
+
+func TestLiteVerifyProof(t *testing.T) {
+	lc := NewLiteClient("./", nil, nil, utils.CreateLogger())
+	lc.state.Settings = LitePoWSettings{8, 8, 1000}
+
+	genesis := makeTestHeader(lc.state.Settings, nil)
+	block := makeTestHeader(lc.state.Settings, genesis)
+	lc.state.Headers = append(lc.state.Headers, genesis, block, makeTestHeader(lc.state.Settings, block))
+
+	ids := [][]byte{[]byte("tx")}
+	steps, _ := utils.GetMerkleProof(ids, 0)
+
+	proof := &nodeclient.ResponseGetMerkleProof{BlockHash: block.Hash, PrevBlockHash: genesis.Hash, Height: 1,
+		MerkleRoot: block.TransactionsHash, Proof: steps}
+
+	header, err := lc.verifyProof(ids[0], proof)
+
+	if err != nil {
+		t.Fatalf("Valid proof is not accepted: %s", err.Error())
+	}
+	if header != block {
+		t.Fatalf("Wrong header is returned for a proof")
+	}
+
+	// a node made own block with the transaction, it is not in synced headers
+	forged := *proof
+	forged.BlockHash = []byte("forged block")
+
+	if _, err := lc.verifyProof(ids[0], &forged); err == nil {
+		t.Fatalf("Proof for a block not in synced headers is accepted")
+	}
+
+	forged = *proof
+	forged.Height = 3
+
+	if _, err := lc.verifyProof(ids[0], &forged); err == nil {
+		t.Fatalf("Proof for a block above synced headers is accepted")
+	}
+
+	forged = *proof
+	forged.BlockHash = []byte{}
+
+	if _, err := lc.verifyProof(ids[0], &forged); err == nil {
+		t.Fatalf("Proof without a block is accepted")
+	}
+}
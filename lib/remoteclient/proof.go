@@ -0,0 +1,27 @@
+package remoteclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+// Checks that a transaction is in a block with given header. A header must be received from
+// a trusted source, not from same response as a proof
+func VerifyTransactionProof(txID []byte, proof *nodeclient.ResponseGetMerkleProof, header *nodeclient.BlockHeader) error {
+	if !bytes.Equal(proof.BlockHash, header.Hash) || proof.Height != header.Height {
+		return errors.New(fmt.Sprintf("Proof is for block %x, header is of block %x", proof.BlockHash, header.Hash))
+	}
+
+	if !bytes.Equal(proof.MerkleRoot, header.TransactionsHash) {
+		return errors.New(fmt.Sprintf("Merkle root of block %x doesn't match its header", header.Hash))
+	}
+
+	if !utils.VerifyMerkleProof(txID, proof.Proof, proof.MerkleRoot) {
+		return errors.New(fmt.Sprintf("Transaction %x is not in block %x", txID, header.Hash))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package remoteclient
+
+import (
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+func TestVerifyTransactionProof(t *testing.T) {
+	ids := [][]byte{[]byte("tx1"), []byte("tx2"), []byte("tx3")}
+
+	root := utils.GetMerkleRoot(ids)
+
+	steps, err := utils.GetMerkleProof(ids, 2)
+
+	if err != nil {
+		t.Fatalf("Proof failed: %s", err.Error())
+	}
+
+	header := &nodeclient.BlockHeader{Hash: []byte("block"), Height: 5, TransactionsHash: root, Version: 1}
+	proof := &nodeclient.ResponseGetMerkleProof{BlockHash: []byte("block"), Height: 5, MerkleRoot: root, Proof: steps}
+
+	if err := VerifyTransactionProof(ids[2], proof, header); err != nil {
+		t.Fatalf("Valid proof is not accepted: %s", err.Error())
+	}
+
+	if err := VerifyTransactionProof(ids[0], proof, header); err == nil {
+		t.Fatalf("Proof of other transaction is accepted")
+	}
+
+	other := *header
+	other.TransactionsHash = utils.GetMerkleRoot(ids[:2])
+
+	if err := VerifyTransactionProof(ids[2], proof, &other); err == nil {
+		t.Fatalf("Proof is accepted with other header")
+	}
+
+	other = *header
+	other.Hash = []byte("other block")
+
+	if err := VerifyTransactionProof(ids[2], proof, &other); err == nil {
+		t.Fatalf("Proof is accepted for other block")
+	}
+}
@@ -0,0 +1,120 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/remoteclient"
+)
+
+// Kinds of block events
+const (
+	BlockAdded   = "added"
+	BlockRemoved = "removed" // a block was replaced by other branch
+)
+
+// Max number of recent blocks remembered to detect switch to other branch
+const subscribeRecentBlocks = 100
+
+// New block or removed block in synced headers
+type BlockEvent struct {
+	Kind   string
+	Header nodeclient.BlockHeader
+}
+
+// Returns a channel where new blocks are sent. Headers are checked every PollInterval.
+// Removed blocks are sent before blocks of new branch. The channel is closed when a context is cancelled
+func (c *Client) SubscribeBlocks(ctx context.Context) (<-chan BlockEvent, error) {
+	recent := []*nodeclient.BlockHeader{}
+
+	err := c.lite.sync(ctx, func(lc *remoteclient.LiteClient) error {
+		if top := lc.GetHeader(lc.GetHeight()); top != nil {
+			recent = append(recent, top)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan BlockEvent, subscribeRecentBlocks)
+
+	go c.pollBlocks(ctx, recent, events)
+
+	return events, nil
+}
+
+func (c *Client) pollBlocks(ctx context.Context, recent []*nodeclient.BlockHeader, events chan BlockEvent) {
+	defer close(events)
+
+	for {
+		select {
+		case <-time.After(c.PollInterval):
+		case <-ctx.Done():
+			return
+		}
+
+		var changes []BlockEvent
+
+		err := c.lite.sync(ctx, func(lc *remoteclient.LiteClient) error {
+			changes, recent = compareHeaders(lc, recent)
+			return nil
+		})
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.Logger.Trace.Printf("SDK: blocks are not checked: %s", err.Error())
+			continue
+		}
+
+		for _, e := range changes {
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Finds blocks which are not in synced headers anymore and new blocks. Returns events and
+// new list of recent blocks
+func compareHeaders(lc *remoteclient.LiteClient, recent []*nodeclient.BlockHeader) ([]BlockEvent, []*nodeclient.BlockHeader) {
+	events := []BlockEvent{}
+
+	height := -1
+
+	if len(recent) > 0 {
+		height = recent[len(recent)-1].Height
+	}
+
+	for len(recent) > 0 {
+		last := recent[len(recent)-1]
+
+		if h := lc.GetHeader(last.Height); h != nil && bytes.Equal(h.Hash, last.Hash) {
+			break
+		}
+		events = append(events, BlockEvent{BlockRemoved, *last})
+		recent = recent[:len(recent)-1]
+		height = last.Height - 1
+	}
+
+	for height < lc.GetHeight() {
+		height++
+
+		h := lc.GetHeader(height)
+		events = append(events, BlockEvent{BlockAdded, *h})
+		recent = append(recent, h)
+	}
+
+	if len(recent) > subscribeRecentBlocks {
+		recent = recent[len(recent)-subscribeRecentBlocks:]
+	}
+
+	return events, recent
+}
@@ -0,0 +1,283 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/remoteclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+// Default settings of a client
+const (
+	DefaultRetries      = 2
+	DefaultRetryDelay   = 1 * time.Second
+	DefaultPollInterval = 5 * time.Second
+)
+
+// Client to work with OurSQL nodes from an application. Methods can be called from different goroutines
+type Client struct {
+	Nodes        []net.NodeAddr
+	Retries      int           // how many times to try the list of nodes again if no node is available
+	RetryDelay   time.Duration // pause before next try of the list of nodes
+	PollInterval time.Duration // how often to check new blocks when waiting for confirmations
+	Logger       *utils.LoggerMan
+	nodeCLI      *nodeclient.NodeClient
+	lite         *liteHeaders
+}
+
+// Transaction prepared by a node. It must be signed and submitted
+type PreparedTX struct {
+	Node       net.NodeAddr // node which prepared a transaction. It is submitted to this node first
+	From       string       // address of a signer
+	TX         []byte
+	DataToSign []byte
+	Finished   bool // SQL query was executed without a transaction (for example, SELECT). Nothing to sign
+}
+
+func NewClient(nodes []net.NodeAddr) *Client {
+	c := &Client{}
+	c.Nodes = nodes
+	c.Retries = DefaultRetries
+	c.RetryDelay = DefaultRetryDelay
+	c.PollInterval = DefaultPollInterval
+	c.Logger = utils.CreateLogger()
+
+	c.nodeCLI = &nodeclient.NodeClient{}
+	c.nodeCLI.Logger = c.Logger
+	c.nodeCLI.Codec = net.CodecNameBinary
+
+	c.lite = newLiteHeaders(c)
+
+	return c
+}
+
+// Set a codec used for requests. Empty codec means legacy gob requests
+func (c *Client) SetCodec(codec string) error {
+	return c.nodeCLI.SetCodec(codec)
+}
+
+// Set TLS identity. Connections to nodes are encrypted and identities of nodes are checked
+func (c *Client) SetIdentity(identity *net.NodeIdentity) {
+	c.nodeCLI.SetIdentity(identity)
+}
+
+// Returns a copy of the node client. NodeClient is not safe for concurrent use, every call gets own copy
+func (c *Client) getNodeClient() *nodeclient.NodeClient {
+	client := *c.nodeCLI
+	client.Logger = c.Logger
+	return &client
+}
+
+// Sends a request to nodes of the list until some node answers. Only network errors are retried,
+// an answer of a node with an error is returned
+func (c *Client) call(ctx context.Context, nodes []net.NodeAddr, request func(client *nodeclient.NodeClient, addr net.NodeAddr) error) error {
+	if len(nodes) == 0 {
+		return errors.New("No nodes to send a request to")
+	}
+
+	var lastErr error
+
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(c.RetryDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		for _, addr := range nodes {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			err := runWithContext(ctx, func() error {
+				return request(c.getNodeClient(), addr)
+			})
+
+			if err == nil {
+				return nil
+			}
+
+			if _, ok := err.(*net.NetworkError); !ok {
+				return err
+			}
+			c.Logger.Trace.Printf("SDK: node %s is not available: %s", addr.NodeAddrToString(), err.Error())
+			lastErr = err
+		}
+	}
+
+	return errors.New(fmt.Sprintf("No node answered: %s", lastErr.Error()))
+}
+
+// Runs a function and waits for result or for cancel of a context. Network requests
+// can not be interrupted, a function continues in background after cancel
+func runWithContext(ctx context.Context, f func() error) error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Nodes list where given node is first
+func (c *Client) nodesStartingWith(addr net.NodeAddr) []net.NodeAddr {
+	nodes := []net.NodeAddr{addr}
+
+	for _, n := range c.Nodes {
+		if n.CompareToAddress(addr) {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// Requests a node to prepare SQL transaction signed by a key. Returns a transaction to sign
+func (c *Client) PrepareSQL(ctx context.Context, pubKey []byte, sql string) (*PreparedTX, error) {
+	from, err := utils.PubKeyToAddres(pubKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &PreparedTX{From: from}
+
+	err = c.call(ctx, c.Nodes, func(client *nodeclient.NodeClient, addr net.NodeAddr) error {
+		finished, txBytes, dataToSign, err := client.SendRequestNewSQLTransaction(addr, pubKey, sql)
+
+		if err != nil {
+			return err
+		}
+		tx.Node = addr
+		tx.Finished = finished
+		tx.TX = txBytes
+		tx.DataToSign = dataToSign
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Requests a node to prepare currency transaction. Returns a transaction to sign
+func (c *Client) PrepareSend(ctx context.Context, pubKey []byte, to string, amount float64) (*PreparedTX, error) {
+	from, err := utils.PubKeyToAddres(pubKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &PreparedTX{From: from}
+
+	err = c.call(ctx, c.Nodes, func(client *nodeclient.NodeClient, addr net.NodeAddr) error {
+		txBytes, dataToSign, err := client.SendRequestNewCurrencyTransaction(addr, pubKey, to, amount)
+
+		if err != nil {
+			return err
+		}
+		tx.Node = addr
+		tx.TX = txBytes
+		tx.DataToSign = dataToSign
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Sends signed transaction. The node which prepared a transaction gets it first.
+// Use this if a transaction is signed outside of the SDK. Returns ID of a transaction
+func (c *Client) Submit(ctx context.Context, tx *PreparedTX, signature []byte) ([]byte, error) {
+	if tx.Finished {
+		return nil, errors.New("Transaction is finished, nothing to submit")
+	}
+
+	var txID []byte
+
+	err := c.call(ctx, c.nodesStartingWith(tx.Node), func(client *nodeclient.NodeClient, addr net.NodeAddr) error {
+		id, err := client.SendNewTransactionData(addr, tx.From, tx.TX, signature)
+
+		if err != nil {
+			return err
+		}
+		txID = id
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return txID, nil
+}
+
+// Signs prepared transaction with keys of a wallet and submits it. Returns ID of a transaction
+func (c *Client) SignAndSubmit(ctx context.Context, wallet *remoteclient.Wallet, tx *PreparedTX) ([]byte, error) {
+	signature, err := utils.SignDataByPubKey(wallet.GetPublicKey(), wallet.GetPrivateKey(), tx.DataToSign)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Submit(ctx, tx, signature)
+}
+
+// Executes SQL query signed by a wallet. Returns ID of new transaction or nil if a query
+// didn't need a transaction
+func (c *Client) ExecuteSQL(ctx context.Context, wallet *remoteclient.Wallet, sql string) ([]byte, error) {
+	tx, err := c.PrepareSQL(ctx, wallet.GetPublicKey(), sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tx.Finished {
+		return nil, nil
+	}
+
+	return c.SignAndSubmit(ctx, wallet, tx)
+}
+
+// Sends money from a wallet to an address. Returns ID of new transaction
+func (c *Client) Send(ctx context.Context, wallet *remoteclient.Wallet, to string, amount float64) ([]byte, error) {
+	tx, err := c.PrepareSend(ctx, wallet.GetPublicKey(), to, amount)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.SignAndSubmit(ctx, wallet, tx)
+}
+
+// Returns balance of an address from first node which answers
+func (c *Client) GetBalance(ctx context.Context, address string) (nodeclient.ComWalletBalance, error) {
+	balance := nodeclient.ComWalletBalance{}
+
+	err := c.call(ctx, c.Nodes, func(client *nodeclient.NodeClient, addr net.NodeAddr) error {
+		b, err := client.SendGetBalance(addr, address)
+
+		if err != nil {
+			return err
+		}
+		balance = b
+		return nil
+	})
+
+	return balance, err
+}
@@ -0,0 +1,230 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/remoteclient"
+)
+
+func makeTestClient(nodes ...net.NodeAddr) *Client {
+	c := NewClient(nodes)
+	c.Retries = 1
+	c.RetryDelay = 10 * time.Millisecond
+	c.PollInterval = 10 * time.Millisecond
+	return c
+}
+
+func makeTestWallet() *remoteclient.Wallet {
+	w := &remoteclient.Wallet{}
+	w.MakeWallet()
+	return w
+}
+
+func TestSQLTransactionConfirmation(t *testing.T) {
+	node, err := NewTestNode()
+
+	if err != nil {
+		t.Fatalf("Test node not started: %s", err.Error())
+	}
+	defer node.Close()
+
+	c := makeTestClient(node.Addr)
+	w := makeTestWallet()
+	ctx := context.Background()
+
+	txID, err := c.ExecuteSQL(ctx, w, "SELECT * FROM notes")
+
+	if err != nil || txID != nil {
+		t.Fatalf("Select should not make a transaction: %x %v", txID, err)
+	}
+
+	txID, err = c.ExecuteSQL(ctx, w, "INSERT INTO notes SET text='hello'")
+
+	if err != nil {
+		t.Fatalf("Transaction not submitted: %s", err.Error())
+	}
+
+	if node.GetPoolSize() != 1 {
+		t.Fatalf("Expected 1 transaction in a pool, got %d", node.GetPoolSize())
+	}
+
+	status, err := c.GetTransactionStatus(ctx, txID, 0)
+
+	if err != nil || status.State != "pending" {
+		t.Fatalf("Transaction must be pending: %v %v", status, err)
+	}
+
+	conf, err := c.GetConfirmation(ctx, txID)
+
+	if err != nil {
+		t.Fatalf("Confirmation not loaded: %s", err.Error())
+	}
+
+	if conf.Height != -1 || conf.Confirmations != 0 {
+		t.Fatalf("Transaction must be pending, got height %d", conf.Height)
+	}
+
+	block := node.MakeBlock()
+	node.MakeBlock()
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	conf, err = c.WaitForConfirmation(waitCtx, txID, 2)
+
+	if err != nil {
+		t.Fatalf("Confirmation not received: %s", err.Error())
+	}
+
+	if !bytes.Equal(conf.BlockHash, block.Hash) || conf.Confirmations != 2 {
+		t.Fatalf("Wrong confirmation: block %x, %d confirmations", conf.BlockHash, conf.Confirmations)
+	}
+
+	status, err = c.GetTransactionStatus(ctx, txID, 2)
+
+	if err != nil || status.State != "final" || status.Confirmations != 2 {
+		t.Fatalf("Transaction must be final: %v %v", status, err)
+	}
+}
+
+func TestWaitForConfirmationCancel(t *testing.T) {
+	node, err := NewTestNode()
+
+	if err != nil {
+		t.Fatalf("Test node not started: %s", err.Error())
+	}
+	defer node.Close()
+
+	c := makeTestClient(node.Addr)
+	w := makeTestWallet()
+
+	txID, err := c.ExecuteSQL(context.Background(), w, "DELETE FROM notes")
+
+	if err != nil {
+		t.Fatalf("Transaction not submitted: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = c.WaitForConfirmation(ctx, txID, 1)
+
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline error, got %v", err)
+	}
+}
+
+func TestRetryOtherNode(t *testing.T) {
+	down, err := NewTestNode()
+
+	if err != nil {
+		t.Fatalf("Test node not started: %s", err.Error())
+	}
+	down.Close()
+
+	node, err := NewTestNode()
+
+	if err != nil {
+		t.Fatalf("Test node not started: %s", err.Error())
+	}
+	defer node.Close()
+
+	w := makeTestWallet()
+	address := string(w.GetAddress())
+	node.SetBalance(address, nodeclient.ComWalletBalance{Total: 10, Approved: 10})
+
+	c := makeTestClient(down.Addr, node.Addr)
+	ctx := context.Background()
+
+	balance, err := c.GetBalance(ctx, address)
+
+	if err != nil {
+		t.Fatalf("Balance not loaded: %s", err.Error())
+	}
+
+	if balance.Approved != 10 {
+		t.Fatalf("Expected balance 10, got %f", balance.Approved)
+	}
+
+	// error of a node is returned without retries
+	_, err = c.Send(ctx, w, address, 20)
+
+	if err == nil || err.Error() != "No enough funds to make new transaction" {
+		t.Fatalf("Expected funds error, got %v", err)
+	}
+
+	_, err = c.Send(ctx, w, address, 5)
+
+	if err != nil {
+		t.Fatalf("Money not sent: %s", err.Error())
+	}
+
+	// no node is available
+	c = makeTestClient(down.Addr)
+
+	_, err = c.GetBalance(ctx, address)
+
+	if err == nil {
+		t.Fatalf("Expected error when no nodes are available")
+	}
+}
+
+func TestSubscribeBlocks(t *testing.T) {
+	node, err := NewTestNode()
+
+	if err != nil {
+		t.Fatalf("Test node not started: %s", err.Error())
+	}
+	defer node.Close()
+
+	c := makeTestClient(node.Addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events, err := c.SubscribeBlocks(ctx)
+
+	if err != nil {
+		t.Fatalf("Not subscribed: %s", err.Error())
+	}
+
+	expect := func(kind string, hash []byte) {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("Events channel is closed")
+			}
+			if e.Kind != kind || !bytes.Equal(e.Header.Hash, hash) {
+				t.Fatalf("Expected %s %x, got %s %x", kind, hash, e.Kind, e.Header.Hash)
+			}
+		case <-ctx.Done():
+			t.Fatalf("No event %s %x", kind, hash)
+		}
+	}
+
+	first := node.MakeBlock()
+	expect(BlockAdded, first.Hash)
+
+	// switch to other branch. a transaction makes new block different from dropped one
+	node.DropBlocks(1)
+
+	if _, err := c.ExecuteSQL(ctx, makeTestWallet(), "DELETE FROM notes"); err != nil {
+		t.Fatalf("Transaction not submitted: %s", err.Error())
+	}
+	second := node.MakeBlock()
+	third := node.MakeBlock()
+
+	expect(BlockRemoved, first.Hash)
+	expect(BlockAdded, second.Hash)
+	expect(BlockAdded, third.Hash)
+
+	cancel()
+
+	for range events {
+	}
+}
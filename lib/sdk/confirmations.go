@@ -0,0 +1,190 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/remoteclient"
+)
+
+// State of a transaction in synced blocks
+type Confirmation struct {
+	TXID          []byte
+	BlockHash     []byte // empty if a transaction is not in a block yet
+	Height        int    // height of a block with a transaction or -1
+	Confirmations int    // 1 when a block with a transaction is on top
+	Conflicts     []remoteclient.LiteConflict
+}
+
+// Headers of blocks synced from all nodes of a client. They are kept in memory only
+type liteHeaders struct {
+	client *Client
+	lock   sync.Mutex
+	lite   *remoteclient.LiteClient
+}
+
+func newLiteHeaders(c *Client) *liteHeaders {
+	return &liteHeaders{client: c}
+}
+
+// Loads new headers from nodes and calls a function while headers are locked
+func (l *liteHeaders) sync(ctx context.Context, f func(lc *remoteclient.LiteClient) error) error {
+	return runWithContext(ctx, func() error {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+
+		if l.lite == nil {
+			l.lite = remoteclient.NewLiteClient("", nil, l.client.getNodeClient(), l.client.Logger)
+			l.lite.InMemory = true
+		}
+		l.lite.Nodes = l.client.Nodes
+
+		var err error
+
+		for attempt := 0; attempt <= l.client.Retries; attempt++ {
+			if attempt > 0 {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				time.Sleep(l.client.RetryDelay)
+			}
+			l.lite.Conflicts = []remoteclient.LiteConflict{}
+
+			err = l.lite.SyncHeaders()
+
+			if err == nil {
+				return f(l.lite)
+			}
+			l.client.Logger.Trace.Printf("SDK: headers are not synced: %s", err.Error())
+		}
+		return err
+	})
+}
+
+// Returns state of a transaction. A proof of a transaction is checked against headers synced from all nodes
+func (c *Client) GetConfirmation(ctx context.Context, txID []byte) (*Confirmation, error) {
+	var proof *nodeclient.ResponseGetMerkleProof
+	var node net.NodeAddr
+
+	err := c.call(ctx, c.Nodes, func(client *nodeclient.NodeClient, addr net.NodeAddr) error {
+		p, err := client.SendGetMerkleProof(addr, txID)
+
+		if err != nil {
+			return err
+		}
+		proof = p
+		node = addr
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &Confirmation{TXID: txID, Height: -1}
+
+	if len(proof.BlockHash) == 0 {
+		// transaction is in a pool of a node
+		return conf, nil
+	}
+
+	err = c.lite.sync(ctx, func(lc *remoteclient.LiteClient) error {
+		conf.Conflicts = append([]remoteclient.LiteConflict{}, lc.Conflicts...)
+
+		header := lc.GetHeader(proof.Height)
+
+		if header == nil {
+			// the node has more blocks than synced headers. next sync will get them
+			return nil
+		}
+
+		err := remoteclient.VerifyTransactionProof(txID, proof, header)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Proof from %s is wrong: %s", node.NodeAddrToString(), err.Error()))
+		}
+		conf.BlockHash = header.Hash
+		conf.Height = header.Height
+		conf.Confirmations = lc.GetHeight() - header.Height + 1
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Returns state of a transaction as a node sees it: pending, inblock, final, conflicted, dropped or unknown.
+// finalDepth is number of confirmations when a transaction is final, with 0 a node uses own setting.
+// Unlike GetConfirmation, the answer of a node is not checked against headers
+func (c *Client) GetTransactionStatus(ctx context.Context, txID []byte, finalDepth int) (*nodeclient.ResponseGetTXStatus, error) {
+	var status *nodeclient.ResponseGetTXStatus
+
+	err := c.call(ctx, c.Nodes, func(client *nodeclient.NodeClient, addr net.NodeAddr) error {
+		s, err := client.SendGetTXStatus(addr, txID, finalDepth)
+
+		if err != nil {
+			return err
+		}
+		status = s
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Returns all changes of a row in primary chain of a node, last change first. key is a value of a primary key
+func (c *Client) GetRowHistory(ctx context.Context, table string, key string) ([]nodeclient.RowChange, error) {
+	var history []nodeclient.RowChange
+
+	err := c.call(ctx, c.Nodes, func(client *nodeclient.NodeClient, addr net.NodeAddr) error {
+		h, err := client.SendGetRowHistory(addr, table, key)
+
+		if err != nil {
+			return err
+		}
+		history = h.Changes
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// Waits until a transaction is in a block and there are given number of confirmations (including
+// that block). Returns an error if a context is cancelled before
+func (c *Client) WaitForConfirmation(ctx context.Context, txID []byte, confirmations int) (*Confirmation, error) {
+	if confirmations < 1 {
+		confirmations = 1
+	}
+
+	for {
+		conf, err := c.GetConfirmation(ctx, txID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if conf.Confirmations >= confirmations {
+			return conf, nil
+		}
+
+		select {
+		case <-time.After(c.PollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// Package sdk is a client for applications which use OurSQL blockchain DB from Go code.
+//
+// It is built on the same network client as the remote wallet (lib/nodeclient and lib/remoteclient).
+// A client knows a list of nodes. A request goes to the first node, if the node is not
+// available the request is sent to next node. The whole list is tried Retries+1 times.
+// Errors returned by a node (for example, wrong SQL or not enough funds) are not retried.
+// All methods accept a context, a call returns as soon as the context is cancelled.
+//
+// Keys never leave an application. A node prepares a transaction, an application signs it and
+// sends it back
+//
+//	client := sdk.NewClient([]net.NodeAddr{net.NewNodeAddr("node1.example.com", 8765), net.NewNodeAddr("node2.example.com", 8765)})
+//
+//	tx, err := client.PrepareSQL(ctx, wallet.GetPublicKey(), "INSERT INTO notes SET text='hello'")
+//	...
+//	txID, err := client.SignAndSubmit(ctx, wallet, tx)
+//	...
+//	conf, err := client.WaitForConfirmation(ctx, txID, 6)
+//
+// Confirmations and new blocks are checked against headers of blocks synced from all nodes,
+// same as lite wallet mode does. Blocks must have Merkle root of transactions (block version 1)
+// to confirm a transaction.
+//
+// TestNode is a node emulation which runs in the same process. It can be used in tests of
+// applications. See docs/SDK.md for more info.
+package sdk
@@ -0,0 +1,501 @@
+package sdk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	gonet "net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+// Complexity of PoW in a test node. Low, so blocks are made fast
+const testNodeComplexity = 8
+
+// Confirmations when a transaction is final if a client doesn't set it
+const testNodeFinalDepth = 6
+
+// Node emulation running in the same process. It answers requests used by the SDK and keeps
+// everything in memory. There is no SQL execution, a transaction is only signature check.
+// Transactions wait in a pool until MakeBlock is called
+type TestNode struct {
+	Addr     net.NodeAddr
+	listener gonet.Listener
+	lock     sync.Mutex
+	balances map[string]nodeclient.ComWalletBalance
+	prepared map[string]bool // transactions prepared but not signed yet
+	pool     [][]byte
+	blocks   [][][]byte // IDs of transactions of every block
+	headers  []*nodeclient.BlockHeader
+}
+
+// Transaction as a test node prepares it
+type testNodeTX struct {
+	PubKey []byte
+	SQL    string
+	To     string
+	Amount float64
+	Time   int64
+}
+
+// Starts a test node on a free local port. Genesis block is created
+func NewTestNode() (*TestNode, error) {
+	listener, err := gonet.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, err
+	}
+
+	n := &TestNode{}
+	n.listener = listener
+	n.Addr = net.NewNodeAddr("127.0.0.1", listener.Addr().(*gonet.TCPAddr).Port)
+	n.balances = map[string]nodeclient.ComWalletBalance{}
+	n.prepared = map[string]bool{}
+	n.pool = [][]byte{}
+	n.blocks = [][][]byte{}
+	n.headers = []*nodeclient.BlockHeader{}
+
+	genesis := sha256.Sum256([]byte("genesis"))
+	n.pool = append(n.pool, genesis[:])
+	n.MakeBlock()
+
+	go n.serve()
+
+	return n, nil
+}
+
+// Stops listening
+func (n *TestNode) Close() error {
+	return n.listener.Close()
+}
+
+// Set balance returned for an address
+func (n *TestNode) SetBalance(address string, balance nodeclient.ComWalletBalance) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.balances[address] = balance
+}
+
+// Number of transactions waiting for a block
+func (n *TestNode) GetPoolSize() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return len(n.pool)
+}
+
+// Height of top block
+func (n *TestNode) GetHeight() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return len(n.headers) - 1
+}
+
+// Makes new block with all transactions from a pool. Empty block is made if there are no transactions
+func (n *TestNode) MakeBlock() *nodeclient.BlockHeader {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	h := &nodeclient.BlockHeader{}
+	h.PrevBlockHash = []byte{}
+	h.Timestamp = time.Now().Unix()
+	h.Height = len(n.headers)
+	h.Version = 1
+	h.TransactionsHash = utils.GetMerkleRoot(n.pool)
+
+	if h.Height > 0 {
+		h.PrevBlockHash = n.headers[h.Height-1].Hash
+	}
+
+	data := utils.MakeProofOfWorkData(h.PrevBlockHash, h.TransactionsHash, h.Timestamp, testNodeComplexity, h.Version)
+
+	for {
+		h.Hash = utils.MakeProofOfWorkHash(data, h.Nonce)
+
+		if utils.CheckProofOfWorkHash(h.Hash, testNodeComplexity) {
+			break
+		}
+		h.Nonce++
+	}
+
+	n.headers = append(n.headers, h)
+	n.blocks = append(n.blocks, n.pool)
+	n.pool = [][]byte{}
+
+	return h
+}
+
+// Removes top blocks, transactions of them return to a pool. It emulates switch to other branch
+func (n *TestNode) DropBlocks(count int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for i := 0; i < count && len(n.headers) > 1; i++ {
+		top := len(n.headers) - 1
+
+		n.pool = append(n.blocks[top], n.pool...)
+		n.headers = n.headers[:top]
+		n.blocks = n.blocks[:top]
+	}
+}
+
+func (n *TestNode) serve() {
+	for {
+		conn, err := n.listener.Accept()
+
+		if err != nil {
+			// listener is closed
+			return
+		}
+		go n.handleConnection(conn)
+	}
+}
+
+func (n *TestNode) handleConnection(conn gonet.Conn) {
+	defer conn.Close()
+
+	codec, command, payload, err := readTestNodeRequest(conn)
+
+	if err != nil {
+		return
+	}
+
+	result, err := n.handleRequest(codec, command, payload)
+
+	success := err == nil
+
+	if err != nil {
+		result = err.Error()
+	}
+
+	response, err := net.EncodePayload(codec, result)
+
+	if err != nil {
+		response, _ = net.EncodePayload(codec, err.Error())
+		success = false
+	}
+
+	conn.Write(net.EncodeEnvelopeResponse(codec, success, response))
+}
+
+// Reads a request in envelope format. Only envelope requests are supported
+func readTestNodeRequest(conn io.Reader) (byte, string, []byte, error) {
+	head := make([]byte, 3)
+
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return 0, "", nil, err
+	}
+
+	if head[0] != net.EnvelopeVersion {
+		return 0, "", nil, errors.New("Only envelope requests are supported")
+	}
+
+	command := make([]byte, head[2])
+
+	if _, err := io.ReadFull(conn, command); err != nil {
+		return 0, "", nil, err
+	}
+
+	// auth string is not checked
+	if _, err := readTestNodeBytes(conn); err != nil {
+		return 0, "", nil, err
+	}
+
+	payload, err := readTestNodeBytes(conn)
+
+	if err != nil {
+		return 0, "", nil, err
+	}
+	return head[1], string(command), payload, nil
+}
+
+func readTestNodeBytes(conn io.Reader) ([]byte, error) {
+	lengthbuffer := make([]byte, 4)
+
+	if _, err := io.ReadFull(conn, lengthbuffer); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(lengthbuffer)
+
+	if length > net.MaxResponseSize {
+		return nil, errors.New("Request is too long")
+	}
+
+	data := make([]byte, length)
+
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (n *TestNode) handleRequest(codec byte, command string, payload []byte) (interface{}, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	switch command {
+	case nodeclient.CommandGetConsensusData:
+		config := struct{ Settings map[string]int }{}
+		config.Settings = map[string]int{
+			"Complexity":                     testNodeComplexity,
+			"ComplexityStep2":                testNodeComplexity,
+			"MaxMinNumberTransactionInBlock": 1000}
+
+		data, err := json.Marshal(config)
+
+		if err != nil {
+			return nil, err
+		}
+		return &nodeclient.ComGetConsensusData{ConfigFile: data, ConfigFileSize: len(data)}, nil
+
+	case nodeclient.CommandGetHeaders:
+		req := nodeclient.ComGetHeaders{}
+
+		if err := net.DecodePayload(codec, payload, &req); err != nil {
+			return nil, err
+		}
+		return n.getHeaders(req)
+
+	case nodeclient.CommandGetBalance:
+		req := nodeclient.ComGetWalletBalance{}
+
+		if err := net.DecodePayload(codec, payload, &req); err != nil {
+			return nil, err
+		}
+		balance := n.balances[req.Address]
+		return &balance, nil
+
+	case "txsqlrequest":
+		req := nodeclient.ComRequestSQLTransaction{}
+
+		if err := net.DecodePayload(codec, payload, &req); err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(req.SQL)), "SELECT") {
+			return &nodeclient.ComRequestTransactionData{Finished: true}, nil
+		}
+		return n.prepareTX(testNodeTX{PubKey: req.PubKey, SQL: req.SQL})
+
+	case "txcurrequest":
+		req := nodeclient.ComRequestTransaction{}
+
+		if err := net.DecodePayload(codec, payload, &req); err != nil {
+			return nil, err
+		}
+
+		from, err := utils.PubKeyToAddres(req.PubKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n.balances[from].Approved < req.Amount {
+			return nil, errors.New("No enough funds to make new transaction")
+		}
+		return n.prepareTX(testNodeTX{PubKey: req.PubKey, To: req.To, Amount: req.Amount})
+
+	case "txdata":
+		req := nodeclient.ComNewTransactionData{}
+
+		if err := net.DecodePayload(codec, payload, &req); err != nil {
+			return nil, err
+		}
+		return n.addTX(req)
+
+	case nodeclient.CommandGetMerkleProof:
+		req := nodeclient.ComGetMerkleProof{}
+
+		if err := net.DecodePayload(codec, payload, &req); err != nil {
+			return nil, err
+		}
+		return n.getMerkleProof(req.TransactionID)
+
+	case nodeclient.CommandGetTXStatus:
+		req := nodeclient.ComGetTXStatus{}
+
+		if err := net.DecodePayload(codec, payload, &req); err != nil {
+			return nil, err
+		}
+		return n.getTXStatus(req.TransactionID, req.FinalDepth), nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Command %s is not supported by test node", command))
+}
+
+func (n *TestNode) getHeaders(req nodeclient.ComGetHeaders) (*nodeclient.ResponseGetHeaders, error) {
+	res := &nodeclient.ResponseGetHeaders{}
+	res.Headers = [][]byte{}
+	res.Height = len(n.headers) - 1
+
+	start := 0
+
+	if len(req.StartFrom) > 0 {
+		start = -1
+
+		for i, h := range n.headers {
+			if bytes.Equal(h.Hash, req.StartFrom) {
+				start = i + 1
+				break
+			}
+		}
+
+		if start < 0 {
+			return nil, errors.New(fmt.Sprintf("Block %x is not in primary chain", req.StartFrom))
+		}
+	}
+
+	for i := start; i < len(n.headers) && len(res.Headers) < req.MaxCount; i++ {
+		var buff bytes.Buffer
+
+		if err := gob.NewEncoder(&buff).Encode(n.headers[i]); err != nil {
+			return nil, err
+		}
+		res.Headers = append(res.Headers, buff.Bytes())
+	}
+	return res, nil
+}
+
+func (n *TestNode) prepareTX(tx testNodeTX) (*nodeclient.ComRequestTransactionData, error) {
+	tx.Time = time.Now().UnixNano()
+
+	var buff bytes.Buffer
+
+	if err := gob.NewEncoder(&buff).Encode(tx); err != nil {
+		return nil, err
+	}
+
+	data := &nodeclient.ComRequestTransactionData{}
+	data.TX = buff.Bytes()
+	hash := sha256.Sum256(data.TX)
+	data.DataToSign = hash[:]
+
+	n.prepared[string(data.TX)] = true
+
+	return data, nil
+}
+
+func (n *TestNode) addTX(req nodeclient.ComNewTransactionData) ([]byte, error) {
+	if !n.prepared[string(req.TX)] {
+		return nil, errors.New("Transaction was not prepared by this node")
+	}
+
+	tx := testNodeTX{}
+
+	if err := gob.NewDecoder(bytes.NewReader(req.TX)).Decode(&tx); err != nil {
+		return nil, err
+	}
+
+	from, err := utils.PubKeyToAddres(tx.PubKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if from != req.Address {
+		return nil, errors.New("Address doesn't match public key of a transaction")
+	}
+
+	hash := sha256.Sum256(req.TX)
+
+	valid, err := utils.VerifySignature(req.Signature, hash[:], tx.PubKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !valid {
+		return nil, errors.New("Signature is not valid")
+	}
+	delete(n.prepared, string(req.TX))
+
+	id := sha256.Sum256(append(req.TX, req.Signature...))
+	n.pool = append(n.pool, id[:])
+
+	return id[:], nil
+}
+
+func (n *TestNode) getMerkleProof(txID []byte) (*nodeclient.ResponseGetMerkleProof, error) {
+	for _, id := range n.pool {
+		if bytes.Equal(id, txID) {
+			// not in a block yet
+			return &nodeclient.ResponseGetMerkleProof{}, nil
+		}
+	}
+
+	for height, ids := range n.blocks {
+		for i, id := range ids {
+			if !bytes.Equal(id, txID) {
+				continue
+			}
+
+			proof, err := utils.GetMerkleProof(ids, i)
+
+			if err != nil {
+				return nil, err
+			}
+
+			res := &nodeclient.ResponseGetMerkleProof{}
+			res.BlockHash = n.headers[height].Hash
+			res.PrevBlockHash = n.headers[height].PrevBlockHash
+			res.Height = height
+			res.MerkleRoot = n.headers[height].TransactionsHash
+			res.Proof = proof
+
+			return res, nil
+		}
+	}
+
+	return nil, errors.New("Transaction is not found")
+}
+
+// Test node has no other branches, so transactions are never conflicted or dropped
+func (n *TestNode) getTXStatus(txID []byte, finalDepth int) *nodeclient.ResponseGetTXStatus {
+	if finalDepth < 1 {
+		finalDepth = testNodeFinalDepth
+	}
+
+	status := &nodeclient.ResponseGetTXStatus{}
+	status.TXID = txID
+	status.State = "unknown"
+	status.Height = -1
+	status.FinalDepth = finalDepth
+
+	for _, id := range n.pool {
+		if bytes.Equal(id, txID) {
+			status.State = "pending"
+			return status
+		}
+	}
+
+	for height, ids := range n.blocks {
+		for _, id := range ids {
+			if !bytes.Equal(id, txID) {
+				continue
+			}
+			status.BlockHash = n.headers[height].Hash
+			status.Height = height
+			status.Confirmations = len(n.headers) - height
+			status.State = "inblock"
+
+			if status.Confirmations >= finalDepth {
+				status.State = "final"
+			}
+			return status
+		}
+	}
+	return status
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 )
 
 // MerkleTree represent a Merkle tree
@@ -68,3 +71,95 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 
 	return &mNode
 }
+
+// Step of a Merkle inclusion proof. Hash of a sibling node on a path from a leaf to the root
+type MerkleProofStep struct {
+	Hash []byte `proto:"1"`
+	Left bool   `proto:"2"` // sibling is on the left side
+}
+
+// Leaf of a Merkle tree used for inclusion proofs. Leaves and inner nodes are hashed with different
+// prefix, so an inner node can not be presented as a leaf
+func MakeMerkleLeaf(data []byte) []byte {
+	hash := sha256.Sum256(append([]byte{0}, data...))
+	return hash[:]
+}
+
+func makeMerkleParent(left, right []byte) []byte {
+	data := append([]byte{1}, left...)
+	hash := sha256.Sum256(append(data, right...))
+	return hash[:]
+}
+
+func makeMerkleLeaves(data [][]byte) [][]byte {
+	leaves := [][]byte{}
+
+	for _, d := range data {
+		leaves = append(leaves, MakeMerkleLeaf(d))
+	}
+	return leaves
+}
+
+// Next level of a tree. Last node goes up without changes if number of nodes is odd
+func makeMerkleLevel(nodes [][]byte) [][]byte {
+	level := [][]byte{}
+
+	for i := 0; i < len(nodes); i += 2 {
+		if i+1 == len(nodes) {
+			level = append(level, nodes[i])
+			break
+		}
+		level = append(level, makeMerkleParent(nodes[i], nodes[i+1]))
+	}
+	return level
+}
+
+// Returns Merkle root of a list. Unlike NewMerkleTree it allows to make short proofs
+// of inclusion of an element
+func GetMerkleRoot(data [][]byte) []byte {
+	if len(data) == 0 {
+		hash := sha256.Sum256([]byte{})
+		return hash[:]
+	}
+	nodes := makeMerkleLeaves(data)
+
+	for len(nodes) > 1 {
+		nodes = makeMerkleLevel(nodes)
+	}
+	return nodes[0]
+}
+
+// Returns proof of inclusion of an element with given index to the Merkle root of a list
+func GetMerkleProof(data [][]byte, index int) ([]MerkleProofStep, error) {
+	if index < 0 || index >= len(data) {
+		return nil, errors.New(fmt.Sprintf("Index %d is out of list of %d elements", index, len(data)))
+	}
+
+	proof := []MerkleProofStep{}
+	nodes := makeMerkleLeaves(data)
+
+	for len(nodes) > 1 {
+		sibling := index ^ 1
+
+		if sibling < len(nodes) {
+			proof = append(proof, MerkleProofStep{nodes[sibling], sibling < index})
+		}
+		nodes = makeMerkleLevel(nodes)
+		index = index / 2
+	}
+	return proof, nil
+}
+
+// Checks if an element is included to a list with given Merkle root
+func VerifyMerkleProof(data []byte, proof []MerkleProofStep, root []byte) bool {
+	hash := MakeMerkleLeaf(data)
+
+	for _, step := range proof {
+		if step.Left {
+			hash = makeMerkleParent(step.Hash, hash)
+		} else {
+			hash = makeMerkleParent(hash, step.Hash)
+		}
+	}
+	return bytes.Equal(hash, root)
+}
@@ -98,3 +98,38 @@ func TestCases(t *testing.T) {
 	}
 
 }
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		data := [][]byte{}
+
+		for i := 0; i < n; i++ {
+			data = append(data, []byte(fmt.Sprintf("tx%d", i)))
+		}
+
+		root := GetMerkleRoot(data)
+
+		for i := 0; i < n; i++ {
+			proof, err := GetMerkleProof(data, i)
+
+			assert.NoError(t, err)
+			assert.True(t, VerifyMerkleProof(data[i], proof, root), fmt.Sprintf("Proof of %d of %d is valid", i, n))
+			assert.False(t, VerifyMerkleProof([]byte("other"), proof, root), "Proof of other data is not valid")
+
+			if len(proof) > 0 {
+				proof[0].Left = !proof[0].Left
+				assert.False(t, VerifyMerkleProof(data[i], proof, root), "Proof with changed side is not valid")
+			}
+		}
+	}
+
+	_, err := GetMerkleProof([][]byte{[]byte("tx0")}, 1)
+
+	assert.Error(t, err)
+
+	// inner node can not be presented as a leaf
+	data := [][]byte{[]byte("tx0"), []byte("tx1")}
+	inner := append(MakeMerkleLeaf(data[0]), MakeMerkleLeaf(data[1])...)
+
+	assert.False(t, VerifyMerkleProof(inner, []MerkleProofStep{}, GetMerkleRoot(data)))
+}
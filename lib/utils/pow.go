@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/big"
+)
+
+// Data of a block hashed in proof of work, without a nonce. Version is added only for new blocks,
+// so hashes of legacy blocks are not changed. Nodes and lite clients must build it same way
+func MakeProofOfWorkData(prevBlockHash []byte, txshash []byte, timestamp int64, complexity int, version int) []byte {
+	data := [][]byte{
+		prevBlockHash,
+		txshash,
+		IntToHex(timestamp),
+		IntToHex(int64(complexity)),
+	}
+
+	if version > 0 {
+		data = append(data, IntToHex(int64(version)))
+	}
+	return bytes.Join(data, []byte{})
+}
+
+// Hash of proof of work data with a nonce
+func MakeProofOfWorkHash(data []byte, nonce int) []byte {
+	hash := sha256.Sum256(append(data, IntToHex(int64(nonce))...))
+	return hash[:]
+}
+
+// Returns a target for a complexity. Complexity is a number of 0 bits in the beginning of a hash
+func MakeProofOfWorkTarget(complexity int) *big.Int {
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-complexity))
+
+	return target
+}
+
+// Checks if a hash is less than the target for a complexity
+func CheckProofOfWorkHash(hash []byte, complexity int) bool {
+	var hashInt big.Int
+
+	hashInt.SetBytes(hash)
+
+	return hashInt.Cmp(MakeProofOfWorkTarget(complexity)) == -1
+}
@@ -3,6 +3,7 @@ package blockchain
 import (
 	"bytes"
 	"errors"
+	"fmt"
 
 	"github.com/gelembjuk/oursql/lib/utils"
 	"github.com/gelembjuk/oursql/node/database"
@@ -592,6 +593,62 @@ func (bc *Blockchain) GetNextBlocks(startfrom []byte, maxcount int) ([]*structur
 	return blocks, nil
 }
 
+// Returns headers of blocks of primary chain after given block. If startfrom is empty, headers
+// start from genesis block
+func (bc *Blockchain) GetHeadersAfter(startfrom []byte, maxcount int) ([]*structures.BlockHeader, error) {
+	bcdb, err := bc.DB.GetBlockchainObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var nextHash []byte
+
+	if len(startfrom) == 0 {
+		nextHash, err = bcdb.GetFirstHash()
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		inchain, _, next, err := bcdb.GetLocationInChain(startfrom)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !inchain {
+			return nil, errors.New(fmt.Sprintf("Block %x is not in primary chain", startfrom))
+		}
+		nextHash = next
+	}
+
+	headers := []*structures.BlockHeader{}
+
+	for len(nextHash) > 0 && len(headers) < maxcount {
+		block, err := bc.GetBlock(nextHash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		header, err := block.GetHeader()
+
+		if err != nil {
+			return nil, err
+		}
+
+		headers = append(headers, header)
+
+		_, _, nextHash, err = bcdb.GetLocationInChain(block.Hash)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return headers, nil
+}
+
 // Returns first blocks in block chain
 func (bc *Blockchain) GetFirstBlocks(maxcount int) ([]*structures.Block, int, error) {
 	localError := func(err error) ([]*structures.Block, int, error) {
@@ -45,12 +45,15 @@ type AllPossibleArgs struct {
 	NodePort            int
 	NodeHost            string
 	NodeAddress         string
+	NodeIdentity        string
 	DefaultAddresses    string
 	Genesis             string
 	Amount              float64
 	LogDest             string
 	LogDestDefault      bool // to know if logs destination was specified or not
 	Transaction         string
+	Table               string
+	Key                 string
 	View                string
 	Clean               bool
 	MySQLHost           string
@@ -60,8 +63,13 @@ type AllPossibleArgs struct {
 	MySQLPassword       string
 	MySQLDBName         string
 	DBTablesPrefix      string
+	MySQLMaxOpen        int
+	MySQLMaxIdle        int
 	DumpFile            string
 	DestinationFile     string
+	DestinationDB       string
+	Height              int
+	Method              string
 	SQL                 string
 	ConsensusFileToCopy string
 	FilePath            string
@@ -85,6 +93,17 @@ type AppInput struct {
 	DBProxyAddress             string
 	ConseususConfigFile        string
 	ConseususConfigFilePresent bool
+	AuditLog                   AuditLogConfig
+	DBProxyPool                DBProxyPoolConfig
+	Transport                  string
+	LocalDiscovery             bool
+	NAT                        string
+	OutboundOnly               bool
+	RequireTLS                 bool
+	MessageLimits              map[string]int
+	RateLimits                 RateLimitsConfig
+	HTTPAPI                    HTTPAPIConfig
+	FinalDepth                 int
 }
 
 type AppConfig struct {
@@ -98,6 +117,44 @@ type AppConfig struct {
 	LogsDestination string
 	Database        database.DatabaseConfig
 	DBProxyAddress  string
+	AuditLog        AuditLogConfig
+	DBProxyPool     DBProxyPoolConfig
+	Transport       string         // tls or plain. Default is tls
+	LocalDiscovery  bool           // find other nodes in local network with multicast
+	NAT             string         // port mapping on a router. upnp, pmp or auto. Empty to not use
+	OutboundOnly    bool           // only connect to other nodes, don't accept connections from them
+	RequireTLS      bool           // refuse plain connections from other hosts. Remote clients must use TLS too
+	MessageLimits   map[string]int // max sizes of requests per command in bytes. "default" is for other commands
+	RateLimits      RateLimitsConfig
+	HTTPAPI         HTTPAPIConfig
+	FinalDepth      int // confirmations needed for a transaction to be final
+}
+
+// Audit log of queries passed through DB proxy
+type AuditLogConfig struct {
+	File     string
+	MaxSize  int // MB. File is rotated when reaches this size
+	MaxFiles int // number of rotated files to keep
+}
+
+// Pool of connections from DB proxy to DB server
+type DBProxyPoolConfig struct {
+	Size        int // max number of idle connections. 0 means connections are not reused
+	MaxOpen     int // max number of all connections to MySQL. Clients wait when all are busy. 0 means no limit
+	IdleTimeout int // seconds
+}
+
+// HTTP JSON API. It is not started if address is empty
+type HTTPAPIConfig struct {
+	Address     string // host:port to listen on
+	RequireAuth bool   // all requests need bearer token. Otherwise only node state needs it
+}
+
+// Limits of requests from other nodes and clients. Zero values mean default limits
+type RateLimitsConfig struct {
+	Classes        map[string]net.RateLimit // requests per second per host for classes sync, poll, client and default
+	Bandwidth      int                      // bytes per second per host
+	MaxConnections int                      // connections served at same time
 }
 
 // Parses input and config file. Command line arguments ovverride config file options
@@ -127,6 +184,8 @@ func parseConfig(dirpath string) (AppInput, error) {
 		cmd.StringVar(&input.ProxyKey, "proxykey", "", "Wallet address which is used to sign SQL transactions in a proxy")
 		cmd.StringVar(&input.Args.Genesis, "genesis", "", "Genesis block text")
 		cmd.StringVar(&input.Args.Transaction, "transaction", "", "Transaction ID")
+		cmd.StringVar(&input.Args.Table, "table", "", "Table name")
+		cmd.StringVar(&input.Args.Key, "key", "", "Value of a primary key of a row")
 		cmd.StringVar(&input.Args.From, "from", "", "Address to send money from")
 		cmd.StringVar(&input.Args.To, "to", "", "Address to send money to")
 		cmd.StringVar(&input.Args.Host, "host", "", "Node Server Host")
@@ -135,6 +194,12 @@ func parseConfig(dirpath string) (AppInput, error) {
 		cmd.IntVar(&input.LocalPort, "localport", 0, "Node Server local port to listen on it")
 		cmd.IntVar(&input.Args.NodePort, "nodeport", 0, "Remote Node Server port")
 		cmd.StringVar(&input.Args.NodeAddress, "nodeaddress", "", "Remote Node Server Address")
+		cmd.StringVar(&input.Args.NodeIdentity, "nodeidentity", "", "Remote Node identity to pin")
+		cmd.StringVar(&input.Transport, "transport", "", "Transport for connections to other nodes. tls or plain")
+		cmd.BoolVar(&input.LocalDiscovery, "localdiscovery", false, "Find other nodes in local network")
+		cmd.StringVar(&input.NAT, "nat", "", "Map a port on a router. upnp, pmp or auto")
+		cmd.BoolVar(&input.OutboundOnly, "outboundonly", false, "Don't accept connections from other nodes")
+		cmd.BoolVar(&input.RequireTLS, "requiretls", false, "Refuse plain connections from other hosts")
 		cmd.StringVar(&input.Args.DefaultAddresses, "defaultaddresses", "", "List of addresses to set as default for consensus config")
 		cmd.Float64Var(&input.Args.Amount, "amount", 0, "Amount money to send")
 		cmd.StringVar(&input.Args.LogDest, "logdest", "", "Destination of logs. file or stdout")
@@ -150,9 +215,20 @@ func parseConfig(dirpath string) (AppInput, error) {
 		cmd.StringVar(&input.Args.MySQLPassword, "mysqlpass", "", "MySQL password")
 		cmd.StringVar(&input.Args.MySQLDBName, "mysqldb", "", "MySQL database")
 		cmd.StringVar(&input.Args.DBTablesPrefix, "tablesprefix", "", "MySQL blockchain tables prefix")
+		cmd.IntVar(&input.Args.MySQLMaxOpen, "mysqlmaxopen", 0, "Max number of node connections to MySQL")
+		cmd.IntVar(&input.Args.MySQLMaxIdle, "mysqlmaxidle", 0, "Number of idle node connections to MySQL kept for reuse")
 		cmd.StringVar(&input.DBProxyAddress, "dbproxyaddr", "", "MySQL DB proxy address host:port")
+		cmd.IntVar(&input.DBProxyPool.Size, "dbproxypool", 0, "Number of idle connections DB proxy keeps for reuse")
+		cmd.IntVar(&input.DBProxyPool.MaxOpen, "dbproxypoolmax", 0, "Max number of MySQL connections of DB proxy pool")
+		cmd.IntVar(&input.RateLimits.MaxConnections, "maxconnections", 0, "Max number of connections served at same time")
+		cmd.StringVar(&input.HTTPAPI.Address, "httpapi", "", "Address to listen for HTTP API requests, host:port")
+		cmd.IntVar(&input.FinalDepth, "finaldepth", 0, "Number of confirmations when a transaction is final")
+		cmd.StringVar(&input.AuditLog.File, "auditlog", "", "File where to write DB proxy audit log")
 		cmd.StringVar(&input.Args.DumpFile, "dumpfile", "", "File where to dump DB")
 		cmd.StringVar(&input.Args.DestinationFile, "destfile", "", "Destination file for export")
+		cmd.StringVar(&input.Args.DestinationDB, "destdb", "", "New MySQL database to restore tables to")
+		cmd.IntVar(&input.Args.Height, "height", -1, "Height of a block")
+		cmd.StringVar(&input.Args.Method, "method", "", "Method to restore tables at a height. replay or rollback")
 		cmd.StringVar(&input.Args.SQL, "sql", "", "SQL command to execute")
 
 		cmd.StringVar(&input.Args.ConsensusFileToCopy, "consensusfile", "", "Consensus file source")
@@ -249,6 +325,77 @@ func parseConfig(dirpath string) (AppInput, error) {
 		}
 
 		input.Database = config.Database
+
+		if input.AuditLog.File == "" {
+			input.AuditLog.File = config.AuditLog.File
+		}
+		input.AuditLog.MaxSize = config.AuditLog.MaxSize
+		input.AuditLog.MaxFiles = config.AuditLog.MaxFiles
+
+		if input.DBProxyPool.Size == 0 {
+			input.DBProxyPool.Size = config.DBProxyPool.Size
+		}
+		if input.DBProxyPool.MaxOpen == 0 {
+			input.DBProxyPool.MaxOpen = config.DBProxyPool.MaxOpen
+		}
+		input.DBProxyPool.IdleTimeout = config.DBProxyPool.IdleTimeout
+
+		if input.Transport == "" {
+			input.Transport = config.Transport
+		}
+
+		if !input.LocalDiscovery {
+			input.LocalDiscovery = config.LocalDiscovery
+		}
+
+		if input.NAT == "" {
+			input.NAT = config.NAT
+		}
+
+		if !input.OutboundOnly {
+			input.OutboundOnly = config.OutboundOnly
+		}
+
+		if !input.RequireTLS {
+			input.RequireTLS = config.RequireTLS
+		}
+
+		input.MessageLimits = config.MessageLimits
+
+		if input.RateLimits.MaxConnections == 0 {
+			input.RateLimits.MaxConnections = config.RateLimits.MaxConnections
+		}
+		input.RateLimits.Classes = config.RateLimits.Classes
+		input.RateLimits.Bandwidth = config.RateLimits.Bandwidth
+
+		if input.HTTPAPI.Address == "" {
+			input.HTTPAPI.Address = config.HTTPAPI.Address
+		}
+		input.HTTPAPI.RequireAuth = config.HTTPAPI.RequireAuth
+
+		if input.FinalDepth == 0 {
+			input.FinalDepth = config.FinalDepth
+		}
+	}
+
+	if input.Transport == "" {
+		input.Transport = TransportTLS
+	}
+
+	if input.Transport != TransportTLS && input.Transport != TransportPlain {
+		return input, errors.New(fmt.Sprintf("Unknown transport %s", input.Transport))
+	}
+
+	if input.RequireTLS && input.Transport != TransportTLS {
+		return input, errors.New("TLS can not be required when transport is plain")
+	}
+
+	if input.NAT != "" && input.NAT != net.NATKindAuto && input.NAT != net.NATKindUPnP && input.NAT != net.NATKindPMP {
+		return input, errors.New(fmt.Sprintf("Unknown NAT kind %s", input.NAT))
+	}
+
+	if input.AuditLog.File != "" && !filepath.IsAbs(input.AuditLog.File) {
+		input.AuditLog.File = input.ConfigDir + input.AuditLog.File
 	}
 
 	if !(input.Args.NodeHost != "" && input.Args.NodePort > 0) &&
@@ -259,6 +406,10 @@ func parseConfig(dirpath string) (AppInput, error) {
 
 		input.Args.NodeHost = na.Host
 		input.Args.NodePort = na.Port
+
+		if input.Args.NodeIdentity == "" {
+			input.Args.NodeIdentity = na.Identity
+		}
 	}
 
 	input.completeDBConfig()
@@ -338,6 +489,12 @@ func (c *AppInput) completeDBConfig() {
 	if c.Database.TablesPrefix == "" && c.Args.DBTablesPrefix != "" {
 		c.Database.TablesPrefix = c.Args.DBTablesPrefix
 	}
+	if c.Database.MaxOpenConns == 0 && c.Args.MySQLMaxOpen > 0 {
+		c.Database.MaxOpenConns = c.Args.MySQLMaxOpen
+	}
+	if c.Database.MaxIdleConns == 0 && c.Args.MySQLMaxIdle > 0 {
+		c.Database.MaxIdleConns = c.Args.MySQLMaxIdle
+	}
 }
 
 // check if this commands really needs a config file
@@ -416,6 +573,45 @@ func (c AppInput) UpdateConfig() error {
 		config.DBProxyAddress = c.DBProxyAddress
 	}
 
+	if c.AuditLog.File != "" {
+		config.AuditLog.File = c.AuditLog.File
+	}
+
+	if c.DBProxyPool.Size > 0 {
+		config.DBProxyPool.Size = c.DBProxyPool.Size
+	}
+	if c.DBProxyPool.MaxOpen > 0 {
+		config.DBProxyPool.MaxOpen = c.DBProxyPool.MaxOpen
+	}
+
+	if c.RateLimits.MaxConnections > 0 {
+		config.RateLimits.MaxConnections = c.RateLimits.MaxConnections
+	}
+
+	if c.HTTPAPI.Address != "" {
+		config.HTTPAPI.Address = c.HTTPAPI.Address
+	}
+
+	if c.Transport != "" {
+		config.Transport = c.Transport
+	}
+
+	if c.LocalDiscovery {
+		config.LocalDiscovery = true
+	}
+
+	if c.NAT != "" {
+		config.NAT = c.NAT
+	}
+
+	if c.OutboundOnly {
+		config.OutboundOnly = true
+	}
+
+	if c.RequireTLS {
+		config.RequireTLS = true
+	}
+
 	if c.Args.NodeHost != "" && c.Args.NodePort > 0 {
 		node := net.NewNodeAddr(c.Args.NodeHost, c.Args.NodePort)
 
@@ -476,6 +672,12 @@ func (c AppInput) UpdateConfig() error {
 	if c.Args.DBTablesPrefix != "" {
 		config.Database.TablesPrefix = c.Args.DBTablesPrefix
 	}
+	if c.Args.MySQLMaxOpen > 0 {
+		config.Database.MaxOpenConns = c.Args.MySQLMaxOpen
+	}
+	if c.Args.MySQLMaxIdle > 0 {
+		config.Database.MaxIdleConns = c.Args.MySQLMaxIdle
+	}
 
 	// convert back to JSON and save to config file
 	file, errf := os.OpenFile(configfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
@@ -529,8 +731,9 @@ func (c AppInput) PrintUsage() {
 	fmt.Println("  importblockchain [-consensusfile FILEPATH] [-nodeaddress HOST:PORT] [-mysqlhost HOST] [-mysqlport PORT] [-mysqluser USER] [-mysqlpass PASSWORD] [-mysqldb DBNAME] [-tablesprefix PREFIX]\n\t- Loads a blockchain from other node to init the DB. If consensusfile is set and it contains initial node address, it will be used")
 	fmt.Println("  restoreblockchain -dumpfile FILEPATH [-mysqlhost HOST] [-mysqlport PORT] [-mysqluser USER] [-mysqlpass PASSWORD] [-mysqldb DBNAME] [-tablesprefix PREFIX]\n\t- Loads a blockchain from dump file and restores it to given DB. A DB credentials can be optional if they are present in config file")
 	fmt.Println("  dumpblockchain -dumpfile FILEPATH\n\t- Dump blockchain DB to a file. This fle can be used to restore a BC")
+	fmt.Println("  dbatheight -height HEIGHT [-table TABLE] [-method replay|rollback] -dumpfile FILEPATH|-destdb DBNAME\n\t- Makes tables as they were at given block height. SQL is written to a dump file or executed in new database on same MySQL server, the live DB is not changed. Without -table all tables of the blockchain are included. replay (default) executes queries of transactions from genesis block, rollback copies current tables and executes rollback queries of the pool and blocks above the height")
 	fmt.Println("  exportconsensusconfig -destfile FILEPATH [-defaultaddresses own,host:port] [-appname NAME]\n\t- Save consensus config file. Can include this node address as initial address.")
-	fmt.Println("  updateconfig [-minter ADDRESS] [-proxykey ADDRESS] [-host HOST] [-port PORT] [-nodehost HOST] [-nodeport PORT] [-mysqlhost HOST] [-mysqlport PORT] [-mysqluser USER] [-mysqlpass PASSWORD] [-mysqldb DBNAME] [-tablesprefix PREFIX] [-dbproxyaddr ADDR]\n\t- Update config file. Allows to set this node minter address, host and port and remote node host and port")
+	fmt.Println("  updateconfig [-minter ADDRESS] [-proxykey ADDRESS] [-host HOST] [-port PORT] [-nodehost HOST] [-nodeport PORT] [-mysqlhost HOST] [-mysqlport PORT] [-mysqluser USER] [-mysqlpass PASSWORD] [-mysqldb DBNAME] [-tablesprefix PREFIX] [-mysqlmaxopen NUMBER] [-mysqlmaxidle NUMBER] [-dbproxyaddr ADDR] [-dbproxypool SIZE] [-dbproxypoolmax NUMBER] [-auditlog FILEPATH] [-transport tls|plain]\n\t- Update config file. Allows to set this node minter address, host and port and remote node host and port")
 
 	fmt.Println("=[Blockchain manage operations]")
 	fmt.Println("  printchain [-view short|long]\n\t- Print all the blocks of the blockchain. Default view is long")
@@ -551,15 +754,19 @@ func (c AppInput) PrintUsage() {
 
 	fmt.Println("=[Transactions]")
 	fmt.Println("  canceltransaction -transaction TRANSACTIONID\n\t- Cancel unapproved transaction. NOTE!. This cancels only from local cache!")
+	fmt.Println("  txstatus -transaction TRANSACTIONID [-finaldepth NUMBER]\n\t- Show if a transaction is pending, in a block (with number of confirmations), final, or was canceled with a block of other branch (dropped or conflicted). -finaldepth number of confirmations when a transaction is final, default is 6")
+	fmt.Println("  rowhistory -table TABLE -key KEY\n\t- Show all changes of a row in primary chain, last change first: block height and time, address which signed a change, SQL of the change and SQL to return the row to the state before it")
 	fmt.Println("  unapprovedtransactions [-clean]\n\t- Print the list of transactions not included in any block yet. If the option -clean provided then cleans the cache")
 
 	fmt.Println("=[Node server operations]")
-	fmt.Println("  startnode [-minter ADDRESS] [-host HOST] [-port PORT] [-proxykey ADDRESS] [-dbproxyaddr ADDR]\n\t- Start a node server. -minter defines minting address, -host - hostname of the node server , -port - listening port, -dbproxyaddr mysql proxy listening address `host:port`")
+	fmt.Println("  startnode [-minter ADDRESS] [-host HOST] [-port PORT] [-proxykey ADDRESS] [-dbproxyaddr ADDR] [-dbproxypool SIZE] [-dbproxypoolmax NUMBER] [-auditlog FILEPATH] [-localdiscovery] [-nat upnp|pmp|auto] [-outboundonly] [-requiretls] [-maxconnections NUMBER] [-httpapi HOST:PORT] [-finaldepth NUMBER] [-mysqlmaxopen NUMBER] [-mysqlmaxidle NUMBER]\n\t- Start a node server. -minter defines minting address, -host - hostname of the node server , -port - listening port, -dbproxyaddr mysql proxy listening address `host:port`, -dbproxypool number of MySQL connections kept for reuse, -dbproxypoolmax max number of MySQL connections of the proxy, -auditlog file to write log of queries passed through the proxy, -localdiscovery find other nodes of same blockchain in local network with multicast, -nat map the port on a router with UPnP or NAT-PMP, -outboundonly connect to other nodes but don't accept connections from them, -requiretls refuse plain connections from other hosts, -maxconnections number of connections served at same time, other connections get \"busy\" error, -httpapi address to serve HTTP JSON API, -finaldepth number of confirmations when a transaction is final, -mysqlmaxopen max number of node connections to MySQL, -mysqlmaxidle number of idle node connections to MySQL kept for reuse. Connections to other nodes use TLS unless \"Transport\": \"plain\" is set in config")
 	fmt.Println("  startintnode [-minter ADDRESS] [-port PORT] [-proxykey ADDRESS] [-dbproxyaddr ADDR]\n\t- Start a node server in interactive mode (no deamon). -minter defines minting address and -port - listening port")
 	fmt.Println("  stopnode\n\t- Stop runnning node")
 	fmt.Println("  nodestate\n\t- Print state of the node process")
 
-	fmt.Println("  shownodes\n\t- Display list of nodes addresses, including inactive")
-	fmt.Println("  addnode -nodehost HOST -nodeport PORT\n\t- Adds new node to list of connections")
+	fmt.Println("  shownodes\n\t- Display list of nodes addresses, including inactive, with pinned identities and identity of this node")
+	fmt.Println("  addnode -nodehost HOST -nodeport PORT [-nodeidentity IDENTITY]\n\t- Adds new node to list of connections. -nodeidentity pins identity of the node for TLS connections")
 	fmt.Println("  removenode -nodehost HOST -nodeport PORT\n\t- Removes a node from list of connections")
+	fmt.Println("  showbans\n\t- Display list of hosts banned for sending invalid data")
+	fmt.Println("  clearbans [-nodehost HOST]\n\t- Removes a ban of a host. Removes all bans if a host is not set")
 }
@@ -2,6 +2,13 @@ package config
 
 // File names
 const PidFileName = "server.pid"
+const NodeIdentityFileName = "nodeidentity.pem"
+
+// Transport for connections between nodes
+const (
+	TransportTLS   = "tls"
+	TransportPlain = "plain"
+)
 
 // other internal constant
 const Daemonprocesscommandline = "daemonnode"
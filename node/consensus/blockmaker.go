@@ -215,6 +215,11 @@ func (n *NodeBlockMaker) CompleteBlock() (*structures.Block, error) {
 
 	b.Hash = hash[:]
 	b.Nonce = nonce
+	// Merkle root is part of PoW data. it is saved in a block so clients can verify proofs
+	// against headers
+	if b.Version >= structures.BlockVersionMerkleRoot {
+		b.MerkleRoot = b.MakeMerkleRoot()
+	}
 
 	if config.MinimumBlockBuildingTime > 0 {
 		for t := time.Since(starttime).Seconds(); t < float64(config.MinimumBlockBuildingTime); t = time.Since(starttime).Seconds() {
@@ -260,6 +265,7 @@ func (n *NodeBlockMaker) makeNewBlockFromTransactions(transactions []structures.
 	if err != nil {
 		return nil, err
 	}
+	newblock.Version = n.config.GetBlockVersion(newblock.Height)
 
 	return &newblock, nil
 }
@@ -275,18 +281,29 @@ func (n *NodeBlockMaker) makeNewBlockFromTransactions(transactions []structures.
 // 4. all inputs must be in blockchain (correct unspent inputs)
 // 5. Additionally verify each transaction agains signatures, total amount, balance etc
 // 6. Verify hash is correc agains rules
+// 7. Version of a block is same as in consensus rules for its height, Merkle root is correct
 func (n *NodeBlockMaker) VerifyBlock(block *structures.Block, flags int) error {
+	// 7.
+	if block.Version != n.config.GetBlockVersion(block.Height) {
+		return NewBlockVerifyError(fmt.Sprintf("wrong version %d", block.Version), block.Hash)
+	}
+
+	err := block.VerifyMerkleRoot()
+
+	if err != nil {
+		return NewBlockVerifyError(err.Error(), block.Hash)
+	}
 	//6. Verify hash
 	pow := NewProofOfWork(block, n.config.Settings)
 
 	valid, err := pow.Validate()
 
 	if err != nil {
-		return err
+		return NewBlockVerifyError(err.Error(), block.Hash)
 	}
 
 	if !valid {
-		return errors.New("Block hash is not valid")
+		return NewBlockVerifyError("Block hash is not valid", block.Hash)
 	}
 	n.Logger.Trace.Println("Block hash verified")
 	// 2. check number of TX
@@ -299,11 +316,11 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block, flags int) error {
 	}
 
 	if txnum < min {
-		return errors.New("Number of transactions is too low")
+		return NewBlockVerifyError("Number of transactions is too low", block.Hash)
 	}
 
 	if txnum > max {
-		return errors.New("Number of transactions is too high")
+		return NewBlockVerifyError("Number of transactions is too high", block.Hash)
 	}
 
 	if flags&lib.TXFlagsSkipSQLBaseCheckIfNotOnTop > 0 {
@@ -329,7 +346,7 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block, flags int) error {
 
 		if tx.IsCoinbaseTransfer() {
 			if coinbaseused {
-				return errors.New("2 coin base TX in the block")
+				return NewBlockVerifyError("2 coin base TX in the block", block.Hash)
 			}
 			coinbaseused = true
 		}
@@ -341,6 +358,10 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block, flags int) error {
 
 		if err != nil {
 			n.Logger.Trace.Printf("tx verify error %x  %s", tx.GetID(), err.Error())
+
+			if _, ok := err.(*transactions.TXVerifyError); ok {
+				return NewBlockVerifyError(err.Error(), block.Hash)
+			}
 			return err
 		}
 
@@ -349,7 +370,7 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block, flags int) error {
 	}
 	// 1.
 	if !coinbaseused {
-		return errors.New("No coinbase TX in the block")
+		return NewBlockVerifyError("No coinbase TX in the block", block.Hash)
 	}
 	return nil
 }
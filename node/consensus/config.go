@@ -1,6 +1,7 @@
 package consensus
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -60,6 +61,8 @@ type ConsensusConfig struct {
 	TableRules             []ConsensusConfigTable
 	InitNodesAddreses      []string
 	PaidTransactionsWallet string
+	BlockVersion           int // version of new blocks. 0 is legacy blocks without Merkle root of transactions IDs
+	BlockVersionAfterBlock int // blocks after this height must have BlockVersion. Older blocks are legacy
 	state                  consensusConfigState
 }
 
@@ -87,6 +90,9 @@ func NewConfigDefault() (*ConsensusConfig, error) {
 	c.UnmanagedTables = []string{}
 	c.TableRules = []ConsensusConfigTable{}
 	c.InitNodesAddreses = []string{}
+	// nodes without consensus file must accept blocks of any existent chain, so only legacy blocks.
+	// Version 1 is enabled with BlockVersion and BlockVersionAfterBlock in a consensus file
+	c.BlockVersion = structures.BlockVersionLegacy
 
 	// make defauls PoW settings
 	s := ProofOfWorkSettings{}
@@ -249,6 +255,24 @@ func (cc ConsensusConfig) IsDefault() bool {
 	return cc.state.isDefault
 }
 
+// Returns hash of consensus rules. Nodes with different hashes can not work in one network.
+// Application info and initial nodes addresses are not rules, they are not included
+func (cc ConsensusConfig) GetHash() []byte {
+	rules := cc
+	rules.Application = ConsensusConfigApplication{}
+	rules.InitNodesAddreses = nil
+
+	jsondata, err := json.Marshal(rules)
+
+	if err != nil {
+		return []byte{}
+	}
+
+	hash := sha256.Sum256(jsondata)
+
+	return hash[:]
+}
+
 // Set config file path. this defines a path where a config file should be, even if it is not yet here
 func (cc *ConsensusConfig) SetConfigFilePath(fp string) {
 	cc.state.filePath = fp
@@ -367,6 +391,14 @@ func (cc *ConsensusConfig) ExtendRulesApplyStartHeigh(setHeigh int) {
 	}
 }
 
+// Returns version of a block with given height
+func (cc ConsensusConfig) GetBlockVersion(height int) int {
+	if height > cc.BlockVersionAfterBlock {
+		return cc.BlockVersion
+	}
+	return structures.BlockVersionLegacy
+}
+
 // Returns trus if a Const structure has any values more 0. False if no any payments required
 func (ccc ConsensusConfigCost) hasAnyNonDefaut() bool {
 	if ccc.ApplyAfterBlock > 0 {
@@ -0,0 +1,28 @@
+package consensus
+
+// Custom errors
+
+import (
+	"fmt"
+)
+
+// Block breaks consensus rules. Other errors of block verification are local problems, like DB errors,
+// and a node which sent the block is not guilty of them
+type BlockVerifyError struct {
+	err  string
+	Hash []byte
+}
+
+func (e *BlockVerifyError) Error() string {
+	return fmt.Sprintf("Block verify failed: %s, for block %x", e.err, e.Hash)
+}
+
+func NewBlockVerifyError(err string, hash []byte) error {
+	return &BlockVerifyError{err, hash}
+}
+
+// Check if an error is about a wrong block
+func IsBlockVerifyError(err error) bool {
+	_, ok := err.(*BlockVerifyError)
+	return ok
+}
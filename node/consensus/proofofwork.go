@@ -42,8 +42,6 @@ func NewProofOfWork(b *structures.Block, settings map[string]interface{}) *Proof
 
 	s.completeSettings()
 
-	target := big.NewInt(1)
-
 	var tb int
 
 	if b != nil && b.Height >= s.MaxMinNumberTransactionInBlock {
@@ -52,7 +50,7 @@ func NewProofOfWork(b *structures.Block, settings map[string]interface{}) *Proof
 		tb = s.Complexity
 	}
 
-	target.Lsh(target, uint(256-tb))
+	target := utils.MakeProofOfWorkTarget(tb)
 
 	pow := &ProofOfWork{}
 	pow.block = b
@@ -65,23 +63,17 @@ func NewProofOfWork(b *structures.Block, settings map[string]interface{}) *Proof
 // Prepares data for next iteration of PoW
 // this will be hashed
 func (pow *ProofOfWork) prepareData() ([]byte, error) {
-	txshash, err := pow.block.HashTransactions()
+	txshash, err := pow.block.GetTransactionsRoot()
 
 	if err != nil {
 		return nil, err
 	}
 
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PrevBlockHash,
-			txshash,
-			utils.IntToHex(pow.block.Timestamp),
-			utils.IntToHex(int64(pow.settings.Complexity)),
-		},
-		[]byte{},
-	)
-
-	return data, nil
+	return pow.joinData(pow.block.PrevBlockHash, txshash, pow.block.Timestamp, pow.block.Version), nil
+}
+
+func (pow *ProofOfWork) joinData(prevBlockHash []byte, txshash []byte, timestamp int64, version int) []byte {
+	return utils.MakeProofOfWorkData(prevBlockHash, txshash, timestamp, pow.settings.Complexity, version)
 }
 
 func (pow *ProofOfWork) addNonceToPrepared(data []byte, nonce int) []byte {
@@ -141,6 +133,27 @@ func (pow *ProofOfWork) Validate() (bool, error) {
 	return isValid, nil
 }
 
+// Checks PoW of a block header. Hash of the header must be same as calculated from header data
+func VerifyBlockHeader(h *structures.BlockHeader, settings map[string]interface{}) error {
+	var hashInt big.Int
+
+	pow := NewProofOfWork(&structures.Block{Height: h.Height}, settings)
+
+	data := pow.addNonceToPrepared(pow.joinData(h.PrevBlockHash, h.TransactionsHash, h.Timestamp, h.Version), h.Nonce)
+	hash := sha256.Sum256(data)
+
+	if !bytes.Equal(hash[:], h.Hash) {
+		return errors.New(fmt.Sprintf("Hash of block header %x doesn't match its data", h.Hash))
+	}
+
+	hashInt.SetBytes(hash[:])
+
+	if hashInt.Cmp(pow.target) != -1 {
+		return errors.New(fmt.Sprintf("Hash of block header %x is not valid", h.Hash))
+	}
+	return nil
+}
+
 //
 func (pow *ProofOfWork) GetTransactionLimitsPerBlock(h int) (min int, max int) {
 	min = h
@@ -12,6 +12,8 @@ type DatabaseConfig struct {
 	DbUser       string
 	DbPassword   string
 	TablesPrefix string
+	MaxOpenConns int // max number of connections of a node to MySQL. 0 means no limit
+	MaxIdleConns int // number of idle connections kept for reuse. 0 means default
 }
 
 func (dbc *DatabaseConfig) HasMinimum() bool {
@@ -10,18 +10,21 @@ import (
 
 const maxPossibleRowsToReturn = 1000000
 
+// Methods of a connection used to execute queries. sql.Tx has them too, so same code works in a snapshot
+type sqlQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 type MySQLDB struct {
-	db           *sql.DB
+	db           sqlQuerier
 	tablesPrefix string
 	Logger       *utils.LoggerMan
 }
 
-// close DB connection
+// release DB connection. The connection is shared by managers, so it is not closed
 func (bdb *MySQLDB) Close() error {
-	if bdb.db == nil {
-		return nil
-	}
-	bdb.db.Close()
 	bdb.db = nil
 
 	return nil
@@ -163,6 +166,12 @@ func (bdb *MySQLDB) CreateTable(table string, keytype string, valuetype string)
 	return err
 }
 
+// create key value table if it doesn't exist yet
+func (bdb *MySQLDB) CreateTableIfNotExists(table string, keytype string, valuetype string) error {
+	_, err := bdb.db.Exec("CREATE TABLE IF NOT EXISTS " + table + " ( k " + keytype + " PRIMARY KEY, v " + valuetype + " )")
+	return err
+}
+
 // encode bytes to string
 func (bdb *MySQLDB) encodeKey(k []byte) string {
 	return hex.EncodeToString(k)
@@ -22,6 +22,9 @@ type DBManager interface {
 	CloseConnection() error
 	IsConnectionOpen() bool
 
+	BeginSnapshot() error
+	EndSnapshot() error
+
 	GetBlockchainObject() (BlockchainInterface, error)
 	GetTransactionsObject() (TranactionsInterface, error)
 	GetUnapprovedTransactionsObject() (UnapprovedTransactionsInterface, error)
@@ -138,4 +141,12 @@ type NodesInterface interface {
 
 	PutNode(nodeID []byte, nodeData []byte) error
 	DeleteNode(nodeID []byte) error
+
+	ForEachBan(callback ForEachKeyIteratorInterface) error
+	PutBan(host []byte, banData []byte) error
+	DeleteBan(host []byte) error
+
+	ForEachAddress(callback ForEachKeyIteratorInterface) error
+	PutAddress(addr []byte, addrData []byte) error
+	DeleteAddress(addr []byte) error
 }
@@ -1,12 +1,14 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"database/sql"
 
@@ -23,12 +25,65 @@ const (
 	ClassNameUnspentOutputs         = "unspentoutputs"
 )
 
+// Returns names of tables where a node keeps blockchain data. Other tables of a DB are tables of an application
+func GetServiceTables(prefix string) []string {
+	tables := []string{blocksTable, blockChainTable, dataReferencesTable, nodesTable, nodesBansTable, nodesAddrBookTable,
+		transactionsTable, transactionsOutputsTable, unapprovedTransactionsTable, unspentTransactionsTable}
+
+	for i := range tables {
+		tables[i] = prefix + tables[i]
+	}
+	return tables
+}
+
+const defaultMaxIdleConns = 5
+
+// Connections to MySQL are shared by all managers with same connection string.
+// sql.DB keeps a pool of connections, so a manager created per request doesn't open new pool
+var (
+	sharedConns     = map[string]*sql.DB{}
+	sharedConnsLock sync.Mutex
+)
+
+// Returns shared connection pool for a config. It is created on first call
+func getSharedConnection(config DatabaseConfig) (*sql.DB, error) {
+	connStr := config.GetMySQLConnString()
+
+	sharedConnsLock.Lock()
+	defer sharedConnsLock.Unlock()
+
+	if db, ok := sharedConns[connStr]; ok {
+		return db, nil
+	}
+
+	db, err := sql.Open("mysql", connStr)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Can not open DB connection: %s", err.Error()))
+	}
+
+	if config.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(config.MaxIdleConns)
+	} else {
+		db.SetMaxIdleConns(defaultMaxIdleConns)
+	}
+
+	if config.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(config.MaxOpenConns)
+	}
+
+	sharedConns[connStr] = db
+
+	return db, nil
+}
+
 type MySQLDBManager struct {
 	Logger     *utils.LoggerMan
 	Config     DatabaseConfig
 	conn       *sql.DB
 	openedConn bool
 	SessID     string
+	snapshot   *sql.Tx // read only transaction. all queries go to it while it is started
 }
 
 func (bdm *MySQLDBManager) QM() DBQueryManager {
@@ -53,13 +108,13 @@ func (bdm *MySQLDBManager) CheckConnection() error {
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
-	_, err = conn.Query("SHOW TABLES")
+	rows, err := conn.Query("SHOW TABLES")
 
 	if err != nil {
 		return err
 	}
+	rows.Close()
 
 	return nil
 }
@@ -82,10 +137,8 @@ func (bdm *MySQLDBManager) CloseConnection() error {
 		return nil
 	}
 
-	if bdm.conn != nil {
-		bdm.conn.Close()
-		bdm.conn = nil
-	}
+	// the connection is shared with other managers, it stays open
+	bdm.conn = nil
 
 	bdm.openedConn = false
 	return nil
@@ -283,29 +336,71 @@ func (bdm *MySQLDBManager) GetNodesObject() (NodesInterface, error) {
 }
 
 // returns DB connection, creates it if needed .
-func (bdm *MySQLDBManager) getConnection() (*sql.DB, error) {
+func (bdm *MySQLDBManager) getConnection() (sqlQuerier, error) {
 
 	if !bdm.openedConn {
 		return nil, errors.New("Connection was not inited")
 	}
 
+	if bdm.snapshot != nil {
+		return bdm.snapshot, nil
+	}
+
 	if bdm.conn != nil {
 		return bdm.conn, nil
 	}
 
-	db, err := sql.Open("mysql", bdm.Config.GetMySQLConnString())
+	db, err := getSharedConnection(bdm.Config)
 
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Can not open DB connection: %s", err.Error()))
+		return nil, err
 	}
-	//db.SetMaxOpenConns(2)
-	db.SetMaxIdleConns(2)
 
 	bdm.conn = db
 
 	return db, nil
 }
 
+// Start read only transaction. Till the end of it all reads of the manager and of objects made by it
+// see the DB as it was at first read, changes done by the node in other connections are not visible.
+// Only InnoDB tables are in a snapshot
+func (bdm *MySQLDBManager) BeginSnapshot() error {
+	if !bdm.openedConn {
+		return errors.New("Connection was not inited")
+	}
+
+	if bdm.snapshot != nil {
+		return errors.New("Snapshot is already started")
+	}
+
+	db, err := getSharedConnection(bdm.Config)
+
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+
+	if err != nil {
+		return err
+	}
+	bdm.snapshot = tx
+
+	return nil
+}
+
+// End read only transaction. Next queries are executed in usual way
+func (bdm *MySQLDBManager) EndSnapshot() error {
+	if bdm.snapshot == nil {
+		return nil
+	}
+	err := bdm.snapshot.Rollback()
+
+	bdm.snapshot = nil
+
+	return err
+}
+
 func (bdm *MySQLDBManager) GetLockerObject() DatabaseLocker {
 	return nil
 }
@@ -314,11 +409,16 @@ func (bdm *MySQLDBManager) SetLockerObject(lockerobj DatabaseLocker) {
 }
 
 func (bdm *MySQLDBManager) Dump(file string) error {
-	conn, err := bdm.getConnection()
+	if !bdm.openedConn {
+		return errors.New("Connection was not inited")
+	}
+	// dumper closes a connection, so shared connection is not used
+	conn, err := sql.Open("mysql", bdm.Config.GetMySQLConnString())
 
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
 	// Register database with mysqldump
 	dumpDir, _ := filepath.Abs(filepath.Dir(file))
 	dumpFilename := filepath.Base(file)
@@ -350,6 +450,7 @@ func (bdm *MySQLDBManager) Restore(file string) error {
 	if err != nil {
 		return err
 	}
+	defer db.Close()
 
 	// load file to string
 	b, err := ioutil.ReadFile(file)
@@ -34,6 +34,12 @@ func (bdm mockMySQLDBManager) CloseConnection() error {
 func (bdm mockMySQLDBManager) IsConnectionOpen() bool {
 	return true
 }
+func (bdm mockMySQLDBManager) BeginSnapshot() error {
+	return nil
+}
+func (bdm mockMySQLDBManager) EndSnapshot() error {
+	return nil
+}
 func (bdm mockMySQLDBManager) InitDatabase() error {
 	return nil
 }
@@ -1,6 +1,8 @@
 package database
 
 const nodesTable = "nodes"
+const nodesBansTable = "nodesbans"
+const nodesAddrBookTable = "nodesaddrbook"
 
 type Nodes struct {
 	DB          *MySQLDB
@@ -17,7 +19,27 @@ func (ns *Nodes) getTableName() string {
 
 // Init new DB. Create table.
 func (ns *Nodes) InitDB() error {
-	return ns.DB.CreateTable(ns.getTableName(), "VARBINARY(100)", "VARBINARY(200)")
+	err := ns.DB.CreateTable(ns.getTableName(), "VARBINARY(100)", "VARBINARY(200)")
+
+	if err != nil {
+		return err
+	}
+	err = ns.initBansDB()
+
+	if err != nil {
+		return err
+	}
+	return ns.initAddrBookDB()
+}
+
+// Bans table is created also for databases inited before it was added
+func (ns *Nodes) initBansDB() error {
+	return ns.DB.CreateTableIfNotExists(ns.DB.tablesPrefix+nodesBansTable, "VARBINARY(100)", "VARBINARY(600)")
+}
+
+// Same for address book table
+func (ns *Nodes) initAddrBookDB() error {
+	return ns.DB.CreateTableIfNotExists(ns.DB.tablesPrefix+nodesAddrBookTable, "VARBINARY(300)", "VARBINARY(400)")
 }
 
 // retrns nodes list iterator
@@ -44,3 +66,75 @@ func (ns *Nodes) PutNode(nodeID []byte, nodeData []byte) error {
 func (ns *Nodes) DeleteNode(nodeID []byte) error {
 	return ns.DB.Delete(ns.getTableName(), nodeID)
 }
+
+// returns bans list iterator
+func (ns *Nodes) ForEachBan(callback ForEachKeyIteratorInterface) error {
+	err := ns.initBansDB()
+
+	if err != nil {
+		return err
+	}
+	return ns.DB.forEachInTable(ns.DB.tablesPrefix+nodesBansTable, callback)
+}
+
+// Save ban of a host
+func (ns *Nodes) PutBan(host []byte, banData []byte) error {
+	err := ns.initBansDB()
+
+	if err != nil {
+		return err
+	}
+
+	err = ns.DB.Delete(ns.DB.tablesPrefix+nodesBansTable, host)
+
+	if err != nil {
+		return err
+	}
+
+	return ns.DB.Put(ns.DB.tablesPrefix+nodesBansTable, host, banData)
+}
+
+func (ns *Nodes) DeleteBan(host []byte) error {
+	err := ns.initBansDB()
+
+	if err != nil {
+		return err
+	}
+	return ns.DB.Delete(ns.DB.tablesPrefix+nodesBansTable, host)
+}
+
+// returns address book iterator
+func (ns *Nodes) ForEachAddress(callback ForEachKeyIteratorInterface) error {
+	err := ns.initAddrBookDB()
+
+	if err != nil {
+		return err
+	}
+	return ns.DB.forEachInTable(ns.DB.tablesPrefix+nodesAddrBookTable, callback)
+}
+
+// Save address book record
+func (ns *Nodes) PutAddress(addr []byte, addrData []byte) error {
+	err := ns.initAddrBookDB()
+
+	if err != nil {
+		return err
+	}
+
+	err = ns.DB.Delete(ns.DB.tablesPrefix+nodesAddrBookTable, addr)
+
+	if err != nil {
+		return err
+	}
+
+	return ns.DB.Put(ns.DB.tablesPrefix+nodesAddrBookTable, addr, addrData)
+}
+
+func (ns *Nodes) DeleteAddress(addr []byte) error {
+	err := ns.initAddrBookDB()
+
+	if err != nil {
+		return err
+	}
+	return ns.DB.Delete(ns.DB.tablesPrefix+nodesAddrBookTable, addr)
+}
@@ -37,6 +37,7 @@ var commandsInteractiveMode = []string{
 	"importblockchain",
 	config.CommandRestoreBlockchain,
 	config.CommandDumpBlockchain,
+	"dbatheight",
 	"exportconsensusconfig",
 	"pullupdates",
 	"printchain",
@@ -53,12 +54,16 @@ var commandsInteractiveMode = []string{
 	"unapprovedtransactions",
 	"mineblock",
 	"canceltransaction",
+	"txstatus",
+	"rowhistory",
 	"dropblock",
 	"addrhistory",
 	"showunspent",
 	"shownodes",
 	"addnode",
-	"removenode"}
+	"removenode",
+	"showbans",
+	"clearbans"}
 
 var commandNodeManageMode = []string{
 	"interactiveautocreate",
@@ -141,6 +146,7 @@ func (c *NodeCLI) CreateNode() error {
 
 	node.Logger = c.Logger
 	node.MinterAddress = c.Input.MinterAddress
+	node.FinalDepth = c.Input.FinalDepth
 
 	var err error
 	// load consensus config
@@ -157,6 +163,17 @@ func (c *NodeCLI) CreateNode() error {
 
 	node.ConsensusConfig.SetConfigFilePath(c.Input.ConseususConfigFile)
 
+	node.RequireTLS = c.Input.RequireTLS
+
+	if c.Input.Transport == config.TransportTLS {
+		node.Identity, err = net.LoadNodeIdentity(c.ConfigDir + config.NodeIdentityFileName)
+
+		if err != nil {
+			c.Logger.Error.Printf("Error when load node identity %s", err.Error())
+			return err
+		}
+	}
+
 	node.Init()
 	node.InitNodes(c.Input.Nodes, false)
 
@@ -242,6 +259,9 @@ func (c NodeCLI) ExecuteCommand() error {
 	case config.CommandDumpBlockchain:
 		return c.commandDumpBlockchain()
 
+	case "dbatheight":
+		return c.commandDBAtHeight()
+
 	case "exportconsensusconfig":
 		return c.commandExportConsensusConfig()
 
@@ -290,6 +310,12 @@ func (c NodeCLI) ExecuteCommand() error {
 	case "canceltransaction":
 		return c.commandCancelTransaction()
 
+	case "txstatus":
+		return c.commandTransactionStatus()
+
+	case "rowhistory":
+		return c.commandRowHistory()
+
 	case "addrhistory":
 		return c.commandAddressHistory()
 
@@ -304,6 +330,12 @@ func (c NodeCLI) ExecuteCommand() error {
 
 	case "removenode":
 		return c.commandRemoveNode()
+
+	case "showbans":
+		return c.commandShowBans()
+
+	case "clearbans":
+		return c.commandClearBans()
 	}
 
 	return errors.New("Unknown management command")
@@ -327,6 +359,15 @@ func (c NodeCLI) createDaemonManager() (*server.NodeDaemon, error) {
 	nd.Node = c.Node
 	nd.DBProxyAddr = c.Input.DBProxyAddress
 	nd.DBAddr = c.Input.Database.GetServerAddress()
+	nd.AuditLog = c.Input.AuditLog
+	nd.DBProxyPool = c.Input.DBProxyPool
+	nd.LocalDiscovery = c.Input.LocalDiscovery
+	nd.NAT = c.Input.NAT
+	nd.OutboundOnly = c.Input.OutboundOnly
+	nd.RequireTLS = c.Input.RequireTLS
+	nd.MessageLimits = c.Input.MessageLimits
+	nd.RateLimits = c.Input.RateLimits
+	nd.HTTPAPI = c.Input.HTTPAPI
 	nd.Init()
 
 	return &nd, nil
@@ -420,8 +461,7 @@ func (c *NodeCLI) forwardCommandToWallet() error {
 // command to it (indtead of accessing database directly)
 func (c *NodeCLI) getLocalNetworkClient() nodeclient.NodeClient {
 	nc := *c.Node.NodeClient
-	nc.NodeAddress.Port = c.AlreadyRunningPort
-	nc.NodeAddress.Host = "localhost"
+	nc.SetNodeAddress(net.NewNodeAddr("localhost", c.AlreadyRunningPort))
 	return nc
 }
 
@@ -508,6 +548,37 @@ func (c *NodeCLI) commandDumpBlockchain() error {
 	return nil
 }
 
+// Makes tables as they were at a block height in a dump file or in new database. Live tables are not changed
+func (c *NodeCLI) commandDBAtHeight() error {
+	args := c.Input.Args
+
+	if args.Height < 0 {
+		return errors.New("Height of a block required")
+	}
+
+	if args.DumpFile == "" && args.DestinationDB == "" {
+		return errors.New("Dump file or destination database required")
+	}
+
+	if args.DumpFile != "" {
+		err := c.Node.DumpDBAtHeight(args.Height, args.Table, args.Method, args.DumpFile)
+
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Tables at height %d were dumped to %s\n", args.Height, args.DumpFile)
+		return nil
+	}
+
+	err := c.Node.RestoreDBAtHeight(args.Height, args.Table, args.Method, args.DestinationDB)
+
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Tables at height %d were restored to database %s\n", args.Height, args.DestinationDB)
+	return nil
+}
+
 // Pull updates from all other known nodes
 func (c *NodeCLI) commandPullUpdates() error {
 
@@ -788,6 +859,69 @@ func (c *NodeCLI) commandCancelTransaction() error {
 	return nil
 }
 
+// Shows if a transaction is pending, in a block or was canceled by switch to other branch
+func (c *NodeCLI) commandTransactionStatus() error {
+	txID, err := hex.DecodeString(c.Input.Args.Transaction)
+	if err != nil {
+		return err
+	}
+
+	status, err := c.Node.GetTransactionStatus(txID, 0)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Transaction %x\n", status.TXID)
+	fmt.Printf("State: %s\n", status.State)
+
+	if len(status.BlockHash) > 0 {
+		fmt.Printf("Block: %x, height %d\n", status.BlockHash, status.Height)
+		fmt.Printf("Confirmations: %d, final after %d\n", status.Confirmations, status.FinalDepth)
+	}
+
+	for _, hash := range status.SideBlocks {
+		fmt.Printf("In block of other branch: %x\n", hash)
+	}
+
+	if len(status.ConflictTX) > 0 {
+		fmt.Printf("Conflicts with transaction: %x\n", status.ConflictTX)
+	}
+
+	if status.Reason != "" {
+		fmt.Printf("Reason: %s\n", status.Reason)
+	}
+
+	return nil
+}
+
+// Shows all changes of a row in primary chain, last change first
+func (c *NodeCLI) commandRowHistory() error {
+	history, err := c.Node.GetTransactionsManager().GetRowHistory(c.Input.Args.Table, c.Input.Args.Key)
+
+	if err != nil {
+		return err
+	}
+
+	if len(history) == 0 {
+		fmt.Println("No changes of the row in the blockchain")
+		return nil
+	}
+
+	fmt.Printf("History of row %s:%s\n", c.Input.Args.Table, c.Input.Args.Key)
+
+	for _, change := range history {
+		fmt.Printf("%s in transaction %x\n", change.Kind, change.TXID)
+		fmt.Printf("  Block: %x, height %d, time %s\n", change.BlockHash, change.Height,
+			time.Unix(change.BlockTime, 0).Format("2006-01-02 15:04:05"))
+		fmt.Printf("  Signed by: %s\n", change.Signer)
+		fmt.Printf("  SQL: %s\n", change.Query)
+		fmt.Printf("  Rollback: %s\n", change.RollbackQuery)
+	}
+
+	return nil
+}
+
 // Drops last block from the top of blockchain
 func (c *NodeCLI) commandDropBlock() error {
 
@@ -856,6 +990,11 @@ func (c *NodeCLI) commandShowState(daemon *server.NodeDaemon) error {
 		fmt.Printf("  Loaded %d of %d blocks\n", info.BlocksNumber, info.ExpectingBlocksHeight+1)
 	}
 
+	if info.Syncing {
+		fmt.Printf("  Headers sync from %d nodes. Headers loaded to %d, blocks added to %d of %d\n",
+			info.SyncPeers, info.SyncHeadersHeight, info.SyncBlocksHeight, info.SyncTargetHeight)
+	}
+
 	fmt.Printf("  Number of unapproved transactions - %d\n", info.TransactionsCached)
 
 	fmt.Printf("  Number of unspent transactions outputs - %d\n", info.UnspentOutputs)
@@ -879,10 +1018,13 @@ func (c *NodeCLI) commandShowNodes() error {
 	} else {
 		nodes = c.Node.NodeNet.GetNodes()
 	}
+	if c.Node.Identity != nil {
+		fmt.Println("This node identity:", c.Node.Identity.ID)
+	}
 	fmt.Println("Nodes:")
 
 	for _, n := range nodes {
-		fmt.Println("  ", n.NodeAddrToString())
+		fmt.Println("  ", n.StringWithIdentity())
 
 	}
 
@@ -892,6 +1034,7 @@ func (c *NodeCLI) commandShowNodes() error {
 // Add a node to connections
 func (c *NodeCLI) commandAddNode() error {
 	newaddr := net.NewNodeAddr(c.Input.Args.NodeHost, c.Input.Args.NodePort)
+	newaddr.Identity = c.Input.Args.NodeIdentity
 
 	if c.AlreadyRunningPort > 0 {
 		nc := c.getLocalNetworkClient()
@@ -932,6 +1075,60 @@ func (c *NodeCLI) commandRemoveNode() error {
 	return nil
 }
 
+// Displays list of banned hosts
+func (c *NodeCLI) commandShowBans() error {
+	var bans []net.PeerBan
+	var err error
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+		bans, err = nc.SendGetBans()
+
+		if err != nil {
+			return err
+		}
+	} else {
+		bans = c.Node.NodeNet.GetPeerBans()
+	}
+
+	if len(bans) == 0 {
+		fmt.Println("No banned hosts")
+		return nil
+	}
+
+	fmt.Println("Banned hosts:")
+
+	for _, b := range bans {
+		fmt.Printf("  %s till %s. %s\n", b.Host, time.Unix(b.Until, 0).Format("2006-01-02 15:04:05"), b.Reason)
+	}
+
+	return nil
+}
+
+// Remove ban of a host or all bans if a host is not provided
+func (c *NodeCLI) commandClearBans() error {
+	host := c.Input.Args.NodeHost
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+
+		err := nc.SendClearBans(host)
+
+		if err != nil {
+			return err
+		}
+	} else {
+		err := c.Node.NodeNet.ClearPeerBans(host)
+
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("Success!")
+
+	return nil
+}
+
 // Execute new SQL command
 func (c *NodeCLI) commandSQL() error {
 	if c.AlreadyRunningPort > 0 {
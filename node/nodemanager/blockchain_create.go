@@ -145,7 +145,7 @@ func (n *makeBlockchain) InitBlockchainFromOther(addr net.NodeAddr, nodeclient *
 	err = n.addFirstBlock(block)
 
 	if err != nil {
-		return false, errors.New(fmt.Sprintf("Create DB abd add first block: %", err.Error()))
+		return false, errors.New(fmt.Sprintf("Create DB abd add first block: %s", err.Error()))
 	}
 
 	defer n.DBConn.CloseConnection()
@@ -382,7 +382,7 @@ func (n *makeBlockchain) addExistentTables(tables []string) (countTales int, cou
 
 //Make new initial block from prepared SQLs
 func (n *makeBlockchain) makeNewInitialBlock(sqls []string, nextBlockHeigh int) error {
-	n.Logger.Trace.Printf("DO next block with %d transactions in t")
+	n.Logger.Trace.Printf("DO next block with %d transactions in it", len(sqls))
 	n.consensusConfig.ExtendRulesApplyStartHeigh(nextBlockHeigh)
 
 	qm, err := n.getSQLQueryManager()
@@ -30,7 +30,7 @@ func (n communicationManager) sendVersionToNodes(nodes []net.NodeAddr, bestHeigh
 	}
 
 	for _, node := range nodes {
-		if node.CompareToAddress(n.node.NodeClient.NodeAddress) {
+		if node.CompareToAddress(n.node.NodeClient.GetNodeAddress()) {
 			continue
 		}
 		n.node.NodeClient.SendVersion(node, bestHeight)
@@ -55,7 +55,7 @@ func (n *communicationManager) sendTransactionToAllASync(tx *structures.Transact
 	n.logger.Trace.Printf("Send transaction to %d nodes in async mode", len(n.node.NodeNet.Nodes))
 
 	for i, node := range n.node.NodeNet.Nodes {
-		if node.CompareToAddress(n.node.NodeClient.NodeAddress) {
+		if node.CompareToAddress(n.node.NodeClient.GetNodeAddress()) {
 			continue
 		}
 		n.logger.Trace.Printf("Send TX %x to %s", tx.GetID(), node.NodeAddrToString())
@@ -78,7 +78,13 @@ func (n *communicationManager) sendTransactionToAllSync(tx *structures.Transacti
 	}
 
 	for i, node := range n.node.NodeNet.Nodes {
-		if node.CompareToAddress(n.node.NodeClient.NodeAddress) {
+		if node.CompareToAddress(n.node.NodeClient.GetNodeAddress()) {
+			continue
+		}
+		if n.node.NodeNet.GetPeerConnection(node) != nil {
+			// the node can request the body over same connection
+			err := n.node.NodeClient.SendInv(node, "tx", [][]byte{tx.GetID()})
+			n.node.NodeNet.HookNeworkOperationResult(err, i)
 			continue
 		}
 		n.logger.Trace.Printf("Send TX %x to %s", tx.GetID(), node.NodeAddrToString())
@@ -115,7 +121,7 @@ func (n *communicationManager) SendBlockToAllASync(newBlock *structures.Block, s
 	}
 
 	for i, node := range n.node.NodeNet.Nodes {
-		if node.CompareToAddress(n.node.NodeClient.NodeAddress) {
+		if node.CompareToAddress(n.node.NodeClient.GetNodeAddress()) {
 			continue
 		}
 
@@ -138,7 +144,13 @@ func (n *communicationManager) SendBlockToAllSync(newBlock *structures.Block, sk
 	}
 
 	for i, node := range n.node.NodeNet.Nodes {
-		if node.CompareToAddress(n.node.NodeClient.NodeAddress) {
+		if node.CompareToAddress(n.node.NodeClient.GetNodeAddress()) {
+			continue
+		}
+		if n.node.NodeNet.GetPeerConnection(node) != nil {
+			// the node can request the body over same connection
+			errc := n.node.NodeClient.SendInv(node, "block", [][]byte{blockshortdata})
+			n.node.NodeNet.HookNeworkOperationResult(errc, i)
 			continue
 		}
 		result, err := n.node.NodeClient.SendCheckBlock(node, blockshortdata)
@@ -182,7 +194,7 @@ func (n *communicationManager) CheckForChangesOnOtherNodes(lastCheckTime int64)
 
 	for _, node := range nodes {
 		n.logger.TraceExt.Printf("Check node %s", node.NodeAddrToString())
-		if node.CompareToAddress(n.node.NodeClient.NodeAddress) {
+		if node.CompareToAddress(n.node.NodeClient.GetNodeAddress()) {
 			continue
 		}
 
@@ -322,6 +334,7 @@ func (n *communicationManager) processTransactionsFromPoolOnOtherNode(node *net.
 
 		if err == nil {
 			addedTransaction = append(addedTransaction, tx.GetID())
+			n.node.publishNewTransaction(tx)
 		}
 	}
 	return addedTransaction, nil
@@ -337,16 +350,26 @@ func (n *communicationManager) processNodesFromPoolOnOtherNode(node *net.NodeAdd
 	}
 
 	added := []net.NodeAddr{}
+	timed := []net.TimedNodeAddr{}
 
 	for _, ns := range nodes {
 		addr := net.NodeAddr{}
 		addr.Port = ns.Port
 		addr.Host = string(ns.Host)
 
+		timed = append(timed, net.TimedNodeAddr{Host: addr.Host, Port: addr.Port})
+
+		// if there are enough known nodes, the address stays in the address book only
+		if n.node.NodeNet.GetCountOfKnownNodes() >= net.DiscoveryTargetNodes {
+			continue
+		}
+
 		if n.node.checkAddressKnown(addr, false) {
 			added = append(added, addr)
 		}
 	}
+	n.node.NodeNet.AddAddresses(timed, node.Host)
+
 	return added, nil
 }
 
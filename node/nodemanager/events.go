@@ -0,0 +1,239 @@
+package nodemanager
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/dbquery/sqlparser"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Events of a node. Applications subscribe to know about new blocks and transactions
+// without polling. A subscription can be filtered by kind of event, table and address
+const (
+	EventBlockAdded   = "blockadded"   // a block is added to the top of primary chain
+	EventBlockRemoved = "blockremoved" // a block is removed from primary chain, other branch became primary
+	EventTXNew        = "txnew"        // a transaction is added to the pool
+	EventTXConfirmed  = "txconfirmed"  // a transaction is in a block added to primary chain
+	EventTXCanceled   = "txcanceled"   // a block with a transaction is removed from primary chain
+
+	DefaultEventsBuffer = 100
+)
+
+type Event struct {
+	Kind      string
+	BlockHash []byte
+	Height    int
+	TXID      []byte
+	Table     string // for SQL transactions
+	RefID     string
+	SQLKind   string   // insert, update, delete, create, drop
+	Addresses []string // author of a transaction and receivers of coins
+	Time      time.Time
+}
+
+// Empty list means any value
+type EventFilter struct {
+	Kinds     []string
+	Tables    []string
+	Addresses []string
+}
+
+type EventSubscription struct {
+	C      chan Event
+	id     int
+	filter EventFilter
+	bus    *EventBus
+}
+
+type EventBus struct {
+	lock          sync.RWMutex
+	subscriptions map[int]*EventSubscription
+	lastID        int
+}
+
+func NewEventBus() *EventBus {
+	b := EventBus{}
+	b.subscriptions = map[int]*EventSubscription{}
+	return &b
+}
+
+// Check if an event is passed by a filter. Block events have no table and addresses,
+// they are filtered only by kind
+func (f EventFilter) Match(e Event) bool {
+	if len(f.Kinds) > 0 && !stringInList(e.Kind, f.Kinds) {
+		return false
+	}
+
+	isBlockEvent := e.Kind == EventBlockAdded || e.Kind == EventBlockRemoved
+
+	if isBlockEvent {
+		return true
+	}
+
+	if len(f.Tables) > 0 && !stringInList(e.Table, f.Tables) {
+		return false
+	}
+
+	if len(f.Addresses) > 0 {
+		for _, a := range e.Addresses {
+			if stringInList(a, f.Addresses) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func stringInList(s string, list []string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe for events. buffer is size of a channel, events are dropped when a subscriber
+// doesn't read them fast enough
+func (b *EventBus) Subscribe(filter EventFilter, buffer int) *EventSubscription {
+	if buffer <= 0 {
+		buffer = DefaultEventsBuffer
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.lastID++
+
+	s := &EventSubscription{}
+	s.C = make(chan Event, buffer)
+	s.id = b.lastID
+	s.filter = filter
+	s.bus = b
+
+	b.subscriptions[s.id] = s
+
+	return s
+}
+
+// Stop receiving events. The channel is closed
+func (s *EventSubscription) Close() {
+	s.bus.lock.Lock()
+	defer s.bus.lock.Unlock()
+
+	if _, ok := s.bus.subscriptions[s.id]; !ok {
+		return
+	}
+	delete(s.bus.subscriptions, s.id)
+	close(s.C)
+}
+
+// Send an event to all subscribers. It never blocks
+func (b *EventBus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for _, s := range b.subscriptions {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.C <- e:
+		default:
+		}
+	}
+}
+
+// Returns number of active subscriptions
+func (b *EventBus) CountSubscriptions() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return len(b.subscriptions)
+}
+
+// Make event for a transaction. SQL query is parsed to know a table and kind of a query
+func newTransactionEvent(kind string, tx *structures.Transaction, block *structures.Block) Event {
+	e := Event{}
+	e.Kind = kind
+	e.TXID = tx.GetID()
+	e.Addresses = []string{}
+
+	if block != nil {
+		e.BlockHash = block.Hash
+		e.Height = block.Height
+	}
+
+	if len(tx.ByPubKey) > 0 {
+		if address, err := utils.PubKeyToAddres(tx.ByPubKey); err == nil {
+			e.Addresses = append(e.Addresses, address)
+		}
+	}
+
+	for _, out := range tx.Vout {
+		address, err := utils.PubKeyHashToAddres(out.PubKeyHash)
+
+		if err == nil && !stringInList(address, e.Addresses) {
+			e.Addresses = append(e.Addresses, address)
+		}
+	}
+
+	if tx.IsSQLCommand() {
+		e.RefID = string(tx.SQLCommand.ReferenceID)
+		e.Table, e.SQLKind = GetSQLTableAndKind(tx)
+	}
+	return e
+}
+
+// Returns a table and a kind of query of SQL transaction. Empty strings if a query can not be parsed
+func GetSQLTableAndKind(tx *structures.Transaction) (table string, kind string) {
+	parser := sqlparser.NewSqlParser()
+
+	if err := parser.Parse(tx.GetSQLQuery()); err != nil {
+		return
+	}
+	return parser.GetTable(), parser.GetKind()
+}
+
+func newBlockEvent(kind string, block *structures.Block) Event {
+	return Event{Kind: kind, BlockHash: block.Hash, Height: block.Height}
+}
+
+// Subscribe for events of this node
+func (n *Node) SubscribeEvents(filter EventFilter) *EventSubscription {
+	return n.Events.Subscribe(filter, DefaultEventsBuffer)
+}
+
+// Events for a block and all transactions in it. txKind is empty if transactions events are not needed
+func (n *Node) publishBlockEvents(kind string, txKind string, block *structures.Block) {
+	if n.Events == nil || n.Events.CountSubscriptions() == 0 {
+		return
+	}
+	n.Events.Publish(newBlockEvent(kind, block))
+
+	if txKind == "" {
+		return
+	}
+
+	for _, tx := range block.Transactions {
+		n.Events.Publish(newTransactionEvent(txKind, &tx, block))
+	}
+}
+
+func (n *Node) publishNewTransaction(tx *structures.Transaction) {
+	if n.Events == nil || n.Events.CountSubscriptions() == 0 {
+		return
+	}
+	n.Events.Publish(newTransactionEvent(EventTXNew, tx, nil))
+}
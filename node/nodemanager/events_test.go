@@ -0,0 +1,63 @@
+package nodemanager
+
+import (
+	"testing"
+
+	"github.com/gelembjuk/oursql/node/structures"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventFilter(t *testing.T) {
+	tx := &structures.Transaction{}
+	tx.ID = []byte{1}
+	tx.SQLCommand.Query = []byte("UPDATE members SET name='a' WHERE id=1")
+	tx.SQLCommand.ReferenceID = []byte("members:id:1")
+
+	e := newTransactionEvent(EventTXNew, tx, nil)
+
+	assert.Equal(t, "members", e.Table)
+	assert.Equal(t, "update", e.SQLKind)
+	assert.Equal(t, "members:id:1", e.RefID)
+
+	assert.True(t, EventFilter{}.Match(e))
+	assert.True(t, EventFilter{Tables: []string{"Members"}}.Match(e))
+	assert.False(t, EventFilter{Tables: []string{"orders"}}.Match(e))
+	assert.False(t, EventFilter{Kinds: []string{EventTXConfirmed}}.Match(e))
+	assert.False(t, EventFilter{Addresses: []string{"addr"}}.Match(e))
+
+	e.Addresses = []string{"addr"}
+	assert.True(t, EventFilter{Addresses: []string{"addr"}}.Match(e))
+
+	// block events are passed by table and address filters
+	block := Event{Kind: EventBlockAdded, Height: 5}
+	assert.True(t, EventFilter{Tables: []string{"orders"}}.Match(block))
+	assert.False(t, EventFilter{Kinds: []string{EventTXNew}}.Match(block))
+}
+
+func TestEventBus(t *testing.T) {
+	b := NewEventBus()
+
+	all := b.Subscribe(EventFilter{}, 2)
+	blocks := b.Subscribe(EventFilter{Kinds: []string{EventBlockAdded}}, 2)
+
+	b.Publish(Event{Kind: EventTXNew})
+	b.Publish(Event{Kind: EventBlockAdded, Height: 1})
+	// buffer is full, the event is dropped for the first subscription
+	b.Publish(Event{Kind: EventBlockAdded, Height: 2})
+
+	assert.Equal(t, EventTXNew, (<-all.C).Kind)
+	e := <-all.C
+	assert.Equal(t, 1, e.Height)
+	assert.False(t, e.Time.IsZero())
+	assert.Len(t, all.C, 0)
+
+	assert.Equal(t, 1, (<-blocks.C).Height)
+	assert.Equal(t, 2, (<-blocks.C).Height)
+
+	all.Close()
+	all.Close()
+
+	_, ok := <-all.C
+	assert.False(t, ok)
+	assert.Equal(t, 1, b.CountSubscriptions())
+}
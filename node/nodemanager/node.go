@@ -32,10 +32,18 @@ type Node struct {
 	ProxyPrivateKey ecdsa.PrivateKey
 
 	OtherNodes []net.NodeAddr
+	// confirmations needed for a transaction to be final. 0 means default depth
+	FinalDepth int
+	// identity for encrypted connections with other nodes. nil means plain connections
+	Identity *net.NodeIdentity
+	// don't fall back to plain connections to nodes which don't support TLS
+	RequireTLS bool
 
 	SessionID       string
 	locks           *NodeLocks
 	ConsensusConfig *consensus.ConsensusConfig
+	// subscriptions for new blocks and transactions
+	Events *EventBus
 }
 type NodeLocks struct {
 	blockAddLock        *sync.Mutex
@@ -70,6 +78,8 @@ func (n *Node) Init() {
 	n.locks = &NodeLocks{}
 	n.locks.InitLocks()
 
+	n.Events = NewEventBus()
+
 	rand.Seed(time.Now().UTC().UnixNano())
 }
 
@@ -82,6 +92,8 @@ func (orignode *Node) Clone() *Node {
 	node.MinterAddress = orignode.MinterAddress
 	node.ProxyPubKey = orignode.ProxyPubKey
 	node.ProxyPrivateKey = orignode.ProxyPrivateKey
+	node.Identity = orignode.Identity
+	node.RequireTLS = orignode.RequireTLS
 	// clone DB object
 	ndb := orignode.DBConn.Clone()
 	node.DBConn = &ndb
@@ -91,9 +103,17 @@ func (orignode *Node) Clone() *Node {
 
 	node.Init()
 
-	node.NodeClient.SetNodeAddress(orignode.NodeClient.NodeAddress)
+	node.NodeClient.SetNodeAddress(orignode.NodeClient.GetNodeAddress())
+	node.NodeClient.SetChainIdentity(orignode.NodeClient.Genesis, orignode.NodeClient.Consensus)
 
 	node.InitNodes(orignode.NodeNet.Nodes, true) // set list of nodes and skip loading default if this is empty list
+	// scores, bans, persistent connections, address book and address votes are same for all clones
+	node.NodeNet.Peers = orignode.NodeNet.Peers
+	node.NodeNet.Conns = orignode.NodeNet.Conns
+	node.NodeNet.Book = orignode.NodeNet.Book
+	node.NodeNet.Votes = orignode.NodeNet.Votes
+	// events from all clones go to same subscribers
+	node.Events = orignode.Events
 
 	return &node
 }
@@ -109,6 +129,14 @@ func (n *Node) GetTransactionsManager() transactions.TransactionsManagerInterfac
 	return transactions.NewManager(n.DBConn.DB(), n.Logger, n.ConsensusConfig.GetInfoForTransactions())
 }
 
+// Returns state of a transaction. If finalDepth is 0, final depth from a node config is used
+func (n *Node) GetTransactionStatus(txID []byte, finalDepth int) (*structures.TransactionStatus, error) {
+	if finalDepth < 1 {
+		finalDepth = n.FinalDepth
+	}
+	return n.GetTransactionsManager().GetTransactionStatus(txID, finalDepth)
+}
+
 // Build BC manager structure
 func (n *Node) GetBCManager() (*blockchain.Blockchain, error) {
 	return blockchain.NewBlockchainManager(n.DBConn.DB(), n.Logger)
@@ -160,12 +188,32 @@ func (n *Node) InitClient() error {
 
 	client.Logger = n.Logger
 	client.NodeNet = &n.NodeNet
+	client.Identity = n.Identity
+	client.RequireTLS = n.RequireTLS
 
 	n.NodeClient = &client
 
 	return nil
 }
 
+// Set genesis hash and consensus rules hash to send them to other nodes in version.
+// Blockchain must exist
+func (n *Node) InitChainIdentity() error {
+	if n.DBConn.OpenConnectionIfNeeded("ChainIdentity", n.SessionID) {
+		defer n.DBConn.CloseConnection()
+	}
+
+	genesis, err := n.NodeBC.GetBCManager().GetGenesisBlockHash()
+
+	if err != nil {
+		return err
+	}
+
+	n.NodeClient.SetChainIdentity(genesis, n.ConsensusConfig.GetHash())
+
+	return nil
+}
+
 // Load list of other nodes addresses
 func (n *Node) InitNodes(list []net.NodeAddr, force bool) error {
 	if n.DBConn.OpenConnectionIfNeeded("CheckNodesAndGenesis", n.SessionID) {
@@ -179,6 +227,12 @@ func (n *Node) InitNodes(list []net.NodeAddr, force bool) error {
 	} else {
 		n.NodeNet.SetNodes(list, true)
 	}
+
+	if !force {
+		// clones share bans and address book with original node
+		n.NodeNet.LoadBans()
+		n.NodeNet.LoadAddressBook()
+	}
 	return nil
 }
 
@@ -261,6 +315,11 @@ func (n *Node) AddNodeToKnown(addr net.NodeAddr, sendversion bool) {
 
 	added := n.checkAddressKnown(addr, false)
 
+	if addr.Identity != "" {
+		// identity set by an operator replaces pinned one
+		n.NodeNet.SetNodeIdentity(addr, addr.Identity)
+	}
+
 	if added && sendversion {
 		n.Logger.Trace.Printf("Added node %s\n", addr.NodeAddrToString())
 		// end version to this node
@@ -284,6 +343,33 @@ func (n *Node) SendVersionToNodes(nodes []net.NodeAddr) {
 	n.GetCommunicationManager().sendVersionToNodes(nodes, bestHeight)
 }
 
+// Try to connect to a node found with discovery. If it responds, it is added to known nodes.
+// Result is remembered in the address book
+func (n *Node) TryAddressFromBook(addr net.NodeAddr) error {
+	opened := n.DBConn.OpenConnectionIfNeeded("GetHeigh", n.SessionID)
+	bestHeight, err := n.NodeBC.GetBestHeight()
+
+	if opened {
+		n.DBConn.CloseConnection()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = n.NodeClient.SendVersion(addr, bestHeight)
+
+	if err != nil {
+		n.NodeNet.MarkAddressAttempt(addr)
+		return err
+	}
+
+	n.NodeNet.MarkAddressGood(addr)
+	n.checkAddressKnown(addr, false)
+
+	return nil
+}
+
 // Check if the address is known . If not then add to known
 // and send list of all addresses to that node
 
@@ -297,13 +383,18 @@ func (n *Node) CheckAddressKnown(addr net.NodeAddr) bool {
 func (n *Node) checkAddressKnown(addr net.NodeAddr, afterinputconnect bool) bool {
 	added := false
 
+	if addr.Host == "" {
+		// request from a client which is not a node
+		return false
+	}
+
 	n.Logger.Trace.Printf("Check node is known %s", addr.NodeAddrToString())
 
 	if !n.NodeNet.CheckIsKnown(addr) &&
-		!addr.CompareToAddress(n.NodeClient.NodeAddress) {
+		!addr.CompareToAddress(n.NodeClient.GetNodeAddress()) {
 		// send him all addresses
 		n.Logger.Trace.Printf("Adding to known to %s", addr.NodeAddrToString())
-		n.NodeClient.SendAddrList(addr, n.NodeNet.Nodes)
+		n.NodeClient.SendAddrList(addr, n.NodeNet.GetNodesToShare())
 
 		n.NodeNet.AddNodeToKnown(addr)
 
@@ -336,6 +427,8 @@ func (n *Node) Send(PubKey []byte, privKey ecdsa.PrivateKey, to string, amount f
 	if err != nil {
 		return nil, err
 	}
+	n.publishNewTransaction(tx)
+
 	n.GetCommunicationManager().sendTransactionToAll(tx)
 
 	return tx.GetID(), nil
@@ -356,6 +449,8 @@ func (n *Node) SQLTransaction(PubKey []byte, privKey ecdsa.PrivateKey, sqlcomman
 		return nil, err
 	}
 	if tx != nil {
+		n.publishNewTransaction(tx)
+
 		n.GetCommunicationManager().sendTransactionToAll(tx)
 
 		return tx.GetID(), nil
@@ -487,6 +582,10 @@ func (n *Node) AddBlock(block *structures.Block) (uint, error) {
 		n.GetTransactionsManager().BlockAdded(block, addstate == blockchain.BCBAddState_addedToTop)
 	}
 
+	if addstate == blockchain.BCBAddState_addedToTop {
+		n.publishBlockEvents(EventBlockAdded, EventTXConfirmed, block)
+	}
+
 	if addstate == blockchain.BCBAddState_addedToParallelTop {
 		// get 2 blocks branches that replaced each other
 		newChain, oldChain, err := n.NodeBC.GetBranchesReplacement(curLastHash, []byte{})
@@ -507,6 +606,7 @@ func (n *Node) AddBlock(block *structures.Block) (uint, error) {
 
 					return 0, err
 				}
+				n.publishBlockEvents(EventBlockRemoved, EventTXCanceled, block)
 				// blocks are from reversed order
 				txFromOld = append(block.Transactions, txFromOld...)
 			}
@@ -518,6 +618,7 @@ func (n *Node) AddBlock(block *structures.Block) (uint, error) {
 
 					return 0, err
 				}
+				n.publishBlockEvents(EventBlockAdded, EventTXConfirmed, block)
 			}
 
 			// add TXs from canceled back to pool . some of them can fails, this is normal
@@ -553,6 +654,8 @@ func (n *Node) DropBlock() error {
 
 	n.GetTransactionsManager().BlockRemoved(block)
 
+	n.publishBlockEvents(EventBlockRemoved, EventTXCanceled, block)
+
 	return nil
 }
 
@@ -592,7 +695,7 @@ func (n *Node) ReceivedFullBlockFromOtherNode(blockdata []byte) (int, uint, *str
 	block, err := structures.NewBlockFromBytes(blockdata)
 
 	if err != nil {
-		return -1, addstate, nil, err
+		return -1, addstate, nil, consensus.NewBlockVerifyError(err.Error(), nil)
 	}
 	// lock this process to prevent conflicts
 	n.locks.transactionsExecute.Lock()
@@ -624,7 +727,14 @@ func (n *Node) ReceivedFullBlockFromOtherNode(blockdata []byte) (int, uint, *str
 
 // Received new transaction . This must verify and if all ok it adds to the pool
 func (n *Node) ReceivedNewTransaction(tx *structures.Transaction, flags int) error {
-	return n.getBlockMakeManager().AddTransactionToPool(tx, flags)
+	err := n.getBlockMakeManager().AddTransactionToPool(tx, flags)
+
+	if err != nil {
+		return err
+	}
+	n.publishNewTransaction(tx)
+
+	return nil
 }
 
 // New transactions created. It is received in serialysed view and signatures separately
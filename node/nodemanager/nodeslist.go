@@ -44,10 +44,9 @@ func (s NodesListStorage) AddNodeToKnown(addr net.NodeAddr) {
 		s.DBConn.Logger.Trace.Printf("err %s", err.Error())
 		return
 	}
-	address := addr.NodeAddrToString()
-	key := []byte(address)
+	key := []byte(addr.NodeAddrToString())
 
-	nddb.PutNode(key, key)
+	nddb.PutNode(key, []byte(addr.StringWithIdentity()))
 
 	return
 }
@@ -77,3 +76,111 @@ func (s NodesListStorage) GetCountOfKnownNodes() (int, error) {
 
 	return nddb.GetCount()
 }
+func (s NodesListStorage) GetBans() ([]net.PeerBan, error) {
+	nddb, err := s.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	bans := []net.PeerBan{}
+
+	err = nddb.ForEachBan(func(k, v []byte) error {
+		ban := net.PeerBan{}
+
+		if err := ban.LoadFromString(string(k), string(v)); err != nil {
+			s.DBConn.Logger.Trace.Printf("Wrong ban record for %s: %s", string(k), err.Error())
+			return nil
+		}
+
+		bans = append(bans, ban)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bans, nil
+}
+func (s NodesListStorage) AddBan(ban net.PeerBan) {
+	if !s.DBConn.CheckConnectionIsOpen() {
+		defer s.DBConn.CloseConnection()
+	}
+
+	nddb, err := s.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		s.DBConn.Logger.Trace.Printf("err %s", err.Error())
+		return
+	}
+
+	nddb.PutBan([]byte(ban.Host), []byte(ban.String()))
+}
+func (s NodesListStorage) RemoveBan(host string) {
+	if !s.DBConn.CheckConnectionIsOpen() {
+		defer s.DBConn.CloseConnection()
+	}
+
+	nddb, err := s.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return
+	}
+
+	nddb.DeleteBan([]byte(host))
+}
+func (s NodesListStorage) GetAddresses() ([]net.KnownAddress, error) {
+	nddb, err := s.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	list := []net.KnownAddress{}
+
+	err = nddb.ForEachAddress(func(k, v []byte) error {
+		addr := net.KnownAddress{}
+
+		if err := addr.LoadFromString(string(k), string(v)); err != nil {
+			s.DBConn.Logger.Trace.Printf("Wrong address book record for %s: %s", string(k), err.Error())
+			return nil
+		}
+
+		list = append(list, addr)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+func (s NodesListStorage) SaveAddress(addr net.KnownAddress) {
+	if !s.DBConn.CheckConnectionIsOpen() {
+		defer s.DBConn.CloseConnection()
+	}
+
+	nddb, err := s.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		s.DBConn.Logger.Trace.Printf("err %s", err.Error())
+		return
+	}
+
+	nddb.PutAddress([]byte(addr.Addr.NodeAddrToString()), []byte(addr.String()))
+}
+func (s NodesListStorage) RemoveAddress(addr net.NodeAddr) {
+	if !s.DBConn.CheckConnectionIsOpen() {
+		defer s.DBConn.CloseConnection()
+	}
+
+	nddb, err := s.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return
+	}
+
+	nddb.DeleteAddress([]byte(addr.NodeAddrToString()))
+}
@@ -0,0 +1,183 @@
+package nodemanager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gelembjuk/oursql/node/database"
+)
+
+// Ways to get tables as they were at some block height
+const (
+	PointInTimeReplay   = "replay"   // execute queries of all transactions from genesis block to the height
+	PointInTimeRollback = "rollback" // copy current tables and execute rollback queries from the top down to the height
+)
+
+// Returns tables of an application in the DB. Tables of blockchain data and unmanaged tables are skipped
+func (n *Node) getManagedTables() ([]string, error) {
+	tables, err := n.DBConn.GetAllTables()
+
+	if err != nil {
+		return nil, err
+	}
+
+	skip := map[string]bool{}
+
+	for _, t := range database.GetServiceTables(n.DBConn.Config.TablesPrefix) {
+		skip[t] = true
+	}
+
+	for _, t := range n.ConsensusConfig.UnmanagedTables {
+		skip[t] = true
+	}
+
+	managed := []string{}
+
+	for _, t := range tables {
+		if !skip[t] {
+			managed = append(managed, t)
+		}
+	}
+	return managed, nil
+}
+
+// Passes SQL queries which make tables as they were at given height, in order of execution.
+// If table is empty, all managed tables are included. A live DB is only read.
+// All is read in one snapshot, so blocks and queries added by a running node meanwhile are not mixed in
+func (n *Node) ForEachQueryAtHeight(height int, table string, method string, callback func(sql string) error) error {
+	if method != "" && method != PointInTimeReplay && method != PointInTimeRollback {
+		return errors.New(fmt.Sprintf("Unknown method %s. Use %s or %s", method, PointInTimeReplay, PointInTimeRollback))
+	}
+
+	err := n.DBConn.DB().BeginSnapshot()
+
+	if err != nil {
+		return err
+	}
+	defer n.DBConn.DB().EndSnapshot()
+
+	tm := n.GetTransactionsManager()
+
+	if method == "" || method == PointInTimeReplay {
+		return tm.ForEachQueryTillHeight(height, table, callback)
+	}
+
+	tables := []string{table}
+
+	if table == "" {
+		var err error
+		tables, err = n.getManagedTables()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// rollbacks are checked first. a query error must not leave a half of a dump
+	rollbacks := []string{}
+
+	err = tm.ForEachRollbackAfterHeight(height, table, func(sql string) error {
+		rollbacks = append(rollbacks, sql)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tables {
+		sqls, err := n.DBConn.DB().QM().ExecuteSQLTableDump(t, 0, 0)
+
+		if err != nil {
+			return err
+		}
+
+		for _, sql := range sqls {
+			err = callback(sql)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, sql := range rollbacks {
+		err = callback(sql)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes SQL queries which make tables as they were at given height to a file
+func (n *Node) DumpDBAtHeight(height int, table string, method string, file string) error {
+	f, err := os.Create(file)
+
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	err = n.ForEachQueryAtHeight(height, table, method, func(sql string) error {
+		_, err := w.WriteString(strings.TrimRight(sql, "; \n") + ";\n")
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Creates new database on same MySQL server and makes tables there as they were at given height.
+// The database must not exist. It is dropped if tables can not be made
+func (n *Node) RestoreDBAtHeight(height int, table string, method string, dbName string) error {
+	if dbName == "" || dbName == n.DBConn.Config.DatabaseName {
+		return errors.New("Destination database must be different from the database of a node")
+	}
+
+	if strings.Contains(dbName, "`") {
+		return errors.New("Wrong destination database name")
+	}
+
+	err := n.DBConn.DB().QM().ExecuteSQL("CREATE DATABASE `" + dbName + "`")
+
+	if err != nil {
+		return err
+	}
+
+	config := n.DBConn.Config
+	config.DatabaseName = dbName
+
+	dest := &database.MySQLDBManager{}
+	dest.SetLogger(n.Logger)
+	dest.SetConfig(config)
+	dest.OpenConnection()
+
+	defer dest.CloseConnection()
+
+	err = n.ForEachQueryAtHeight(height, table, method, func(sql string) error {
+		err := dest.ExecuteSQL(sql)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Query failed in %s: %s. %s", dbName, sql, err.Error()))
+		}
+		return nil
+	})
+
+	if err != nil {
+		// half filled database must not be taken for a good one
+		if derr := n.DBConn.DB().QM().ExecuteSQL("DROP DATABASE `" + dbName + "`"); derr != nil {
+			n.Logger.Error.Printf("Database %s is not dropped after an error: %s", dbName, derr.Error())
+		}
+		return err
+	}
+	return nil
+}
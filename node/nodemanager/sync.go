@@ -0,0 +1,525 @@
+package nodemanager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/blockchain"
+	"github.com/gelembjuk/oursql/node/consensus"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Headers-first sync. A node which is far behind other nodes first loads headers of blocks
+// and checks PoW of them. Then bodies of blocks are loaded in parallel from several nodes
+// and added to the blockchain in order of heights
+const (
+	SyncMinHeightDiff = 50              // sync is started if other node has more blocks than this
+	syncMaxPeers      = 8               // max count of nodes to load from
+	syncHeadersBatch  = 500             // headers requested in one request
+	syncBlocksWindow  = 64              // blocks loaded in parallel before they are added
+	syncBlockTimeout  = 2 * time.Minute // a node which doesn't return a block in this time is not used anymore
+)
+
+// State of the sync process
+type SyncProgress struct {
+	Running       bool
+	HeadersHeight int // height of last loaded and verified header
+	BlocksHeight  int // height of last added block
+	TargetHeight  int // best height known on other nodes
+	Peers         int // count of nodes used to load blocks
+}
+
+type HeadersSync struct {
+	node     *Node
+	logger   *utils.LoggerMan
+	lock     sync.Mutex
+	progress SyncProgress
+	stopChan chan struct{}
+	doneChan chan struct{}
+
+	blockTimeout time.Duration
+	busyWait     time.Duration // pause of a worker after a node responded "busy"
+	// requests to other nodes and adding of blocks. Tests replace them
+	getHeaders func(client *nodeclient.NodeClient, addr net.NodeAddr, startFrom []byte, maxCount int) (*nodeclient.ResponseGetHeaders, error)
+	getBlock   func(client *nodeclient.NodeClient, addr net.NodeAddr, blockHash []byte) (*nodeclient.ResponseGetBlock, error)
+	addToChain func(node *Node, block *structures.Block) (uint, error)
+}
+
+type syncPeer struct {
+	addr   net.NodeAddr
+	height int
+	failed bool
+}
+
+type syncBlockResult struct {
+	index int
+	block *structures.Block
+	peer  *syncPeer
+	err   error
+}
+
+// Create sync object. It is not started
+func (n *Node) NewHeadersSync() *HeadersSync {
+	s := &HeadersSync{}
+	s.node = n
+	s.logger = n.Logger
+	s.blockTimeout = syncBlockTimeout
+	s.busyWait = net.BusyRetryAfter
+	s.getHeaders = (*nodeclient.NodeClient).SendGetHeaders
+	s.getBlock = (*nodeclient.NodeClient).SendGetBlock
+	s.addToChain = (*Node).AddBlock
+	return s
+}
+
+// Start sync in background. Returns false if a sync is already running
+func (s *HeadersSync) Start() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.progress.Running {
+		return false
+	}
+
+	s.progress = SyncProgress{Running: true}
+	s.stopChan = make(chan struct{})
+	s.doneChan = make(chan struct{})
+
+	go s.run()
+
+	return true
+}
+
+// Stop sync and wait while it is done
+func (s *HeadersSync) Stop() {
+	s.lock.Lock()
+
+	if !s.progress.Running {
+		s.lock.Unlock()
+		return
+	}
+	close(s.stopChan)
+	done := s.doneChan
+
+	s.lock.Unlock()
+
+	<-done
+}
+
+// Returns copy of current state
+func (s *HeadersSync) GetProgress() SyncProgress {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.progress
+}
+
+func (s *HeadersSync) IsRunning() bool {
+	return s.GetProgress().Running
+}
+
+func (s *HeadersSync) updateProgress(update func(p *SyncProgress)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	update(&s.progress)
+}
+
+func (s *HeadersSync) isStopped() bool {
+	select {
+	case <-s.stopChan:
+		return true
+	default:
+	}
+	return false
+}
+
+func (s *HeadersSync) run() {
+	node := s.node.Clone()
+	node.SessionID = utils.RandString(5)
+
+	err := node.DBConn.OpenConnection(node.SessionID)
+
+	if err == nil {
+		err = s.doSync(node)
+
+		if err == nil {
+			// the rest of blocks, created while we were loading, is loaded in usual way
+			node.SendVersionToNodes([]net.NodeAddr{})
+		}
+
+		node.DBConn.CloseConnection()
+	}
+
+	if err != nil {
+		s.logger.Error.Printf("Headers sync failed: %s", err.Error())
+	}
+
+	s.lock.Lock()
+	s.progress.Running = false
+	close(s.doneChan)
+	s.lock.Unlock()
+}
+
+func (s *HeadersSync) doSync(node *Node) error {
+	topHash, err := node.NodeBC.GetTopBlockHash()
+
+	if err != nil {
+		return err
+	}
+
+	height, err := node.NodeBC.GetBestHeight()
+
+	if err != nil {
+		return err
+	}
+
+	peers := s.findPeers(node, topHash, height)
+
+	if len(peers) == 0 || peers[0].height-height < SyncMinHeightDiff {
+		s.logger.Trace.Printf("Headers sync is not needed. Our height %d", height)
+		return nil
+	}
+
+	s.logger.Trace.Printf("Headers sync from %d nodes. Our height %d, target height %d", len(peers), height, peers[0].height)
+
+	s.updateProgress(func(p *SyncProgress) {
+		p.HeadersHeight = height
+		p.BlocksHeight = height
+		p.TargetHeight = peers[0].height
+		p.Peers = len(peers)
+	})
+
+	for !s.isStopped() {
+		headers, err := s.loadHeaders(node, topHash, height, peers)
+
+		if err != nil {
+			return err
+		}
+
+		if len(headers) == 0 {
+			s.logger.Trace.Printf("Headers sync complete. Height %d", height)
+			return nil
+		}
+
+		s.updateProgress(func(p *SyncProgress) {
+			p.HeadersHeight = headers[len(headers)-1].Height
+		})
+
+		for start := 0; start < len(headers); start += syncBlocksWindow {
+			end := start + syncBlocksWindow
+
+			if end > len(headers) {
+				end = len(headers)
+			}
+
+			err = s.loadAndAddBlocks(node, headers[start:end], peers)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		topHash = headers[len(headers)-1].Hash
+		height = headers[len(headers)-1].Height
+	}
+	return nil
+}
+
+// Ask nodes about their heights. Nodes which don't have our top block in the primary chain are skipped,
+// blocks from them will be loaded in usual way. Returns nodes with highest first
+func (s *HeadersSync) findPeers(node *Node, topHash []byte, height int) []*syncPeer {
+	peers := []*syncPeer{}
+	seen := map[string]bool{}
+
+	for _, addr := range node.NodeNet.GetConnecttionVerifiedNodeAddresses(syncMaxPeers) {
+		a := *addr
+
+		if seen[a.NodeAddrToString()] || a.CompareToAddress(node.NodeClient.GetNodeAddress()) ||
+			node.NodeNet.IsPeerBanned(a.Host) {
+			continue
+		}
+		seen[a.NodeAddrToString()] = true
+
+		res, err := s.getHeaders(node.NodeClient, a, topHash, 0)
+
+		if err != nil {
+			s.logger.Trace.Printf("Node %s can not be used for sync: %s", a.NodeAddrToString(), err.Error())
+			continue
+		}
+
+		if res.Height > height {
+			peers = append(peers, &syncPeer{a, res.Height, false})
+		}
+	}
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].height > peers[j].height })
+
+	return peers
+}
+
+// Load next part of headers after a block. Headers are requested from a node with best height.
+// If a node fails or returns wrong headers, next node is used
+func (s *HeadersSync) loadHeaders(node *Node, prevHash []byte, prevHeight int, peers []*syncPeer) ([]*structures.BlockHeader, error) {
+	for _, p := range peers {
+		if p.failed || p.height <= prevHeight {
+			continue
+		}
+
+		res, err := s.getHeaders(node.NodeClient, p.addr, prevHash, syncHeadersBatch)
+
+		if err != nil {
+			s.logger.Trace.Printf("Headers request to %s failed: %s", p.addr.NodeAddrToString(), err.Error())
+
+			if !isBusyError(err) {
+				p.failed = true
+			}
+			continue
+		}
+
+		headers := []*structures.BlockHeader{}
+
+		for _, hdata := range res.Headers {
+			h, err := structures.NewBlockHeaderFromBytes(hdata)
+
+			if err == nil {
+				err = s.checkHeader(node, h, prevHash, prevHeight+len(headers)+1)
+			}
+
+			if err != nil {
+				s.logger.Trace.Printf("Wrong header from %s: %s", p.addr.NodeAddrToString(), err.Error())
+				node.NodeNet.ReportPeerMisbehavior(p.addr.Host, net.PenaltyInvalidBlock, "invalid block header: "+err.Error())
+				p.failed = true
+				break
+			}
+			headers = append(headers, h)
+			prevHash = h.Hash
+		}
+
+		if p.failed {
+			// headers loaded before a wrong one are still fine
+			if len(headers) > 0 {
+				return headers, nil
+			}
+			continue
+		}
+
+		if len(headers) == 0 {
+			// the node has nothing more
+			p.height = prevHeight
+			continue
+		}
+		return headers, nil
+	}
+
+	return []*structures.BlockHeader{}, nil
+}
+
+// Check header is next after previous one, has correct version and PoW is valid
+func (s *HeadersSync) checkHeader(node *Node, h *structures.BlockHeader, prevHash []byte, height int) error {
+	if !bytes.Equal(h.PrevBlockHash, prevHash) {
+		return errors.New(fmt.Sprintf("Block %x is not next after %x", h.Hash, prevHash))
+	}
+
+	if h.Height != height {
+		return errors.New(fmt.Sprintf("Block %x has wrong height %d, expected %d", h.Hash, h.Height, height))
+	}
+
+	if h.Version != node.ConsensusConfig.GetBlockVersion(h.Height) {
+		return errors.New(fmt.Sprintf("Block %x has wrong version %d", h.Hash, h.Version))
+	}
+
+	return consensus.VerifyBlockHeader(h, node.ConsensusConfig.Settings)
+}
+
+// Load bodies of blocks in parallel from all nodes which have them and add blocks to the blockchain in order
+func (s *HeadersSync) loadAndAddBlocks(node *Node, headers []*structures.BlockHeader, peers []*syncPeer) error {
+	jobs := make(chan int, len(headers))
+	results := make(chan syncBlockResult, len(headers)+len(peers))
+
+	defer func() {
+		// drop jobs left after an error, so workers exit
+		for len(jobs) > 0 {
+			<-jobs
+		}
+		close(jobs)
+	}()
+
+	for i := range headers {
+		jobs <- i
+	}
+
+	lastHeight := headers[len(headers)-1].Height
+	workers := 0
+
+	for _, p := range peers {
+		if p.failed || p.height < lastHeight {
+			continue
+		}
+		// every worker has own client, so it can work in own routine
+		client := *node.NodeClient
+
+		go s.blocksWorker(&client, p, headers, jobs, results)
+
+		workers++
+	}
+
+	s.updateProgress(func(p *SyncProgress) {
+		p.Peers = workers
+	})
+
+	blocks := make([]*structures.Block, len(headers))
+	sources := make([]*syncPeer, len(headers))
+	loaded := 0
+	next := 0
+
+	for loaded < len(headers) {
+		if workers == 0 {
+			return errors.New("No nodes left to load blocks from")
+		}
+
+		var r syncBlockResult
+
+		select {
+		case r = <-results:
+		case <-s.stopChan:
+			return errors.New("Sync is stopped")
+		}
+
+		if r.err != nil && isBusyError(r.err) {
+			// the node limits our requests. The worker waits and continues, the block is loaded by any node
+			s.logger.Trace.Printf("Node %s is busy, block is requeued: %s", r.peer.addr.NodeAddrToString(), r.err.Error())
+			jobs <- r.index
+			continue
+		}
+
+		if r.err != nil {
+			s.logger.Trace.Printf("Loading block from %s failed: %s", r.peer.addr.NodeAddrToString(), r.err.Error())
+
+			r.peer.failed = true
+			workers--
+			// other node will load it
+			jobs <- r.index
+			continue
+		}
+
+		blocks[r.index] = r.block
+		sources[r.index] = r.peer
+		loaded++
+
+		// add all blocks which are ready
+		for next < len(blocks) && blocks[next] != nil {
+			err := s.addBlock(node, blocks[next], sources[next])
+
+			if err != nil {
+				return err
+			}
+			next++
+		}
+	}
+	return nil
+}
+
+func (s *HeadersSync) blocksWorker(client *nodeclient.NodeClient, p *syncPeer, headers []*structures.BlockHeader,
+	jobs <-chan int, results chan<- syncBlockResult) {
+
+	for i := range jobs {
+		block, err := s.loadBlock(client, p, headers[i])
+
+		results <- syncBlockResult{i, block, p, err}
+
+		if err != nil && isBusyError(err) {
+			select {
+			case <-time.After(s.busyWait):
+			case <-s.stopChan:
+				return
+			}
+			continue
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Node responded it is busy or it asked to wait recently. It is not a failure of the node
+func isBusyError(err error) bool {
+	errv, ok := err.(*net.NetworkError)
+
+	return ok && errv.IsBusy()
+}
+
+// Load a block body and check it is same as in the header.
+// If a node stalls, the block is loaded from other node
+func (s *HeadersSync) loadBlock(client *nodeclient.NodeClient, p *syncPeer, h *structures.BlockHeader) (*structures.Block, error) {
+	type response struct {
+		res *nodeclient.ResponseGetBlock
+		err error
+	}
+	done := make(chan response, 1)
+
+	go func() {
+		res, err := s.getBlock(client, p.addr, h.Hash)
+		done <- response{res, err}
+	}()
+
+	var r response
+
+	select {
+	case r = <-done:
+	case <-time.After(s.blockTimeout):
+		return nil, errors.New(fmt.Sprintf("Block %x is not received in %s", h.Hash, s.blockTimeout))
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	block, err := structures.NewBlockFromBytes(r.res.Block)
+
+	if err == nil {
+		var matches bool
+		matches, err = h.MatchesBlock(block)
+
+		if err == nil && !matches {
+			err = errors.New(fmt.Sprintf("Block %x doesn't match its header", h.Hash))
+		}
+	}
+
+	if err != nil {
+		client.NodeNet.ReportPeerMisbehavior(p.addr.Host, net.PenaltyInvalidBlock, "invalid block: "+err.Error())
+		return nil, err
+	}
+	return block, nil
+}
+
+func (s *HeadersSync) addBlock(node *Node, block *structures.Block, p *syncPeer) error {
+	node.locks.transactionsExecute.Lock()
+	defer node.locks.transactionsExecute.Unlock()
+
+	addstate, err := s.addToChain(node, block)
+
+	if err != nil {
+		if consensus.IsBlockVerifyError(err) {
+			// body matched the header, so the block is wrong in the chain of that node
+			node.NodeNet.ReportPeerMisbehavior(p.addr.Host, net.PenaltyInvalidBlock, "invalid block: "+err.Error())
+		}
+		return err
+	}
+
+	if addstate == blockchain.BCBAddState_notAddedNoPrev {
+		return errors.New(fmt.Sprintf("Previous block not found for %x", block.Hash))
+	}
+
+	s.updateProgress(func(p *SyncProgress) {
+		p.BlocksHeight = block.Height
+	})
+	return nil
+}
@@ -0,0 +1,380 @@
+package nodemanager
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/consensus"
+	"github.com/gelembjuk/oursql/node/structures"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSyncGenesisHash = []byte("genesis block hash")
+
+// Fake node to load headers and blocks from
+type testSyncNode struct {
+	blocks []*structures.Block
+	// what the node does on a block request
+	fail    bool
+	stall   chan struct{}
+	wrong   bool
+	limiter *net.RateLimiter // requests of the syncing node are limited as on a real server
+	served  int
+	lock    sync.Mutex
+}
+
+func (tn *testSyncNode) getHeaders(startFrom []byte, maxCount int) (*nodeclient.ResponseGetHeaders, error) {
+	res := &nodeclient.ResponseGetHeaders{Height: len(tn.blocks)}
+
+	start := -1
+
+	if bytes.Equal(startFrom, testSyncGenesisHash) {
+		start = 0
+	}
+
+	for i, b := range tn.blocks {
+		if bytes.Equal(b.Hash, startFrom) {
+			start = i + 1
+		}
+	}
+
+	if start < 0 {
+		return nil, errors.New("Block not found")
+	}
+
+	for i := start; i < len(tn.blocks) && i < start+maxCount; i++ {
+		h, _ := tn.blocks[i].GetHeader()
+		hdata, _ := h.Serialize()
+		res.Headers = append(res.Headers, hdata)
+	}
+	return res, nil
+}
+
+func (tn *testSyncNode) getBlock(hash []byte) (*nodeclient.ResponseGetBlock, error) {
+	if tn.stall != nil {
+		<-tn.stall
+		return nil, errors.New("Connection closed")
+	}
+
+	if tn.fail {
+		return nil, errors.New("Connection refused")
+	}
+
+	if err := tn.limiter.AllowRequest("10.0.0.100", "getblock", 100); err != nil {
+		return nil, err
+	}
+
+	for i, b := range tn.blocks {
+		if !bytes.Equal(b.Hash, hash) {
+			continue
+		}
+
+		if tn.wrong {
+			// body of other block
+			b = tn.blocks[(i+1)%len(tn.blocks)]
+		}
+
+		bdata, _ := b.Serialize()
+
+		tn.lock.Lock()
+		tn.served++
+		tn.lock.Unlock()
+
+		// give other workers a chance to take jobs
+		time.Sleep(2 * time.Millisecond)
+
+		return &nodeclient.ResponseGetBlock{Block: bdata, Size: len(bdata)}, nil
+	}
+	return nil, errors.New("Block not found")
+}
+
+func (tn *testSyncNode) getServed() int {
+	tn.lock.Lock()
+	defer tn.lock.Unlock()
+
+	return tn.served
+}
+
+func makeTestSyncNode() *Node {
+	node := &Node{}
+	node.Logger = utils.CreateLogger()
+	node.NodeNet.Init()
+	node.NodeClient = &nodeclient.NodeClient{NodeNet: &node.NodeNet}
+	node.locks = &NodeLocks{}
+	node.locks.InitLocks()
+	node.ConsensusConfig = &consensus.ConsensusConfig{}
+	node.ConsensusConfig.BlockVersion = structures.BlockVersionMerkleRoot
+	node.ConsensusConfig.Settings = map[string]interface{}{"Complexity": 1}
+	return node
+}
+
+// Makes a chain of blocks after the genesis block with valid PoW
+func makeTestSyncChain(t *testing.T, node *Node, count int) []*structures.Block {
+	blocks := []*structures.Block{}
+	prevHash := testSyncGenesisHash
+
+	for height := 1; height <= count; height++ {
+		b := &structures.Block{}
+		b.Timestamp = time.Now().Unix()
+		b.PrevBlockHash = prevHash
+		b.Height = height
+		b.Version = node.ConsensusConfig.GetBlockVersion(height)
+		b.Transactions = []structures.Transaction{structures.Transaction{ID: []byte{byte(height), 1}, Time: b.Timestamp}}
+		b.MerkleRoot = b.MakeMerkleRoot()
+
+		nonce, hash, err := consensus.NewProofOfWork(b, node.ConsensusConfig.Settings).Run()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Nonce = nonce
+		b.Hash = hash
+
+		blocks = append(blocks, b)
+		prevHash = hash
+	}
+	return blocks
+}
+
+// Makes sync object which uses fake nodes instead of network and records added blocks
+func makeTestHeadersSync(node *Node, nodes map[string]*testSyncNode, added *[]int) *HeadersSync {
+	s := node.NewHeadersSync()
+	s.blockTimeout = 100 * time.Millisecond
+	s.busyWait = 20 * time.Millisecond
+
+	s.getHeaders = func(client *nodeclient.NodeClient, addr net.NodeAddr, startFrom []byte, maxCount int) (*nodeclient.ResponseGetHeaders, error) {
+		return nodes[addr.Host].getHeaders(startFrom, maxCount)
+	}
+	s.getBlock = func(client *nodeclient.NodeClient, addr net.NodeAddr, blockHash []byte) (*nodeclient.ResponseGetBlock, error) {
+		return nodes[addr.Host].getBlock(blockHash)
+	}
+	s.addToChain = func(node *Node, block *structures.Block) (uint, error) {
+		*added = append(*added, block.Height)
+		return 0, nil
+	}
+	return s
+}
+
+func makeTestSyncPeers(hosts ...string) []*syncPeer {
+	peers := []*syncPeer{}
+
+	for _, host := range hosts {
+		peers = append(peers, &syncPeer{net.NodeAddr{Host: host, Port: 8765}, 10, false})
+	}
+	return peers
+}
+
+func getTestSyncHeaders(blocks []*structures.Block) []*structures.BlockHeader {
+	headers := []*structures.BlockHeader{}
+
+	for _, b := range blocks {
+		h, _ := b.GetHeader()
+		headers = append(headers, h)
+	}
+	return headers
+}
+
+func TestSyncCheckHeader(t *testing.T) {
+	node := makeTestSyncNode()
+	blocks := makeTestSyncChain(t, node, 2)
+	s := node.NewHeadersSync()
+
+	h, _ := blocks[1].GetHeader()
+	assert.NoError(t, s.checkHeader(node, h, blocks[0].Hash, 2))
+
+	assert.Error(t, s.checkHeader(node, h, testSyncGenesisHash, 2), "wrong previous block")
+	assert.Error(t, s.checkHeader(node, h, blocks[0].Hash, 3), "wrong height")
+
+	h, _ = blocks[1].GetHeader()
+	h.Version = structures.BlockVersionLegacy
+	assert.Error(t, s.checkHeader(node, h, blocks[0].Hash, 2), "wrong version")
+
+	h, _ = blocks[1].GetHeader()
+	h.Nonce++
+	assert.Error(t, s.checkHeader(node, h, blocks[0].Hash, 2), "hash doesn't match data")
+
+	h, _ = blocks[1].GetHeader()
+	h.Timestamp++
+	assert.Error(t, s.checkHeader(node, h, blocks[0].Hash, 2), "hash doesn't match data")
+}
+
+func TestSyncLoadHeaders(t *testing.T) {
+	node := makeTestSyncNode()
+	blocks := makeTestSyncChain(t, node, 10)
+
+	// 4th block of the first node is from other chain
+	otherBlocks := append([]*structures.Block{}, blocks...)
+	other := *otherBlocks[3]
+	other.PrevBlockHash = []byte("other chain")
+	otherBlocks[3] = &other
+
+	nodes := map[string]*testSyncNode{
+		"10.0.0.1": &testSyncNode{blocks: otherBlocks},
+		"10.0.0.2": &testSyncNode{blocks: blocks},
+	}
+	added := []int{}
+	s := makeTestHeadersSync(node, nodes, &added)
+	peers := makeTestSyncPeers("10.0.0.1", "10.0.0.2")
+
+	score := node.NodeNet.GetPeerScore("10.0.0.1")
+
+	// headers before the wrong one are used
+	headers, err := s.loadHeaders(node, testSyncGenesisHash, 0, peers)
+
+	assert.NoError(t, err)
+	assert.Len(t, headers, 3)
+	assert.True(t, peers[0].failed)
+	assert.True(t, node.NodeNet.GetPeerScore("10.0.0.1") < score)
+
+	// the rest is loaded from other node
+	headers, err = s.loadHeaders(node, headers[2].Hash, 3, peers)
+
+	assert.NoError(t, err)
+	assert.Len(t, headers, 7)
+	assert.Equal(t, 4, headers[0].Height)
+	assert.Equal(t, blocks[9].Hash, headers[6].Hash)
+	assert.False(t, peers[1].failed)
+
+	// nothing more
+	headers, err = s.loadHeaders(node, blocks[9].Hash, 10, peers)
+
+	assert.NoError(t, err)
+	assert.Len(t, headers, 0)
+}
+
+func TestSyncLoadBlocksFromSeveralNodes(t *testing.T) {
+	node := makeTestSyncNode()
+	blocks := makeTestSyncChain(t, node, 10)
+
+	nodes := map[string]*testSyncNode{
+		"10.0.0.1": &testSyncNode{blocks: blocks},
+		"10.0.0.2": &testSyncNode{blocks: blocks},
+		"10.0.0.3": &testSyncNode{blocks: blocks},
+	}
+	added := []int{}
+	s := makeTestHeadersSync(node, nodes, &added)
+	peers := makeTestSyncPeers("10.0.0.1", "10.0.0.2", "10.0.0.3")
+
+	err := s.loadAndAddBlocks(node, getTestSyncHeaders(blocks), peers)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, added)
+	assert.Equal(t, 10, s.GetProgress().BlocksHeight)
+	assert.Equal(t, 3, s.GetProgress().Peers)
+
+	served := 0
+
+	for host, n := range nodes {
+		assert.True(t, n.getServed() > 0, "node "+host+" was not used")
+		served += n.getServed()
+	}
+	assert.Equal(t, 10, served)
+}
+
+func TestSyncLoadBlocksAfterFailedNodes(t *testing.T) {
+	node := makeTestSyncNode()
+	blocks := makeTestSyncChain(t, node, 10)
+
+	stall := make(chan struct{})
+	defer close(stall)
+
+	nodes := map[string]*testSyncNode{
+		"10.0.0.1": &testSyncNode{blocks: blocks, fail: true},
+		"10.0.0.2": &testSyncNode{blocks: blocks, stall: stall},
+		"10.0.0.3": &testSyncNode{blocks: blocks, wrong: true},
+		"10.0.0.4": &testSyncNode{blocks: blocks},
+	}
+	added := []int{}
+	s := makeTestHeadersSync(node, nodes, &added)
+	peers := makeTestSyncPeers("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4")
+
+	score := node.NodeNet.GetPeerScore("10.0.0.1")
+
+	err := s.loadAndAddBlocks(node, getTestSyncHeaders(blocks), peers)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, added)
+
+	// blocks requested from failed nodes are loaded from the good one
+	assert.True(t, peers[0].failed)
+	assert.True(t, peers[1].failed)
+	assert.True(t, peers[2].failed)
+	assert.False(t, peers[3].failed)
+	assert.Equal(t, 10, nodes["10.0.0.4"].getServed())
+
+	// only a node which returned wrong block is penalized
+	assert.Equal(t, score, node.NodeNet.GetPeerScore("10.0.0.1"))
+	assert.Equal(t, score, node.NodeNet.GetPeerScore("10.0.0.2"))
+	assert.True(t, node.NodeNet.GetPeerScore("10.0.0.3") < score)
+}
+
+func TestSyncLoadBlocksNoNodesLeft(t *testing.T) {
+	node := makeTestSyncNode()
+	blocks := makeTestSyncChain(t, node, 3)
+
+	nodes := map[string]*testSyncNode{
+		"10.0.0.1": &testSyncNode{blocks: blocks, fail: true},
+	}
+	added := []int{}
+	s := makeTestHeadersSync(node, nodes, &added)
+
+	err := s.loadAndAddBlocks(node, getTestSyncHeaders(blocks), makeTestSyncPeers("10.0.0.1"))
+
+	assert.Error(t, err)
+	assert.Empty(t, added)
+}
+
+func TestSyncLoadBlocksFromRateLimitedNode(t *testing.T) {
+	node := makeTestSyncNode()
+	blocks := makeTestSyncChain(t, node, 10)
+
+	limiter := net.NewRateLimiter(map[string]net.RateLimit{net.RateClassSync: {Rate: 50, Burst: 3}}, 0, 0)
+
+	nodes := map[string]*testSyncNode{
+		"10.0.0.1": &testSyncNode{blocks: blocks, limiter: limiter},
+	}
+	added := []int{}
+	s := makeTestHeadersSync(node, nodes, &added)
+	peers := makeTestSyncPeers("10.0.0.1")
+
+	score := node.NodeNet.GetPeerScore("10.0.0.1")
+
+	// the node responds busy after 3 blocks, the sync waits instead of dropping the node
+	err := s.loadAndAddBlocks(node, getTestSyncHeaders(blocks), peers)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, added)
+	assert.False(t, peers[0].failed)
+	assert.Equal(t, 10, nodes["10.0.0.1"].getServed())
+	assert.Equal(t, score, node.NodeNet.GetPeerScore("10.0.0.1"))
+}
+
+func TestSyncAddBlockPenalty(t *testing.T) {
+	node := makeTestSyncNode()
+	blocks := makeTestSyncChain(t, node, 1)
+
+	added := []int{}
+	s := makeTestHeadersSync(node, map[string]*testSyncNode{}, &added)
+	peer := makeTestSyncPeers("10.0.0.1")[0]
+
+	score := node.NodeNet.GetPeerScore("10.0.0.1")
+
+	// local DB failure is not a fault of the node
+	s.addToChain = func(node *Node, block *structures.Block) (uint, error) {
+		return 0, errors.New("Error 2006: MySQL server has gone away")
+	}
+	assert.Error(t, s.addBlock(node, blocks[0], peer))
+	assert.Equal(t, score, node.NodeNet.GetPeerScore("10.0.0.1"))
+
+	s.addToChain = func(node *Node, block *structures.Block) (uint, error) {
+		return 0, consensus.NewBlockVerifyError("No coinbase TX in the block", block.Hash)
+	}
+	assert.Error(t, s.addBlock(node, blocks[0], peer))
+	assert.True(t, node.NodeNet.GetPeerScore("10.0.0.1") < score)
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/config"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+const (
+	auditLogDefaultMaxSize  = 100 // MB
+	auditLogDefaultMaxFiles = 10
+
+	auditOutcomeExecuted           = "executed"
+	auditOutcomeFailed             = "failed"
+	auditOutcomeRejected           = "rejected"
+	auditOutcomeSignatureRequested = "signature_requested"
+	auditOutcomeTXRejected         = "tx_rejected"
+)
+
+// Record of audit log. One record per query received by DB proxy
+type auditRecord struct {
+	Time           string
+	ClientIP       string
+	User           string
+	SessionID      string
+	Query          string
+	CanonicalQuery string
+	Signer         string
+	TXID           string
+	Outcome        string
+	Error          string `json:",omitempty"`
+}
+
+// Set info of a transaction created for a query
+func (r *auditRecord) setTX(tx *structures.Transaction) {
+	if tx == nil {
+		return
+	}
+	r.TXID = hex.EncodeToString(tx.GetID())
+	r.CanonicalQuery = tx.GetSQLQuery()
+	r.Signer, _ = utils.PubKeyToAddres(tx.ByPubKey)
+}
+
+// Append only log in JSON lines format. When a file reaches max size it is renamed
+// to FILE.1, previous FILE.1 to FILE.2 etc. Oldest files are removed
+type auditLog struct {
+	filePath string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+	lock     sync.Mutex
+}
+
+func newAuditLog(c config.AuditLogConfig) (*auditLog, error) {
+	a := &auditLog{}
+	a.filePath = c.File
+	a.maxSize = int64(c.MaxSize) * 1024 * 1024
+	a.maxFiles = c.MaxFiles
+
+	if a.maxSize <= 0 {
+		a.maxSize = auditLogDefaultMaxSize * 1024 * 1024
+	}
+	if a.maxFiles <= 0 {
+		a.maxFiles = auditLogDefaultMaxFiles
+	}
+
+	err := a.open()
+
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	a.file = f
+	a.size = info.Size()
+
+	return nil
+}
+
+// Add new record to the log. Time is set if it is empty
+func (a *auditLog) Write(r auditRecord) error {
+	if r.Time == "" {
+		r.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	line, err := json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.file == nil {
+		return os.ErrClosed
+	}
+
+	if a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		err = a.rotate()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+
+	return err
+}
+
+// Shift old files and start new one
+func (a *auditLog) rotate() error {
+	a.file.Close()
+	a.file = nil
+
+	os.Remove(a.filePath + "." + strconv.Itoa(a.maxFiles))
+
+	for i := a.maxFiles - 1; i > 0; i-- {
+		os.Rename(a.filePath+"."+strconv.Itoa(i), a.filePath+"."+strconv.Itoa(i+1))
+	}
+
+	err := os.Rename(a.filePath, a.filePath+".1")
+
+	if err != nil {
+		return err
+	}
+
+	return a.open()
+}
+
+func (a *auditLog) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+
+	return err
+}
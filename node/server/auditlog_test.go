@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gelembjuk/oursql/node/config"
+)
+
+func TestAuditLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "audit.log")
+
+	a, err := newAuditLog(config.AuditLogConfig{File: file, MaxSize: 1, MaxFiles: 2})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	// force small size to check rotation
+	a.maxSize = 300
+
+	for i := 0; i < 10; i++ {
+		err = a.Write(auditRecord{SessionID: "s1", Query: strings.Repeat("x", 50), Outcome: auditOutcomeExecuted})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.Close()
+
+	if _, err := os.Stat(file + ".1"); err != nil {
+		t.Errorf("Rotated file is not found: %s", err)
+	}
+	if _, err := os.Stat(file + ".2"); err != nil {
+		t.Errorf("Second rotated file is not found: %s", err)
+	}
+	if _, err := os.Stat(file + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Only 2 rotated files must be kept")
+	}
+
+	f, err := os.Open(file)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		r := auditRecord{}
+
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("Line is not JSON: %s", err)
+		}
+		if r.Time == "" || r.SessionID != "s1" {
+			t.Errorf("Unexpected record %v", r)
+		}
+	}
+}
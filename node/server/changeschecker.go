@@ -68,6 +68,9 @@ func (c *changesChecker) Run() {
 		if c.S.Node.NodeNet.CheckHadInputConnects() {
 			// other nodes can connect to this node. No need to do extra check often
 			c.ticker = 180 // try again in 3 minutes
+		} else if c.S.Node.NodeNet.CountPeerConnections() > 0 {
+			// other nodes push changes over persistent connections. This is only fallback
+			c.ticker = 180
 		} else {
 			c.ticker = 5 // 5 seconds as it looks like other nodes can not connect to this node
 		}
@@ -29,6 +29,16 @@ type NodeDaemon struct {
 	Node        *nodemanager.Node
 	DBProxyAddr string
 	DBAddr      string
+	AuditLog    config.AuditLogConfig
+	DBProxyPool config.DBProxyPoolConfig
+
+	LocalDiscovery bool
+	NAT            string
+	OutboundOnly   bool
+	RequireTLS     bool
+	MessageLimits  map[string]int
+	RateLimits     config.RateLimitsConfig
+	HTTPAPI        config.HTTPAPIConfig
 }
 
 func (n *NodeDaemon) Init() error {
@@ -69,6 +79,15 @@ func (n *NodeDaemon) createServer() error {
 
 	server.DBProxyAddr = n.DBProxyAddr
 	server.DBAddr = n.DBAddr
+	server.AuditLog = n.AuditLog
+	server.DBProxyPool = n.DBProxyPool
+	server.LocalDiscovery = n.LocalDiscovery
+	server.NAT = n.NAT
+	server.OutboundOnly = n.OutboundOnly
+	server.RequireTLS = n.RequireTLS
+	server.MessageLimits = net.NewMessageLimits(n.MessageLimits)
+	server.RateLimiter = net.NewRateLimiter(n.RateLimits.Classes, n.RateLimits.Bandwidth, n.RateLimits.MaxConnections)
+	server.HTTPAPI = n.HTTPAPI
 
 	n.Server = &server
 
@@ -305,7 +324,7 @@ func (n *NodeDaemon) DaemonizeServer() error {
 
 		// to force server to try to handle next command if there were no input connects
 		// if we don't do this it will stay in "Accepting" mode and can not real channel
-		n.Logger.Trace.Println("Send void command on port ", server.NodeAddress.Port)
+		n.Logger.Trace.Println("Send void command on port ", server.GetNodeAddress().Port)
 		serverAddr := net.NewNodeAddr("localhost", server.NodePort)
 
 		nodeclient := server.GetClient()
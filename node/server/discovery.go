@@ -0,0 +1,171 @@
+package server
+
+import (
+	"time"
+
+	netlib "github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+const (
+	discoveryInterval     = 2 * time.Minute
+	discoveryGetAddrPeers = 3 // addresses are requested from so many nodes every time
+)
+
+// Finds other nodes. Addresses are requested from known nodes with getaddr and kept in the address book.
+// When there are not enough known nodes, addresses from the book are tried.
+// Optionally nodes in a local network are found with multicast announces
+type nodesDiscovery struct {
+	S            *NodeServer
+	logger       *utils.LoggerMan
+	stopChan     chan struct{}
+	completeChan chan struct{}
+	local        *netlib.LocalDiscovery
+}
+
+func StartNodesDiscovery(s *NodeServer) (c *nodesDiscovery) {
+	c = &nodesDiscovery{}
+
+	c.logger = s.Logger
+	c.S = s
+
+	c.stopChan = make(chan struct{})
+	c.completeChan = make(chan struct{})
+
+	if s.LocalDiscovery && !s.OutboundOnly {
+		c.startLocalDiscovery()
+	}
+
+	go c.Run()
+
+	return c
+}
+
+func (c *nodesDiscovery) Run() {
+	for {
+		exit := false
+
+		select {
+		case <-c.stopChan:
+			exit = true
+		case <-time.After(discoveryInterval):
+		}
+
+		if exit {
+			break
+		}
+
+		c.requestAddresses()
+		c.tryAddresses()
+	}
+	c.logger.Trace.Printf("Nodes Discovery Return routine")
+	close(c.completeChan)
+}
+
+func (c *nodesDiscovery) Stop() {
+	c.logger.Trace.Println("Stop nodes discovery")
+
+	close(c.stopChan)
+
+	<-c.completeChan
+
+	if c.local != nil {
+		c.local.Stop()
+	}
+
+	c.logger.TraceExt.Println("Nodes Discovery Stopped")
+}
+
+// Ask few known nodes for addresses they know
+func (c *nodesDiscovery) requestAddresses() {
+	node := c.S.Node
+
+	for i, addr := range node.NodeNet.GetConnecttionVerifiedNodeAddresses(discoveryGetAddrPeers) {
+		if i >= discoveryGetAddrPeers {
+			break
+		}
+
+		if addr.CompareToAddress(node.NodeClient.GetNodeAddress()) || node.NodeNet.IsPeerBanned(addr.Host) {
+			continue
+		}
+
+		list, err := node.NodeClient.SendGetAddr(*addr, netlib.GetAddrMaxCount)
+
+		if err != nil {
+			c.logger.Trace.Printf("Getaddr from %s failed: %s", addr.NodeAddrToString(), err.Error())
+			continue
+		}
+
+		added := node.NodeNet.AddAddresses(list, addr.Host)
+
+		c.logger.Trace.Printf("Received %d addresses from %s, %d are new. Address book size %d",
+			len(list), addr.NodeAddrToString(), added, node.NodeNet.GetAddressBookSize())
+	}
+}
+
+// Connect to nodes from the address book if there are not enough known nodes
+func (c *nodesDiscovery) tryAddresses() {
+	node := c.S.Node
+
+	need := netlib.DiscoveryTargetNodes - node.NodeNet.GetCountOfKnownNodes()
+
+	if need <= 0 {
+		return
+	}
+
+	for _, addr := range node.NodeNet.PickAddressesToTry(need * 2) {
+		if node.NodeNet.GetCountOfKnownNodes() >= netlib.DiscoveryTargetNodes {
+			return
+		}
+
+		if addr.CompareToAddress(node.NodeClient.GetNodeAddress()) || node.NodeNet.IsPeerBanned(addr.Host) {
+			continue
+		}
+
+		err := node.TryAddressFromBook(addr)
+
+		if err != nil {
+			c.logger.Trace.Printf("Address %s from the book failed: %s", addr.NodeAddrToString(), err.Error())
+			continue
+		}
+		c.logger.Trace.Printf("Found new node %s", addr.NodeAddrToString())
+	}
+}
+
+// Start announces in a local network. Only nodes with same genesis block are added
+func (c *nodesDiscovery) startLocalDiscovery() {
+	node := c.S.Node.Clone()
+
+	err := node.DBConn.OpenConnection(node.SessionID)
+
+	if err != nil {
+		c.logger.Error.Printf("Local discovery is not started: %s", err.Error())
+		return
+	}
+
+	genesis, err := node.NodeBC.GetBCManager().GetGenesisBlockHash()
+
+	node.DBConn.CloseConnection()
+
+	if err != nil {
+		c.logger.Error.Printf("Local discovery is not started: %s", err.Error())
+		return
+	}
+
+	local := netlib.NewLocalDiscovery(c.S.NodePort, genesis, func(addr netlib.NodeAddr) {
+		if c.S.Node.NodeNet.CheckIsKnown(addr) || c.S.Node.NodeNet.IsPeerBanned(addr.Host) {
+			return
+		}
+		c.logger.Trace.Printf("Found node %s in local network", addr.NodeAddrToString())
+
+		c.S.Node.AddNodeToKnown(addr, true)
+	})
+
+	err = local.Start()
+
+	if err != nil {
+		c.logger.Error.Printf("Local discovery is not started: %s", err.Error())
+		return
+	}
+	c.local = local
+}
@@ -0,0 +1,504 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/remoteclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/blockchain"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Read-only block explorer. Pages are served by HTTP API server on /explorer/ .
+// Data are read directly from blockchain and transactions managers of a node
+const (
+	explorerPrefix   = "/explorer/"
+	explorerPageSize = 20
+)
+
+type explorer struct {
+	S         *NodeServer
+	logger    *utils.LoggerMan
+	templates *template.Template
+}
+
+type explorerBlockRow struct {
+	Hash    string
+	Height  int
+	Time    int64
+	TXCount int
+}
+
+type explorerIndexPage struct {
+	Height  int
+	Tip     string
+	Pending int
+	Blocks  []explorerBlockRow
+	Older   string // hash to start next page from
+}
+
+type explorerBlockPage struct {
+	Hash          string
+	PrevHash      string
+	Height        int
+	Time          int64
+	Nonce         int
+	Confirmations int
+	Transactions  []apiTransaction
+}
+
+type explorerTXPage struct {
+	TX            apiTransaction
+	Pending       bool
+	BlockHash     string
+	BlockHeight   int
+	Confirmations int
+}
+
+type explorerAddressPage struct {
+	Address string
+	Balance remoteclient.WalletBalance
+	History []apiHistoryRecord
+}
+
+func newExplorer(s *NodeServer) (*explorer, error) {
+	e := &explorer{}
+	e.S = s
+	e.logger = s.Logger
+
+	funcs := template.FuncMap{
+		"blocktime": func(t int64) string {
+			return time.Unix(t, 0).UTC().Format("2006-01-02 15:04:05")
+		},
+		"txtime": func(t int64) string {
+			return time.Unix(0, t).UTC().Format("2006-01-02 15:04:05")
+		},
+		"short": func(s string) string {
+			if len(s) > 16 {
+				return s[:8] + ".." + s[len(s)-8:]
+			}
+			return s
+		},
+	}
+
+	var err error
+	e.templates, err = template.New("explorer").Funcs(funcs).Parse(explorerTemplates)
+
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *explorer) register(mux *http.ServeMux) {
+	mux.HandleFunc(explorerPrefix, e.handleIndex)
+	mux.HandleFunc(explorerPrefix+"block/", e.handleBlock)
+	mux.HandleFunc(explorerPrefix+"tx/", e.handleTransaction)
+	mux.HandleFunc(explorerPrefix+"address/", e.handleAddress)
+	mux.HandleFunc(explorerPrefix+"search", e.handleSearch)
+}
+
+func (e *explorer) render(w http.ResponseWriter, status int, name string, data interface{}) {
+	buf := bytes.Buffer{}
+
+	err := e.templates.ExecuteTemplate(&buf, name, data)
+
+	if err != nil {
+		e.logger.Error.Printf("Explorer page %s error: %s", name, err.Error())
+		http.Error(w, "Page error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+func (e *explorer) renderError(w http.ResponseWriter, status int, err error) {
+	e.render(w, status, "error", err.Error())
+}
+
+// Returns last part of a path
+func getExplorerPathArgument(r *http.Request, page string) string {
+	return strings.Trim(strings.TrimPrefix(r.URL.Path, explorerPrefix+page), "/")
+}
+
+// GET /explorer/?from=HASH . List of blocks from the top or from given block
+func (e *explorer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != explorerPrefix {
+		e.renderError(w, http.StatusNotFound, errors.New("Page not found"))
+		return
+	}
+
+	node, err := e.S.getRequestNode()
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer node.DBConn.CloseConnection()
+
+	page := explorerIndexPage{}
+	page.Blocks = []explorerBlockRow{}
+
+	topHash, height, err := node.NodeBC.GetBCManager().GetState()
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	page.Height = height
+	page.Tip = hex.EncodeToString(topHash)
+	page.Pending, _ = node.GetTransactionsManager().GetUnapprovedCount()
+
+	var bci *blockchain.BlockchainIterator
+
+	if from := r.URL.Query().Get("from"); from != "" {
+		fromHash, herr := hex.DecodeString(from)
+
+		if herr != nil {
+			e.renderError(w, http.StatusBadRequest, errors.New("Wrong block hash"))
+			return
+		}
+		bci, err = blockchain.NewBlockchainIteratorFrom(node.DBConn.DB(), fromHash)
+	} else {
+		bci, err = blockchain.NewBlockchainIterator(node.DBConn.DB())
+	}
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	for len(page.Blocks) < explorerPageSize {
+		block, err := bci.Next()
+
+		if err != nil {
+			e.renderError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if block == nil {
+			break
+		}
+
+		page.Blocks = append(page.Blocks, explorerBlockRow{
+			Hash:    hex.EncodeToString(block.Hash),
+			Height:  block.Height,
+			Time:    block.Timestamp,
+			TXCount: len(block.Transactions),
+		})
+
+		if len(block.PrevBlockHash) == 0 {
+			page.Older = ""
+			break
+		}
+		page.Older = hex.EncodeToString(block.PrevBlockHash)
+	}
+
+	e.render(w, http.StatusOK, "index", page)
+}
+
+// GET /explorer/block/HASH
+func (e *explorer) handleBlock(w http.ResponseWriter, r *http.Request) {
+	hash, err := hex.DecodeString(getExplorerPathArgument(r, "block/"))
+
+	if err != nil || len(hash) == 0 {
+		e.renderError(w, http.StatusBadRequest, errors.New("Wrong block hash"))
+		return
+	}
+
+	node, err := e.S.getRequestNode()
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer node.DBConn.CloseConnection()
+
+	exists, err := node.NodeBC.CheckBlockExists(hash)
+
+	if err != nil || !exists {
+		e.renderError(w, http.StatusNotFound, errors.New("Block not found"))
+		return
+	}
+
+	block, err := node.NodeBC.GetBlock(hash)
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	height, err := node.NodeBC.GetBestHeight()
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page := explorerBlockPage{}
+	page.Hash = hex.EncodeToString(block.Hash)
+	page.PrevHash = hex.EncodeToString(block.PrevBlockHash)
+	page.Height = block.Height
+	page.Time = block.Timestamp
+	page.Nonce = block.Nonce
+	page.Confirmations = height - block.Height + 1
+	page.Transactions = []apiTransaction{}
+
+	for i := range block.Transactions {
+		page.Transactions = append(page.Transactions, getAPITransaction(&block.Transactions[i]))
+	}
+
+	e.render(w, http.StatusOK, "block", page)
+}
+
+// GET /explorer/tx/TXID . A transaction from a pool or from primary chain
+func (e *explorer) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	txID, err := hex.DecodeString(getExplorerPathArgument(r, "tx/"))
+
+	if err != nil || len(txID) == 0 {
+		e.renderError(w, http.StatusBadRequest, errors.New("Wrong transaction ID"))
+		return
+	}
+
+	node, err := e.S.getRequestNode()
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer node.DBConn.CloseConnection()
+
+	tm := node.GetTransactionsManager()
+
+	tx, err := tm.GetIfExists(txID)
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if tx == nil {
+		e.renderError(w, http.StatusNotFound, errors.New("Transaction not found"))
+		return
+	}
+
+	page := explorerTXPage{}
+	page.TX = getAPITransaction(tx)
+
+	blockHash, err := tm.GetTransactionBlock(txID)
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if blockHash == nil {
+		page.Pending = true
+	} else {
+		block, err := node.NodeBC.GetBlock(blockHash)
+
+		if err != nil {
+			e.renderError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		height, err := node.NodeBC.GetBestHeight()
+
+		if err != nil {
+			e.renderError(w, http.StatusInternalServerError, err)
+			return
+		}
+		page.BlockHash = hex.EncodeToString(blockHash)
+		page.BlockHeight = block.Height
+		page.Confirmations = height - block.Height + 1
+	}
+
+	e.render(w, http.StatusOK, "tx", page)
+}
+
+// GET /explorer/address/ADDRESS
+func (e *explorer) handleAddress(w http.ResponseWriter, r *http.Request) {
+	address := getExplorerPathArgument(r, "address/")
+
+	if !(remoteclient.Wallet{}).ValidateAddress(address) {
+		e.renderError(w, http.StatusBadRequest, errors.New("Address is not valid"))
+		return
+	}
+
+	node, err := e.S.getRequestNode()
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer node.DBConn.CloseConnection()
+
+	page := explorerAddressPage{}
+	page.Address = address
+
+	page.Balance, err = node.GetTransactionsManager().GetAddressBalance(address)
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	history, err := node.NodeBC.GetAddressHistory(address)
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page.History = getAPIAddressHistory(address, history)
+
+	e.render(w, http.StatusOK, "address", page)
+}
+
+// Converts history of an address to API view
+func getAPIAddressHistory(address string, history []structures.TransactionsHistory) []apiHistoryRecord {
+	result := []apiHistoryRecord{}
+
+	for _, t := range history {
+		// IOType is true for incoming transaction. Address is other side of a transaction
+		record := apiHistoryRecord{TXID: hex.EncodeToString(t.TXID), Out: !t.IOType, Amount: t.Value}
+
+		if t.IOType {
+			record.From = t.Address
+			record.To = address
+		} else {
+			record.From = address
+			record.To = t.Address
+		}
+		result = append(result, record)
+	}
+	return result
+}
+
+// GET /explorer/search?q=QUERY . Query can be a block height, a block hash, a transaction ID or an address
+func (e *explorer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	if query == "" {
+		http.Redirect(w, r, explorerPrefix, http.StatusFound)
+		return
+	}
+
+	if (remoteclient.Wallet{}).ValidateAddress(query) {
+		http.Redirect(w, r, explorerPrefix+"address/"+query, http.StatusFound)
+		return
+	}
+
+	node, err := e.S.getRequestNode()
+
+	if err != nil {
+		e.renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer node.DBConn.CloseConnection()
+
+	if height, err := strconv.Atoi(query); err == nil {
+		block, err := node.NodeBC.GetBCManager().GetBlockAtHeight(height)
+
+		if err == nil {
+			http.Redirect(w, r, explorerPrefix+"block/"+hex.EncodeToString(block.Hash), http.StatusFound)
+			return
+		}
+	}
+
+	if hash, err := hex.DecodeString(query); err == nil && len(hash) > 0 {
+		if exists, _ := node.NodeBC.CheckBlockExists(hash); exists {
+			http.Redirect(w, r, explorerPrefix+"block/"+query, http.StatusFound)
+			return
+		}
+
+		if tx, _ := node.GetTransactionsManager().GetIfExists(hash); tx != nil {
+			http.Redirect(w, r, explorerPrefix+"tx/"+query, http.StatusFound)
+			return
+		}
+	}
+
+	e.renderError(w, http.StatusNotFound, errors.New("Nothing found for "+query))
+}
+
+const explorerTemplates = `
+{{define "header"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>OurSQL explorer</title>
+<style>
+body { font-family: sans-serif; margin: 20px; }
+table { border-collapse: collapse; margin-bottom: 20px; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+pre { background: #f4f4f4; padding: 6px; margin: 0; white-space: pre-wrap; }
+.mono { font-family: monospace; }
+</style></head><body>
+<p><a href="/explorer/">OurSQL explorer</a>
+<form action="/explorer/search" method="get" style="display:inline; margin-left: 20px">
+<input name="q" size="70" placeholder="Block height or hash, transaction ID, address"> <input type="submit" value="Search">
+</form></p>
+{{end}}
+
+{{define "footer"}}</body></html>{{end}}
+
+{{define "error"}}{{template "header"}}<h2>{{.}}</h2>{{template "footer"}}{{end}}
+
+{{define "index"}}{{template "header"}}
+<p>Height: {{.Height}}. Top block: <a class="mono" href="/explorer/block/{{.Tip}}">{{.Tip}}</a>. Transactions in the pool: {{.Pending}}</p>
+<table>
+<tr><th>Height</th><th>Hash</th><th>Time</th><th>Transactions</th></tr>
+{{range .Blocks}}<tr><td>{{.Height}}</td><td class="mono"><a href="/explorer/block/{{.Hash}}">{{.Hash}}</a></td><td>{{blocktime .Time}}</td><td>{{.TXCount}}</td></tr>
+{{end}}</table>
+{{if .Older}}<p><a href="/explorer/?from={{.Older}}">Older blocks</a></p>{{end}}
+{{template "footer"}}{{end}}
+
+{{define "transaction"}}<table>
+<tr><th>ID</th><td class="mono"><a href="/explorer/tx/{{.ID}}">{{.ID}}</a></td></tr>
+<tr><th>Time</th><td>{{txtime .Time}}</td></tr>
+{{if .From}}<tr><th>From</th><td class="mono"><a href="/explorer/address/{{.From}}">{{.From}}</a></td></tr>{{end}}
+{{if .IsCoinbase}}<tr><th>Coinbase</th><td>yes</td></tr>{{end}}
+{{if .Inputs}}<tr><th>Inputs</th><td>{{range .Inputs}}<div class="mono"><a href="/explorer/tx/{{.TXID}}">{{short .TXID}}</a>:{{.Vout}}</div>{{end}}</td></tr>{{end}}
+{{if .Outputs}}<tr><th>Outputs</th><td>{{range .Outputs}}<div class="mono"><a href="/explorer/address/{{.Address}}">{{.Address}}</a> {{.Value}}</div>{{end}}</td></tr>{{end}}
+{{if .IsSQLCommand}}<tr><th>SQL</th><td><pre>{{.SQL}}</pre></td></tr>
+<tr><th>Rollback</th><td><pre>{{.SQLRollback}}</pre></td></tr>
+<tr><th>Reference</th><td class="mono">{{.SQLReference}}</td></tr>
+{{if .SQLBaseTX}}<tr><th>Base transaction</th><td class="mono"><a href="/explorer/tx/{{.SQLBaseTX}}">{{.SQLBaseTX}}</a></td></tr>{{end}}{{end}}
+</table>{{end}}
+
+{{define "block"}}{{template "header"}}
+<h2>Block {{.Height}}</h2>
+<table>
+<tr><th>Hash</th><td class="mono">{{.Hash}}</td></tr>
+<tr><th>Previous</th><td class="mono">{{if .PrevHash}}<a href="/explorer/block/{{.PrevHash}}">{{.PrevHash}}</a>{{end}}</td></tr>
+<tr><th>Time</th><td>{{blocktime .Time}}</td></tr>
+<tr><th>Nonce</th><td>{{.Nonce}}</td></tr>
+<tr><th>Confirmations</th><td>{{.Confirmations}}</td></tr>
+</table>
+<h3>Transactions</h3>
+{{range .Transactions}}{{template "transaction" .}}{{end}}
+{{template "footer"}}{{end}}
+
+{{define "tx"}}{{template "header"}}
+<h2>Transaction</h2>
+{{if .Pending}}<p>The transaction is in the pool, it is not in a block yet</p>
+{{else}}<p>Block <a class="mono" href="/explorer/block/{{.BlockHash}}">{{.BlockHeight}}</a>. Confirmations: {{.Confirmations}}</p>{{end}}
+{{template "transaction" .TX}}
+{{template "footer"}}{{end}}
+
+{{define "address"}}{{template "header"}}
+<h2 class="mono">{{.Address}}</h2>
+<p>Balance: {{.Balance.Total}} (approved {{.Balance.Approved}}, pending {{.Balance.Pending}})</p>
+<table>
+<tr><th>Transaction</th><th>Direction</th><th>Amount</th><th>From</th><th>To</th></tr>
+{{range .History}}<tr><td class="mono"><a href="/explorer/tx/{{.TXID}}">{{short .TXID}}</a></td><td>{{if .Out}}out{{else}}in{{end}}</td><td>{{.Amount}}</td>
+<td class="mono">{{if eq .From "Coin base"}}{{.From}}{{else}}<a href="/explorer/address/{{.From}}">{{.From}}</a>{{end}}</td><td class="mono"><a href="/explorer/address/{{.To}}">{{.To}}</a></td></tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+`
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/structures"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplorerPages(t *testing.T) {
+	s := &NodeServer{}
+	s.Logger = utils.CreateLogger()
+
+	e, err := newExplorer(s)
+	assert.NoError(t, err)
+
+	tx := &structures.Transaction{}
+	tx.ID = []byte{1, 2}
+	tx.SQLCommand.Query = []byte("INSERT INTO t VALUES ('<b>')")
+
+	render := func(name string, data interface{}) string {
+		w := httptest.NewRecorder()
+		e.render(w, http.StatusOK, name, data)
+		assert.Equal(t, http.StatusOK, w.Code, name)
+		return w.Body.String()
+	}
+
+	body := render("index", explorerIndexPage{Height: 3, Tip: "aa", Blocks: []explorerBlockRow{{"aa", 3, 0, 1}}, Older: "bb"})
+	assert.Contains(t, body, "/explorer/?from=bb")
+
+	body = render("block", explorerBlockPage{Hash: "aa", Height: 3, Transactions: []apiTransaction{getAPITransaction(tx)}})
+	assert.Contains(t, body, "/explorer/tx/0102")
+	// SQL is escaped
+	assert.Contains(t, body, "&lt;b&gt;")
+
+	body = render("tx", explorerTXPage{TX: getAPITransaction(tx), Pending: true})
+	assert.Contains(t, body, "in the pool")
+
+	body = render("address", explorerAddressPage{Address: "addr", History: []apiHistoryRecord{{"0102", false, 5, "Coin base", "addr"}}})
+	assert.True(t, strings.Contains(body, "Coin base") && !strings.Contains(body, "/explorer/address/Coin base"))
+}
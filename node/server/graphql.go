@@ -0,0 +1,723 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	netlib "github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/remoteclient"
+	"github.com/gelembjuk/oursql/node/blockchain"
+	"github.com/gelembjuk/oursql/node/nodemanager"
+	"github.com/gelembjuk/oursql/node/structures"
+	"github.com/graphql-go/graphql"
+)
+
+// GraphQL endpoint for reports over chain data. Queries are read-only, resolvers read blocks
+// and indexes directly. Lists of blocks and transactions are paginated with cursors,
+// a cursor is a hash of last block in a page. Schema is described in docs/HTTPAPI.md
+const (
+	graphqlDefaultPageSize = 20
+	graphqlMaxPageSize     = 100
+	graphqlMaxRequestSize  = 64 * 1024
+)
+
+type graphqlContextKey int
+
+const graphqlNodeKey graphqlContextKey = 0
+
+// Request in GraphQL over HTTP format. JSON keys are query, operationName and variables
+type graphqlRequest struct {
+	Query         string
+	OperationName string
+	Variables     map[string]interface{}
+}
+
+type graphqlBlock struct {
+	Hash              string
+	PrevHash          string
+	Height            int
+	Time              int64
+	Nonce             int
+	TransactionsCount int
+	block             *structures.Block
+}
+
+type graphqlTransaction struct {
+	ID           string
+	Time         int64
+	From         string
+	Inputs       []apiTXInput
+	Outputs      []apiTXOutput
+	SQL          string
+	SQLRollback  string
+	SQLReference string
+	SQLBaseTX    string
+	Table        string
+	SQLKind      string
+	IsCoinbase   bool
+	IsSQLCommand bool
+	BlockHash    string
+	BlockHeight  int
+	Pending      bool
+}
+
+type graphqlBlocksPage struct {
+	Blocks      []graphqlBlock
+	EndCursor   string
+	HasNextPage bool
+}
+
+type graphqlTransactionsPage struct {
+	Transactions []graphqlTransaction
+	EndCursor    string
+	HasNextPage  bool
+}
+
+type graphqlAddress struct {
+	Address string
+	Balance remoteclient.WalletBalance
+	History []apiHistoryRecord
+}
+
+// Filter of transactions. Empty values mean any
+type graphqlTXFilter struct {
+	Table   string
+	Kind    string
+	Address string
+	Signer  string
+	SQLOnly bool
+}
+
+// Range of blocks to walk. Blocks are read from the top or from a block before a cursor
+type graphqlBlocksRange struct {
+	After      string
+	First      int
+	FromHeight int
+	ToHeight   int // -1 means the top
+}
+
+// GET /api/v1/graphql?query=... or POST /api/v1/graphql with JSON body
+func (a *httpAPI) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	request := graphqlRequest{}
+
+	switch r.Method {
+	case http.MethodGet:
+		request.Query = r.URL.Query().Get("query")
+		request.OperationName = r.URL.Query().Get("operationName")
+
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &request.Variables); err != nil {
+				a.writeError(w, http.StatusBadRequest, errors.New("Wrong variables"))
+				return
+			}
+		}
+	case http.MethodPost:
+		body, err := netlib.ReadAllLimited(r.Body, graphqlMaxRequestSize)
+
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := json.Unmarshal(body, &request); err != nil {
+			a.writeError(w, http.StatusBadRequest, errors.New("Wrong GraphQL request"))
+			return
+		}
+	default:
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("Only GET and POST requests are supported"))
+		return
+	}
+
+	if !a.checkAuth(w, r, false) {
+		return
+	}
+
+	if err := a.S.RateLimiter.AllowRequest(getHTTPRequestIP(r), "graphql", len(request.Query)); err != nil {
+		a.writeError(w, http.StatusTooManyRequests, err)
+		return
+	}
+
+	if request.Query == "" {
+		a.writeError(w, http.StatusBadRequest, errors.New("Query is empty"))
+		return
+	}
+
+	node, err := a.S.getRequestNode()
+
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer node.DBConn.CloseConnection()
+
+	result := graphql.Do(graphql.Params{
+		Schema:         a.graphqlSchema,
+		RequestString:  request.Query,
+		OperationName:  request.OperationName,
+		VariableValues: request.Variables,
+		Context:        context.WithValue(r.Context(), graphqlNodeKey, node),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		a.logger.Trace.Printf("GraphQL response error: %s", err.Error())
+	}
+}
+
+func getGraphQLNode(p graphql.ResolveParams) *nodemanager.Node {
+	return p.Context.Value(graphqlNodeKey).(*nodemanager.Node)
+}
+
+func getGraphQLBlock(block *structures.Block) graphqlBlock {
+	return graphqlBlock{
+		Hash:              hex.EncodeToString(block.Hash),
+		PrevHash:          hex.EncodeToString(block.PrevBlockHash),
+		Height:            block.Height,
+		Time:              block.Timestamp,
+		Nonce:             block.Nonce,
+		TransactionsCount: len(block.Transactions),
+		block:             block,
+	}
+}
+
+// block is nil for transactions from the pool
+func getGraphQLTransaction(tx *structures.Transaction, block *structures.Block) graphqlTransaction {
+	v := getAPITransaction(tx)
+
+	t := graphqlTransaction{
+		ID:           v.ID,
+		Time:         v.Time,
+		From:         v.From,
+		Inputs:       v.Inputs,
+		Outputs:      v.Outputs,
+		SQL:          v.SQL,
+		SQLRollback:  v.SQLRollback,
+		SQLReference: v.SQLReference,
+		SQLBaseTX:    v.SQLBaseTX,
+		IsCoinbase:   v.IsCoinbase,
+		IsSQLCommand: v.IsSQLCommand,
+	}
+
+	if t.IsSQLCommand {
+		t.Table, t.SQLKind = nodemanager.GetSQLTableAndKind(tx)
+	}
+
+	if block == nil {
+		t.Pending = true
+	} else {
+		t.BlockHash = hex.EncodeToString(block.Hash)
+		t.BlockHeight = block.Height
+	}
+	return t
+}
+
+func (f graphqlTXFilter) Match(t graphqlTransaction) bool {
+	if f.SQLOnly && !t.IsSQLCommand {
+		return false
+	}
+	if f.Table != "" && !strings.EqualFold(f.Table, t.Table) {
+		return false
+	}
+	if f.Kind != "" && !strings.EqualFold(f.Kind, t.SQLKind) {
+		return false
+	}
+	if f.Signer != "" && t.From != f.Signer {
+		return false
+	}
+	if f.Address == "" || t.From == f.Address {
+		return true
+	}
+	for _, out := range t.Outputs {
+		if out.Address == f.Address {
+			return true
+		}
+	}
+	return false
+}
+
+func getGraphQLTXFilter(args map[string]interface{}) graphqlTXFilter {
+	f := graphqlTXFilter{}
+	f.Table, _ = args["table"].(string)
+	f.Kind, _ = args["kind"].(string)
+	f.Address, _ = args["address"].(string)
+	f.Signer, _ = args["signer"].(string)
+	f.SQLOnly, _ = args["sqlOnly"].(bool)
+	return f
+}
+
+func getGraphQLBlocksRange(args map[string]interface{}) graphqlBlocksRange {
+	r := graphqlBlocksRange{First: graphqlDefaultPageSize, ToHeight: -1}
+	r.After, _ = args["after"].(string)
+
+	if v, ok := args["first"].(int); ok && v > 0 {
+		r.First = v
+	}
+	if r.First > graphqlMaxPageSize {
+		r.First = graphqlMaxPageSize
+	}
+	if v, ok := args["fromHeight"].(int); ok {
+		r.FromHeight = v
+	}
+	if v, ok := args["toHeight"].(int); ok {
+		r.ToHeight = v
+	}
+	return r
+}
+
+// Walk blocks of primary chain from the top down. callback returns true when a page is full.
+// Returns a cursor of last walked block and if there are more blocks in a range
+func walkGraphQLBlocks(node *nodemanager.Node, r graphqlBlocksRange, callback func(block *structures.Block) bool) (string, bool, error) {
+	var bci *blockchain.BlockchainIterator
+	var err error
+
+	if r.After != "" {
+		hash, herr := hex.DecodeString(r.After)
+
+		if herr != nil {
+			return "", false, errors.New("Wrong cursor")
+		}
+
+		block, berr := node.NodeBC.GetBlock(hash)
+
+		if berr != nil {
+			return "", false, errors.New("Cursor block is not found")
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			return "", false, nil
+		}
+		bci, err = blockchain.NewBlockchainIteratorFrom(node.DBConn.DB(), block.PrevBlockHash)
+	} else {
+		bci, err = blockchain.NewBlockchainIterator(node.DBConn.DB())
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	for {
+		block, err := bci.Next()
+
+		if err != nil {
+			return "", false, err
+		}
+
+		if block.Height < r.FromHeight {
+			return "", false, nil
+		}
+
+		if r.ToHeight < 0 || block.Height <= r.ToHeight {
+			if callback(block) {
+				more := len(block.PrevBlockHash) > 0 && block.Height > r.FromHeight
+
+				return hex.EncodeToString(block.Hash), more, nil
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			return "", false, nil
+		}
+	}
+}
+
+func resolveGraphQLBlock(p graphql.ResolveParams) (interface{}, error) {
+	node := getGraphQLNode(p)
+
+	if height, ok := p.Args["height"].(int); ok {
+		block, err := node.NodeBC.GetBCManager().GetBlockAtHeight(height)
+
+		if err != nil {
+			// no such height
+			return nil, nil
+		}
+		return getGraphQLBlock(block), nil
+	}
+
+	h, ok := p.Args["hash"].(string)
+
+	if !ok {
+		return nil, errors.New("Hash or height is required")
+	}
+
+	hash, err := hex.DecodeString(h)
+
+	if err != nil {
+		return nil, errors.New("Wrong block hash")
+	}
+
+	exists, err := node.NodeBC.CheckBlockExists(hash)
+
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	block, err := node.NodeBC.GetBlock(hash)
+
+	if err != nil {
+		return nil, err
+	}
+	return getGraphQLBlock(block), nil
+}
+
+func resolveGraphQLBlocks(p graphql.ResolveParams) (interface{}, error) {
+	r := getGraphQLBlocksRange(p.Args)
+	page := graphqlBlocksPage{Blocks: []graphqlBlock{}}
+
+	var err error
+
+	page.EndCursor, page.HasNextPage, err = walkGraphQLBlocks(getGraphQLNode(p), r, func(block *structures.Block) bool {
+		page.Blocks = append(page.Blocks, getGraphQLBlock(block))
+		return len(page.Blocks) >= r.First
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Transactions are added by blocks. A page can have more than first transactions, all matching
+// transactions of last block are in a page, so a next page starts from next block
+func resolveGraphQLTransactions(p graphql.ResolveParams) (interface{}, error) {
+	r := getGraphQLBlocksRange(p.Args)
+	filter := getGraphQLTXFilter(p.Args)
+	page := graphqlTransactionsPage{Transactions: []graphqlTransaction{}}
+
+	var err error
+
+	page.EndCursor, page.HasNextPage, err = walkGraphQLBlocks(getGraphQLNode(p), r, func(block *structures.Block) bool {
+		page.Transactions = append(page.Transactions, filterGraphQLTransactions(block, filter)...)
+		return len(page.Transactions) >= r.First
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func filterGraphQLTransactions(block *structures.Block, filter graphqlTXFilter) []graphqlTransaction {
+	list := []graphqlTransaction{}
+
+	for i := range block.Transactions {
+		t := getGraphQLTransaction(&block.Transactions[i], block)
+
+		if filter.Match(t) {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+// Find a transaction in the pool or in primary chain
+func getGraphQLTransactionByID(node *nodemanager.Node, txID []byte) (interface{}, error) {
+	tm := node.GetTransactionsManager()
+
+	tx, err := tm.GetIfExists(txID)
+
+	if err != nil || tx == nil {
+		return nil, err
+	}
+
+	blockHash, err := tm.GetTransactionBlock(txID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if blockHash == nil {
+		return getGraphQLTransaction(tx, nil), nil
+	}
+
+	block, err := node.NodeBC.GetBlock(blockHash)
+
+	if err != nil {
+		return nil, err
+	}
+	return getGraphQLTransaction(tx, block), nil
+}
+
+func resolveGraphQLTransaction(p graphql.ResolveParams) (interface{}, error) {
+	txID, err := hex.DecodeString(p.Args["id"].(string))
+
+	if err != nil || len(txID) == 0 {
+		return nil, errors.New("Wrong transaction ID")
+	}
+	return getGraphQLTransactionByID(getGraphQLNode(p), txID)
+}
+
+// Last transaction in primary chain which changed a row
+func resolveGraphQLRow(p graphql.ResolveParams) (interface{}, error) {
+	node := getGraphQLNode(p)
+
+	txID, err := node.GetTransactionsManager().GetTransactionForRow([]byte(p.Args["refID"].(string)))
+
+	if err != nil || len(txID) == 0 {
+		return nil, err
+	}
+	return getGraphQLTransactionByID(node, txID)
+}
+
+// All changes of a row in primary chain, last change first
+func resolveGraphQLRowHistory(p graphql.ResolveParams) (interface{}, error) {
+	history, err := getGraphQLNode(p).GetTransactionsManager().GetRowHistory(p.Args["table"].(string), p.Args["key"].(string))
+
+	if err != nil {
+		return nil, err
+	}
+
+	list := []apiRowChange{}
+
+	for _, c := range history {
+		list = append(list, getAPIRowChange(nodeclient.RowChange(c)))
+	}
+	return list, nil
+}
+
+func resolveGraphQLAddress(p graphql.ResolveParams) (interface{}, error) {
+	address := p.Args["address"].(string)
+
+	if !(remoteclient.Wallet{}).ValidateAddress(address) {
+		return nil, errors.New("Address is not valid")
+	}
+
+	node := getGraphQLNode(p)
+
+	a := graphqlAddress{Address: address}
+
+	var err error
+	a.Balance, err = node.GetTransactionsManager().GetAddressBalance(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := node.NodeBC.GetAddressHistory(address)
+
+	if err != nil {
+		return nil, err
+	}
+	a.History = getAPIAddressHistory(address, history)
+
+	return a, nil
+}
+
+func resolveGraphQLNodes(p graphql.ResolveParams) (interface{}, error) {
+	return getGraphQLNode(p).NodeNet.GetNodes(), nil
+}
+
+// Arguments of lists of blocks
+func getGraphQLRangeArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"first":      &graphql.ArgumentConfig{Type: graphql.Int, Description: "Max number of items, 100 at most"},
+		"after":      &graphql.ArgumentConfig{Type: graphql.String, Description: "Cursor from previous page"},
+		"fromHeight": &graphql.ArgumentConfig{Type: graphql.Int},
+		"toHeight":   &graphql.ArgumentConfig{Type: graphql.Int},
+	}
+}
+
+func getGraphQLFilterArgs(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	args["table"] = &graphql.ArgumentConfig{Type: graphql.String}
+	args["kind"] = &graphql.ArgumentConfig{Type: graphql.String, Description: "insert, update, delete, create or drop"}
+	args["address"] = &graphql.ArgumentConfig{Type: graphql.String, Description: "Author or receiver of a transaction"}
+	args["signer"] = &graphql.ArgumentConfig{Type: graphql.String, Description: "Address which signed a transaction"}
+	args["sqlOnly"] = &graphql.ArgumentConfig{Type: graphql.Boolean}
+	return args
+}
+
+func newGraphQLSchema() (graphql.Schema, error) {
+	inputType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Input",
+		Fields: graphql.Fields{
+			"txID": &graphql.Field{Type: graphql.String},
+			"vout": &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	outputType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Output",
+		Fields: graphql.Fields{
+			"address": &graphql.Field{Type: graphql.String},
+			"value":   &graphql.Field{Type: graphql.Float},
+		},
+	})
+
+	transactionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Transaction",
+		Fields: graphql.Fields{
+			"id":           &graphql.Field{Type: graphql.String},
+			"time":         &graphql.Field{Type: graphql.Float, Description: "Nanoseconds"},
+			"from":         &graphql.Field{Type: graphql.String},
+			"inputs":       &graphql.Field{Type: graphql.NewList(inputType)},
+			"outputs":      &graphql.Field{Type: graphql.NewList(outputType)},
+			"sql":          &graphql.Field{Type: graphql.String},
+			"sqlRollback":  &graphql.Field{Type: graphql.String},
+			"sqlReference": &graphql.Field{Type: graphql.String, Description: "Reference ID of a row"},
+			"sqlBaseTX":    &graphql.Field{Type: graphql.String, Description: "Previous transaction which changed a row"},
+			"table":        &graphql.Field{Type: graphql.String},
+			"sqlKind":      &graphql.Field{Type: graphql.String},
+			"isCoinbase":   &graphql.Field{Type: graphql.Boolean},
+			"isSQLCommand": &graphql.Field{Type: graphql.Boolean},
+			"blockHash":    &graphql.Field{Type: graphql.String},
+			"blockHeight":  &graphql.Field{Type: graphql.Int},
+			"pending":      &graphql.Field{Type: graphql.Boolean, Description: "A transaction is in the pool"},
+		},
+	})
+
+	blockType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Block",
+		Fields: graphql.Fields{
+			"hash":              &graphql.Field{Type: graphql.String},
+			"prevHash":          &graphql.Field{Type: graphql.String},
+			"height":            &graphql.Field{Type: graphql.Int},
+			"time":              &graphql.Field{Type: graphql.Int, Description: "Seconds"},
+			"nonce":             &graphql.Field{Type: graphql.Int},
+			"transactionsCount": &graphql.Field{Type: graphql.Int},
+			"transactions": &graphql.Field{
+				Type: graphql.NewList(transactionType),
+				Args: getGraphQLFilterArgs(graphql.FieldConfigArgument{}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return filterGraphQLTransactions(p.Source.(graphqlBlock).block, getGraphQLTXFilter(p.Args)), nil
+				},
+			},
+		},
+	})
+
+	blocksPageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "BlocksPage",
+		Fields: graphql.Fields{
+			"blocks":      &graphql.Field{Type: graphql.NewList(blockType)},
+			"endCursor":   &graphql.Field{Type: graphql.String},
+			"hasNextPage": &graphql.Field{Type: graphql.Boolean},
+		},
+	})
+
+	transactionsPageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TransactionsPage",
+		Fields: graphql.Fields{
+			"transactions": &graphql.Field{Type: graphql.NewList(transactionType)},
+			"endCursor":    &graphql.Field{Type: graphql.String},
+			"hasNextPage":  &graphql.Field{Type: graphql.Boolean},
+		},
+	})
+
+	balanceType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Balance",
+		Fields: graphql.Fields{
+			"total":    &graphql.Field{Type: graphql.Float},
+			"approved": &graphql.Field{Type: graphql.Float},
+			"pending":  &graphql.Field{Type: graphql.Float},
+		},
+	})
+
+	historyType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "HistoryRecord",
+		Fields: graphql.Fields{
+			"txID":   &graphql.Field{Type: graphql.String},
+			"out":    &graphql.Field{Type: graphql.Boolean},
+			"amount": &graphql.Field{Type: graphql.Float},
+			"from":   &graphql.Field{Type: graphql.String},
+			"to":     &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	addressType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Address",
+		Fields: graphql.Fields{
+			"address": &graphql.Field{Type: graphql.String},
+			"balance": &graphql.Field{Type: balanceType},
+			"history": &graphql.Field{Type: graphql.NewList(historyType)},
+		},
+	})
+
+	rowChangeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "RowChange",
+		Fields: graphql.Fields{
+			"txID":        &graphql.Field{Type: graphql.String},
+			"kind":        &graphql.Field{Type: graphql.String},
+			"blockHash":   &graphql.Field{Type: graphql.String},
+			"height":      &graphql.Field{Type: graphql.Int},
+			"blockTime":   &graphql.Field{Type: graphql.Int, Description: "Seconds"},
+			"txTime":      &graphql.Field{Type: graphql.Float, Description: "Nanoseconds"},
+			"signer":      &graphql.Field{Type: graphql.String},
+			"sql":         &graphql.Field{Type: graphql.String},
+			"sqlRollback": &graphql.Field{Type: graphql.String, Description: "Returns a row to the state before the change"},
+			"sqlBaseTX":   &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	nodeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Node",
+		Fields: graphql.Fields{
+			"host": &graphql.Field{Type: graphql.String},
+			"port": &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"block": &graphql.Field{
+				Type: blockType,
+				Args: graphql.FieldConfigArgument{
+					"hash":   &graphql.ArgumentConfig{Type: graphql.String},
+					"height": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: resolveGraphQLBlock,
+			},
+			"blocks": &graphql.Field{
+				Type:    blocksPageType,
+				Args:    getGraphQLRangeArgs(),
+				Resolve: resolveGraphQLBlocks,
+			},
+			"transaction": &graphql.Field{
+				Type: transactionType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: resolveGraphQLTransaction,
+			},
+			"transactions": &graphql.Field{
+				Type:    transactionsPageType,
+				Args:    getGraphQLFilterArgs(getGraphQLRangeArgs()),
+				Resolve: resolveGraphQLTransactions,
+			},
+			"row": &graphql.Field{
+				Type:        transactionType,
+				Description: "Last transaction which changed a row",
+				Args: graphql.FieldConfigArgument{
+					"refID": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: resolveGraphQLRow,
+			},
+			"rowHistory": &graphql.Field{
+				Type:        graphql.NewList(rowChangeType),
+				Description: "All changes of a row, last change first",
+				Args: graphql.FieldConfigArgument{
+					"table": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"key":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: resolveGraphQLRowHistory,
+			},
+			"address": &graphql.Field{
+				Type: addressType,
+				Args: graphql.FieldConfigArgument{
+					"address": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: resolveGraphQLAddress,
+			},
+			"nodes": &graphql.Field{
+				Type:    graphql.NewList(nodeType),
+				Resolve: resolveGraphQLNodes,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/node/nodemanager"
+	"github.com/gelembjuk/oursql/node/structures"
+	"github.com/graphql-go/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQLSchema(t *testing.T) {
+	schema, err := newGraphQLSchema()
+	assert.NoError(t, err)
+
+	node := &nodemanager.Node{}
+	node.NodeNet.Init()
+	node.NodeNet.SetNodes([]net.NodeAddr{{Host: "host1", Port: 8765}}, true)
+
+	result := graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: "{ nodes { host port } }",
+		Context:       context.WithValue(context.Background(), graphqlNodeKey, node),
+	})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"nodes": []interface{}{map[string]interface{}{"host": "host1", "port": 8765}}}, result.Data)
+
+	result = graphql.Do(graphql.Params{Schema: schema, RequestString: "{ transactions(first: 5) { wrongField } }"})
+	assert.NotEmpty(t, result.Errors)
+}
+
+func TestGraphQLTransactionsFilter(t *testing.T) {
+	block := &structures.Block{Hash: []byte{1}, Height: 7}
+
+	tx := structures.Transaction{}
+	tx.ID = []byte{1}
+	tx.SQLCommand.Query = []byte("DELETE FROM members WHERE id=1")
+	tx.Vout = []structures.TXCurrrencyOutput{}
+	block.Transactions = append(block.Transactions, tx)
+
+	tx = structures.Transaction{}
+	tx.ID = []byte{2}
+	tx.SQLCommand.Query = []byte("INSERT INTO orders SET id=1")
+	block.Transactions = append(block.Transactions, tx)
+
+	list := filterGraphQLTransactions(block, graphqlTXFilter{Table: "members"})
+	assert.Len(t, list, 1)
+	assert.Equal(t, "01", list[0].ID)
+	assert.Equal(t, "delete", list[0].SQLKind)
+	assert.Equal(t, 7, list[0].BlockHeight)
+	assert.False(t, list[0].Pending)
+
+	assert.Len(t, filterGraphQLTransactions(block, graphqlTXFilter{Kind: "insert"}), 1)
+	assert.Len(t, filterGraphQLTransactions(block, graphqlTXFilter{SQLOnly: true}), 2)
+	assert.Len(t, filterGraphQLTransactions(block, graphqlTXFilter{Address: "addr"}), 0)
+	assert.Len(t, filterGraphQLTransactions(block, graphqlTXFilter{Signer: "addr"}), 0)
+
+	r := getGraphQLBlocksRange(map[string]interface{}{"first": 1000, "fromHeight": 100})
+	assert.Equal(t, graphqlMaxPageSize, r.First)
+	assert.Equal(t, 100, r.FromHeight)
+	assert.Equal(t, -1, r.ToHeight)
+}
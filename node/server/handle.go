@@ -2,7 +2,6 @@ package server
 
 import (
 	"bytes"
-	"encoding/gob"
 	"errors"
 	"fmt"
 
@@ -17,6 +16,9 @@ import (
 	"github.com/gelembjuk/oursql/node/transactions"
 )
 
+// max count of headers returned on one request
+const maxHeadersInResponse = 2000
+
 type NodeServerRequest struct {
 	Node              *nodemanager.Node
 	S                 *NodeServer
@@ -27,6 +29,8 @@ type NodeServerRequest struct {
 	Response          []byte
 	NodeAuthStrIsGood bool
 	SessID            string
+	PeerIdentity      string        // identity of a node connected with TLS. Empty for plain connections
+	Format            requestFormat // format and codec of a request. Response is encoded same way
 }
 
 func (s *NodeServerRequest) Init() {
@@ -36,19 +40,37 @@ func (s *NodeServerRequest) Init() {
 
 // Reads and parses request from network data
 func (s *NodeServerRequest) parseRequestData(payload interface{}) error {
-	var buff bytes.Buffer
-
-	buff.Write(s.Request)
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(payload)
+	err := net.DecodePayload(s.Format.Codec, s.Request, payload)
 
 	if err != nil {
+		s.reportMisbehavior(net.PenaltyMalformedMessage, "malformed request data")
 		return errors.New("Parse request: " + err.Error())
 	}
 
 	return nil
 }
 
+// Encodes response data with codec of a request
+func (s *NodeServerRequest) encodeResponse(data interface{}) ([]byte, error) {
+	return net.EncodePayload(s.Format.Codec, data)
+}
+
+// Decrease score of a host which sent the request
+func (s *NodeServerRequest) reportMisbehavior(penalty int, reason string) {
+	s.S.Node.NodeNet.ReportPeerMisbehavior(s.RequestIP, penalty, reason)
+}
+
+// If identity of a node is pinned, requests on behalf of the node are accepted only
+// from a connection authenticated with same identity
+func (s *NodeServerRequest) checkPeerIdentity(addr net.NodeAddr) error {
+	pinned := s.S.Node.NodeNet.GetNodeIdentity(addr)
+
+	if pinned == "" || pinned == s.PeerIdentity {
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Request on behalf of %s is rejected. Node identity doesn't match", addr.NodeAddrToString()))
+}
+
 // Find and return the list of unspent transactions
 func (s *NodeServerRequest) handleGetUnspent() error {
 	s.HasResponse = true
@@ -86,7 +108,7 @@ func (s *NodeServerRequest) handleGetUnspent() error {
 		return err
 	}
 
-	s.Response, err = net.GobEncode(result)
+	s.Response, err = s.encodeResponse(result)
 
 	if err != nil {
 		return err
@@ -129,7 +151,7 @@ func (s *NodeServerRequest) handleGetHistory() error {
 		result = append(result, ut)
 	}
 
-	s.Response, err = net.GobEncode(result)
+	s.Response, err = s.encodeResponse(result)
 
 	if err != nil {
 		return err
@@ -162,7 +184,7 @@ func (s *NodeServerRequest) handleGetBalance() error {
 	balance.Approved = balancen.Approved
 	balance.Pending = balancen.Pending
 
-	s.Response, err = net.GobEncode(balance)
+	s.Response, err = s.encodeResponse(balance)
 
 	if err != nil {
 		return err
@@ -197,7 +219,7 @@ func (s *NodeServerRequest) handleTxData() error {
 
 	s.S.blocksMakerObj.NewTransaction(TX.GetID())
 
-	s.Response, err = net.GobEncode(TX.GetID())
+	s.Response, err = s.encodeResponse(TX.GetID())
 
 	if err != nil {
 		return errors.New(fmt.Sprintf("TXFull Response Error: %s", err.Error()))
@@ -237,7 +259,7 @@ func (s *NodeServerRequest) handleGetTransaction() error {
 		return err
 	}
 
-	s.Response, err = net.GobEncode(result)
+	s.Response, err = s.encodeResponse(result)
 
 	if err != nil {
 		return err
@@ -277,7 +299,7 @@ func (s *NodeServerRequest) handleCheckBlock() error {
 
 	result.Exists = blockstate != 0
 
-	s.Response, err = net.GobEncode(result)
+	s.Response, err = s.encodeResponse(result)
 
 	if err != nil {
 		return err
@@ -312,7 +334,7 @@ func (s *NodeServerRequest) handleTxCurRequest() error {
 	result.DataToSign = DataToSign
 	result.TX = TXBytes
 
-	s.Response, err = net.GobEncode(result)
+	s.Response, err = s.encodeResponse(result)
 
 	if err != nil {
 		return err
@@ -362,7 +384,7 @@ func (s *NodeServerRequest) handleTxSQLRequest() error {
 	result.DataToSign = DataToSign
 	result.TX = TXBytes
 
-	s.Response, err = net.GobEncode(result)
+	s.Response, err = s.encodeResponse(result)
 
 	if err != nil {
 		return err
@@ -398,7 +420,7 @@ func (s *NodeServerRequest) handleGetFirstBlocks() error {
 		result.Blocks = append(result.Blocks, blockdata)
 	}
 
-	s.Response, err = net.GobEncode(result)
+	s.Response, err = s.encodeResponse(result)
 
 	if err != nil {
 		return err
@@ -413,23 +435,31 @@ func (s *NodeServerRequest) handleGetFirstBlocks() error {
 func (s *NodeServerRequest) handleGetConsensusData() error {
 	s.HasResponse = true
 
-	result := nodeclient.ComGetConsensusData{}
-
-	var err error
-
-	appname := s.Node.ConsensusConfig.Application.Name
+	var payload nodeclient.ComConsensusDataRequest
 
-	if appname == "" {
-		appname = "UnnamedApp"
+	if len(s.Request) > 0 {
+		// old nodes send nothing
+		if err := s.parseRequestData(&payload); err != nil {
+			return err
+		}
 	}
 
-	result.ConfigFile, err = s.Node.ConsensusConfig.Export("own", appname, s.S.NodeAddress.NodeAddrToString())
+	result, err := s.getConsensusData()
 
 	if err != nil {
 		return err
 	}
 
-	s.Response, err = net.GobEncode(result)
+	result.ConfigFileSize = len(result.ConfigFile)
+	result.ModuleSize = len(result.Module)
+
+	if payload.ChunkSize > 0 {
+		// rest of data will be requested with getchunk
+		result.ConfigFile, _ = net.GetDataChunk(result.ConfigFile, 0, payload.ChunkSize)
+		result.Module, _ = net.GetDataChunk(result.Module, 0, payload.ChunkSize)
+	}
+
+	s.Response, err = s.encodeResponse(result)
 
 	if err != nil {
 		return err
@@ -439,6 +469,28 @@ func (s *NodeServerRequest) handleGetConsensusData() error {
 	return nil
 }
 
+// Returns consensus config and module of this node
+func (s *NodeServerRequest) getConsensusData() (nodeclient.ComGetConsensusData, error) {
+	result := nodeclient.ComGetConsensusData{}
+
+	var err error
+
+	appname := s.Node.ConsensusConfig.Application.Name
+
+	if appname == "" {
+		appname = "UnnamedApp"
+	}
+
+	result.ConfigFile, err = s.Node.ConsensusConfig.Export("own", appname, s.S.GetNodeAddress().NodeAddrToString())
+
+	if err != nil {
+		return result, err
+	}
+	result.Module = []byte{}
+
+	return result, nil
+}
+
 // Received the lst of nodes from some other node. add missed nodes to own nodes list
 
 func (s *NodeServerRequest) handleAddr() error {
@@ -451,13 +503,34 @@ func (s *NodeServerRequest) handleAddr() error {
 		return err
 	}
 
+	if err := s.checkPeerIdentity(payload.AddrFrom); err != nil {
+		return err
+	}
+
 	s.Node.CheckAddressKnown(payload.AddrFrom)
 
 	addednodes := []net.NodeAddr{}
+	timed := []net.TimedNodeAddr{}
 
 	//s.Logger.Trace.Printf("SessID: %s . Received nodes %s", s.SessID, payload)
 
 	for _, node := range payload.Addresses {
+		if !node.AcceptsConnections() {
+			continue
+		}
+		// identities are pinned only on own connection to a node
+		node.Identity = ""
+		// codec is negotiated with a node directly
+		node.Codec = ""
+
+		timed = append(timed, net.TimedNodeAddr{Host: node.Host, Port: node.Port})
+
+		// when there are enough known nodes, new ones go only to the address book.
+		// discovery will try them later
+		if s.S.Node.NodeNet.GetCountOfKnownNodes() >= net.DiscoveryTargetNodes {
+			continue
+		}
+
 		//s.Logger.Trace.Printf("SessID: %s . node %s", s.SessID, node.NodeAddrToString())
 		if s.S.Node.NodeNet.AddNodeToKnown(node) {
 			addednodes = append(addednodes, node)
@@ -465,6 +538,8 @@ func (s *NodeServerRequest) handleAddr() error {
 		}
 	}
 
+	s.Node.NodeNet.AddAddresses(timed, s.RequestIP)
+
 	//s.Logger.Trace.Printf("SessID: %s . There are %d known nodes now!", s.SessID, len(s.Node.NodeNet.Nodes))
 	//s.Logger.Trace.Printf("SessID: %s . Send version to %d new nodes", s.SessID, len(addednodes))
 
@@ -487,10 +562,18 @@ func (s *NodeServerRequest) handleBlock() error {
 		return err
 	}
 
+	if err := s.checkPeerIdentity(payload.AddrFrom); err != nil {
+		return err
+	}
+
 	blockstate, addstate, block, err := s.Node.ReceivedFullBlockFromOtherNode(payload.Block)
 	s.Logger.Trace.Printf("adding new block %d, %d", blockstate, addstate)
 	// state of this adding we don't check. not interesting in this place
 	if err != nil {
+		if blockstate == -1 && consensus.IsBlockVerifyError(err) {
+			// block can not be parsed or breaks consensus rules. Local DB errors are not a fault of other node
+			s.reportMisbehavior(net.PenaltyInvalidBlock, "invalid block: "+err.Error())
+		}
 		return err
 	}
 
@@ -566,6 +649,10 @@ func (s *NodeServerRequest) handleInv() error {
 		return err
 	}
 
+	if err := s.checkPeerIdentity(payload.AddrFrom); err != nil {
+		return err
+	}
+
 	s.Logger.Trace.Printf("SessID: %s . Recevied inventory with %d %s\n", s.SessID, len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
@@ -731,7 +818,14 @@ func (s *NodeServerRequest) handleGetBlock() error {
 		return err
 	}
 
-	s.Response, err = net.GobEncode(result)
+	result.Size = len(result.Block)
+
+	if payload.ChunkSize > 0 {
+		// big block is returned in chunks
+		result.Block, _ = net.GetDataChunk(result.Block, 0, payload.ChunkSize)
+	}
+
+	s.Response, err = s.encodeResponse(result)
 
 	if err != nil {
 		return err
@@ -740,6 +834,266 @@ func (s *NodeServerRequest) handleGetBlock() error {
 	return nil
 }
 
+// Returns part of big data. Blocks and consensus data are loaded in chunks
+func (s *NodeServerRequest) handleGetChunk() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetChunk
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+
+	switch payload.Kind {
+	case nodeclient.ChunkKindBlock:
+		block, err := s.Node.NodeBC.GetBlock(payload.ID)
+
+		if err != nil {
+			return err
+		}
+
+		data, err = block.Serialize()
+
+		if err != nil {
+			return err
+		}
+
+	case nodeclient.ChunkKindConfigFile, nodeclient.ChunkKindModule:
+		cdata, err := s.getConsensusData()
+
+		if err != nil {
+			return err
+		}
+
+		data = cdata.ConfigFile
+
+		if payload.Kind == nodeclient.ChunkKindModule {
+			data = cdata.Module
+		}
+
+	default:
+		return errors.New(fmt.Sprintf("Unknown kind of data %s", payload.Kind))
+	}
+
+	result := nodeclient.ResponseGetChunk{}
+	result.Size = len(data)
+	result.Data, err = net.GetDataChunk(data, payload.Offset, payload.Length)
+
+	if err != nil {
+		return err
+	}
+
+	s.Response, err = s.encodeResponse(result)
+
+	return err
+}
+
+// Returns addresses of other nodes from the address book. It is used for discovery
+func (s *NodeServerRequest) handleGetAddr() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetAddr
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	if payload.MaxCount <= 0 || payload.MaxCount > net.GetAddrMaxCount {
+		payload.MaxCount = net.GetAddrMaxCount
+	}
+
+	result := nodeclient.ResponseGetAddr{}
+	result.Addresses = []net.TimedNodeAddr{}
+
+	for _, a := range s.Node.NodeNet.GetAddressesToShare(payload.MaxCount + 1) {
+		// don't tell a node about itself
+		if a.Host == payload.AddrFrom.Host && a.Port == payload.AddrFrom.Port {
+			continue
+		}
+		result.Addresses = append(result.Addresses, a)
+	}
+
+	if len(result.Addresses) > payload.MaxCount {
+		result.Addresses = result.Addresses[:payload.MaxCount]
+	}
+
+	s.Logger.Trace.Printf("Return %d addresses to %s", len(result.Addresses), payload.AddrFrom.NodeAddrToString())
+
+	s.Response, err = s.encodeResponse(result)
+
+	return err
+}
+
+// Returns headers of blocks after given block. It is used for headers-first sync
+func (s *NodeServerRequest) handleGetHeaders() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetHeaders
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	if payload.MaxCount > maxHeadersInResponse {
+		payload.MaxCount = maxHeadersInResponse
+	}
+
+	headers, err := s.Node.NodeBC.GetBCManager().GetHeadersAfter(payload.StartFrom, payload.MaxCount)
+
+	if err != nil {
+		return err
+	}
+
+	result := nodeclient.ResponseGetHeaders{}
+	result.Headers = [][]byte{}
+
+	for _, h := range headers {
+		hdata, err := h.Serialize()
+
+		if err != nil {
+			return err
+		}
+		result.Headers = append(result.Headers, hdata)
+	}
+
+	result.Height, err = s.Node.NodeBC.GetBestHeight()
+
+	if err != nil {
+		return err
+	}
+
+	s.Logger.Trace.Printf("Return %d headers after %x", len(result.Headers), payload.StartFrom)
+
+	s.Response, err = s.encodeResponse(result)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Returns proof that a transaction is in a block of primary chain. Lite clients check it
+// against headers of blocks
+func (s *NodeServerRequest) handleGetMerkleProof() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetMerkleProof
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	blockHash, err := s.Node.GetTransactionsManager().GetTransactionBlock(payload.TransactionID)
+
+	if err != nil {
+		return err
+	}
+
+	result := nodeclient.ResponseGetMerkleProof{}
+
+	if len(blockHash) == 0 {
+		// transaction is pending or unknown. empty response
+		s.Response, err = s.encodeResponse(result)
+		return err
+	}
+
+	block, err := s.Node.NodeBC.GetBlock(blockHash)
+
+	if err != nil {
+		return err
+	}
+
+	result.BlockHash = block.Hash
+	result.PrevBlockHash = block.PrevBlockHash
+	result.Height = block.Height
+	result.MerkleRoot = block.MerkleRoot
+
+	result.Proof, err = block.GetMerkleProof(payload.TransactionID)
+
+	if err != nil {
+		return err
+	}
+
+	s.Response, err = s.encodeResponse(result)
+
+	return err
+}
+
+// Response with a state of a transaction and number of confirmations
+func (s *NodeServerRequest) handleGetTXStatus() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetTXStatus
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	status, err := s.Node.GetTransactionStatus(payload.TransactionID, payload.FinalDepth)
+
+	if err != nil {
+		return err
+	}
+
+	result := nodeclient.ResponseGetTXStatus{}
+	result.TXID = status.TXID
+	result.State = status.State
+	result.BlockHash = status.BlockHash
+	result.Height = status.Height
+	result.Confirmations = status.Confirmations
+	result.FinalDepth = status.FinalDepth
+	result.SideBlocks = status.SideBlocks
+	result.ConflictTX = status.ConflictTX
+	result.Reason = status.Reason
+
+	s.Response, err = s.encodeResponse(result)
+
+	return err
+}
+
+// Response with all changes of a row
+func (s *NodeServerRequest) handleGetRowHistory() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetRowHistory
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	history, err := s.Node.GetTransactionsManager().GetRowHistory(payload.Table, payload.Key)
+
+	if err != nil {
+		return err
+	}
+
+	result := nodeclient.ResponseGetRowHistory{}
+	result.Changes = []nodeclient.RowChange{}
+
+	for _, c := range history {
+		result.Changes = append(result.Changes, nodeclient.RowChange(c))
+	}
+
+	s.Response, err = s.encodeResponse(result)
+
+	return err
+}
+
 /*
 * Response on request to get full body of a block or transaction
  */
@@ -753,6 +1107,10 @@ func (s *NodeServerRequest) handleGetData() error {
 		return err
 	}
 
+	if err := s.checkPeerIdentity(payload.AddrFrom); err != nil {
+		return err
+	}
+
 	s.Logger.Trace.Printf("SessID: %s . Data Requested of type %s, id %x\n", s.SessID, payload.Type, payload.ID)
 
 	if payload.Type == "block" {
@@ -808,10 +1166,15 @@ func (s *NodeServerRequest) handleTx() error {
 		return err
 	}
 
+	if err := s.checkPeerIdentity(payload.AddFrom); err != nil {
+		return err
+	}
+
 	txData := payload.Transaction
 	tx, err := structures.DeserializeTransaction(txData)
 
 	if err != nil {
+		s.reportMisbehavior(net.PenaltyMalformedMessage, "malformed transaction")
 		return err
 	}
 
@@ -844,9 +1207,13 @@ func (s *NodeServerRequest) handleTx() error {
 					return nil*/
 
 				// TODO in future we can createsomethign more start here. Like, get TX with all previous TXs that are not approved yet
+
+				// missed input is not a fault of a sender
+				return err
 			}
 
 		}
+		s.reportMisbehavior(net.PenaltyInvalidTransaction, "invalid transaction: "+err.Error())
 		return err
 	}
 
@@ -920,7 +1287,7 @@ func (s *NodeServerRequest) handleGetUpdates() error {
 		s.Logger.TraceExt.Printf("   tx in pool: %x", tx)
 	}
 
-	s.Response, err = net.GobEncode(result)
+	s.Response, err = s.encodeResponse(result)
 
 	if err != nil {
 		return err
@@ -944,14 +1311,43 @@ func (s *NodeServerRequest) handleVersion() error {
 		return err
 	}
 
-	topHash, myBestHeight, err := s.Node.NodeBC.GetBCManager().GetState()
+	if payload.AddrFrom.Host == "localhost" {
+		payload.AddrFrom.Host = s.RequestIP
+	}
 
-	if err != nil {
+	if s.Format.Envelope {
+		// tell the node what address we see it from. Old nodes send requests in legacy format and don't wait for this
+		s.HasResponse = true
+
+		s.Response, err = s.encodeResponse(nodeclient.ResponseVersion{AddrYou: s.RequestIP})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.checkPeerIdentity(payload.AddrFrom); err != nil {
 		return err
 	}
 
-	if payload.AddrFrom.Host == "localhost" {
-		payload.AddrFrom.Host = s.RequestIP
+	if err := s.checkChainIdentity(payload); err != nil {
+		s.Logger.Error.Printf("Node %s is refused: %s", payload.AddrFrom.NodeAddrToString(), err.Error())
+
+		// forget the node and drop a persistent connection to it
+		s.S.Node.NodeNet.RemoveNodeFromKnown(payload.AddrFrom)
+
+		if c := s.S.Node.NodeNet.GetPeerConnection(payload.AddrFrom); c != nil {
+			c.Close()
+		}
+		// the host is banned, so other commands from it are dropped too
+		s.reportMisbehavior(net.PenaltyWrongChain, "refused in version: "+err.Error())
+		return err
+	}
+
+	topHash, myBestHeight, err := s.Node.NodeBC.GetBCManager().GetState()
+
+	if err != nil {
+		return err
 	}
 
 	s.Logger.Trace.Printf("Received version from %s. Their heigh %d, our heigh %d\n",
@@ -960,13 +1356,22 @@ func (s *NodeServerRequest) handleVersion() error {
 	foreignerBestHeight := payload.BestHeight
 
 	if myBestHeight < foreignerBestHeight {
-		s.Logger.Trace.Printf("Request blocks from %s\n", payload.AddrFrom.NodeAddrToString())
-
 		if foreignerBestHeight > s.S.Transit.MaxKnownHeigh {
 			s.S.Transit.MaxKnownHeigh = foreignerBestHeight
 		}
 
-		s.Node.NodeClient.SendGetBlocksUpper(payload.AddrFrom, topHash)
+		if s.S.headersSyncObj != nil && s.S.headersSyncObj.IsRunning() {
+			s.Logger.Trace.Printf("Headers sync is running. Skip request of blocks from %s\n", payload.AddrFrom.NodeAddrToString())
+
+		} else if s.S.headersSyncObj != nil && foreignerBestHeight-myBestHeight >= nodemanager.SyncMinHeightDiff {
+			s.Logger.Trace.Printf("Start headers sync. Blocks are behind %s\n", payload.AddrFrom.NodeAddrToString())
+
+			s.S.headersSyncObj.Start()
+		} else {
+			s.Logger.Trace.Printf("Request blocks from %s\n", payload.AddrFrom.NodeAddrToString())
+
+			s.Node.NodeClient.SendGetBlocksUpper(payload.AddrFrom, topHash)
+		}
 
 	} else if myBestHeight > foreignerBestHeight {
 		s.Logger.Trace.Printf("Send my version back to %s\n", payload.AddrFrom.NodeAddrToString())
@@ -978,6 +1383,45 @@ func (s *NodeServerRequest) handleVersion() error {
 
 	s.S.Node.CheckAddressKnown(payload.AddrFrom)
 
+	// codec for requests to the node is chosen from codecs it supports.
+	// When we see its codecs first time, version is sent back so the node can choose a codec for us too
+	codec := net.ChooseCodec(payload.Codecs)
+	codecWasKnown := s.S.Node.NodeNet.GetNodeCodec(payload.AddrFrom) != ""
+
+	if s.S.Node.NodeNet.SetNodeCodec(payload.AddrFrom, codec) &&
+		codec != "" && !codecWasKnown && myBestHeight <= foreignerBestHeight {
+
+		s.Logger.Trace.Printf("Codec %s is chosen for %s. Send my version back\n", codec, payload.AddrFrom.NodeAddrToString())
+
+		s.Node.NodeClient.SendVersion(payload.AddrFrom, myBestHeight)
+	}
+
+	if codec != "" && !codecWasKnown && s.S.peersConnectorObj != nil {
+		// the node understands envelope format, so persistent connection can be opened
+		s.S.peersConnectorObj.Wake()
+	}
+
+	return nil
+}
+
+// Check if other node works with same blockchain and same rules.
+// Empty hashes are not checked, old nodes don't send them and new nodes don't have a blockchain yet
+func (s *NodeServerRequest) checkChainIdentity(payload nodeclient.ComVersion) error {
+	if payload.Version < net.MinNodeVersion {
+		return errors.New(fmt.Sprintf("Protocol version %d is not supported. Minimum is %d", payload.Version, net.MinNodeVersion))
+	}
+
+	genesis := s.Node.NodeClient.Genesis
+
+	if len(payload.Genesis) > 0 && len(genesis) > 0 && !bytes.Equal(payload.Genesis, genesis) {
+		return errors.New(fmt.Sprintf("Other blockchain. Genesis block %x, our genesis block %x", payload.Genesis, genesis))
+	}
+
+	consensus := s.Node.NodeClient.Consensus
+
+	if len(payload.Consensus) > 0 && len(consensus) > 0 && !bytes.Equal(payload.Consensus, consensus) {
+		return errors.New(fmt.Sprintf("Different consensus rules. Rules hash %x, our rules hash %x", payload.Consensus, consensus))
+	}
 	return nil
 }
 
@@ -992,7 +1436,7 @@ func (s *NodeServerRequest) handleGetNodes() error {
 
 	var err error
 
-	s.Response, err = net.GobEncode(&nodes)
+	s.Response, err = s.encodeResponse(&nodes)
 
 	if err != nil {
 		return err
@@ -1065,10 +1509,67 @@ func (s *NodeServerRequest) handleGetState() error {
 
 	info.ExpectingBlocksHeight = s.S.Transit.MaxKnownHeigh
 
-	s.Response, err = net.GobEncode(&info)
+	if s.S.headersSyncObj != nil {
+		progress := s.S.headersSyncObj.GetProgress()
+
+		info.Syncing = progress.Running
+		info.SyncHeadersHeight = progress.HeadersHeight
+		info.SyncBlocksHeight = progress.BlocksHeight
+		info.SyncTargetHeight = progress.TargetHeight
+		info.SyncPeers = progress.Peers
+	}
+
+	s.Response, err = s.encodeResponse(&info)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Returns list of banned hosts
+func (s *NodeServerRequest) handleGetBans() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	bans := s.S.Node.NodeNet.GetPeerBans()
+
+	var err error
+
+	s.Response, err = s.encodeResponse(&bans)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Removes ban of a host or all bans
+func (s *NodeServerRequest) handleClearBans() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	var payload nodeclient.ComClearBans
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	err = s.S.Node.NodeNet.ClearPeerBans(payload.Host)
 
 	if err != nil {
 		return err
 	}
+
+	s.Response = []byte{}
+
 	return nil
 }
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+
+	netlib "github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/nodemanager"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestVersion(version int, genesis, consensus []byte) nodeclient.ComVersion {
+	addr := netlib.NodeAddr{Host: "10.0.0.1", Port: 8765}
+	return nodeclient.ComVersion{Version: version, BestHeight: 10, AddrFrom: addr, Genesis: genesis, Consensus: consensus}
+}
+
+func makeTestVersionRequest(t *testing.T, payload nodeclient.ComVersion) *NodeServerRequest {
+	node := &nodemanager.Node{}
+	node.Logger = utils.CreateLogger()
+	node.NodeNet.Init()
+	node.NodeClient = &nodeclient.NodeClient{}
+	node.NodeClient.Genesis = []byte{1, 1}
+	node.NodeClient.Consensus = []byte{2, 2}
+
+	s := &NodeServerRequest{}
+	s.Node = node
+	s.S = &NodeServer{Node: node}
+	s.Logger = node.Logger
+	s.RequestIP = payload.AddrFrom.Host
+	s.Format = requestFormat{false, netlib.CodecGob}
+
+	var err error
+	s.Request, err = netlib.EncodePayload(netlib.CodecGob, payload)
+	assert.NoError(t, err)
+
+	return s
+}
+
+func TestVersionOtherGenesis(t *testing.T) {
+	s := makeTestVersionRequest(t, makeTestVersion(netlib.NodeVersion, []byte{1, 2}, []byte{2, 2}))
+
+	err := s.handleVersion()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Genesis")
+	// later commands from the host are dropped
+	assert.True(t, s.Node.NodeNet.IsPeerBanned("10.0.0.1"))
+}
+
+func TestVersionOtherConsensus(t *testing.T) {
+	s := makeTestVersionRequest(t, makeTestVersion(netlib.NodeVersion, []byte{1, 1}, []byte{2, 3}))
+
+	err := s.handleVersion()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "consensus")
+	assert.True(t, s.Node.NodeNet.IsPeerBanned("10.0.0.1"))
+}
+
+func TestVersionLegacyNode(t *testing.T) {
+	// nodes of version 1 don't send hashes
+	s := makeTestVersionRequest(t, makeTestVersion(1, nil, nil))
+	assert.NoError(t, s.checkChainIdentity(makeTestVersion(1, nil, nil)))
+
+	// same chain
+	assert.NoError(t, s.checkChainIdentity(makeTestVersion(netlib.NodeVersion, []byte{1, 1}, []byte{2, 2})))
+
+	// our node has no blockchain yet
+	s.Node.NodeClient.Genesis = nil
+	assert.NoError(t, s.checkChainIdentity(makeTestVersion(netlib.NodeVersion, []byte{1, 2}, []byte{2, 2})))
+
+	assert.Error(t, s.checkChainIdentity(makeTestVersion(0, nil, nil)))
+	assert.False(t, s.Node.NodeNet.IsPeerBanned("10.0.0.1"))
+}
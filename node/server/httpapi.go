@@ -0,0 +1,610 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	netlib "github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/nodemanager"
+	"github.com/gelembjuk/oursql/node/structures"
+	"github.com/graphql-go/graphql"
+)
+
+// HTTP JSON API for applications and dashboards. Requests are executed by same handlers as
+// node server commands, payloads are passed to them in JSON codec.
+// Description of endpoints is in docs/HTTPAPI.md
+const (
+	httpAPIPrefix       = "/api/v1/"
+	httpAPITimeout      = 30 * time.Second
+	httpAPIStopTimeout  = 5 * time.Second
+	httpAPIAuthRequired = "Auth token is required"
+	httpAPIEventsPing   = 30 * time.Second // comment line is sent to keep idle events stream open
+)
+
+type httpAPI struct {
+	S      *NodeServer
+	logger *utils.LoggerMan
+	mux    *http.ServeMux
+	server *http.Server
+	stop   chan struct{} // closes events streams, server shutdown doesn't wait for them
+
+	graphqlSchema graphql.Schema
+}
+
+// Response of every endpoint. Only one of fields is set
+type httpAPIResponse struct {
+	Result interface{} `json:",omitempty"`
+	Error  string      `json:",omitempty"`
+}
+
+// Views of data returned by API. IDs and hashes are hex strings
+type apiHistoryRecord struct {
+	TXID   string
+	Out    bool // false for incoming transaction
+	Amount float64
+	From   string
+	To     string
+}
+
+type apiUnspentOutput struct {
+	TXID   string
+	Vout   int
+	Amount float64
+	IsBase bool
+	From   string
+}
+
+type apiUnspent struct {
+	Outputs   []apiUnspentOutput
+	LastBlock string
+}
+
+type apiTXInput struct {
+	TXID string
+	Vout int
+}
+
+type apiTXOutput struct {
+	Address string
+	Value   float64
+}
+
+type apiTransaction struct {
+	ID            string
+	Time          int64 // nanoseconds
+	From          string
+	Inputs        []apiTXInput
+	Outputs       []apiTXOutput
+	SQL           string
+	SQLRollback   string
+	SQLReference  string
+	SQLBaseTX     string
+	IsCoinbase    bool
+	IsSQLCommand  bool
+	IsTransaction bool
+}
+
+type apiRowChange struct {
+	TXID        string
+	Kind        string
+	BlockHash   string
+	Height      int
+	BlockTime   int64 // seconds
+	TXTime      int64 // nanoseconds
+	Signer      string
+	SQL         string
+	SQLRollback string
+	SQLBaseTX   string
+}
+
+type apiEvent struct {
+	Kind      string
+	BlockHash string
+	Height    int
+	TXID      string
+	Table     string
+	RefID     string
+	SQLKind   string
+	Addresses []string
+	Time      int64 // nanoseconds
+}
+
+func StartHTTPAPI(s *NodeServer) (*httpAPI, error) {
+	a := &httpAPI{}
+	a.S = s
+	a.logger = s.Logger
+	a.stop = make(chan struct{})
+
+	a.mux = http.NewServeMux()
+	a.mux.HandleFunc(httpAPIPrefix+"balance/", a.handleBalance)
+	a.mux.HandleFunc(httpAPIPrefix+"history/", a.handleHistory)
+	a.mux.HandleFunc(httpAPIPrefix+"unspent/", a.handleUnspent)
+	a.mux.HandleFunc(httpAPIPrefix+"transaction/", a.handleTransaction)
+	a.mux.HandleFunc(httpAPIPrefix+"rowhistory/", a.handleRowHistory)
+	a.mux.HandleFunc(httpAPIPrefix+"state", a.handleState)
+	a.mux.HandleFunc(httpAPIPrefix+"nodes", a.handleNodes)
+	a.mux.HandleFunc(httpAPIPrefix+"events", a.handleEvents)
+	a.mux.HandleFunc(httpAPIPrefix+"graphql", a.handleGraphQL)
+
+	var err error
+	a.graphqlSchema, err = newGraphQLSchema()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// browsers can not send auth token, the explorer is not available when auth is required
+	if !s.HTTPAPI.RequireAuth {
+		e, err := newExplorer(s)
+
+		if err != nil {
+			return nil, err
+		}
+		e.register(a.mux)
+	}
+
+	ln, err := net.Listen("tcp", s.HTTPAPI.Address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a.server = &http.Server{
+		Handler:      a.mux,
+		ReadTimeout:  httpAPITimeout,
+		WriteTimeout: httpAPITimeout,
+	}
+
+	go func() {
+		err := a.server.Serve(ln)
+
+		if err != nil && err != http.ErrServerClosed {
+			a.logger.Error.Printf("HTTP API stopped: %s", err.Error())
+		}
+	}()
+
+	a.logger.Trace.Printf("HTTP API listens on %s", ln.Addr().String())
+
+	return a, nil
+}
+
+func (a *httpAPI) Stop() {
+	a.logger.Trace.Println("Stop HTTP API")
+
+	close(a.stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpAPIStopTimeout)
+	defer cancel()
+
+	a.server.Shutdown(ctx)
+}
+
+// Returns bearer token from a request. It is same auth string as CLI uses
+func getHTTPAuthString(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+func getHTTPRequestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Check method and auth. Writes error response and returns false if a request can not be executed
+func (a *httpAPI) checkRequest(w http.ResponseWriter, r *http.Request, needsAuth bool) bool {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("Only GET requests are supported"))
+		return false
+	}
+	return a.checkAuth(w, r, needsAuth)
+}
+
+func (a *httpAPI) checkAuth(w http.ResponseWriter, r *http.Request, needsAuth bool) bool {
+	auth := getHTTPAuthString(r)
+
+	if (needsAuth || a.S.HTTPAPI.RequireAuth) &&
+		(auth == "" || subtle.ConstantTimeCompare([]byte(auth), []byte(a.S.NodeAuthStr)) != 1) {
+		a.writeError(w, http.StatusUnauthorized, errors.New(httpAPIAuthRequired))
+		return false
+	}
+	return true
+}
+
+// Node object with open DB connection for requests which read data directly. Connection must be closed after a request
+func (s *NodeServer) getRequestNode() (*nodemanager.Node, error) {
+	node := s.Node.Clone()
+	node.SessionID = utils.RandString(5)
+
+	err := node.DBConn.OpenConnection(node.SessionID)
+
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// Executes a node server command. A payload and a result are encoded with JSON codec
+func (a *httpAPI) execute(r *http.Request, command string, payload interface{}, result interface{}) error {
+	request := []byte{}
+
+	if payload != nil {
+		var err error
+		request, err = netlib.EncodePayload(netlib.CodecJSON, payload)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	format := requestFormat{true, netlib.CodecJSON}
+
+	response := a.S.processRequest(command, request, getHTTPAuthString(r), format, getHTTPRequestIP(r), "")
+
+	if len(response) == 0 {
+		return errors.New("Command has no response")
+	}
+
+	_, success, data, err := netlib.DecodeEnvelopeResponse(response)
+
+	if err != nil {
+		return err
+	}
+
+	if !success {
+		var message string
+
+		err := json.Unmarshal(data, &message)
+
+		if err != nil {
+			return err
+		}
+
+		if busy := netlib.ParseBusyMessage(message); busy != nil {
+			return busy
+		}
+		return errors.New(message)
+	}
+
+	if result != nil {
+		return json.Unmarshal(data, result)
+	}
+	return nil
+}
+
+func (a *httpAPI) writeResult(w http.ResponseWriter, result interface{}) {
+	a.writeResponse(w, http.StatusOK, httpAPIResponse{Result: result})
+}
+
+func (a *httpAPI) writeError(w http.ResponseWriter, status int, err error) {
+	if errv, ok := err.(*netlib.NetworkError); ok && errv.IsBusy() {
+		status = http.StatusTooManyRequests
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", netlib.BusyRetryAfter/time.Second))
+	}
+	a.writeResponse(w, status, httpAPIResponse{Error: err.Error()})
+}
+
+func (a *httpAPI) writeResponse(w http.ResponseWriter, status int, response httpAPIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(response)
+
+	if err != nil {
+		a.logger.Trace.Printf("HTTP API response error: %s", err.Error())
+	}
+}
+
+// Returns last part of a path. It is an address or ID
+func getHTTPPathArgument(r *http.Request, endpoint string) string {
+	return strings.Trim(strings.TrimPrefix(r.URL.Path, httpAPIPrefix+endpoint), "/")
+}
+
+// GET /api/v1/balance/ADDRESS
+func (a *httpAPI) handleBalance(w http.ResponseWriter, r *http.Request) {
+	if !a.checkRequest(w, r, false) {
+		return
+	}
+
+	payload := nodeclient.ComGetWalletBalance{Address: getHTTPPathArgument(r, "balance/")}
+	result := nodeclient.ComWalletBalance{}
+
+	if err := a.execute(r, nodeclient.CommandGetBalance, payload, &result); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	a.writeResult(w, result)
+}
+
+// GET /api/v1/history/ADDRESS
+func (a *httpAPI) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if !a.checkRequest(w, r, false) {
+		return
+	}
+
+	payload := nodeclient.ComGetHistoryTransactions{Address: getHTTPPathArgument(r, "history/")}
+	history := []nodeclient.ComHistoryTransaction{}
+
+	if err := a.execute(r, "gethistory", payload, &history); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result := []apiHistoryRecord{}
+
+	for _, t := range history {
+		// IOType is true for incoming transaction
+		result = append(result, apiHistoryRecord{hex.EncodeToString(t.TXID), !t.IOType, t.Amount, t.From, t.To})
+	}
+	a.writeResult(w, result)
+}
+
+// GET /api/v1/unspent/ADDRESS
+func (a *httpAPI) handleUnspent(w http.ResponseWriter, r *http.Request) {
+	if !a.checkRequest(w, r, false) {
+		return
+	}
+
+	payload := nodeclient.ComGetUnspentTransactions{Address: getHTTPPathArgument(r, "unspent/")}
+	unspent := nodeclient.ComUnspentTransactions{}
+
+	if err := a.execute(r, "getunspent", payload, &unspent); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result := apiUnspent{}
+	result.Outputs = []apiUnspentOutput{}
+	result.LastBlock = hex.EncodeToString(unspent.LastBlock)
+
+	for _, t := range unspent.Transactions {
+		result.Outputs = append(result.Outputs, apiUnspentOutput{hex.EncodeToString(t.TXID), t.Vout, t.Amount, t.IsBase, t.From})
+	}
+	a.writeResult(w, result)
+}
+
+// GET /api/v1/transaction/TXID
+func (a *httpAPI) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	if !a.checkRequest(w, r, false) {
+		return
+	}
+
+	txID, err := hex.DecodeString(getHTTPPathArgument(r, "transaction/"))
+
+	if err != nil || len(txID) == 0 {
+		a.writeError(w, http.StatusBadRequest, errors.New("Wrong transaction ID"))
+		return
+	}
+
+	payload := nodeclient.ComGetTransaction{TransactionID: txID}
+	response := nodeclient.ResponseGetTransaction{}
+
+	if err := a.execute(r, nodeclient.CommandGetTransaction, payload, &response); err != nil {
+		a.writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	tx, err := structures.DeserializeTransaction(response.Transaction)
+
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.writeResult(w, getAPITransaction(tx))
+}
+
+// GET /api/v1/rowhistory/TABLE/KEY
+func (a *httpAPI) handleRowHistory(w http.ResponseWriter, r *http.Request) {
+	if !a.checkRequest(w, r, false) {
+		return
+	}
+
+	// a key can have slashes, only first one separates a table
+	parts := strings.SplitN(getHTTPPathArgument(r, "rowhistory/"), "/", 2)
+
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		a.writeError(w, http.StatusBadRequest, errors.New("Table and key of a row are required"))
+		return
+	}
+
+	payload := nodeclient.ComGetRowHistory{Table: parts[0], Key: parts[1]}
+	history := nodeclient.ResponseGetRowHistory{}
+
+	if err := a.execute(r, nodeclient.CommandGetRowHistory, payload, &history); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result := []apiRowChange{}
+
+	for _, c := range history.Changes {
+		result = append(result, getAPIRowChange(c))
+	}
+	a.writeResult(w, result)
+}
+
+func getAPIRowChange(c nodeclient.RowChange) apiRowChange {
+	return apiRowChange{
+		TXID:        hex.EncodeToString(c.TXID),
+		Kind:        c.Kind,
+		BlockHash:   hex.EncodeToString(c.BlockHash),
+		Height:      c.Height,
+		BlockTime:   c.BlockTime,
+		TXTime:      c.TXTime,
+		Signer:      c.Signer,
+		SQL:         c.Query,
+		SQLRollback: c.RollbackQuery,
+		SQLBaseTX:   hex.EncodeToString(c.BaseTX)}
+}
+
+// GET /api/v1/state . Auth is required
+func (a *httpAPI) handleState(w http.ResponseWriter, r *http.Request) {
+	if !a.checkRequest(w, r, true) {
+		return
+	}
+
+	result := nodeclient.ComGetNodeState{}
+
+	if err := a.execute(r, nodeclient.CommandGetState, nil, &result); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	a.writeResult(w, result)
+}
+
+// GET /api/v1/nodes
+func (a *httpAPI) handleNodes(w http.ResponseWriter, r *http.Request) {
+	if !a.checkRequest(w, r, false) {
+		return
+	}
+
+	result := []netlib.NodeAddr{}
+
+	if err := a.execute(r, "getnodes", nil, &result); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	a.writeResult(w, result)
+}
+
+// GET /api/v1/events?kind=K&table=T&address=A
+// Server-sent events stream. Every parameter can be a comma separated list or repeated
+func (a *httpAPI) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !a.checkRequest(w, r, false) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		a.writeError(w, http.StatusInternalServerError, errors.New("Streaming is not supported"))
+		return
+	}
+
+	filter := nodemanager.EventFilter{}
+	filter.Kinds = getHTTPQueryList(r, "kind")
+	filter.Tables = getHTTPQueryList(r, "table")
+	filter.Addresses = getHTTPQueryList(r, "address")
+
+	subscription := a.S.Node.SubscribeEvents(filter)
+	defer subscription.Close()
+
+	// the stream is open until a client disconnects
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ping := time.NewTicker(httpAPIEventsPing)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.stop:
+			return
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e, ok := <-subscription.C:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(getAPIEvent(e))
+
+			if err != nil {
+				a.logger.Trace.Printf("HTTP API event encoding error: %s", err.Error())
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// Returns values of a query parameter. Values can be comma separated
+func getHTTPQueryList(r *http.Request, name string) []string {
+	list := []string{}
+
+	for _, v := range r.URL.Query()[name] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+func getAPIEvent(e nodemanager.Event) apiEvent {
+	v := apiEvent{}
+	v.Kind = e.Kind
+	v.Height = e.Height
+	v.Table = e.Table
+	v.RefID = e.RefID
+	v.SQLKind = e.SQLKind
+	v.Addresses = e.Addresses
+	v.Time = e.Time.UnixNano()
+
+	if len(e.BlockHash) > 0 {
+		v.BlockHash = hex.EncodeToString(e.BlockHash)
+	}
+	if len(e.TXID) > 0 {
+		v.TXID = hex.EncodeToString(e.TXID)
+	}
+	return v
+}
+
+// Converts a transaction to API view
+func getAPITransaction(tx *structures.Transaction) apiTransaction {
+	v := apiTransaction{}
+	v.ID = hex.EncodeToString(tx.GetID())
+	v.Time = tx.GetTime()
+	v.IsCoinbase = tx.IsCoinbaseTransfer()
+	v.IsSQLCommand = tx.IsSQLCommand()
+	v.IsTransaction = tx.IsCurrencyTransfer()
+	v.Inputs = []apiTXInput{}
+	v.Outputs = []apiTXOutput{}
+
+	if len(tx.ByPubKey) > 0 {
+		v.From, _ = utils.PubKeyToAddres(tx.ByPubKey)
+	}
+
+	if !v.IsCoinbase {
+		for _, in := range tx.Vin {
+			v.Inputs = append(v.Inputs, apiTXInput{hex.EncodeToString(in.Txid), in.Vout})
+		}
+	}
+
+	for _, out := range tx.Vout {
+		address, _ := utils.PubKeyHashToAddres(out.PubKeyHash)
+		v.Outputs = append(v.Outputs, apiTXOutput{address, out.Value})
+	}
+
+	if v.IsSQLCommand {
+		v.SQL = string(tx.SQLCommand.Query)
+		v.SQLRollback = string(tx.SQLCommand.RollbackQuery)
+		v.SQLReference = string(tx.SQLCommand.ReferenceID)
+		v.SQLBaseTX = hex.EncodeToString(tx.GetSQLBaseTX())
+	}
+	return v
+}
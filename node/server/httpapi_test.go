@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/nodemanager"
+	"github.com/gelembjuk/oursql/node/structures"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPAPICheckRequest(t *testing.T) {
+	s := &NodeServer{}
+	s.NodeAuthStr = "secret"
+	a := &httpAPI{S: s, logger: utils.CreateLogger()}
+
+	check := func(method string, token string, needsAuth bool) (bool, int) {
+		r := httptest.NewRequest(method, "/api/v1/balance/addr", nil)
+
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+
+		return a.checkRequest(w, r, needsAuth), w.Code
+	}
+
+	ok, _ := check("GET", "", false)
+	assert.True(t, ok)
+
+	ok, code := check("POST", "", false)
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+
+	ok, code = check("GET", "wrong", true)
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	ok, _ = check("GET", "secret", true)
+	assert.True(t, ok)
+
+	s.HTTPAPI.RequireAuth = true
+
+	ok, _ = check("GET", "", false)
+	assert.False(t, ok)
+}
+
+func TestHTTPAPIPathArgument(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/v1/transaction/abcd/", nil)
+
+	assert.Equal(t, "abcd", getHTTPPathArgument(r, "transaction/"))
+	assert.Equal(t, "192.0.2.1", getHTTPRequestIP(r))
+}
+
+func TestAPITransaction(t *testing.T) {
+	tx := &structures.Transaction{}
+	tx.ID = []byte{1, 2}
+	tx.SQLCommand.Query = []byte("INSERT INTO t VALUES (1)")
+	tx.SQLBaseTX = []byte{3, 4}
+
+	v := getAPITransaction(tx)
+
+	data, err := json.Marshal(v)
+	assert.NoError(t, err)
+
+	decoded := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "0102", decoded["ID"])
+	assert.Equal(t, "0304", decoded["SQLBaseTX"])
+	assert.Equal(t, "INSERT INTO t VALUES (1)", decoded["SQL"])
+}
+
+func TestAPIRowChange(t *testing.T) {
+	c := structures.RowChange{}
+	c.TXID = []byte{1, 2}
+	c.BaseTX = []byte{3, 4}
+	c.Query = "UPDATE t SET a=2 WHERE id=1"
+	c.RollbackQuery = "UPDATE t SET a=1 WHERE id=1"
+
+	v := getAPIRowChange(nodeclient.RowChange(c))
+
+	assert.Equal(t, "0102", v.TXID)
+	assert.Equal(t, "0304", v.SQLBaseTX)
+	assert.Equal(t, c.Query, v.SQL)
+	assert.Equal(t, c.RollbackQuery, v.SQLRollback)
+
+	// table and key are required
+	a := &httpAPI{S: &NodeServer{}, logger: utils.CreateLogger()}
+	w := httptest.NewRecorder()
+
+	a.handleRowHistory(w, httptest.NewRequest("GET", "/api/v1/rowhistory/t", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHTTPAPIEvents(t *testing.T) {
+	s := &NodeServer{}
+	s.Node = &nodemanager.Node{Events: nodemanager.NewEventBus()}
+	a := &httpAPI{S: s, logger: utils.CreateLogger(), stop: make(chan struct{})}
+
+	server := httptest.NewServer(http.HandlerFunc(a.handleEvents))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/events?kind=txnew&table=members,orders")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	for s.Node.Events.CountSubscriptions() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Node.Events.Publish(nodemanager.Event{Kind: nodemanager.EventTXNew, Table: "users", TXID: []byte{1}})
+	s.Node.Events.Publish(nodemanager.Event{Kind: nodemanager.EventTXNew, Table: "orders", TXID: []byte{2}})
+
+	reader := bufio.NewReader(resp.Body)
+
+	line, _ := reader.ReadString('\n')
+	assert.Equal(t, "event: txnew\n", line)
+
+	line, _ = reader.ReadString('\n')
+	e := apiEvent{}
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e))
+	assert.Equal(t, "02", e.TXID)
+	assert.Equal(t, "orders", e.Table)
+
+	close(a.stop)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"time"
+
+	netlib "github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+const (
+	natCheckInterval = time.Minute
+	natRenewInterval = netlib.NATMappingLifetime / 2
+)
+
+// Keeps external address of a node up to date. When NAT is enabled, a port is mapped on a router
+// and the mapping is renewed. When a host is not set in config, an address other nodes see is used
+type natManager struct {
+	S            *NodeServer
+	logger       *utils.LoggerMan
+	stopChan     chan struct{}
+	completeChan chan struct{}
+	autoHost     bool // a host was not set, it is detected
+	nat          netlib.NAT
+	mappedPort   int
+	renewTime    time.Time
+}
+
+func StartNATManager(s *NodeServer) (c *natManager) {
+	c = &natManager{}
+
+	c.logger = s.Logger
+	c.S = s
+
+	c.stopChan = make(chan struct{})
+	c.completeChan = make(chan struct{})
+
+	host := s.GetNodeAddress().Host
+	c.autoHost = host == "" || host == "localhost"
+
+	go c.Run()
+
+	return c
+}
+
+func (c *natManager) Run() {
+	for {
+		c.updateMapping()
+		c.updateHost()
+
+		exit := false
+
+		select {
+		case <-c.stopChan:
+			exit = true
+		case <-time.After(natCheckInterval):
+		}
+
+		if exit {
+			break
+		}
+	}
+	c.deleteMapping()
+
+	c.logger.Trace.Printf("NAT Manager Return routine")
+	close(c.completeChan)
+}
+
+func (c *natManager) Stop() {
+	c.logger.Trace.Println("Stop NAT manager")
+
+	close(c.stopChan)
+
+	<-c.completeChan
+
+	c.logger.TraceExt.Println("NAT Manager Stopped")
+}
+
+// Map a port on a router or renew existent mapping
+func (c *natManager) updateMapping() {
+	if c.S.NAT == "" || time.Now().Before(c.renewTime) {
+		return
+	}
+
+	if c.nat == nil {
+		nat, err := netlib.DiscoverNAT(c.S.NAT)
+
+		if err != nil {
+			c.logger.Trace.Printf("NAT router is not found: %s", err.Error())
+			// don't search on every check
+			c.renewTime = time.Now().Add(natRenewInterval)
+			return
+		}
+		c.logger.Trace.Printf("Found NAT router %s", nat.String())
+		c.nat = nat
+	}
+
+	address := c.S.GetNodeAddress()
+
+	port, err := c.nat.AddPortMapping(c.S.NodePort, address.Port, netlib.NATMappingLifetime)
+
+	if err != nil {
+		c.logger.Error.Printf("Port mapping on %s failed: %s", c.nat.String(), err.Error())
+		c.nat = nil
+		c.renewTime = time.Now().Add(natRenewInterval)
+		return
+	}
+	c.renewTime = time.Now().Add(natRenewInterval)
+
+	if port != c.mappedPort {
+		c.logger.Trace.Printf("Port %d is mapped to external port %d on %s", c.S.NodePort, port, c.nat.String())
+	}
+	c.mappedPort = port
+
+	if port > 0 && port != address.Port {
+		// a router chose other port
+		address.Port = port
+		c.S.setNodeAddress(address)
+	}
+
+	if !c.autoHost {
+		return
+	}
+
+	ip, err := c.nat.GetExternalAddress()
+
+	if err != nil {
+		c.logger.Trace.Printf("External address is not received from %s: %s", c.nat.String(), err.Error())
+		return
+	}
+	c.setHost(ip.String())
+}
+
+// Use an address reported by other nodes if a router didn't tell it
+func (c *natManager) updateHost() {
+	if !c.autoHost || c.nat != nil {
+		return
+	}
+
+	if host := c.S.Node.NodeNet.GetExternalAddress(); host != "" {
+		c.setHost(host)
+	}
+}
+
+func (c *natManager) setHost(host string) {
+	address := c.S.GetNodeAddress()
+
+	if host == address.Host {
+		return
+	}
+	c.logger.Trace.Printf("External address of the node is %s", host)
+
+	address.Host = host
+	c.S.setNodeAddress(address)
+}
+
+func (c *natManager) deleteMapping() {
+	if c.nat == nil || c.mappedPort == 0 {
+		return
+	}
+
+	err := c.nat.DeletePortMapping(c.S.NodePort, c.mappedPort)
+
+	if err != nil {
+		c.logger.Trace.Printf("Port mapping is not deleted: %s", err.Error())
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"time"
+
+	netlib "github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+const (
+	maxOutgoingPeerConnections = 8  // this node opens connections to so many nodes
+	maxPeerConnections         = 64 // including connections opened by other nodes
+	peersConnectInterval       = 30 * time.Second
+	peerConnectRetryInterval   = 10 * time.Minute
+)
+
+// Keeps persistent connections to other nodes. New transactions and blocks are pushed
+// to other nodes over these connections, so they don't need to wait for changes checker
+type peersConnector struct {
+	S            *NodeServer
+	logger       *utils.LoggerMan
+	stopChan     chan struct{}
+	completeChan chan struct{}
+	wakeChan     chan struct{}
+	failed       map[string]time.Time // nodes which didn't accept a connection and time of next try
+}
+
+func StartPeersConnector(s *NodeServer) (c *peersConnector) {
+	c = &peersConnector{}
+
+	c.logger = s.Logger
+	c.S = s
+
+	c.stopChan = make(chan struct{})
+	c.completeChan = make(chan struct{})
+	c.wakeChan = make(chan struct{}, 1)
+	c.failed = map[string]time.Time{}
+
+	go c.Run()
+
+	return c
+}
+
+func (c *peersConnector) Run() {
+	for {
+		c.connectToPeers()
+
+		exit := false
+
+		select {
+		case <-c.stopChan:
+			exit = true
+		case <-c.wakeChan:
+		case <-time.After(peersConnectInterval):
+		}
+
+		if exit {
+			break
+		}
+	}
+	c.logger.Trace.Printf("Peers Connector Return routine")
+	close(c.completeChan)
+}
+
+// Ask to check connections now. It is called when new node is known
+func (c *peersConnector) Wake() {
+	select {
+	case c.wakeChan <- struct{}{}:
+	default:
+	}
+}
+
+func (c *peersConnector) Stop() {
+	c.logger.Trace.Println("Stop peers connector")
+
+	close(c.stopChan)
+
+	<-c.completeChan
+
+	c.logger.TraceExt.Println("Peers Connector Stopped")
+}
+
+// Open connections to nodes which understand envelope format
+func (c *peersConnector) connectToPeers() {
+	node := c.S.Node
+
+	for _, addr := range node.NodeNet.GetNodes() {
+		if node.NodeNet.CountPeerConnections() >= maxOutgoingPeerConnections {
+			return
+		}
+
+		if addr.Codec == "" || !addr.AcceptsConnections() || addr.CompareToAddress(node.NodeClient.GetNodeAddress()) ||
+			node.NodeNet.GetPeerConnection(addr) != nil || node.NodeNet.IsPeerBanned(addr.Host) {
+			continue
+		}
+
+		if retry, ok := c.failed[addr.NodeAddrToString()]; ok && time.Now().Before(retry) {
+			continue
+		}
+
+		mc, err := node.NodeClient.OpenPersistentConnection(addr, c.S.muxRequestHandler(addr.Host, node.NodeNet.GetNodeIdentity(addr)))
+
+		if err != nil {
+			c.logger.Trace.Printf("Persistent connection to %s failed: %s", addr.NodeAddrToString(), err.Error())
+			c.failed[addr.NodeAddrToString()] = time.Now().Add(peerConnectRetryInterval)
+			continue
+		}
+		delete(c.failed, addr.NodeAddrToString())
+
+		if !node.NodeNet.Conns.Add(mc) {
+			// other node connected to us at same time
+			mc.Close()
+			continue
+		}
+
+		go c.S.runPersistentConnection(mc)
+	}
+}
+
+// Other node asked to keep a connection open
+func (s *NodeServer) acceptPersistentConnection(conn net.Conn, request []byte, format requestFormat, requestIP string, peerIdentity string) {
+	var payload nodeclient.ComMux
+
+	err := netlib.DecodePayload(format.Codec, request, &payload)
+
+	if err == nil && payload.AddrFrom.Host == "" {
+		err = errors.New("Node address is empty")
+	}
+
+	if err != nil {
+		s.Node.NodeNet.ReportPeerMisbehavior(requestIP, netlib.PenaltyMalformedMessage, "malformed request")
+		s.sendErrorBack(conn, format, err)
+		conn.Close()
+		return
+	}
+
+	if payload.AddrFrom.Host == "localhost" {
+		payload.AddrFrom.Host = requestIP
+	}
+
+	if pinned := s.Node.NodeNet.GetNodeIdentity(payload.AddrFrom); pinned != "" && pinned != peerIdentity {
+		err = errors.New("Node identity doesn't match")
+	} else if s.Node.NodeNet.CountPeerConnections() >= maxPeerConnections {
+		err = errors.New("Too many persistent connections")
+	}
+
+	if err != nil {
+		s.sendErrorBack(conn, format, err)
+		conn.Close()
+		return
+	}
+
+	_, err = conn.Write(netlib.EncodeEnvelopeResponse(format.Codec, true, []byte{}))
+
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	mc := netlib.NewMuxConn(conn, payload.AddrFrom, s.muxRequestHandler(requestIP, peerIdentity))
+
+	// if there is a connection to the node already, this one is still served but not used for requests from this side
+	s.Node.NodeNet.Conns.Add(mc)
+
+	s.Node.CheckAddressKnown(payload.AddrFrom)
+
+	s.runPersistentConnection(mc)
+}
+
+// Serve a persistent connection till it is closed
+func (s *NodeServer) runPersistentConnection(mc *netlib.MuxConn) {
+	s.Logger.Trace.Printf("Persistent connection with %s is open", mc.Addr.NodeAddrToString())
+
+	if s.MessageLimits != nil {
+		mc.Limits = s.MessageLimits
+	}
+
+	err := mc.Run()
+
+	s.Logger.Trace.Printf("Persistent connection with %s is closed: %s", mc.Addr.NodeAddrToString(), err.Error())
+
+	if !mc.Addr.AcceptsConnections() {
+		// outbound-only node can not be reached without this connection
+		s.Node.NodeNet.RemoveNodeFromKnown(mc.Addr)
+	}
+}
+
+// Returns a function to execute requests received over a persistent connection
+func (s *NodeServer) muxRequestHandler(requestIP string, peerIdentity string) netlib.MuxRequestHandler {
+	return func(c *netlib.MuxConn, id uint32, data []byte, needResponse bool) {
+		if s.Node.NodeNet.IsPeerBanned(requestIP) {
+			s.Logger.Trace.Printf("Persistent connection with banned host %s is closed", requestIP)
+			c.Close()
+			return
+		}
+
+		var dataresponse []byte
+
+		command, request, authstring, format, err := s.readRequest(bytes.NewReader(data))
+
+		if err == nil && command == nodeclient.CommandMux {
+			err = errors.New("Connection is persistent already")
+		}
+
+		if err != nil {
+			s.reportReadError(requestIP, err)
+			dataresponse = s.errorResponse(format, errors.New("Network Data Reading Error: "+err.Error()))
+		} else {
+			dataresponse = s.processRequest(command, request, authstring, format, requestIP, peerIdentity)
+		}
+
+		if needResponse {
+			// empty response if a command doesn't respond. a client will get an error
+			err = c.Respond(id, dataresponse)
+
+			if err != nil {
+				s.Logger.Error.Println("Sending response error: ", err.Error())
+			}
+		}
+	}
+}
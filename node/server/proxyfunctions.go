@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/dbproxy"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+// Virtual functions and tables to get blockchain info with SQL. Such queries are
+// answered by the proxy and never reach a DB server
+//
+// SELECT oursql_balance('address')
+// SELECT oursql_tx_status('txid') - pending, confirmed or unknown
+// SELECT oursql_block_height()
+// SELECT * FROM oursql.blocks [LIMIT count [OFFSET offset] | LIMIT offset, count]
+
+// Error code returned when virtual query fails
+const proxyFunctionErrorCode = 5
+
+// Number of blocks returned when a query has no LIMIT
+const proxyBlocksDefaultLimit = 100
+
+var (
+	proxyFunctionQueryRegexp = regexp.MustCompile(`(?is)^\s*select\s+(oursql_\w+)\s*\(\s*('[^']*')?\s*\)\s*(?:as\s+(\w+))?\s*;?\s*$`)
+	proxyBlocksQueryRegexp   = regexp.MustCompile(`(?is)^\s*select\s+\*\s+from\s+oursql\.blocks(?:\s+limit\s+(\d+)(?:\s*,\s*(\d+)|\s+offset\s+(\d+))?)?\s*;?\s*$`)
+)
+
+var proxyBlocksColumns = []dbproxy.CustomResponseColumn{
+	{Name: "height", Type: dbproxy.ColumnTypeInteger},
+	{Name: "hash", Type: dbproxy.ColumnTypeText},
+	{Name: "prev_hash", Type: dbproxy.ColumnTypeText},
+	{Name: "time", Type: dbproxy.ColumnTypeDateTime},
+	{Name: "transactions", Type: dbproxy.ColumnTypeInteger},
+}
+
+// Returns response if a query is one of virtual queries. Else returns nil
+func (q *queryFilter) getVirtualQueryResponse(query string) dbproxy.CustomRequestActionInterface {
+	if match := proxyFunctionQueryRegexp.FindStringSubmatch(query); match != nil {
+		name := strings.ToLower(match[1])
+		hasArg := match[2] != ""
+		arg := strings.Trim(match[2], "'")
+
+		column := match[3]
+
+		if column == "" {
+			column = name + "(" + match[2] + ")"
+		}
+
+		return q.getFunctionResponse(name, arg, hasArg, column)
+	}
+
+	if match := proxyBlocksQueryRegexp.FindStringSubmatch(query); match != nil {
+		limit, offset := parseProxyLimit(match[1], match[2], match[3])
+
+		return q.getBlocksResponse(limit, offset)
+	}
+	return nil
+}
+
+func (q *queryFilter) getFunctionResponse(name, arg string, hasArg bool, column string) dbproxy.CustomRequestActionInterface {
+	var value string
+	var err error
+	var columnType dbproxy.CustomResponseColumnType
+
+	switch name {
+	case "oursql_balance":
+		columnType = dbproxy.ColumnTypeDecimal
+
+		if !hasArg {
+			err = errors.New("Address is expected")
+			break
+		}
+		value, err = q.getBalance(arg)
+
+	case "oursql_tx_status":
+		columnType = dbproxy.ColumnTypeText
+
+		if !hasArg {
+			err = errors.New("Transaction ID is expected")
+			break
+		}
+		value, err = q.getTXStatus(arg)
+
+	case "oursql_block_height":
+		columnType = dbproxy.ColumnTypeInteger
+
+		if hasArg {
+			err = errors.New("No arguments expected")
+			break
+		}
+		value, err = q.getBlockHeight()
+
+	default:
+		// not our function. let a server to report it
+		return nil
+	}
+
+	if err != nil {
+		q.Logger.Trace.Printf("Proxy function %s error %s", name, err.Error())
+		return dbproxy.NewCustomErrorResponse(fmt.Sprintf("%s: %s", name, err.Error()), proxyFunctionErrorCode)
+	}
+
+	columns := []dbproxy.CustomResponseColumn{{Name: column, Type: columnType}}
+
+	return dbproxy.NewCustomDataTypedRowsResponse(columns, [][]string{{value}})
+}
+
+func (q *queryFilter) getBalance(address string) (string, error) {
+	if _, err := utils.AddresToPubKeyHash(address); err != nil {
+		return "", errors.New(fmt.Sprintf("Wrong address: %s", err.Error()))
+	}
+
+	balance, err := q.Node.GetTransactionsManager().GetAddressBalance(address)
+
+	if err != nil {
+		return "", err
+	}
+	return formatProxyAmount(balance.Total), nil
+}
+
+// Transaction is confirmed if it is in a block of primary branch
+func (q *queryFilter) getTXStatus(txid string) (string, error) {
+	id, err := hex.DecodeString(txid)
+
+	if err != nil || len(id) == 0 {
+		return "", errors.New("Wrong transaction ID")
+	}
+
+	tm := q.Node.GetTransactionsManager()
+
+	tx, err := tm.GetIfUnapprovedExists(id)
+
+	if err != nil {
+		return "", err
+	}
+
+	if tx != nil {
+		return "pending", nil
+	}
+
+	tx, err = tm.GetIfExists(id)
+
+	if err != nil {
+		return "", err
+	}
+
+	if tx != nil {
+		return "confirmed", nil
+	}
+	return "unknown", nil
+}
+
+func (q *queryFilter) getBlockHeight() (string, error) {
+	bc, err := q.Node.GetBCManager()
+
+	if err != nil {
+		return "", err
+	}
+
+	height, err := bc.GetBestHeight()
+
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(height), nil
+}
+
+// Blocks of primary branch from the top
+func (q *queryFilter) getBlocksResponse(limit, offset int) dbproxy.CustomRequestActionInterface {
+	rows := [][]string{}
+
+	bci, err := q.Node.GetBlockChainIterator()
+
+	if err != nil {
+		return dbproxy.NewCustomErrorResponse(err.Error(), proxyFunctionErrorCode)
+	}
+
+	for i := 0; len(rows) < limit; i++ {
+		block, err := bci.Next()
+
+		if err != nil {
+			return dbproxy.NewCustomErrorResponse(err.Error(), proxyFunctionErrorCode)
+		}
+
+		if i >= offset {
+			rows = append(rows, []string{
+				strconv.Itoa(block.Height),
+				hex.EncodeToString(block.Hash),
+				hex.EncodeToString(block.PrevBlockHash),
+				time.Unix(block.Timestamp, 0).UTC().Format("2006-01-02 15:04:05"),
+				strconv.Itoa(len(block.Transactions))})
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return dbproxy.NewCustomDataTypedRowsResponse(proxyBlocksColumns, rows)
+}
+
+// LIMIT can be "count", "offset, count" or "count OFFSET offset"
+func parseProxyLimit(first, second, offsetStr string) (limit, offset int) {
+	limit = proxyBlocksDefaultLimit
+
+	if first == "" {
+		return
+	}
+
+	limit, _ = strconv.Atoi(first)
+
+	if second != "" {
+		offset = limit
+		limit, _ = strconv.Atoi(second)
+	} else if offsetStr != "" {
+		offset, _ = strconv.Atoi(offsetStr)
+	}
+	return
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyFunctionsQueries(t *testing.T) {
+	match := proxyFunctionQueryRegexp.FindStringSubmatch("SELECT oursql_balance('1abc') AS b;")
+	assert.Equal(t, []string{"SELECT oursql_balance('1abc') AS b;", "oursql_balance", "'1abc'", "b"}, match)
+
+	match = proxyFunctionQueryRegexp.FindStringSubmatch("select oursql_block_height()")
+	assert.Equal(t, "", match[2])
+
+	assert.Nil(t, proxyFunctionQueryRegexp.FindStringSubmatch("SELECT oursql_balance('a') FROM t"))
+
+	limits := map[string][2]int{
+		"SELECT * FROM oursql.blocks":                  {proxyBlocksDefaultLimit, 0},
+		"select * from oursql.blocks limit 5;":         {5, 0},
+		"SELECT * FROM oursql.blocks LIMIT 10, 5":      {5, 10},
+		"SELECT * FROM oursql.blocks LIMIT 5 OFFSET 3": {5, 3},
+	}
+
+	for query, expected := range limits {
+		match = proxyBlocksQueryRegexp.FindStringSubmatch(query)
+
+		if assert.NotNil(t, match, query) {
+			limit, offset := parseProxyLimit(match[1], match[2], match[3])
+			assert.Equal(t, expected, [2]int{limit, offset}, query)
+		}
+	}
+
+	assert.Nil(t, proxyBlocksQueryRegexp.FindStringSubmatch("SELECT * FROM oursql.blocks WHERE height=1"))
+}
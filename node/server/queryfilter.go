@@ -6,14 +6,23 @@ Errors returned by the proxy must have MySQL codes.
 2 - Query requires public key
 3 - Query requires data to sign
 4 - Error preparing of query parsing
+5 - Error of blockchain info function (see proxyfunctions.go)
 
 */
 import (
 	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gelembjuk/oursql/lib"
 	"github.com/gelembjuk/oursql/lib/dbproxy"
 	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/config"
 	"github.com/gelembjuk/oursql/node/nodemanager"
 	"github.com/gelembjuk/oursql/node/structures"
 )
@@ -23,18 +32,60 @@ type queryFilter struct {
 	Node                *nodemanager.Node
 	Logger              *utils.LoggerMan
 	sessionTransactions map[string]*structures.Transaction
+	// adds a transaction of a proxied query to the pool. It is Node.ReceivedNewTransaction
+	addToPool func(tx *structures.Transaction, flags int) error
 	// Use this to notify a main server process about new transaction was added to a pool
 	newTransactionChan chan []byte
 	blockmakerObj      *blocksMaker
+	// audit log is optional. Records waiting for a server response are kept per session
+	audit        *auditLog
+	sessions     map[string]dbproxy.DBProxySessionInfo
+	auditRecords map[string]*auditRecord
+	sessionsLock sync.Mutex
+	// last transaction created in a session. Info is added to next OK response once
+	sessionLastTX     map[string]proxyTXInfo
+	sessionInfoToSend map[string]bool
 }
 
-func InitQueryFilter(proxyAddr, dbAddr string, node *nodemanager.Node, logger *utils.LoggerMan, bmo *blocksMaker) (q *queryFilter, err error) {
+// Info about transaction made by a proxied query. Apps can get it with SELECT @oursql_last_tx
+type proxyTXInfo struct {
+	ID     string
+	Signer string
+	Cost   float64
+}
+
+// Session variables with last transaction info
+const (
+	proxyVarLastTX       = "@oursql_last_tx"
+	proxyVarLastTXSigner = "@oursql_last_tx_signer"
+	proxyVarLastTXCost   = "@oursql_last_tx_cost"
+)
+
+var proxyLastTXQueryRegexp = regexp.MustCompile(`(?i)^\s*select\s+(@oursql_last_tx\w*(\s*,\s*@oursql_last_tx\w*)*)\s*;?\s*$`)
+
+func InitQueryFilter(proxyAddr, dbAddr string, node *nodemanager.Node, logger *utils.LoggerMan, bmo *blocksMaker,
+	auditConfig config.AuditLogConfig, poolConfig config.DBProxyPoolConfig) (q *queryFilter, err error) {
 	q = &queryFilter{}
 
 	q.Logger = logger
 	q.Node = node
+	q.addToPool = node.ReceivedNewTransaction
 	q.sessionTransactions = make(map[string]*structures.Transaction)
 	q.blockmakerObj = bmo
+	q.sessions = make(map[string]dbproxy.DBProxySessionInfo)
+	q.auditRecords = make(map[string]*auditRecord)
+	q.sessionLastTX = make(map[string]proxyTXInfo)
+	q.sessionInfoToSend = make(map[string]bool)
+
+	if auditConfig.File != "" {
+		q.audit, err = newAuditLog(auditConfig)
+
+		if err != nil {
+			q.Logger.Error.Printf("Error opening audit log %s", err.Error())
+			return
+		}
+		q.Logger.Trace.Printf("Audit log is written to %s", auditConfig.File)
+	}
 
 	q.Logger.Trace.Printf("DB Proxy Start on %s  %s", proxyAddr, dbAddr)
 
@@ -47,6 +98,12 @@ func InitQueryFilter(proxyAddr, dbAddr string, node *nodemanager.Node, logger *u
 
 	q.DBProxy.SetLoggers(q.Logger.Trace, q.Logger.Error)
 
+	if poolConfig.Size > 0 {
+		if pp, ok := q.DBProxy.(dbproxy.DBProxyPoolInterface); ok {
+			pp.SetPool(poolConfig.Size, poolConfig.MaxOpen, time.Duration(poolConfig.IdleTimeout)*time.Second)
+		}
+	}
+
 	q.DBProxy.SetFilter(q)
 
 	err = q.DBProxy.Init()
@@ -67,17 +124,42 @@ func InitQueryFilter(proxyAddr, dbAddr string, node *nodemanager.Node, logger *u
 	return
 }
 func (q *queryFilter) RequestCallback(query string, sessionID string) (dbproxy.CustomRequestActionInterface, error) {
+	record := q.newAuditRecord(sessionID, query)
+
+	// queries answered by the proxy itself are audited too
+	if r := q.getLastTXVariables(query, sessionID); r != nil {
+		record.Outcome = auditOutcomeExecuted
+		q.writeAudit(record)
+		return r, nil
+	}
+
+	if r := q.getVirtualQueryResponse(query); r != nil {
+		record.Outcome = auditOutcomeExecuted
+		q.writeAudit(record)
+		return r, nil
+	}
+
 	qm, err := q.Node.GetSQLQueryManager()
 
 	if err != nil {
+		record.Outcome = auditOutcomeRejected
+		record.Error = err.Error()
+		q.writeAudit(record)
 		return nil, err
 	}
 	result := qm.NewQueryFromProxy(query)
 
 	q.Logger.Trace.Printf("Proxy Query process status %d", result.Status)
 
+	record.setTX(result.TX)
+
 	if result.Error != nil {
 		q.Logger.Trace.Printf("Proxy Query error %s code %d", result.Error.Error(), result.ErrorCode)
+
+		record.Outcome = auditOutcomeRejected
+		record.Error = result.Error.Error()
+		q.writeAudit(record)
+
 		if result.ErrorCode > 0 {
 			return dbproxy.NewCustomErrorResponse(result.Error.Error(), result.ErrorCode), nil
 		}
@@ -94,6 +176,9 @@ func (q *queryFilter) RequestCallback(query string, sessionID string) (dbproxy.C
 
 		q.Logger.Trace.Println("Return transaction prepare info")
 
+		record.Outcome = auditOutcomeSignatureRequested
+		q.writeAudit(record)
+
 		return dbproxy.NewCustomDataKeyValueResponse(response), nil
 	}
 
@@ -106,6 +191,12 @@ func (q *queryFilter) RequestCallback(query string, sessionID string) (dbproxy.C
 		q.Logger.Trace.Printf("Query: %s, sessID: %s, no TX needed\n", query, sessionID)
 	}
 
+	if result.Status != 3 {
+		record.CanonicalQuery = result.ReplaceQuery
+	}
+	// outcome is known only after a server response
+	q.setPendingAudit(sessionID, record)
+
 	if result.Status == 3 {
 		// it means query was not executed and must be passed to a server
 		return nil, nil
@@ -116,6 +207,16 @@ func (q *queryFilter) RequestCallback(query string, sessionID string) (dbproxy.C
 	return dbproxy.NewCustomQueryRequest(result.ReplaceQuery), nil
 }
 func (q *queryFilter) ResponseCallback(sessionID string, err error) {
+	record := q.getPendingAudit(sessionID)
+
+	if record != nil {
+		if err != nil {
+			record.Outcome = auditOutcomeFailed
+			record.Error = err.Error()
+		} else {
+			record.Outcome = auditOutcomeExecuted
+		}
+	}
 
 	if err != nil {
 		q.Logger.Trace.Printf("DB Proxy Response Error: %s. Canceling TX from a pool", err.Error())
@@ -125,22 +226,184 @@ func (q *queryFilter) ResponseCallback(sessionID string, err error) {
 		}
 
 	} else if tx, ok := q.sessionTransactions[sessionID]; ok {
+		delete(q.sessionTransactions, sessionID)
+
 		// Add the TX to the pool
-		err := q.Node.ReceivedNewTransaction(tx, lib.TXFlagsVerifyAllowMissedForDelete)
+		err := q.addToPool(tx, lib.TXFlagsVerifyAllowMissedForDelete)
 
 		if err != nil {
 			// Rollback?
 			// TODO
 			q.Logger.Trace.Printf("Error adding TX to pool from proxy %x %s", tx.GetID(), err.Error())
+
+			if record != nil {
+				record.Outcome = auditOutcomeTXRejected
+				record.Error = err.Error()
+			}
+			// a client must not see the rejected TX or an older one as a result of this query
+			q.clearLastTX(sessionID)
+		} else {
+			// Notify server thread about new TX completed fine
+			q.blockmakerObj.NewTransaction(tx.GetID())
+
+			q.setLastTX(sessionID, tx)
 		}
+	}
 
-		// Notify server thread about new TX completed fine
+	if record != nil {
+		q.writeAudit(record)
+	}
+}
 
-		q.blockmakerObj.NewTransaction(tx.GetID())
+// New client connected to DB proxy
+func (q *queryFilter) SessionStarted(info dbproxy.DBProxySessionInfo) {
+	q.sessionsLock.Lock()
+	defer q.sessionsLock.Unlock()
 
-		delete(q.sessionTransactions, sessionID)
+	q.sessions[info.SessionID] = info
+}
+
+func (q *queryFilter) SessionClosed(sessionID string) {
+	q.sessionsLock.Lock()
+	defer q.sessionsLock.Unlock()
+
+	delete(q.sessions, sessionID)
+	delete(q.auditRecords, sessionID)
+	delete(q.sessionLastTX, sessionID)
+	delete(q.sessionInfoToSend, sessionID)
+}
+
+// Remember TX info to return it to a client
+func (q *queryFilter) setLastTX(sessionID string, tx *structures.Transaction) {
+	info := proxyTXInfo{}
+	info.ID = hex.EncodeToString(tx.GetID())
+	info.Signer, _ = utils.PubKeyToAddres(tx.ByPubKey)
+	info.Cost = tx.GetSentAmount()
+
+	q.sessionsLock.Lock()
+	defer q.sessionsLock.Unlock()
+
+	q.sessionLastTX[sessionID] = info
+	q.sessionInfoToSend[sessionID] = true
+}
+
+func (q *queryFilter) clearLastTX(sessionID string) {
+	q.sessionsLock.Lock()
+	defer q.sessionsLock.Unlock()
+
+	delete(q.sessionLastTX, sessionID)
+	delete(q.sessionInfoToSend, sessionID)
+}
+
+// Info for OK response after a query created new transaction
+func (q *queryFilter) GetResponseInfo(sessionID string) string {
+	q.sessionsLock.Lock()
+	defer q.sessionsLock.Unlock()
+
+	if !q.sessionInfoToSend[sessionID] {
+		return ""
+	}
+	delete(q.sessionInfoToSend, sessionID)
+
+	info := q.sessionLastTX[sessionID]
+
+	return fmt.Sprintf("OurSQL TX: %s Signer: %s Cost: %s", info.ID, info.Signer, formatProxyAmount(info.Cost))
+}
+
+// Returns response if a query requests last transaction variables
+func (q *queryFilter) getLastTXVariables(query, sessionID string) dbproxy.CustomRequestActionInterface {
+	match := proxyLastTXQueryRegexp.FindStringSubmatch(query)
+
+	if match == nil {
+		return nil
+	}
+
+	q.sessionsLock.Lock()
+	info, found := q.sessionLastTX[sessionID]
+	q.sessionsLock.Unlock()
+
+	columns := []string{}
+	row := []string{}
+
+	for _, v := range strings.Split(match[1], ",") {
+		v = strings.TrimSpace(v)
+		value := ""
+
+		if found {
+			switch strings.ToLower(v) {
+			case proxyVarLastTX:
+				value = info.ID
+			case proxyVarLastTXSigner:
+				value = info.Signer
+			case proxyVarLastTXCost:
+				value = formatProxyAmount(info.Cost)
+			}
+		}
+		columns = append(columns, v)
+		row = append(row, value)
+	}
+
+	return dbproxy.NewCustomDataRowsResponse(columns, [][]string{row})
+}
+
+func formatProxyAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
+
+// Prepare audit record for a query. Outcome is set later
+func (q *queryFilter) newAuditRecord(sessionID, query string) *auditRecord {
+	r := &auditRecord{}
+	r.SessionID = sessionID
+	r.Query = query
+	r.CanonicalQuery = query
+
+	q.sessionsLock.Lock()
+	info, ok := q.sessions[sessionID]
+	q.sessionsLock.Unlock()
+
+	if ok {
+		r.ClientIP, _, _ = net.SplitHostPort(info.ClientAddress)
+		r.User = info.User
 	}
+	return r
 }
+
+func (q *queryFilter) setPendingAudit(sessionID string, r *auditRecord) {
+	if q.audit == nil {
+		return
+	}
+	q.sessionsLock.Lock()
+	defer q.sessionsLock.Unlock()
+
+	q.auditRecords[sessionID] = r
+}
+
+// Returns a record waiting for a response and removes it from the list
+func (q *queryFilter) getPendingAudit(sessionID string) *auditRecord {
+	q.sessionsLock.Lock()
+	defer q.sessionsLock.Unlock()
+
+	r, ok := q.auditRecords[sessionID]
+
+	if !ok {
+		return nil
+	}
+	delete(q.auditRecords, sessionID)
+
+	return r
+}
+
+func (q *queryFilter) writeAudit(r *auditRecord) {
+	if q.audit == nil {
+		return
+	}
+	err := q.audit.Write(*r)
+
+	if err != nil {
+		q.Logger.Error.Printf("Error writing audit log %s", err.Error())
+	}
+}
+
 func (q *queryFilter) Stop() error {
 	q.Logger.Trace.Println("Stop DB proxy")
 
@@ -150,5 +413,9 @@ func (q *queryFilter) Stop() error {
 		q.Logger.Trace.Println("DB proxy stopped")
 	}
 
+	if q.audit != nil {
+		q.audit.Close()
+	}
+
 	return nil
 }
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/dbproxy"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/config"
+	"github.com/gelembjuk/oursql/node/structures"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestQueryFilter(addErr error) *queryFilter {
+	q := &queryFilter{}
+	q.Logger = utils.CreateLogger()
+	q.blockmakerObj = &blocksMaker{}
+	q.sessionTransactions = make(map[string]*structures.Transaction)
+	q.sessions = make(map[string]dbproxy.DBProxySessionInfo)
+	q.auditRecords = make(map[string]*auditRecord)
+	q.sessionLastTX = make(map[string]proxyTXInfo)
+	q.sessionInfoToSend = make(map[string]bool)
+	q.addToPool = func(tx *structures.Transaction, flags int) error {
+		return addErr
+	}
+	return q
+}
+
+func TestProxyTransactionAccepted(t *testing.T) {
+	q := makeTestQueryFilter(nil)
+
+	q.sessionTransactions["s1"] = &structures.Transaction{ID: []byte{1, 2}}
+	q.ResponseCallback("s1", nil)
+
+	assert.Contains(t, q.GetResponseInfo("s1"), "OurSQL TX: 0102 ")
+	assert.Equal(t, "0102", q.sessionLastTX["s1"].ID)
+	assert.Empty(t, q.sessionTransactions)
+}
+
+func TestProxyTransactionRejected(t *testing.T) {
+	q := makeTestQueryFilter(errors.New("Conflicts with other transaction"))
+
+	// info of a previous transaction must not be shown for the rejected one
+	q.setLastTX("s1", &structures.Transaction{ID: []byte{1, 2}})
+
+	q.sessionTransactions["s1"] = &structures.Transaction{ID: []byte{3, 4}}
+	q.ResponseCallback("s1", nil)
+
+	assert.Equal(t, "", q.GetResponseInfo("s1"))
+
+	_, found := q.sessionLastTX["s1"]
+	assert.False(t, found)
+	assert.Empty(t, q.sessionTransactions)
+}
+
+func TestProxyAuditLocalQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "audit.log")
+
+	q := makeTestQueryFilter(nil)
+	q.audit, err = newAuditLog(config.AuditLogConfig{File: file})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.SessionStarted(dbproxy.DBProxySessionInfo{SessionID: "s1", ClientAddress: "10.0.0.1:5000", User: "u1"})
+
+	// answered by the proxy without a query manager
+	r, err := q.RequestCallback("SELECT @oursql_last_tx", "s1")
+	assert.NoError(t, err)
+	assert.NotNil(t, r)
+
+	r, err = q.RequestCallback("SELECT oursql_balance()", "s1")
+	assert.NoError(t, err)
+	assert.NotNil(t, r)
+
+	q.audit.Close()
+
+	f, err := os.Open(file)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records := []auditRecord{}
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		record := auditRecord{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "SELECT @oursql_last_tx", records[0].Query)
+		assert.Equal(t, "SELECT oursql_balance()", records[1].Query)
+
+		for _, record := range records {
+			assert.Equal(t, auditOutcomeExecuted, record.Outcome)
+			assert.Equal(t, "10.0.0.1", record.ClientIP)
+			assert.Equal(t, "u1", record.User)
+		}
+	}
+}
@@ -8,12 +8,14 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gelembjuk/oursql/lib/dbproxy"
 	netlib "github.com/gelembjuk/oursql/lib/net"
 	"github.com/gelembjuk/oursql/lib/nodeclient"
 	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/config"
 	"github.com/gelembjuk/oursql/node/nodemanager"
 )
 
@@ -23,6 +25,7 @@ type NodeServer struct {
 
 	NodeAddress netlib.NodeAddr // Port can be different from NodePort. NodeAddress is address exposed outside
 	NodePort    int             // This is the port where a server will listen
+	addressLock sync.Mutex      // NodeAddress is changed by NAT manager after a server is started
 
 	Transit nodeTransit
 
@@ -33,12 +36,30 @@ type NodeServer struct {
 
 	changesCheckerObj *changesChecker
 	blocksMakerObj    *blocksMaker
+	headersSyncObj    *nodemanager.HeadersSync
+	peersConnectorObj *peersConnector
+	discoveryObj      *nodesDiscovery
+	natManagerObj     *natManager
+	httpAPIObj        *httpAPI
 
 	DBProxyAddr string
 	DBAddr      string
+	AuditLog    config.AuditLogConfig
+	DBProxyPool config.DBProxyPoolConfig
 	QueryFilter *queryFilter
 
 	NodeAuthStr string
+
+	LocalDiscovery bool   // find nodes in local network with multicast
+	NAT            string // map a port on a router. upnp, pmp or auto. Empty to not use
+	OutboundOnly   bool   // don't accept connections from other nodes. Only local connections are accepted
+	RequireTLS     bool   // refuse plain connections. Local connections are still accepted
+
+	MessageLimits *netlib.MessageLimits // max sizes of requests per command
+
+	RateLimiter *netlib.RateLimiter // requests rate and bandwidth per host, count of connections
+
+	HTTPAPI config.HTTPAPIConfig
 }
 
 func (s *NodeServer) GetClient() *nodeclient.NodeClient {
@@ -46,24 +67,115 @@ func (s *NodeServer) GetClient() *nodeclient.NodeClient {
 	return s.Node.NodeClient
 }
 
+// Returns address of the node exposed outside
+func (s *NodeServer) GetNodeAddress() netlib.NodeAddr {
+	s.addressLock.Lock()
+	defer s.addressLock.Unlock()
+
+	return s.NodeAddress
+}
+
+// Set address exposed outside. The client will include it in requests
+func (s *NodeServer) setNodeAddress(address netlib.NodeAddr) {
+	s.addressLock.Lock()
+	s.NodeAddress = address
+	s.addressLock.Unlock()
+
+	if s.OutboundOnly {
+		// zero port tells other nodes to not connect to us. They use persistent connections opened by this node
+		address.Port = 0
+	}
+	s.Node.NodeClient.SetNodeAddress(address)
+}
+
 // handle received data. It can be one way command or a request for some data
 
 func (s *NodeServer) handleConnection(conn net.Conn) {
-	starttime := time.Now().UnixNano()
-	sessid := utils.RandString(5)
+	requestIP := ""
+
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		requestIP = addr.IP.String()
+	}
+
+	if s.Node.NodeNet.IsPeerBanned(requestIP) {
+		s.Logger.Trace.Printf("Connection from banned host %s is closed", requestIP)
+		conn.Close()
+		return
+	}
+
+	// when there are too many connections, a request is read only to respond "busy" in its format
+	busy := !s.RateLimiter.AcquireConnection()
+
+	if !busy {
+		defer s.RateLimiter.ReleaseConnection()
+	}
+
+	// local connections can't be read by others. CLI commands use them to stop a node
+	allowPlain := !s.RequireTLS || isLocalHost(requestIP)
+
+	acceptedConn, peerIdentity, err := netlib.AcceptNodeConnection(conn, s.Node.Identity, allowPlain)
+
+	if err != nil {
+		s.Logger.Trace.Printf("Connection from %s is not accepted: %s", conn.RemoteAddr().String(), err.Error())
+
+		if errv, ok := err.(*netlib.NetworkError); !ok || !errv.IsPlainRefused() {
+			s.reportReadError(requestIP, err)
+		}
+		conn.Close()
+		return
+	}
+	conn = acceptedConn
 
 	//s.Logger.Trace.Printf("New command. Start reading %s", sessid)
 
-	command, request, authstring, err := s.readRequest(conn)
+	command, request, authstring, format, err := s.readRequest(conn)
 
 	if err != nil {
-		s.sendErrorBack(conn, errors.New("Network Data Reading Error: "+err.Error()))
+		s.reportReadError(requestIP, err)
+		s.sendErrorBack(conn, format, errors.New("Network Data Reading Error: "+err.Error()))
+		conn.Close()
+		return
+	}
+
+	if busy {
+		s.sendErrorBack(conn, format, netlib.NewBusyError(fmt.Sprintf("too many connections. Retry after %d seconds", netlib.BusyRetryAfter/time.Second)))
 		conn.Close()
 		return
 	}
 
+	if command == nodeclient.CommandMux && format.Envelope {
+		// the connection stays open
+		s.acceptPersistentConnection(conn, request, format, requestIP, peerIdentity)
+		return
+	}
+
+	dataresponse := s.processRequest(command, request, authstring, format, requestIP, peerIdentity)
+
+	if len(dataresponse) > 0 {
+		_, err := conn.Write(dataresponse)
+
+		if err != nil {
+			s.Logger.Error.Println("Sending response error: ", err.Error())
+		}
+	}
+
+	conn.Close()
+}
+
+// Execute a request. Returns data to send back, it is nil if a command has no response.
+// This is used for requests received on usual and on persistent connections
+func (s *NodeServer) processRequest(command string, request []byte, authstring string, format requestFormat,
+	requestIP string, peerIdentity string) []byte {
+
+	starttime := time.Now().UnixNano()
+	sessid := utils.RandString(5)
+
 	s.Logger.TraceExt.Printf("Received %s command", command)
 
+	if err := s.RateLimiter.AllowRequest(requestIP, command, len(request)); err != nil {
+		return s.errorResponse(format, err)
+	}
+
 	requestobj := NodeServerRequest{}
 	requestobj.Node = s.Node.Clone()
 	requestobj.Node.SessionID = sessid
@@ -73,19 +185,16 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	requestobj.S = s
 	requestobj.S.Node.SessionID = sessid
 	requestobj.SessID = sessid
-
-	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-		requestobj.RequestIP = addr.IP.String()
-	}
+	requestobj.PeerIdentity = peerIdentity
+	requestobj.Format = format
+	requestobj.RequestIP = requestIP
 	request = nil
 
 	// open blockchain. and close in the end ofthis function
-	err = requestobj.Node.DBConn.OpenConnection(sessid)
+	err := requestobj.Node.DBConn.OpenConnection(sessid)
 
 	if err != nil {
-		s.sendErrorBack(conn, errors.New("Blockchain open Error: "+err.Error()))
-		conn.Close()
-		return
+		return s.errorResponse(format, errors.New("Blockchain open Error: "+err.Error()))
 	}
 
 	//s.Logger.Trace.Printf("Nodes Network State: %d , %s", len(requestobj.Node.NodeNet.Nodes), requestobj.Node.NodeNet.Nodes)
@@ -102,6 +211,24 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	case nodeclient.CommandBlock:
 		rerr = requestobj.handleBlock()
 
+	case nodeclient.CommandGetHeaders:
+		rerr = requestobj.handleGetHeaders()
+
+	case nodeclient.CommandGetMerkleProof:
+		rerr = requestobj.handleGetMerkleProof()
+
+	case nodeclient.CommandGetTXStatus:
+		rerr = requestobj.handleGetTXStatus()
+
+	case nodeclient.CommandGetRowHistory:
+		rerr = requestobj.handleGetRowHistory()
+
+	case nodeclient.CommandGetAddr:
+		rerr = requestobj.handleGetAddr()
+
+	case nodeclient.CommandGetChunk:
+		rerr = requestobj.handleGetChunk()
+
 	case nodeclient.CommandGetBlock:
 		rerr = requestobj.handleGetBlock()
 
@@ -156,6 +283,12 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	case nodeclient.CommandGetState:
 		rerr = requestobj.handleGetState()
 
+	case nodeclient.CommandGetBans:
+		rerr = requestobj.handleGetBans()
+
+	case nodeclient.CommandClearBans:
+		rerr = requestobj.handleClearBans()
+
 	case nodeclient.CommandGetUpdates:
 		rerr = requestobj.handleGetUpdates()
 
@@ -168,11 +301,14 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	case "version":
 		rerr = requestobj.handleVersion()
 	default:
+		requestobj.reportMisbehavior(netlib.PenaltyMalformedMessage, "unknown command "+command)
 		rerr = errors.New("Unknown command!")
 	}
 
 	requestobj.Node.DBConn.CloseConnection()
 
+	var dataresponse []byte
+
 	if rerr != nil {
 		s.Logger.Error.Println("Network Command Handle Error: ", rerr.Error())
 		s.Logger.Trace.Println("Network Command Handle Error: ", rerr.Error())
@@ -180,42 +316,35 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 		if requestobj.HasResponse {
 			// return error to the client
 			// first byte is bool false to indicate there was error
-			s.sendErrorBack(conn, rerr)
+			dataresponse = s.errorResponse(format, rerr)
 		}
 	}
 
 	if requestobj.HasResponse && requestobj.Response != nil && rerr == nil {
 		// send this response back
 		// first byte is bool true to indicate request was success
-		dataresponse := append([]byte{1}, requestobj.Response...)
+		if format.Envelope {
+			dataresponse = netlib.EncodeEnvelopeResponse(format.Codec, true, requestobj.Response)
+		} else {
+			dataresponse = append([]byte{1}, requestobj.Response...)
+		}
 
 		s.Logger.TraceExt.Printf("Responding %d bytes\n", len(dataresponse))
-
-		_, err := conn.Write(dataresponse)
-
-		if err != nil {
-			s.Logger.Error.Println("Sending response error: ", err.Error())
-		}
 	}
+	s.RateLimiter.AddSentData(requestIP, len(dataresponse))
+
 	duration := time.Since(time.Unix(0, starttime))
 	ms := duration.Nanoseconds() / int64(time.Millisecond)
 	s.Logger.TraceExt.Printf("Complete processing %s command. Time: %d ms, sess %s", command, ms, sessid)
 
-	conn.Close()
+	return dataresponse
 }
 
 // response error to a client
-func (s *NodeServer) sendErrorBack(conn net.Conn, err error) {
-	s.Logger.Error.Println("Sending back error message: ", err.Error())
-	s.Logger.Trace.Println("Sending back error message: ", err.Error())
-
-	payload, err := netlib.GobEncode(err.Error())
-
-	if err == nil {
-		dataresponse := append([]byte{0}, payload...)
-
-		s.Logger.Trace.Printf("Responding %d bytes as error message\n", len(dataresponse))
+func (s *NodeServer) sendErrorBack(conn net.Conn, format requestFormat, err error) {
+	dataresponse := s.errorResponse(format, err)
 
+	if dataresponse != nil {
 		_, err = conn.Write(dataresponse)
 
 		if err != nil {
@@ -224,10 +353,34 @@ func (s *NodeServer) sendErrorBack(conn net.Conn, err error) {
 	}
 }
 
+// Builds error response in format of a request
+func (s *NodeServer) errorResponse(format requestFormat, err error) []byte {
+	s.Logger.Error.Println("Sending back error message: ", err.Error())
+	s.Logger.Trace.Println("Sending back error message: ", err.Error())
+
+	payload, err := netlib.EncodePayload(format.Codec, err.Error())
+
+	if err != nil {
+		return nil
+	}
+
+	var dataresponse []byte
+
+	if format.Envelope {
+		dataresponse = netlib.EncodeEnvelopeResponse(format.Codec, false, payload)
+	} else {
+		dataresponse = append([]byte{0}, payload...)
+	}
+
+	s.Logger.Trace.Printf("Responding %d bytes as error message\n", len(dataresponse))
+
+	return dataresponse
+}
+
 // Starts a server for node. It listens TPC port and communicates with other nodes and lite clients
 
 func (s *NodeServer) StartServer(serverStartResult chan string) error {
-	s.Logger.Trace.Printf("Prepare server to start %s on a localport %d", s.NodeAddress.NodeAddrToString(), s.NodePort)
+	s.Logger.Trace.Printf("Prepare server to start %s on a localport %d", s.GetNodeAddress().NodeAddrToString(), s.NodePort)
 
 	returnWithError := func(err error) error {
 		serverStartResult <- err.Error()
@@ -238,9 +391,15 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 
 		return err
 	}
+	// genesis and consensus hashes are sent in version, so nodes of other chains don't talk to us
+	err := s.Node.InitChainIdentity()
+
+	if err != nil {
+		return returnWithError(err)
+	}
 	// this channel must be inited here. It is used inside StartDatabaseProxy()
 	// DB proxy wil notify about new transactions using this channel
-	err := s.initBlocksMaker()
+	err = s.initBlocksMaker()
 
 	if err != nil {
 		return returnWithError(err)
@@ -257,7 +416,14 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 	}
 
 	// We listen on a port on all interfaces
-	ln, err := net.Listen(netlib.Protocol, ":"+strconv.Itoa(s.NodePort))
+	listenAddr := ":" + strconv.Itoa(s.NodePort)
+
+	if s.OutboundOnly {
+		// the port is still needed for local clients and to stop the server
+		listenAddr = "127.0.0.1" + listenAddr
+	}
+
+	ln, err := net.Listen(netlib.Protocol, listenAddr)
 
 	if err != nil {
 		return returnWithError(err)
@@ -265,10 +431,14 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 	defer ln.Close()
 
 	// client will use the address to include it in requests
-	s.Node.NodeClient.SetNodeAddress(s.NodeAddress)
+	s.setNodeAddress(s.GetNodeAddress())
 
 	s.Node.SendVersionToNodes([]netlib.NodeAddr{})
 
+	// if blocks are far behind other nodes, load them with headers-first sync
+	s.headersSyncObj = s.Node.NewHeadersSync()
+	s.headersSyncObj.Start()
+
 	s.Logger.Trace.Println("Start block bilding routine")
 
 	// we set buffer to 100 transactions.
@@ -279,6 +449,23 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 	if err != nil {
 		return returnWithError(err)
 	}
+	// keep persistent connections with other nodes
+	s.peersConnectorObj = StartPeersConnector(s)
+	// find more nodes with getaddr and in local network
+	s.discoveryObj = StartNodesDiscovery(s)
+
+	if !s.OutboundOnly {
+		// map a port on a router and detect external address
+		s.natManagerObj = StartNATManager(s)
+	}
+	if s.HTTPAPI.Address != "" {
+		// JSON API for applications
+		s.httpAPIObj, err = StartHTTPAPI(s)
+
+		if err != nil {
+			return returnWithError(err)
+		}
+	}
 	// run blocks maker routine
 	err = s.blocksMakerObj.Start()
 
@@ -337,7 +524,7 @@ func (s *NodeServer) TryToMakeNewBlock(tx []byte) {
 // MySQL proxy server. It is in the middle between a DB server and DB client an reads requests
 func (s *NodeServer) startDatabaseProxy() (started bool, err error) {
 
-	s.QueryFilter, err = InitQueryFilter(s.DBProxyAddr, s.DBAddr, s.Node.Clone(), s.Logger, s.blocksMakerObj)
+	s.QueryFilter, err = InitQueryFilter(s.DBProxyAddr, s.DBAddr, s.Node.Clone(), s.Logger, s.blocksMakerObj, s.AuditLog, s.DBProxyPool)
 	started = true
 
 	if err != nil {
@@ -383,11 +570,36 @@ func (s *NodeServer) stopAllSubroutines() {
 		s.QueryFilter = nil
 	}
 
+	if s.headersSyncObj != nil {
+		s.headersSyncObj.Stop()
+	}
+
 	if s.changesCheckerObj != nil {
 		s.changesCheckerObj.Stop()
 		s.changesCheckerObj = nil
 	}
 
+	if s.discoveryObj != nil {
+		s.discoveryObj.Stop()
+		s.discoveryObj = nil
+	}
+
+	if s.natManagerObj != nil {
+		s.natManagerObj.Stop()
+		s.natManagerObj = nil
+	}
+
+	if s.httpAPIObj != nil {
+		s.httpAPIObj.Stop()
+		s.httpAPIObj = nil
+	}
+
+	if s.peersConnectorObj != nil {
+		s.peersConnectorObj.Stop()
+		s.peersConnectorObj = nil
+	}
+	s.Node.NodeNet.Conns.CloseAll()
+
 	if s.blocksMakerObj != nil {
 		s.blocksMakerObj.Stop()
 
@@ -398,39 +610,76 @@ func (s *NodeServer) stopAllSubroutines() {
 	close(s.StopMainConfirmChan)
 }
 
-// Reads and parses request from network data
-func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error) {
-	// 1. Read command
-	commandbuffer, err := s.readFromConnection(conn, netlib.CommandLength)
+// Format of a request. Response is sent back in same format
+type requestFormat struct {
+	Envelope bool
+	Codec    byte
+}
+
+// Reads and parses request from network data. A request can be in legacy format or in envelope format
+func (s *NodeServer) readRequest(conn io.Reader) (string, []byte, string, requestFormat, error) {
+	format := requestFormat{false, netlib.CodecGob}
+
+	// 1. Read first byte. It is envelope version or first letter of a command
+	firstbyte, err := s.readFromConnection(conn, 1)
+
+	if err != nil {
+		if !isTimeoutError(err) {
+			// connection closed without any data
+			err = io.EOF
+		}
+		return "", nil, "", format, err
+	}
+
+	if netlib.IsEnvelopeRequest(firstbyte) {
+		format.Envelope = true
+
+		command, databuffer, authstr, codec, err := s.readEnvelopeRequest(conn)
+		format.Codec = codec
+
+		return command, databuffer, authstr, format, err
+	}
+
+	// 2. Read rest of command
+	commandbuffer, err := s.readFromConnection(conn, netlib.CommandLength-1)
 
 	if err != nil {
-		return "", nil, "", err
+		return "", nil, "", format, err
 	}
 
-	command := netlib.BytesToCommand(commandbuffer)
+	command := netlib.BytesToCommand(append(firstbyte, commandbuffer...))
 
-	// 2. Get length of command data
+	// 3. Get length of command data
 
 	lengthbuffer, err := s.readFromConnection(conn, 4)
 
 	if err != nil {
-		return "", nil, "", err
+		return "", nil, "", format, err
 	}
 
 	var datalength uint32
 	binary.Read(bytes.NewReader(lengthbuffer), binary.LittleEndian, &datalength)
 
-	// 3. Get length of extra data
+	// 4. Get length of extra data
 	lengthbuffer, err = s.readFromConnection(conn, 4)
 
 	if err != nil {
-		return "", nil, "", err
+		return "", nil, "", format, err
 	}
 
 	var extradatalength uint32
 	binary.Read(bytes.NewReader(lengthbuffer), binary.LittleEndian, &extradatalength)
 
-	// 4. read command data by length
+	// big requests are rejected before data are read
+	if err := s.MessageLimits.CheckLength(command, datalength); err != nil {
+		return "", nil, "", format, err
+	}
+
+	if extradatalength > netlib.MaxAuthStringSize {
+		return "", nil, "", format, errors.New("Extra data are too long")
+	}
+
+	// 5. read command data by length
 	//s.Logger.Trace.Printf("Before read data %d bytes", datalength)
 
 	databuffer := []byte{}
@@ -439,11 +688,11 @@ func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error)
 		databuffer, err = s.readFromConnection(conn, int(datalength))
 
 		if err != nil {
-			return "", nil, "", errors.New(fmt.Sprintf("Error reading %d bytes of request: %s", datalength, err.Error()))
+			return "", nil, "", format, wrapReadError(err, fmt.Sprintf("Error reading %d bytes of request", datalength))
 		}
 	}
 
-	// 5. read extra data by length
+	// 6. read extra data by length
 
 	authstr := ""
 
@@ -451,17 +700,80 @@ func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error)
 		extradatabuffer, err := s.readFromConnection(conn, int(extradatalength))
 
 		if err != nil {
-			return "", nil, "", errors.New(fmt.Sprintf("Error reading %d bytes of extra data: %s", extradatalength, err.Error()))
+			return "", nil, "", format, wrapReadError(err, fmt.Sprintf("Error reading %d bytes of extra data", extradatalength))
 		}
 
 		authstr = netlib.BytesToCommand(extradatabuffer)
 	}
 
-	return command, databuffer, authstr, nil
+	return command, databuffer, authstr, format, nil
+}
+
+// Reads rest of a request in envelope format, after version byte. See lib/net/envelope.go
+func (s *NodeServer) readEnvelopeRequest(conn io.Reader) (string, []byte, string, byte, error) {
+	header, err := s.readFromConnection(conn, 2)
+
+	if err != nil {
+		return "", nil, "", netlib.CodecGob, err
+	}
+
+	codec := header[0]
+
+	if _, err := netlib.GetCodecName(codec); err != nil {
+		// errors are sent back with gob if a codec is not known
+		return "", nil, "", netlib.CodecGob, err
+	}
+
+	if header[1] == 0 {
+		return "", nil, "", codec, errors.New("Command is empty")
+	}
+
+	commandbuffer, err := s.readFromConnection(conn, int(header[1]))
+
+	if err != nil {
+		return "", nil, "", codec, err
+	}
+
+	command := string(commandbuffer)
+
+	authbuffer, err := s.readEnvelopeBytes(conn, netlib.MaxAuthStringSize)
+
+	if err != nil {
+		return "", nil, "", codec, wrapReadError(err, "Error reading auth string")
+	}
+
+	databuffer, err := s.readEnvelopeBytes(conn, s.MessageLimits.GetLimit(command))
+
+	if err != nil {
+		return "", nil, "", codec, wrapReadError(err, "Error reading request")
+	}
+
+	return command, databuffer, string(authbuffer), codec, nil
+}
+
+// Reads length prefixed bytes of envelope. Length is checked before data are read
+func (s *NodeServer) readEnvelopeBytes(conn io.Reader, maxLength uint32) ([]byte, error) {
+	lengthbuffer, err := s.readFromConnection(conn, 4)
+
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(lengthbuffer)
+
+	if length > maxLength {
+		return nil, errors.New(fmt.Sprintf("Data are too long, %d bytes. Limit is %d bytes", length, maxLength))
+	}
+
+	if length == 0 {
+		return []byte{}, nil
+	}
+
+	return s.readFromConnection(conn, int(length))
 }
 
 // Read given amount of bytes from connection
-func (s *NodeServer) readFromConnection(conn net.Conn, countofbytes int) ([]byte, error) {
+func (s *NodeServer) readFromConnection(conn io.Reader, countofbytes int) ([]byte, error) {
 	buff := new(bytes.Buffer)
 
 	pauses := 0
@@ -491,7 +803,7 @@ func (s *NodeServer) readFromConnection(conn net.Conn, countofbytes int) ([]byte
 
 		if read == 0 {
 			if pauses > 30 {
-				break
+				return nil, netlib.NewTimeoutError(fmt.Sprintf("Expected %d bytes, read - %d", countofbytes, buff.Len()))
 			}
 			time.Sleep(1 * time.Second)
 			pauses++
@@ -505,3 +817,41 @@ func (s *NodeServer) readFromConnection(conn net.Conn, countofbytes int) ([]byte
 
 	return buff.Bytes(), nil
 }
+
+// Adds context to an error of reading. Timeout errors are returned as is to detect them later
+func wrapReadError(err error, context string) error {
+	if isTimeoutError(err) {
+		return err
+	}
+	return errors.New(fmt.Sprintf("%s: %s", context, err.Error()))
+}
+
+func isTimeoutError(err error) bool {
+	if errv, ok := err.(*netlib.NetworkError); ok {
+		return errv.IsTimeout()
+	}
+	if errv, ok := err.(net.Error); ok {
+		return errv.Timeout()
+	}
+	return false
+}
+
+// Decrease score of a host which sent broken request or didn't send it in time
+// Connections from local host come from CLI of an operator
+func isLocalHost(ip string) bool {
+	parsed := net.ParseIP(ip)
+
+	return parsed != nil && parsed.IsLoopback()
+}
+
+func (s *NodeServer) reportReadError(host string, err error) {
+	if err == io.EOF {
+		// port check or a client changed mind. not a problem
+		return
+	}
+	if isTimeoutError(err) {
+		s.Node.NodeNet.ReportPeerMisbehavior(host, netlib.PenaltyTimeout, "request timeout")
+		return
+	}
+	s.Node.NodeNet.ReportPeerMisbehavior(host, netlib.PenaltyMalformedMessage, "malformed request")
+}
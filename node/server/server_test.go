@@ -1,9 +1,98 @@
 package server
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	netlib "github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/nodemanager"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestServerStart(t *testing.T) {
 
 }
+
+func TestRequireTLSRefusesPlain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeidentity")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	identity, err := netlib.LoadNodeIdentity(filepath.Join(dir, "node.pem"))
+	assert.NoError(t, err)
+
+	s := &NodeServer{}
+	s.Logger = utils.CreateLogger()
+	s.RequireTLS = true
+	s.RateLimiter = netlib.NewRateLimiter(nil, 0, 0)
+	s.Node = &nodemanager.Node{}
+	s.Node.Identity = identity
+
+	score := s.Node.NodeNet.GetPeerScore("")
+
+	client, conn := net.Pipe()
+	defer client.Close()
+
+	done := make(chan bool)
+
+	go func() {
+		s.handleConnection(conn)
+		close(done)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Write([]byte("getblocks"))
+
+	// closed without a response
+	_, err = client.Read(make([]byte, 10))
+	assert.Error(t, err)
+
+	<-done
+
+	// refused plain connection is not a misbehavior
+	assert.Equal(t, score, s.Node.NodeNet.GetPeerScore(""))
+
+	assert.True(t, isLocalHost("127.0.0.1"))
+	assert.True(t, isLocalHost("::1"))
+	assert.False(t, isLocalHost("10.0.0.1"))
+	assert.False(t, isLocalHost(""))
+}
+
+func TestNATSetNodeAddress(t *testing.T) {
+	s := &NodeServer{}
+	s.Logger = utils.CreateLogger()
+	s.Node = &nodemanager.Node{}
+	s.Node.NodeClient = &nodeclient.NodeClient{}
+	s.NodeAddress = netlib.NewNodeAddr("", 8765)
+	s.OutboundOnly = true
+
+	c := &natManager{S: s, logger: s.Logger, autoHost: true}
+
+	done := make(chan bool)
+
+	// requests read the address while NAT manager changes it
+	go func() {
+		for i := 0; i < 100; i++ {
+			s.GetNodeAddress()
+			s.Node.NodeClient.GetNodeAddress()
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 100; i++ {
+		c.setHost(fmt.Sprintf("10.0.0.%d", i%2+1))
+	}
+	<-done
+
+	assert.Equal(t, "10.0.0.2", s.GetNodeAddress().Host)
+	assert.Equal(t, 8765, s.GetNodeAddress().Port)
+	// outbound-only node keeps zero port in requests
+	assert.Equal(t, netlib.NewNodeAddr("10.0.0.2", 0), s.Node.NodeClient.GetNodeAddress())
+}
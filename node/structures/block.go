@@ -10,6 +10,14 @@ import (
 	"github.com/gelembjuk/oursql/lib/utils"
 )
 
+// Versions of blocks. Legacy blocks have a hash of whole transactions in PoW data.
+// Blocks of version 1 have Merkle root of transactions IDs, so inclusion of a transaction
+// can be proved without a block body
+const (
+	BlockVersionLegacy     = 0
+	BlockVersionMerkleRoot = 1
+)
+
 // Block represents a block in the blockchain
 type Block struct {
 	Timestamp     int64
@@ -18,6 +26,8 @@ type Block struct {
 	Hash          []byte
 	Nonce         int
 	Height        int
+	Version       int
+	MerkleRoot    []byte // merkle root of transactions IDs. Empty for legacy blocks
 }
 
 // short info about a block. to exchange over network
@@ -25,6 +35,19 @@ type BlockShort struct {
 	PrevBlockHash []byte
 	Hash          []byte
 	Height        int
+	MerkleRoot    []byte
+}
+
+// Header of a block. It has all data needed to check PoW of a block without transactions.
+// It is used to sync blockchain headers first
+type BlockHeader struct {
+	Timestamp        int64
+	PrevBlockHash    []byte
+	Hash             []byte
+	Nonce            int
+	Height           int
+	TransactionsHash []byte // merkle root of transactions. Merkle root of transactions IDs since version 1
+	Version          int
 }
 
 // simpler representation of a block. transactions are presented as strings
@@ -96,10 +119,66 @@ func (b *Block) GetShortCopy() *BlockShort {
 	bs.Hash = b.Hash[:]
 	bs.PrevBlockHash = b.PrevBlockHash[:]
 	bs.Height = b.Height
+	bs.MerkleRoot = b.MerkleRoot[:]
 
 	return &bs
 }
 
+// Returns header of a block
+func (b *Block) GetHeader() (*BlockHeader, error) {
+	txshash, err := b.GetTransactionsRoot()
+
+	if err != nil {
+		return nil, err
+	}
+
+	h := BlockHeader{}
+	h.Timestamp = b.Timestamp
+	h.PrevBlockHash = b.PrevBlockHash[:]
+	h.Hash = b.Hash[:]
+	h.Nonce = b.Nonce
+	h.Height = b.Height
+	h.TransactionsHash = txshash
+	h.Version = b.Version
+
+	return &h, nil
+}
+
+// Check if a block has same hashes as the header
+func (h *BlockHeader) MatchesBlock(b *Block) (bool, error) {
+	if !bytes.Equal(h.Hash, b.Hash) || !bytes.Equal(h.PrevBlockHash, b.PrevBlockHash) ||
+		h.Height != b.Height || h.Version != b.Version {
+		return false, nil
+	}
+
+	txshash, err := b.GetTransactionsRoot()
+
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(h.TransactionsHash, txshash), nil
+}
+
+// Serialise BlockHeader to bytes
+func (h *BlockHeader) Serialize() ([]byte, error) {
+	var result bytes.Buffer
+	encoder := gob.NewEncoder(&result)
+
+	err := encoder.Encode(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Bytes(), nil
+}
+
+// Deserialize BlockHeader from bytes
+func (h *BlockHeader) DeserializeHeader(d []byte) error {
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+
+	return decoder.Decode(h)
+}
+
 // Returns simpler copy of a block. This is the version for easy print
 // TODO . not sure we really need this
 func (b *Block) GetSimpler() *BlockSimpler {
@@ -136,6 +215,13 @@ func (b *Block) Copy() *Block {
 
 	bc.Nonce = b.Nonce
 	bc.Height = b.Height
+	bc.Version = b.Version
+
+	bc.MerkleRoot = make([]byte, len(b.MerkleRoot))
+
+	if len(b.MerkleRoot) > 0 {
+		copy(bc.MerkleRoot, b.MerkleRoot)
+	}
 
 	for _, t := range b.Transactions {
 		tc, _ := t.Copy()
@@ -185,6 +271,66 @@ func (b *Block) HashTransactions() ([]byte, error) {
 	return mTree.RootNode.Data, nil
 }
 
+// Returns IDs of all transactions in the block in same order
+func (b *Block) GetTransactionsIDs() [][]byte {
+	list := [][]byte{}
+
+	for _, tx := range b.Transactions {
+		list = append(list, tx.GetID())
+	}
+	return list
+}
+
+// Merkle root of transactions IDs
+func (b *Block) MakeMerkleRoot() []byte {
+	return utils.GetMerkleRoot(b.GetTransactionsIDs())
+}
+
+// Hash of transactions used in PoW data and in a header. It depends on a version of the block
+func (b *Block) GetTransactionsRoot() ([]byte, error) {
+	if b.Version >= BlockVersionMerkleRoot {
+		return b.MakeMerkleRoot(), nil
+	}
+	return b.HashTransactions()
+}
+
+// Checks Merkle root of the block. IDs of transactions must be hashes of transactions data,
+// in other case the root doesn't prove anything
+func (b *Block) VerifyMerkleRoot() error {
+	if b.Version < BlockVersionMerkleRoot {
+		return nil
+	}
+
+	for _, tx := range b.Transactions {
+		err := tx.VerifyID()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !bytes.Equal(b.MerkleRoot, b.MakeMerkleRoot()) {
+		return errors.New(fmt.Sprintf("Merkle root of block %x is wrong", b.Hash))
+	}
+	return nil
+}
+
+// Returns proof of inclusion of a transaction to Merkle root of the block
+func (b *Block) GetMerkleProof(txID []byte) ([]utils.MerkleProofStep, error) {
+	if b.Version < BlockVersionMerkleRoot {
+		return nil, errors.New(fmt.Sprintf("Block %x has no Merkle root of transactions IDs", b.Hash))
+	}
+
+	ids := b.GetTransactionsIDs()
+
+	for i, id := range ids {
+		if bytes.Equal(id, txID) {
+			return utils.GetMerkleProof(ids, i)
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("Transaction %x is not in block %x", txID, b.Hash))
+}
+
 // Serialize serializes the block
 func (b *Block) Serialize() ([]byte, error) {
 	var result bytes.Buffer
@@ -1,13 +1,79 @@
 package structures
 
 import (
+	"bytes"
 	"testing"
+
+	"github.com/gelembjuk/oursql/lib/utils"
 )
 
 func TestCopyBlock(t *testing.T) {
 
 }
 
+func TestBlockMerkleRoot(t *testing.T) {
+	txs := []Transaction{}
+
+	for i := 0; i < 3; i++ {
+		tx, _ := NewCoinbaseTransaction("", "", float64(i+1))
+		txs = append(txs, *tx)
+	}
+
+	b := Block{}
+	b.PrepareNewBlock(txs, []byte{1, 2, 3}, 5)
+	b.Version = BlockVersionMerkleRoot
+	b.MerkleRoot = b.MakeMerkleRoot()
+
+	data, err := b.Serialize()
+
+	if err != nil {
+		t.Fatalf("Serialize error %s", err.Error())
+	}
+
+	b2, err := NewBlockFromBytes(data)
+
+	if err != nil {
+		t.Fatalf("Deserialize error %s", err.Error())
+	}
+
+	if err := b2.VerifyMerkleRoot(); err != nil {
+		t.Fatalf("Merkle root is not valid: %s", err.Error())
+	}
+
+	h, err := b2.GetHeader()
+
+	if err != nil {
+		t.Fatalf("Header error %s", err.Error())
+	}
+
+	if !bytes.Equal(h.TransactionsHash, b.MerkleRoot) || h.Version != BlockVersionMerkleRoot {
+		t.Fatalf("Header has no Merkle root of the block")
+	}
+
+	proof, err := b2.GetMerkleProof(txs[1].GetID())
+
+	if err != nil {
+		t.Fatalf("Proof error %s", err.Error())
+	}
+
+	if !utils.VerifyMerkleProof(txs[1].GetID(), proof, h.TransactionsHash) {
+		t.Fatalf("Proof is not valid")
+	}
+
+	// transaction changed, ID is same
+	b2.Transactions[1].Vout[0].Value = 100
+
+	if err := b2.VerifyMerkleRoot(); err == nil {
+		t.Fatalf("Changed transaction is not detected")
+	}
+
+	b.Version = BlockVersionLegacy
+
+	if _, err := b.GetMerkleProof(txs[1].GetID()); err == nil {
+		t.Fatalf("Legacy block has no proofs")
+	}
+}
+
 /*
 func TestDeserialiseBlock(t *testing.T) {
 	data := []string{
@@ -17,6 +17,17 @@ func NewBlockShortFromBytes(bsdata []byte) (*BlockShort, error) {
 	return bs, nil
 }
 
+// Make BlockHeader object from bytes
+func NewBlockHeaderFromBytes(data []byte) (*BlockHeader, error) {
+	h := &BlockHeader{}
+	err := h.DeserializeHeader(data)
+
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
 // Make Block object from bytes
 func NewBlockFromBytes(bsdata []byte) (*Block, error) {
 	bs := &Block{}
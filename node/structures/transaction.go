@@ -99,6 +99,22 @@ func (tx *Transaction) makeHash() ([]byte, error) {
 	return tx.ID, nil
 }
 
+// Checks if ID of a transaction is a hash of its data
+func (tx Transaction) VerifyID() error {
+	id := tx.ID
+
+	hash, err := tx.makeHash()
+
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(id, hash) {
+		return errors.New(fmt.Sprintf("ID of transaction %x doesn't match its data", id))
+	}
+	return nil
+}
+
 // TrimmedCopy creates a trimmed copy of Transaction to be used in signing
 func (tx Transaction) Copy() (*Transaction, error) {
 	//if tx.IsCoinbase() {
@@ -344,6 +360,20 @@ func (tx Transaction) CreatedByPubKeyHash(pubKeyHash []byte) bool {
 	return bytes.Compare(lockingHash, pubKeyHash) == 0
 }
 
+// Returns amount sent to other addresses. Outputs back to the creator (change) are not counted
+// For SQL transaction this is a cost of a query
+func (tx Transaction) GetSentAmount() float64 {
+	amount := 0.0
+
+	for _, out := range tx.Vout {
+		if tx.CreatedByPubKeyHash(out.PubKeyHash) {
+			continue
+		}
+		amount += out.Value
+	}
+	return amount
+}
+
 //
 func (tx Transaction) GetTime() int64 {
 	return tx.Time
@@ -8,3 +8,42 @@ type TransactionsHistory struct {
 	Address string
 	Value   float64
 }
+
+// States of a transaction in a node
+const (
+	TXStatePending    = "pending"    // in a pool of a node
+	TXStateInBlock    = "inblock"    // in a block of primary chain, but not deep enough to be final
+	TXStateFinal      = "final"      // there are final depth blocks or more in primary chain, including a block with the transaction
+	TXStateConflicted = "conflicted" // was in a block of other branch, other transaction in primary chain uses same inputs or row
+	TXStateDropped    = "dropped"    // was in a block of other branch and was not added back to a pool
+	TXStateUnknown    = "unknown"    // a node doesn't know the transaction
+)
+
+// Default number of confirmations when a transaction is final
+const DefaultFinalDepth = 6
+
+type TransactionStatus struct {
+	TXID          []byte
+	State         string
+	BlockHash     []byte // block of primary chain with the transaction
+	Height        int    // height of the block or -1
+	Confirmations int    // 1 if the block is on top of primary chain
+	FinalDepth    int
+	SideBlocks    [][]byte // blocks out of primary chain with the transaction
+	ConflictTX    []byte   // transaction in primary chain which is used instead, if it is known
+	Reason        string   // why the transaction conflicts
+}
+
+// One change of a DB row. Query is SQL of the change, RollbackQuery returns a row to the state before it
+type RowChange struct {
+	TXID          []byte
+	Kind          string // insert, update or delete
+	BlockHash     []byte
+	Height        int
+	BlockTime     int64 // seconds
+	TXTime        int64 // nanoseconds
+	Signer        string
+	Query         string
+	RollbackQuery string
+	BaseTX        []byte // previous change of the row. For a first insert it is a table create transaction
+}
@@ -8,6 +8,7 @@ import (
 )
 
 type UnApprovedTransactionCallbackInterface func(txhash, txstr string) error
+type SQLQueryCallbackInterface func(sql string) error
 type UnspentTransactionOutputCallbackInterface func(fromaddr string, value float64, txID []byte, output int, isbase bool) error
 
 type TransactionsManagerInterface interface {
@@ -19,6 +20,18 @@ type TransactionsManagerInterface interface {
 	GetUnapprovedTransactionsFiltered(minCreateTime int64, maxCount int, ignoreTransactions [][]byte) ([][]byte, error)
 	GetIfExists(txid []byte) (*structures.Transaction, error)
 	GetIfUnapprovedExists(txid []byte) (*structures.Transaction, error)
+	// Returns hash of a block in primary chain with the transaction. nil if it is not in a block
+	GetTransactionBlock(txid []byte) ([]byte, error)
+	// Returns state of a transaction: pending, in a block, final, conflicted or dropped
+	GetTransactionStatus(txid []byte, finalDepth int) (*structures.TransactionStatus, error)
+	// Returns ID of last transaction in primary chain which changed a row
+	GetTransactionForRow(refID []byte) ([]byte, error)
+	// Returns all changes of a row in primary chain, last change first
+	GetRowHistory(table string, key string) ([]structures.RowChange, error)
+	// Queries of SQL transactions from genesis block to a height, in order of execution
+	ForEachQueryTillHeight(height int, table string, callback SQLQueryCallbackInterface) error
+	// Rollback queries of transactions from the pool and blocks above a height, last transaction first
+	ForEachRollbackAfterHeight(height int, table string, callback SQLQueryCallbackInterface) error
 
 	VerifyTransaction(tx *structures.Transaction, prevtxs []structures.Transaction, tip []byte, flags int) (bool, error)
 
@@ -955,6 +955,18 @@ func (n *txManager) GetIfUnapprovedExists(txid []byte) (*structures.Transaction,
 	return nil, nil
 }
 
+// Find a block of primary chain where the transaction is
+func (n *txManager) GetTransactionBlock(txid []byte) ([]byte, error) {
+	_, _, blockHash, err := n.getIndexManager().GetCurrencyTransactionAllInfo(txid, []byte{})
+
+	return blockHash, err
+}
+
+// Find last transaction which changed a row
+func (n *txManager) GetTransactionForRow(refID []byte) ([]byte, error) {
+	return n.getDataRowsAndTransacionsManager().GetTXForRefID(refID)
+}
+
 // Calculates pending balance of address.
 func (n *txManager) getAddressPendingBalance(address string) (float64, error) {
 	PubKeyHash, _ := utils.AddresToPubKeyHash(address)
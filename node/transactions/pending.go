@@ -86,7 +86,7 @@ func (u *unApprovedTransactions) renewCache() error {
 	allPairs, err := utdb.GetAll()
 
 	if err != nil {
-		u.Logger.Trace.Println("Loading error %s ", err.Error())
+		u.Logger.Trace.Printf("Loading error %s ", err.Error())
 		return err
 	}
 
@@ -0,0 +1,208 @@
+package transactions
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/gelembjuk/oursql/lib"
+	"github.com/gelembjuk/oursql/node/blockchain"
+	"github.com/gelembjuk/oursql/node/dbquery/sqlparser"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Returns a table and a kind of SQL transaction query
+func getSQLTableAndKind(tx *structures.Transaction) (string, string, error) {
+	parser := sqlparser.NewSqlParser()
+
+	err := parser.Parse(tx.GetSQLQuery())
+
+	if err != nil {
+		return "", "", errors.New(fmt.Sprintf("Query of transaction %x can not be parsed: %s", tx.GetID(), err.Error()))
+	}
+	return parser.GetTable(), parser.GetKind(), nil
+}
+
+func (n *txManager) checkHeightInChain(bcMan *blockchain.Blockchain, height int) error {
+	bestHeight, err := bcMan.GetBestHeight()
+
+	if err != nil {
+		return err
+	}
+
+	if height < 0 || height > bestHeight {
+		return errors.New(fmt.Sprintf("Height must be from 0 to %d", bestHeight))
+	}
+	return nil
+}
+
+// Passes queries of SQL transactions in primary chain from genesis block to given height, in order of execution.
+// Executed on empty DB they make tables as they were at that height. If table is not empty, only queries of the table are passed
+func (n *txManager) ForEachQueryTillHeight(height int, table string, callback SQLQueryCallbackInterface) error {
+	bcMan, err := blockchain.NewBlockchainManager(n.DB, n.Logger)
+
+	if err != nil {
+		return err
+	}
+
+	err = n.checkHeightInChain(bcMan, height)
+
+	if err != nil {
+		return err
+	}
+
+	bcdb, err := n.DB.GetBlockchainObject()
+
+	if err != nil {
+		return err
+	}
+
+	hash, err := bcMan.GetGenesisBlockHash()
+
+	if err != nil {
+		return err
+	}
+
+	for len(hash) > 0 {
+		block, err := bcMan.GetBlock(hash)
+
+		if err != nil {
+			return err
+		}
+
+		if block.Height > height {
+			break
+		}
+
+		for i := range block.Transactions {
+			tx := &block.Transactions[i]
+
+			if !tx.IsSQLCommand() {
+				continue
+			}
+
+			if table != "" {
+				txTable, _, err := getSQLTableAndKind(tx)
+
+				if err != nil {
+					return err
+				}
+
+				if txTable != table {
+					continue
+				}
+			}
+
+			err = callback(tx.GetSQLQuery())
+
+			if err != nil {
+				return err
+			}
+		}
+
+		_, _, hash, err = bcdb.GetLocationInChain(hash)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Passes rollback queries of SQL transactions from the pool and from blocks above given height, last transaction first.
+// Executed on a copy of current tables they return tables to the state at that height.
+// If table is not empty, only queries of the table are passed
+func (n *txManager) ForEachRollbackAfterHeight(height int, table string, callback SQLQueryCallbackInterface) error {
+	bcMan, err := blockchain.NewBlockchainManager(n.DB, n.Logger)
+
+	if err != nil {
+		return err
+	}
+
+	err = n.checkHeightInChain(bcMan, height)
+
+	if err != nil {
+		return err
+	}
+
+	rollback := func(tx *structures.Transaction) error {
+		if !tx.IsSQLCommand() {
+			return nil
+		}
+
+		txTable, kind, err := getSQLTableAndKind(tx)
+
+		if err != nil {
+			return err
+		}
+
+		if table != "" && txTable != table {
+			return nil
+		}
+
+		if len(tx.SQLCommand.RollbackQuery) == 0 {
+			if kind == lib.QueryKindDrop {
+				return errors.New(fmt.Sprintf("Table %s was dropped after height %d, it can not be restored with rollback. Replay from genesis block", txTable, height))
+			}
+			return nil
+		}
+		return callback(string(tx.SQLCommand.RollbackQuery))
+	}
+
+	// transactions of the pool are executed on a DB too
+	poolSize, err := n.GetUnapprovedCount()
+
+	if err != nil {
+		return err
+	}
+
+	pool := []*structures.Transaction{}
+
+	if poolSize > 0 {
+		pool, err = n.getUnapprovedTransactionsManager().GetTransactions(poolSize)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// GetTransactions returns oldest transaction first
+	sort.Sort(sort.Reverse(structures.Transactions(pool)))
+
+	for _, tx := range pool {
+		err = rollback(tx)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	bci, err := blockchain.NewBlockchainIterator(n.DB)
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		block, err := bci.Next()
+
+		if err != nil {
+			return err
+		}
+
+		if block.Height <= height {
+			break
+		}
+
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			err = rollback(&block.Transactions[i])
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,329 @@
+package transactions
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/database"
+	"github.com/gelembjuk/oursql/node/structures"
+	"github.com/stretchr/testify/assert"
+)
+
+var errTestDBNotUsed = errors.New("Not used in test")
+
+// DB in memory with blocks and a pool. Index of transactions and rows references are optional,
+// they are not needed for point in time queries
+type testDBManager struct {
+	bc    *testBlockchainDB
+	pool  *testPoolDB
+	index *testIndexDB
+	refs  *testRefsDB
+}
+
+func (m *testDBManager) QM() database.DBQueryManager                                { return nil }
+func (m *testDBManager) SetConfig(config database.DatabaseConfig) error             { return nil }
+func (m *testDBManager) SetLogger(logger *utils.LoggerMan) error                    { return nil }
+func (m *testDBManager) GetLockerObject() database.DatabaseLocker                   { return nil }
+func (m *testDBManager) SetLockerObject(lockerobj database.DatabaseLocker)          {}
+func (m *testDBManager) InitDatabase() error                                        { return nil }
+func (m *testDBManager) CheckDBExists() (bool, error)                               { return true, nil }
+func (m *testDBManager) CheckConnection() error                                     { return nil }
+func (m *testDBManager) OpenConnection() error                                      { return nil }
+func (m *testDBManager) CloseConnection() error                                     { return nil }
+func (m *testDBManager) IsConnectionOpen() bool                                     { return true }
+func (m *testDBManager) BeginSnapshot() error                                       { return nil }
+func (m *testDBManager) EndSnapshot() error                                         { return nil }
+func (m *testDBManager) GetBlockchainObject() (database.BlockchainInterface, error) { return m.bc, nil }
+func (m *testDBManager) GetTransactionsObject() (database.TranactionsInterface, error) {
+	if m.index == nil {
+		return nil, errTestDBNotUsed
+	}
+	return m.index, nil
+}
+func (m *testDBManager) GetUnapprovedTransactionsObject() (database.UnapprovedTransactionsInterface, error) {
+	return m.pool, nil
+}
+func (m *testDBManager) GetUnspentOutputsObject() (database.UnspentOutputsInterface, error) {
+	return nil, errTestDBNotUsed
+}
+func (m *testDBManager) GetNodesObject() (database.NodesInterface, error) {
+	return nil, errTestDBNotUsed
+}
+func (m *testDBManager) GetDataReferencesObject() (database.DataReferencesaInterface, error) {
+	if m.refs == nil {
+		return nil, errTestDBNotUsed
+	}
+	return m.refs, nil
+}
+
+type testBlockchainDB struct {
+	blocks map[string][]byte
+	prev   map[string][]byte
+	next   map[string][]byte
+	first  []byte
+	top    []byte
+}
+
+func (b *testBlockchainDB) InitDB() error                        { return nil }
+func (b *testBlockchainDB) GetTopBlock() ([]byte, error)         { return b.blocks[string(b.top)], nil }
+func (b *testBlockchainDB) GetBlock(hash []byte) ([]byte, error) { return b.blocks[string(hash)], nil }
+func (b *testBlockchainDB) PutBlockOnTop(hash []byte, blockdata []byte) error {
+	return errTestDBNotUsed
+}
+func (b *testBlockchainDB) PutBlock(hash []byte, blockdata []byte) error { return errTestDBNotUsed }
+func (b *testBlockchainDB) CheckBlockExists(hash []byte) (bool, error) {
+	_, ok := b.blocks[string(hash)]
+	return ok, nil
+}
+func (b *testBlockchainDB) DeleteBlock(hash []byte) error   { return errTestDBNotUsed }
+func (b *testBlockchainDB) SaveTopHash(hash []byte) error   { return errTestDBNotUsed }
+func (b *testBlockchainDB) GetTopHash() ([]byte, error)     { return b.top, nil }
+func (b *testBlockchainDB) SaveFirstHash(hash []byte) error { return errTestDBNotUsed }
+func (b *testBlockchainDB) GetFirstHash() ([]byte, error)   { return b.first, nil }
+func (b *testBlockchainDB) GetLocationInChain(hash []byte) (bool, []byte, []byte, error) {
+	if _, ok := b.prev[string(hash)]; !ok {
+		return false, nil, nil, nil
+	}
+	return true, b.prev[string(hash)], b.next[string(hash)], nil
+}
+func (b *testBlockchainDB) BlockInChain(hash []byte) (bool, error) {
+	_, ok := b.prev[string(hash)]
+	return ok, nil
+}
+func (b *testBlockchainDB) RemoveFromChain(hash []byte) error      { return errTestDBNotUsed }
+func (b *testBlockchainDB) AddToChain(hash, prevHash []byte) error { return errTestDBNotUsed }
+
+// Add a block with transactions on top
+func (b *testBlockchainDB) addBlock(t *testing.T, txs ...structures.Transaction) *structures.Block {
+	block := &structures.Block{}
+	block.Height = len(b.blocks)
+	block.Hash = []byte{byte(block.Height + 1)}
+	block.PrevBlockHash = b.top
+	block.Transactions = txs
+
+	data, err := block.Serialize()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.blocks[string(block.Hash)] = data
+	b.prev[string(block.Hash)] = b.top
+
+	if len(b.top) > 0 {
+		b.next[string(b.top)] = block.Hash
+	} else {
+		b.first = block.Hash
+	}
+	b.top = block.Hash
+
+	return block
+}
+
+// Add a block of a side branch. It is not in the chain
+func (b *testBlockchainDB) addSideBlock(t *testing.T, hash []byte, prev *structures.Block, txs ...structures.Transaction) *structures.Block {
+	block := &structures.Block{}
+	block.Height = prev.Height + 1
+	block.Hash = hash
+	block.PrevBlockHash = prev.Hash
+	block.Transactions = txs
+
+	data, err := block.Serialize()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.blocks[string(block.Hash)] = data
+
+	return block
+}
+
+type testPoolDB struct {
+	txs [][][]byte
+}
+
+func (p *testPoolDB) InitDB() error     { return nil }
+func (p *testPoolDB) TruncateDB() error { return errTestDBNotUsed }
+func (p *testPoolDB) ForEach(callback database.ForEachKeyIteratorInterface) error {
+	for _, row := range p.txs {
+		if err := callback(row[0], row[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (p *testPoolDB) GetCount() (int, error)                          { return len(p.txs), nil }
+func (p *testPoolDB) GetAll() ([][][]byte, error)                     { return p.txs, nil }
+func (p *testPoolDB) PutTransaction(txID []byte, txdata []byte) error { return errTestDBNotUsed }
+func (p *testPoolDB) DeleteTransaction(txID []byte) error             { return errTestDBNotUsed }
+func (p *testPoolDB) GetTransaction(txID []byte) ([]byte, error) {
+	for _, row := range p.txs {
+		if bytes.Equal(row[0], txID) {
+			return row[1], nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *testPoolDB) addTransaction(t *testing.T, tx structures.Transaction) {
+	data, err := structures.SerializeTransaction(&tx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.txs = append(p.txs, [][]byte{tx.ID, data})
+}
+
+// Index of transactions to blocks
+type testIndexDB struct {
+	blocks map[string][]byte
+}
+
+func (i *testIndexDB) InitDB() error     { return nil }
+func (i *testIndexDB) TruncateDB() error { return errTestDBNotUsed }
+func (i *testIndexDB) PutTXToBlockLink(txID []byte, blockHash []byte) error {
+	i.blocks[string(txID)] = blockHash
+	return nil
+}
+func (i *testIndexDB) GetBlockHashForTX(txID []byte) ([]byte, error) {
+	return i.blocks[string(txID)], nil
+}
+func (i *testIndexDB) DeleteTXToBlockLink(txID []byte) error {
+	delete(i.blocks, string(txID))
+	return nil
+}
+func (i *testIndexDB) PutTXSpentOutputs(txID []byte, outputs []byte) error { return errTestDBNotUsed }
+func (i *testIndexDB) GetTXSpentOutputs(txID []byte) ([]byte, error)       { return nil, nil }
+func (i *testIndexDB) DeleteTXSpentData(txID []byte) error                 { return errTestDBNotUsed }
+
+// Last transaction of each row
+type testRefsDB struct {
+	txs map[string][]byte
+}
+
+func (r *testRefsDB) InitDB() error     { return nil }
+func (r *testRefsDB) TruncateDB() error { return errTestDBNotUsed }
+func (r *testRefsDB) SetTXForRefID(RefID []byte, txID []byte) error {
+	r.txs[string(RefID)] = txID
+	return nil
+}
+func (r *testRefsDB) GetTXForRefID(RefID []byte) ([]byte, error) { return r.txs[string(RefID)], nil }
+func (r *testRefsDB) DeleteRefID(RefID []byte) error {
+	delete(r.txs, string(RefID))
+	return nil
+}
+
+func makeTestSQLTX(id byte, time int64, query string, rollback string) structures.Transaction {
+	tx := structures.Transaction{ID: []byte{id}, Time: time}
+	tx.SQLCommand.Query = []byte(query)
+	tx.SQLCommand.RollbackQuery = []byte(rollback)
+	return tx
+}
+
+// Blocks:
+// 0: create tables members and orders
+// 1: insert a member
+// 2: update the member
+// 3: insert an order
+// Pool: update the member again
+func makeTestPointInTimeManager(t *testing.T) (*txManager, *testDBManager) {
+	db := &testDBManager{}
+	db.bc = &testBlockchainDB{map[string][]byte{}, map[string][]byte{}, map[string][]byte{}, nil, []byte{}}
+	db.pool = &testPoolDB{}
+
+	db.bc.addBlock(t,
+		makeTestSQLTX(1, 1, "CREATE TABLE members (id INT PRIMARY KEY, name VARCHAR(50))", "DROP TABLE members"),
+		makeTestSQLTX(2, 2, "CREATE TABLE orders (id INT PRIMARY KEY, amount INT)", "DROP TABLE orders"))
+	db.bc.addBlock(t,
+		makeTestSQLTX(3, 3, "INSERT INTO members (id, name) VALUES (1, 'a')", "DELETE FROM members WHERE id=1"))
+	db.bc.addBlock(t,
+		makeTestSQLTX(4, 4, "UPDATE members SET name='b' WHERE id=1", "UPDATE members SET name='a' WHERE id=1"))
+	db.bc.addBlock(t,
+		makeTestSQLTX(5, 5, "INSERT INTO orders (id, amount) VALUES (1, 10)", "DELETE FROM orders WHERE id=1"))
+
+	db.pool.addTransaction(t, makeTestSQLTX(6, 6, "UPDATE members SET name='c' WHERE id=1", "UPDATE members SET name='b' WHERE id=1"))
+
+	// pool cache is shared by the package, it must be loaded from this DB
+	transactionsCache = nil
+
+	return NewManager(db, utils.CreateLogger(), structures.ConsensusInfo{}).(*txManager), db
+}
+
+func collectTestQueries(call func(callback SQLQueryCallbackInterface) error) ([]string, error) {
+	list := []string{}
+
+	err := call(func(sql string) error {
+		list = append(list, sql)
+		return nil
+	})
+	return list, err
+}
+
+func TestForEachQueryTillHeight(t *testing.T) {
+	tm, _ := makeTestPointInTimeManager(t)
+
+	list, err := collectTestQueries(func(cb SQLQueryCallbackInterface) error { return tm.ForEachQueryTillHeight(1, "", cb) })
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"CREATE TABLE members (id INT PRIMARY KEY, name VARCHAR(50))",
+		"CREATE TABLE orders (id INT PRIMARY KEY, amount INT)",
+		"INSERT INTO members (id, name) VALUES (1, 'a')"}, list)
+
+	list, err = collectTestQueries(func(cb SQLQueryCallbackInterface) error { return tm.ForEachQueryTillHeight(3, "orders", cb) })
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"CREATE TABLE orders (id INT PRIMARY KEY, amount INT)",
+		"INSERT INTO orders (id, amount) VALUES (1, 10)"}, list)
+
+	_, err = collectTestQueries(func(cb SQLQueryCallbackInterface) error { return tm.ForEachQueryTillHeight(4, "", cb) })
+	assert.Error(t, err)
+
+	_, err = collectTestQueries(func(cb SQLQueryCallbackInterface) error { return tm.ForEachQueryTillHeight(-1, "", cb) })
+	assert.Error(t, err)
+}
+
+func TestForEachRollbackAfterHeight(t *testing.T) {
+	tm, _ := makeTestPointInTimeManager(t)
+
+	// last change first, the pool is above all blocks
+	list, err := collectTestQueries(func(cb SQLQueryCallbackInterface) error { return tm.ForEachRollbackAfterHeight(1, "", cb) })
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"UPDATE members SET name='b' WHERE id=1",
+		"DELETE FROM orders WHERE id=1",
+		"UPDATE members SET name='a' WHERE id=1"}, list)
+
+	list, err = collectTestQueries(func(cb SQLQueryCallbackInterface) error { return tm.ForEachRollbackAfterHeight(2, "members", cb) })
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UPDATE members SET name='b' WHERE id=1"}, list)
+
+	// top block, only the pool is rolled back
+	list, err = collectTestQueries(func(cb SQLQueryCallbackInterface) error { return tm.ForEachRollbackAfterHeight(3, "orders", cb) })
+
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+
+	_, err = collectTestQueries(func(cb SQLQueryCallbackInterface) error { return tm.ForEachRollbackAfterHeight(4, "", cb) })
+	assert.Error(t, err)
+}
+
+func TestForEachRollbackAfterDrop(t *testing.T) {
+	tm, db := makeTestPointInTimeManager(t)
+
+	db.pool.addTransaction(t, makeTestSQLTX(7, 7, "DROP TABLE orders", ""))
+	transactionsCache = nil
+
+	_, err := collectTestQueries(func(cb SQLQueryCallbackInterface) error { return tm.ForEachRollbackAfterHeight(1, "orders", cb) })
+	assert.Error(t, err)
+
+	// other table can be restored
+	_, err = collectTestQueries(func(cb SQLQueryCallbackInterface) error { return tm.ForEachRollbackAfterHeight(1, "members", cb) })
+	assert.NoError(t, err)
+}
@@ -0,0 +1,109 @@
+package transactions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/blockchain"
+	"github.com/gelembjuk/oursql/node/dbquery/sqlparser"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Returns all changes of a row in primary chain, last change first.
+// Starts from a transaction in the index of rows and follows base transactions while they change same row.
+// A first insert of a row is based on a table create, the history stops there
+func (n *txManager) GetRowHistory(table string, key string) ([]structures.RowChange, error) {
+	if table == "" || key == "" {
+		return nil, errors.New("Table and key of a row are required")
+	}
+
+	refID := []byte(table + ":" + key)
+
+	history := []structures.RowChange{}
+
+	txID, err := n.GetTransactionForRow(refID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bcMan, err := blockchain.NewBlockchainManager(n.DB, n.Logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	visited := map[string]bool{}
+
+	for len(txID) > 0 {
+		if visited[string(txID)] {
+			return nil, errors.New(fmt.Sprintf("Loop in history of a row at transaction %x", txID))
+		}
+		visited[string(txID)] = true
+
+		blockHashes, err := n.getIndexManager().GetTranactionBlocks(txID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		blockHash, err := bcMan.ChooseHashUnderTip(blockHashes, []byte{})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if blockHash == nil {
+			return nil, errors.New(fmt.Sprintf("Transaction %x is not found in primary chain", txID))
+		}
+
+		block, err := bcMan.GetBlock(blockHash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tx, err := bcMan.GetTransactionFromBlock(txID, blockHash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if tx == nil {
+			return nil, errors.New(fmt.Sprintf("Transaction %x is not found in block %x", txID, blockHash))
+		}
+
+		if !bytes.Equal(tx.SQLCommand.ReferenceID, refID) {
+			// base of a first insert. it is a change of a table, not of the row
+			break
+		}
+
+		change := structures.RowChange{}
+		change.TXID = tx.GetID()
+		change.BlockHash = block.Hash
+		change.Height = block.Height
+		change.BlockTime = block.Timestamp
+		change.TXTime = tx.Time
+		change.Query = string(tx.SQLCommand.Query)
+		change.RollbackQuery = string(tx.SQLCommand.RollbackQuery)
+		change.BaseTX = tx.GetSQLBaseTX()
+
+		if len(tx.ByPubKey) > 0 {
+			change.Signer, _ = utils.PubKeyToAddres(tx.ByPubKey)
+		}
+
+		parser := sqlparser.NewSqlParser()
+
+		if parser.Parse(change.Query) == nil {
+			change.Kind = parser.GetKind()
+		}
+
+		history = append(history, change)
+
+		txID = tx.GetSQLBaseTX()
+	}
+
+	return history, nil
+}
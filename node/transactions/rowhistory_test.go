@@ -0,0 +1,127 @@
+package transactions
+
+import (
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/structures"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestRowTX(id byte, query, refID string, base byte) structures.Transaction {
+	tx := makeTestSQLTX(id, int64(id), query, "")
+	tx.SQLCommand.ReferenceID = []byte(refID)
+
+	if base > 0 {
+		tx.SQLBaseTX = []byte{base}
+	}
+	return tx
+}
+
+// Blocks:
+// 0: create table members
+// 1: insert a member, insert other member
+// 2: update the member
+// 3: delete the member
+// Side block after 1: update of other member
+func makeTestRowHistoryManager(t *testing.T) (*txManager, *testDBManager) {
+	db := &testDBManager{}
+	db.bc = &testBlockchainDB{map[string][]byte{}, map[string][]byte{}, map[string][]byte{}, nil, []byte{}}
+	db.pool = &testPoolDB{}
+	db.index = &testIndexDB{map[string][]byte{}}
+	db.refs = &testRefsDB{map[string][]byte{}}
+
+	blocks := []*structures.Block{}
+	blocks = append(blocks, db.bc.addBlock(t,
+		makeTestRowTX(1, "CREATE TABLE members (id INT PRIMARY KEY, name VARCHAR(50))", "members:*", 0)))
+	blocks = append(blocks, db.bc.addBlock(t,
+		makeTestRowTX(2, "INSERT INTO members (id, name) VALUES (1, 'a')", "members:1", 1),
+		makeTestRowTX(3, "INSERT INTO members (id, name) VALUES (2, 'a')", "members:2", 1)))
+	blocks = append(blocks, db.bc.addBlock(t,
+		makeTestRowTX(4, "UPDATE members SET name='b' WHERE id=1", "members:1", 2)))
+	blocks = append(blocks, db.bc.addBlock(t,
+		makeTestRowTX(5, "DELETE FROM members WHERE id=1", "members:1", 4)))
+	blocks = append(blocks, db.bc.addSideBlock(t, []byte{100}, blocks[1],
+		makeTestRowTX(6, "UPDATE members SET name='c' WHERE id=2", "members:2", 3)))
+
+	index := newTransactionIndex(db, utils.CreateLogger())
+
+	for _, block := range blocks {
+		if err := index.BlockAdded(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.refs.txs["members:*"] = []byte{1}
+	db.refs.txs["members:1"] = []byte{5}
+	db.refs.txs["members:2"] = []byte{3}
+
+	transactionsCache = nil
+
+	return NewManager(db, utils.CreateLogger(), structures.ConsensusInfo{}).(*txManager), db
+}
+
+func TestGetRowHistory(t *testing.T) {
+	tm, _ := makeTestRowHistoryManager(t)
+
+	// base transactions are followed till the table create
+	history, err := tm.GetRowHistory("members", "1")
+	assert.NoError(t, err)
+
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, []byte{5}, history[0].TXID)
+		assert.Equal(t, "delete", history[0].Kind)
+		assert.Equal(t, 3, history[0].Height)
+		assert.Equal(t, []byte{4}, history[0].BaseTX)
+
+		assert.Equal(t, []byte{4}, history[1].TXID)
+		assert.Equal(t, "update", history[1].Kind)
+		assert.Equal(t, "UPDATE members SET name='b' WHERE id=1", history[1].Query)
+
+		assert.Equal(t, []byte{2}, history[2].TXID)
+		assert.Equal(t, "insert", history[2].Kind)
+		assert.Equal(t, []byte{2}, history[2].BlockHash)
+		assert.Equal(t, []byte{1}, history[2].BaseTX)
+	}
+
+	// update in side block is not a history
+	history, err = tm.GetRowHistory("members", "2")
+	assert.NoError(t, err)
+
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, []byte{3}, history[0].TXID)
+	}
+
+	history, err = tm.GetRowHistory("members", "3")
+	assert.NoError(t, err)
+	assert.Empty(t, history)
+
+	_, err = tm.GetRowHistory("members", "")
+	assert.Error(t, err)
+}
+
+func TestGetRowHistoryErrors(t *testing.T) {
+	tm, db := makeTestRowHistoryManager(t)
+
+	// last change is only in a side block
+	db.refs.txs["members:2"] = []byte{6}
+
+	_, err := tm.GetRowHistory("members", "2")
+	assert.Error(t, err)
+
+	// transactions are based on each other
+	block := db.bc.addBlock(t,
+		makeTestRowTX(7, "UPDATE members SET name='d' WHERE id=3", "members:3", 8),
+		makeTestRowTX(8, "UPDATE members SET name='e' WHERE id=3", "members:3", 7))
+
+	if err := newTransactionIndex(db, utils.CreateLogger()).BlockAdded(block); err != nil {
+		t.Fatal(err)
+	}
+	db.refs.txs["members:3"] = []byte{8}
+
+	_, err = tm.GetRowHistory("members", "3")
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Loop")
+	}
+}
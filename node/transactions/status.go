@@ -0,0 +1,109 @@
+package transactions
+
+import (
+	"github.com/gelembjuk/oursql/lib"
+	"github.com/gelembjuk/oursql/node/blockchain"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Returns state of a transaction. A transaction is final when a block with it has finalDepth confirmations.
+// Transactions from canceled blocks which were not added back to the pool are dropped or conflicted
+func (n *txManager) GetTransactionStatus(txid []byte, finalDepth int) (*structures.TransactionStatus, error) {
+	if finalDepth < 1 {
+		finalDepth = structures.DefaultFinalDepth
+	}
+
+	status := &structures.TransactionStatus{}
+	status.TXID = txid
+	status.State = structures.TXStateUnknown
+	status.Height = -1
+	status.FinalDepth = finalDepth
+	status.SideBlocks = [][]byte{}
+
+	bcMan, err := blockchain.NewBlockchainManager(n.DB, n.Logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	blockHashes, err := n.getIndexManager().GetTranactionBlocks(txid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	blockHash, err := bcMan.ChooseHashUnderTip(blockHashes, []byte{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if blockHash != nil {
+		block, err := bcMan.GetBlock(blockHash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		bestHeight, err := bcMan.GetBestHeight()
+
+		if err != nil {
+			return nil, err
+		}
+
+		status.BlockHash = blockHash
+		status.Height = block.Height
+		status.Confirmations = bestHeight - block.Height + 1
+		status.State = structures.TXStateInBlock
+
+		if status.Confirmations >= finalDepth {
+			status.State = structures.TXStateFinal
+		}
+		return status, nil
+	}
+
+	// blocks of other branches
+	status.SideBlocks = blockHashes
+
+	tx, err := n.getUnapprovedTransactionsManager().GetIfExists(txid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tx != nil {
+		status.State = structures.TXStatePending
+		return status, nil
+	}
+
+	if len(blockHashes) == 0 {
+		return status, nil
+	}
+
+	// transaction was canceled when other branch became primary. check why it was not added back
+	tx, err = bcMan.GetTransactionFromBlock(txid, blockHashes[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tx == nil {
+		return status, nil
+	}
+
+	_, err = n.VerifyTransaction(tx, nil, []byte{}, lib.TXFlagsBasedOnTopOfChain)
+
+	if err != nil {
+		status.State = structures.TXStateConflicted
+		status.Reason = err.Error()
+
+		if verr, ok := err.(*TXVerifyError); ok && len(verr.TX) > 0 {
+			status.ConflictTX = verr.TX
+		}
+		return status, nil
+	}
+
+	status.State = structures.TXStateDropped
+
+	return status, nil
+}
@@ -0,0 +1,134 @@
+package transactions
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/structures"
+	"github.com/stretchr/testify/assert"
+)
+
+// SQL transaction signed with the key. Verify needs correct signature
+func makeTestSignedSQLTX(t *testing.T, key *ecdsa.PrivateKey, time int64, query, refID string, base []byte) structures.Transaction {
+	tx := structures.Transaction{Time: time}
+	tx.SQLCommand.Query = []byte(query)
+	tx.SQLCommand.ReferenceID = []byte(refID)
+	tx.SQLBaseTX = base
+
+	pubKey := append(key.PublicKey.X.FillBytes(make([]byte, 32)), key.PublicKey.Y.FillBytes(make([]byte, 32))...)
+
+	data, err := tx.PrepareSignData(pubKey, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := utils.SignData(*key, data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.CompleteTransaction(signature)
+
+	return tx
+}
+
+// Blocks:
+// 0: create table members
+// 1: insert a member
+// 2: update the member
+// Side block after 1: other update of the member based on the insert, create table orders
+// Pool: insert other member
+func makeTestStatusManager(t *testing.T) (*txManager, []structures.Transaction) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &testDBManager{}
+	db.bc = &testBlockchainDB{map[string][]byte{}, map[string][]byte{}, map[string][]byte{}, nil, []byte{}}
+	db.pool = &testPoolDB{}
+	db.index = &testIndexDB{map[string][]byte{}}
+	db.refs = &testRefsDB{map[string][]byte{}}
+
+	txs := []structures.Transaction{}
+	txs = append(txs, makeTestSignedSQLTX(t, key, 1, "CREATE TABLE members (id INT PRIMARY KEY, name VARCHAR(50))", "members:*", nil))
+	txs = append(txs, makeTestSignedSQLTX(t, key, 2, "INSERT INTO members (id, name) VALUES (1, 'a')", "members:1", txs[0].ID))
+	txs = append(txs, makeTestSignedSQLTX(t, key, 3, "UPDATE members SET name='b' WHERE id=1", "members:1", txs[1].ID))
+	txs = append(txs, makeTestSignedSQLTX(t, key, 4, "UPDATE members SET name='c' WHERE id=1", "members:1", txs[1].ID))
+	txs = append(txs, makeTestSignedSQLTX(t, key, 5, "CREATE TABLE orders (id INT PRIMARY KEY, amount INT)", "orders:*", nil))
+	txs = append(txs, makeTestSignedSQLTX(t, key, 6, "INSERT INTO members (id, name) VALUES (2, 'd')", "members:2", txs[0].ID))
+
+	index := newTransactionIndex(db, utils.CreateLogger())
+
+	blocks := []*structures.Block{}
+	blocks = append(blocks, db.bc.addBlock(t, txs[0]))
+	blocks = append(blocks, db.bc.addBlock(t, txs[1]))
+	blocks = append(blocks, db.bc.addBlock(t, txs[2]))
+	blocks = append(blocks, db.bc.addSideBlock(t, []byte{100}, blocks[1], txs[3], txs[4]))
+
+	for _, block := range blocks {
+		if err := index.BlockAdded(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.refs.txs["members:*"] = txs[0].ID
+	db.refs.txs["members:1"] = txs[2].ID
+
+	db.pool.addTransaction(t, txs[5])
+
+	// pool cache is shared by the package, it must be loaded from this DB
+	transactionsCache = nil
+
+	return NewManager(db, utils.CreateLogger(), structures.ConsensusInfo{}).(*txManager), txs
+}
+
+func TestGetTransactionStatus(t *testing.T) {
+	tm, txs := makeTestStatusManager(t)
+
+	status, err := tm.GetTransactionStatus(txs[0].ID, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, structures.TXStateFinal, status.State)
+	assert.Equal(t, 0, status.Height)
+	assert.Equal(t, 3, status.Confirmations)
+
+	status, err = tm.GetTransactionStatus(txs[1].ID, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, structures.TXStateInBlock, status.State)
+	assert.Equal(t, 1, status.Height)
+	assert.Equal(t, 2, status.Confirmations)
+	assert.Equal(t, []byte{2}, status.BlockHash)
+
+	status, err = tm.GetTransactionStatus(txs[5].ID, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, structures.TXStatePending, status.State)
+	assert.Equal(t, -1, status.Height)
+
+	status, err = tm.GetTransactionStatus([]byte{1, 2, 3}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, structures.TXStateUnknown, status.State)
+}
+
+func TestGetTransactionStatusCanceled(t *testing.T) {
+	tm, txs := makeTestStatusManager(t)
+
+	// the row was updated by other transaction in primary chain
+	status, err := tm.GetTransactionStatus(txs[3].ID, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, structures.TXStateConflicted, status.State)
+	assert.Equal(t, [][]byte{{100}}, status.SideBlocks)
+	assert.Equal(t, txs[2].ID, status.ConflictTX)
+	assert.NotEmpty(t, status.Reason)
+
+	// still valid, but not added back to a pool
+	status, err = tm.GetTransactionStatus(txs[4].ID, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, structures.TXStateDropped, status.State)
+	assert.Equal(t, [][]byte{{100}}, status.SideBlocks)
+	assert.Empty(t, status.ConflictTX)
+}
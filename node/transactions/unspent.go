@@ -468,7 +468,7 @@ func (u unspentTransactions) UpdateOnBlockAdd(block *structures.Block) error {
 	uodb, err := u.DB.GetUnspentOutputsObject()
 
 	if err != nil {
-		u.Logger.Trace.Printf("UTXO, db err %e", err.Error())
+		u.Logger.Trace.Printf("UTXO, db err %s", err.Error())
 		return err
 	}
 
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
+	"github.com/gelembjuk/oursql/lib/net"
 	"github.com/gelembjuk/oursql/lib/remoteclient"
 )
 
@@ -28,6 +30,13 @@ func GetAppInput() (remoteclient.AppInput, error) {
 	cmd.StringVar(&input.NodeHost, "nodehost", "", "Node Server Host")
 	cmd.Float64Var(&input.Amount, "amount", 0, "Amount money to send")
 	cmd.StringVar(&input.LogDest, "logdest", "file", "Destination of logs. file or stdout")
+	cmd.StringVar(&input.TXID, "txid", "", "ID of a transaction")
+	cmd.BoolVar(&input.Lite, "lite", false, "Check headers and compare answers of all nodes")
+	cmd.IntVar(&input.FinalDepth, "finaldepth", 0, "Number of confirmations when a transaction is final")
+	cmd.StringVar(&input.Table, "table", "", "Table name")
+	cmd.StringVar(&input.Key, "key", "", "Value of a primary key of a row")
+
+	nodesPtr := cmd.String("nodes", "", "List of nodes host:port separated with comma")
 
 	datadirPtr := cmd.String("configdir", "", "Location of data files, config")
 
@@ -37,6 +46,18 @@ func GetAppInput() (remoteclient.AppInput, error) {
 		log.Panic(err)
 	}
 
+	for _, a := range strings.Split(*nodesPtr, ",") {
+		if a == "" {
+			continue
+		}
+		addr := net.NodeAddr{}
+
+		if err := addr.LoadFromString(a); err != nil {
+			return input, err
+		}
+		input.Nodes = append(input.Nodes, addr)
+	}
+
 	if *datadirPtr != "" {
 		input.ConfigDir = *datadirPtr
 		if input.ConfigDir[len(input.ConfigDir)-1:] != "/" {
@@ -78,6 +99,9 @@ func GetAppInput() (remoteclient.AppInput, error) {
 		if input.Address == "" && config.Address != "" {
 			input.Address = config.Address
 		}
+		if len(input.Nodes) == 0 && len(config.Nodes) > 0 {
+			input.Nodes = config.Nodes
+		}
 	}
 
 	return input, nil
@@ -122,6 +146,7 @@ func updateConfig(datadir string, c remoteclient.AppInput) error {
 	if c.Command == "setnode" {
 		config.NodeHost = c.NodeHost
 		config.NodePort = c.NodePort
+		config.Nodes = c.Nodes
 	}
 
 	// convert back to JSON and save to config file
@@ -150,9 +175,13 @@ func printUsage() {
 	fmt.Println("  createwallet\n\t- Generates a new key-pair and saves it into the wallet file")
 	fmt.Println("  showunspent -address ADDRESS\n\t- Displays the list of all unspent transactions and total balance")
 	fmt.Println("  showhistory -address ADDRESS\n\t- Displays the wallet history. All In?Out transactions")
-	fmt.Println("  getbalance -address ADDRESS\n\t- Get balance of ADDRESS")
+	fmt.Println("  getbalance -address ADDRESS [-lite]\n\t- Get balance of ADDRESS. With -lite balance is requested from all nodes and checked against headers")
+	fmt.Println("  syncheaders\n\t- Loads headers of blocks from all nodes and checks PoW of them")
 	fmt.Println("  listaddresses\n\t- Lists all addresses from the wallet file")
 	fmt.Println("  listbalances\n\t- Lists all addresses from the wallet file and show balance for each")
+	fmt.Println("  verifytx -txid TXID\n\t- Checks that a transaction is in a block with Merkle proof. Headers are synced from all nodes to check a proof")
+	fmt.Println("  txstatus -txid TXID [-finaldepth NUMBER]\n\t- Shows state of a transaction on every node: pending, inblock, final, conflicted, dropped or unknown, and number of confirmations. Without -finaldepth a node uses own setting")
+	fmt.Println("  rowhistory -table TABLE -key KEY\n\t- Shows all changes of a row: block, time, address which signed a change, SQL of the change and SQL to return the row to previous state")
 	fmt.Println("  send -from FROM -to TO -amount AMOUNT\n\t- Send AMOUNT of coins from FROM address to TO. ")
-	fmt.Println("  setnode -nodehost HOST -nodeport PORT\n\t- Saves a node host and port to configfile. ")
+	fmt.Println("  setnode -nodehost HOST -nodeport PORT [-nodes HOST:PORT,HOST:PORT]\n\t- Saves a node host and port to configfile. Nodes list is used in lite mode ")
 }